@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"chatapp/internal/store"
+	"chatapp/server"
+)
+
+// runAdminCommand manages instance admins from the command line, which is
+// how the first one gets made. On an invite-only server "admin invite"
+// makes the invite the first account registers with.
+func runAdminCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: admin list | grant <username> | revoke <username> | invite [max_uses]")
+	}
+	action, args := args[0], args[1:]
+
+	username := ""
+	if action == "grant" || action == "revoke" {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: admin %s <username>", action)
+		}
+		username, args = args[0], args[1:]
+	}
+
+	maxUses := 1
+	if action == "invite" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n < 0 || n > server.MaxInviteUses {
+				return fmt.Errorf("max_uses must be between 0 (unlimited) and %d", server.MaxInviteUses)
+			}
+			maxUses, args = n, args[1:]
+		}
+	}
+
+	cfg, err := server.LoadConfig(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewDatabase(cfg.DatabaseOptions())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if err := db.Migrate(ctx); err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		users, err := db.ListUsers(ctx, "", -1, 0)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.IsAdmin {
+				fmt.Printf("%d\t%s\n", user.ID, user.Username)
+			}
+		}
+		return nil
+	case "grant", "revoke":
+		user, err := db.GetUserByUsername(ctx, username)
+		if err != nil {
+			return fmt.Errorf("no user named %q", username)
+		}
+		if user.Username == "system" {
+			return fmt.Errorf("the system user can't be an admin")
+		}
+		if err := db.SetInstanceAdmin(ctx, user.ID, action == "grant"); err != nil {
+			return err
+		}
+		fmt.Printf("%s is %san instance admin\n", user.Username, map[bool]string{true: "now ", false: "no longer "}[action == "grant"])
+		return nil
+	case "invite":
+		if err := db.EnsureSystemUser(ctx); err != nil {
+			return err
+		}
+		system, err := db.GetUserByUsername(ctx, "system")
+		if err != nil {
+			return err
+		}
+		invite, err := db.CreateRegistrationInvite(ctx, system.ID, maxUses, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Println(invite.Token)
+		return nil
+	default:
+		return fmt.Errorf("unknown admin action %q", action)
+	}
+}
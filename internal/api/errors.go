@@ -0,0 +1,134 @@
+// Package api holds what every part of the HTTP API answers with: error
+// codes and responses, JSON request bodies and details of the request.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"chatapp/internal/i18n"
+)
+
+// Error codes in API error responses. Clients should branch on these, the
+// messages are meant for people and may change.
+const (
+	// Generic codes, one per status; see statusErrorCode
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodePayloadTooLarge  = "payload_too_large"
+	ErrCodeInternal         = "internal_error"
+
+	ErrCodeInvalidJSON        = "invalid_json"
+	ErrCodeValidationFailed   = "validation_failed" // see field_errors
+	ErrCodeMissingToken       = "missing_token"
+	ErrCodeInvalidSession     = "invalid_session"
+	ErrCodeQuotaExceeded      = "quota_exceeded"
+	ErrCodeTooManyConnections = "too_many_connections"
+	ErrCodeUsernameTaken      = "username_taken"
+	ErrCodeRoomNameTaken      = "room_name_taken"
+	ErrCodeExportNotReady     = "export_not_ready"
+	ErrCodeRegistrationClosed = "registration_closed"
+	ErrCodeInviteRequired     = "invite_required"
+	ErrCodeInvalidInvite      = "invalid_invite" // unknown, used up or expired
+	ErrCodeCaptchaRequired    = "captcha_required"
+	ErrCodeCaptchaFailed      = "captcha_failed"
+	ErrCodeEmailUnverified    = "email_unverified"
+	ErrCodeRenameCooldown     = "rename_cooldown"
+	ErrCodeCSRFFailed         = "csrf_failed"
+	ErrCodeInsufficientScope  = "insufficient_scope"
+	ErrCodeDraining           = "draining" // the instance is restarting, reconnect
+	ErrCodeResyncRequired     = "resync_required"
+	ErrCodeLegalHold          = "legal_hold" // deleting would remove held messages
+	ErrCodePluginRejected     = "plugin_rejected"
+	ErrCodeRequestDeclined    = "request_declined" // a DM request was declined recently
+)
+
+// ContentLanguageHeader carries the language server.languageMiddleware
+// picked for the request, which error messages are translated into
+const ContentLanguageHeader = "Content-Language"
+
+// RequestIDHeader carries the ID server.requestLogMiddleware gives every request,
+// which error responses repeat
+const RequestIDHeader = "X-Request-ID"
+
+// ErrorResponse is the body of every API error
+type ErrorResponse struct {
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	FieldErrors []FieldError `json:"field_errors,omitempty"`
+	RequestID   string       `json:"request_id"`
+
+	// Error repeats Message for clients written before there were codes
+	Error string `json:"error"`
+}
+
+// FieldError describes why one request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// statusErrorCode is the code for errors that have nothing more specific
+// to say than their status
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// RespondError reports an error with the generic code for its status
+func RespondError(w http.ResponseWriter, message string, status int) {
+	RespondErrorCode(w, statusErrorCode(status), message, status)
+}
+
+// RespondErrorCode reports an error with a specific code
+func RespondErrorCode(w http.ResponseWriter, code, message string, status int) {
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message})
+}
+
+// RespondValidationErrors reports every rejected field at once
+func RespondValidationErrors(w http.ResponseWriter, errs []FieldError) {
+	writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+		Code:        ErrCodeValidationFailed,
+		Message:     errs[0].Message,
+		FieldErrors: errs,
+	})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, body ErrorResponse) {
+	body.RequestID = w.Header().Get(RequestIDHeader)
+	if lang := w.Header().Get(ContentLanguageHeader); lang != "" {
+		body.Message = i18n.Error(lang, body.Code, body.Message)
+		for i := range body.FieldErrors {
+			body.FieldErrors[i].Message = i18n.Error(lang, body.FieldErrors[i].Code, body.FieldErrors[i].Message)
+		}
+	}
+	body.Error = body.Message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
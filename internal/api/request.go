@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the address the request came from, without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WebSocketScheme returns the ws scheme matching how a client reached us,
+// honouring X-Forwarded-Proto from a trusted TLS-terminating proxy (others'
+// are dropped by server.proxyMiddleware)
+func WebSocketScheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "wss"
+	}
+	return "ws"
+}
+
+// RequestScheme is WebSocketScheme for plain HTTP URLs
+func RequestScheme(r *http.Request) string {
+	if WebSocketScheme(r) == "wss" {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// BodyLimitMiddleware turns away request bodies over limit bytes with a 413
+// before anything reads them. Bodies sent without a Content-Length are cut
+// off at the limit instead, which DecodeJSON reports the same way.
+func BodyLimitMiddleware(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			respondPayloadTooLarge(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondPayloadTooLarge(w http.ResponseWriter, limit int64) {
+	RespondErrorCode(w, ErrCodePayloadTooLarge, fmt.Sprintf("Request body is larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+}
+
+// DecodeJSON decodes the request body into v, and otherwise responds and
+// returns false. Fields v doesn't have and anything after the JSON value are
+// rejected, so typos and junk don't pass silently.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data")
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondPayloadTooLarge(w, tooLarge.Limit)
+		return false
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		RespondErrorCode(w, ErrCodeInvalidJSON, "Unknown field "+field, http.StatusBadRequest)
+		return false
+	}
+	RespondErrorCode(w, ErrCodeInvalidJSON, "Invalid JSON", http.StatusBadRequest)
+	return false
+}
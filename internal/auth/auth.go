@@ -0,0 +1,396 @@
+// Package auth handles sessions and API tokens: logging in, session cookies,
+// token scopes and daily request quotas.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/store"
+)
+
+// sessionTouchInterval is how often a session in use has its last use and
+// IP written down, so not every request is a write
+const sessionTouchInterval = time.Minute
+
+// ErrInvalidSession is returned for a token that isn't a session's or whose
+// session expired
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Manager keeps sessions in the database, so they outlive restarts and
+// every instance sharing the database accepts them.
+type Manager struct {
+	db         *store.Database
+	usage      *UsageMeter
+	sessionTTL time.Duration
+}
+
+type Session struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	LastUsed  time.Time `json:"last_used"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+
+	// CSRFToken is set on sessions kept in a cookie, whose state-changing
+	// requests must repeat it in the X-CSRF-Token header
+	CSRFToken string `json:"-"`
+
+	// Name and Scopes are set on tokens made for an integration, which may
+	// only do what their scopes allow; sessions from logging in may do
+	// anything
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// SessionInfo is what a user sees about their sessions; it never includes
+// the token itself
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	LastUsed  time.Time `json:"last_used"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Current   bool      `json:"current"`
+	Name      string    `json:"name,omitempty"`   // scoped tokens only
+	Scopes    []string  `json:"scopes,omitempty"` // scoped tokens only
+}
+
+// NewManager makes a Manager whose sessions live for sessionTTL and whose
+// users may make dailyQuota API requests a day (0 for no limit)
+func NewManager(db *store.Database, sessionTTL time.Duration, dailyQuota int) *Manager {
+	return &Manager{
+		db:         db,
+		usage:      NewUsageMeter(db, dailyQuota),
+		sessionTTL: sessionTTL,
+	}
+}
+
+func (am *Manager) GenerateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// CreateSession logs the user in. Sessions for cookie mode get a CSRF token.
+func (am *Manager) CreateSession(user *store.User, r *http.Request, cookie bool) (*Session, error) {
+	session := &Session{
+		UserID:   user.ID,
+		Username: user.Username,
+	}
+	if cookie {
+		csrfToken, err := am.GenerateToken()
+		if err != nil {
+			return nil, err
+		}
+		session.CSRFToken = csrfToken
+	}
+
+	if err := am.addSession(session, r, am.sessionTTL); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// CreateToken makes a token for an integration acting as the user, limited
+// to scopes
+func (am *Manager) CreateToken(user *Session, r *http.Request, name string, scopes []string, ttl time.Duration) (*Session, error) {
+	session := &Session{
+		UserID:   user.UserID,
+		Username: user.Username,
+		Name:     name,
+		Scopes:   scopes,
+	}
+	if err := am.addSession(session, r, ttl); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// addSession gives a session its token and ID, makes it live for ttl and
+// stores it
+func (am *Manager) addSession(session *Session, r *http.Request, ttl time.Duration) error {
+	token, err := am.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	// Sessions are referred to by a separate ID so listing them doesn't leak tokens
+	id, err := store.GenerateInviteCode()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	session.ID = id
+	session.Token = token
+	session.CreatedAt = now
+	session.ExpiresAt = now.Add(ttl)
+	session.LastUsed = now
+	session.UserAgent = r.UserAgent()
+	session.IP = api.ClientIP(r)
+
+	return am.db.CreateSession(r.Context(), &store.Session{
+		ID:        session.ID,
+		UserID:    session.UserID,
+		CSRFToken: session.CSRFToken,
+		Name:      session.Name,
+		Scopes:    session.Scopes,
+		UserAgent: session.UserAgent,
+		IP:        session.IP,
+		CreatedAt: session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+		LastUsed:  session.LastUsed,
+	}, token)
+}
+
+func sessionFromStore(stored *store.Session, token string) *Session {
+	return &Session{
+		ID:        stored.ID,
+		Token:     token,
+		UserID:    stored.UserID,
+		Username:  stored.Username,
+		CreatedAt: stored.CreatedAt,
+		ExpiresAt: stored.ExpiresAt,
+		LastUsed:  stored.LastUsed,
+		UserAgent: stored.UserAgent,
+		IP:        stored.IP,
+		CSRFToken: stored.CSRFToken,
+		Name:      stored.Name,
+		Scopes:    stored.Scopes,
+	}
+}
+
+// ValidateSession returns the session token authenticates. It's
+// ErrInvalidSession if there's none or it expired; other errors are the
+// database's.
+func (am *Manager) ValidateSession(ctx context.Context, token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrInvalidSession
+	}
+	stored, err := am.db.GetSessionByToken(ctx, token)
+	if errors.Is(err, store.ErrSessionNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+	return sessionFromStore(stored, token), nil
+}
+
+// Usage is the meter counting every user's requests against the daily quota
+func (am *Manager) Usage() *UsageMeter {
+	return am.usage
+}
+
+// SessionCount returns how many sessions haven't expired
+func (am *Manager) SessionCount(ctx context.Context) (int, error) {
+	return am.db.CountSessions(ctx, time.Now())
+}
+
+// DeleteSession logs out the session token authenticates and returns its
+// ID, or "" if there was none
+func (am *Manager) DeleteSession(ctx context.Context, token string) (string, error) {
+	stored, err := am.db.GetSessionByToken(ctx, token)
+	if errors.Is(err, store.ErrSessionNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	deleted, err := am.db.DeleteSessions(ctx, stored.UserID, []string{stored.ID})
+	if err != nil || len(deleted) == 0 {
+		return "", err
+	}
+	return stored.ID, nil
+}
+
+// TouchSession records that a session was just used from r. It's only
+// written down every sessionTouchInterval, or when the IP changes, and a
+// failure to is only missing bookkeeping, so it isn't reported.
+func (am *Manager) TouchSession(session *Session, r *http.Request) {
+	now := time.Now().UTC()
+	ip := api.ClientIP(r)
+	if now.Sub(session.LastUsed) < sessionTouchInterval && ip == session.IP {
+		return
+	}
+	session.LastUsed = now
+	session.IP = ip
+	am.db.TouchSession(r.Context(), session.ID, now, ip)
+}
+
+// UserSessions lists the user's live sessions, marking the one with ID
+// currentID
+func (am *Manager) UserSessions(ctx context.Context, userID int, currentID string) ([]SessionInfo, error) {
+	stored, err := am.db.GetUserSessions(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionInfo, 0, len(stored))
+	for _, session := range stored {
+		sessions = append(sessions, SessionInfo{
+			ID:        session.ID,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			LastUsed:  session.LastUsed,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			Current:   session.ID == currentID,
+			Name:      session.Name,
+			Scopes:    session.Scopes,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSessions deletes the user's sessions selected by match and returns
+// their IDs so callers can tear down connections using them
+func (am *Manager) RevokeSessions(ctx context.Context, userID int, match func(session *Session) bool) ([]string, error) {
+	stored, err := am.db.GetUserSessions(ctx, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, session := range stored {
+		if match(sessionFromStore(&session, "")) {
+			ids = append(ids, session.ID)
+		}
+	}
+	return am.db.DeleteSessions(ctx, userID, ids)
+}
+
+func (am *Manager) ExtractToken(r *http.Request) string {
+	token, _ := am.extractCredentials(r)
+	return token
+}
+
+// extractCredentials returns the request's session token, from the
+// Authorization header or else the session cookie, and whether it came from
+// the cookie
+func (am *Manager) extractCredentials(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), false
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// RequireAuth lets requests with a session through. Scoped tokens aren't,
+// see RequireScope.
+func (am *Manager) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return am.requireAuth(nil, next)
+}
+
+// RequireScope is RequireAuth for routes scoped tokens may use too: GET and
+// HEAD requests need the read scope, anything else the write scope. An
+// empty scope keeps scoped tokens out.
+func (am *Manager) RequireScope(read, write string, next http.HandlerFunc) http.HandlerFunc {
+	return am.requireAuth(func(r *http.Request) string {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return read
+		}
+		return write
+	}, next)
+}
+
+// RequireScopeFunc is RequireScope for routes whose requests need different
+// scopes by more than their method; scope returns the one r needs
+func (am *Manager) RequireScopeFunc(scope func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return am.requireAuth(scope, next)
+}
+
+func (am *Manager) requireAuth(routeScope func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, fromCookie := am.extractCredentials(r)
+		if token == "" {
+			api.RespondErrorCode(w, api.ErrCodeMissingToken, "Missing authorization token", http.StatusUnauthorized)
+			return
+		}
+
+		session, err := am.ValidateSession(r.Context(), token)
+		if errors.Is(err, ErrInvalidSession) {
+			api.RespondErrorCode(w, api.ErrCodeInvalidSession, "Invalid or expired session", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			api.RespondError(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
+
+		// Browsers send cookies along by themselves, even on requests other
+		// sites trigger, so those have to prove they came from our client
+		if fromCookie && !validCSRF(r, session) {
+			api.RespondErrorCode(w, api.ErrCodeCSRFFailed, "Missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		if session.Scopes != nil {
+			scope := ""
+			if routeScope != nil {
+				scope = routeScope(r)
+			}
+			if scope == "" || !session.HasScope(scope) {
+				RespondInsufficientScope(w, scope)
+				return
+			}
+		}
+
+		// Meter the request against the user's daily quota
+		requests, ok, err := am.usage.Record(r.Context(), session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to meter request", http.StatusInternalServerError)
+			return
+		}
+		am.usage.setRateLimitHeaders(w, requests)
+		if !ok {
+			retryAfter := time.Until(NextUsageReset(time.Now())).Seconds()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter))))
+			api.RespondErrorCode(w, api.ErrCodeQuotaExceeded, "Daily API quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		am.TouchSession(session, r)
+
+		// Add session to request context
+		r = r.WithContext(contextWithSession(r.Context(), session))
+		next(w, r)
+	}
+}
+
+// Context helpers
+type contextKey string
+
+const sessionKey contextKey = "session"
+
+func contextWithSession(ctx context.Context, session *Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+func SessionFromContext(ctx context.Context) *Session {
+	if session, ok := ctx.Value(sessionKey).(*Session); ok {
+		return session
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"chatapp/internal/api"
+)
+
+// Browser clients can keep their session in an HttpOnly cookie, out of reach
+// of scripts, instead of holding a bearer token: register and login with
+// "cookie": true. The CSRF token that comes with it, in the response and in
+// a cookie scripts can read, must be sent back in the X-CSRF-Token header on
+// every request that isn't a GET, HEAD or OPTIONS.
+const (
+	SessionCookieName = "commons_session"
+	csrfCookieName    = "commons_csrf"
+	CSRFHeader        = "X-CSRF-Token"
+)
+
+// validCSRF reports whether a cookie-authenticated request may go ahead
+func validCSRF(r *http.Request, session *Session) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	token := r.Header.Get(CSRFHeader)
+	return session.CSRFToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+func SetSessionCookies(w http.ResponseWriter, r *http.Request, session *Session) {
+	secure := api.RequestScheme(r) == "https"
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    session.CSRFToken,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearSessionCookies(w http.ResponseWriter, r *http.Request) {
+	secure := api.RequestScheme(r) == "https"
+	for _, name := range []string{SessionCookieName, csrfCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			Secure:   secure,
+			HttpOnly: name == SessionCookieName,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
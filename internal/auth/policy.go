@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"chatapp/internal/api"
+)
+
+// CredentialPolicy holds the rules usernames and passwords are checked
+// against on register and password change
+type CredentialPolicy struct {
+	MinUsernameLength int
+	MaxUsernameLength int
+	UsernameCharset   *regexp.Regexp
+	BannedUsernames   []string // compared case-insensitively
+
+	MinPasswordLength int
+	MaxPasswordLength int // bcrypt ignores anything past 72 bytes
+}
+
+func DefaultCredentialPolicy() *CredentialPolicy {
+	return &CredentialPolicy{
+		MinUsernameLength: 3,
+		MaxUsernameLength: 32,
+		UsernameCharset:   regexp.MustCompile(`^[A-Za-z0-9_.-]+$`),
+		BannedUsernames:   []string{"system", "admin", "administrator", "root", "moderator", "commons", "everyone", "here"},
+		MinPasswordLength: 8,
+		MaxPasswordLength: 72,
+	}
+}
+
+func (p *CredentialPolicy) ValidateUsername(username string) []api.FieldError {
+	errs := make([]api.FieldError, 0)
+
+	length := utf8.RuneCountInString(username)
+	if length < p.MinUsernameLength || length > p.MaxUsernameLength {
+		errs = append(errs, api.FieldError{
+			Field:   "username",
+			Code:    "length",
+			Message: fmt.Sprintf("Username must be %d to %d characters", p.MinUsernameLength, p.MaxUsernameLength),
+		})
+	}
+
+	if username != "" && !p.UsernameCharset.MatchString(username) {
+		errs = append(errs, api.FieldError{
+			Field:   "username",
+			Code:    "charset",
+			Message: "Username may only contain letters, digits, '_', '.' and '-'",
+		})
+	}
+
+	for _, banned := range p.BannedUsernames {
+		if strings.EqualFold(username, banned) {
+			errs = append(errs, api.FieldError{
+				Field:   "username",
+				Code:    "reserved",
+				Message: "This username is reserved",
+			})
+			break
+		}
+	}
+
+	return errs
+}
+
+// ValidatePassword checks a new password; field names the request field it
+// came from so errors point at the right input
+func (p *CredentialPolicy) ValidatePassword(field, password, username string) []api.FieldError {
+	errs := make([]api.FieldError, 0)
+
+	if utf8.RuneCountInString(password) < p.MinPasswordLength {
+		errs = append(errs, api.FieldError{
+			Field:   field,
+			Code:    "too_short",
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinPasswordLength),
+		})
+	}
+
+	if len(password) > p.MaxPasswordLength {
+		errs = append(errs, api.FieldError{
+			Field:   field,
+			Code:    "too_long",
+			Message: fmt.Sprintf("Password must be at most %d bytes", p.MaxPasswordLength),
+		})
+	}
+
+	if username != "" && strings.EqualFold(password, username) {
+		errs = append(errs, api.FieldError{
+			Field:   field,
+			Code:    "matches_username",
+			Message: "Password must not be the same as the username",
+		})
+	}
+
+	return errs
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"chatapp/internal/api"
+)
+
+// Integrations get tokens limited to the scopes they need instead of a full
+// session. A scoped token acts as the user who made it, so it can never do
+// more than they can, and it can't manage the account (password, sessions,
+// tokens, halls joined) at all.
+const (
+	ScopeReadMessages  = "read:messages"  // halls, rooms, messages and DMs; ws and SSE
+	ScopeWriteMessages = "write:messages" // sending, reacting, voice, drafts, DMs
+	ScopeAdminHall     = "admin:hall"     // hall settings, rooms and moderation
+)
+
+var ValidScopes = map[string]bool{
+	ScopeReadMessages:  true,
+	ScopeWriteMessages: true,
+	ScopeAdminHall:     true,
+}
+
+// MaxTokenTTL caps how long a scoped token can live
+const MaxTokenTTL = 365 * 24 * time.Hour
+
+// HasScope reports whether the session may do what scope covers. Sessions
+// from logging in may do anything.
+func (session *Session) HasScope(scope string) bool {
+	if session.Scopes == nil {
+		return true
+	}
+	for _, s := range session.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func RespondInsufficientScope(w http.ResponseWriter, scope string) {
+	if scope == "" {
+		api.RespondErrorCode(w, api.ErrCodeInsufficientScope, "Scoped tokens can't use this endpoint", http.StatusForbidden)
+		return
+	}
+	api.RespondErrorCode(w, api.ErrCodeInsufficientScope, "This token needs the "+scope+" scope", http.StatusForbidden)
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// UsageMeter counts authenticated API requests per user and enforces a
+// daily quota so one misbehaving bot can't monopolize the instance. All of a
+// user's tokens share the quota. Counts live in the database, so they
+// survive restarts and instances sharing it agree on them.
+type UsageMeter struct {
+	db    *store.Database
+	quota atomic.Int64
+}
+
+func NewUsageMeter(db *store.Database, quota int) *UsageMeter {
+	um := &UsageMeter{db: db}
+	um.SetQuota(quota)
+	return um
+}
+
+// Quota is the number of requests a user may make per UTC day, 0 for no limit
+func (um *UsageMeter) Quota() int {
+	return int(um.quota.Load())
+}
+
+// SetQuota changes the daily quota; requests already counted today count
+// against the new one
+func (um *UsageMeter) SetQuota(quota int) {
+	um.quota.Store(int64(quota))
+}
+
+// NextUsageReset returns the start of the next UTC day, when quotas reset.
+func NextUsageReset(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Record counts a request by the user and reports whether it is within the
+// daily quota, along with the user's count for the day. Rejected requests
+// are not counted.
+func (um *UsageMeter) Record(ctx context.Context, userID int) (int, bool, error) {
+	return um.db.RecordAPIRequest(ctx, userID, time.Now(), um.Quota())
+}
+
+// Usage returns the user's usage for the current day.
+func (um *UsageMeter) Usage(ctx context.Context, userID int) (store.APIUsage, error) {
+	return um.db.GetAPIUsage(ctx, userID, time.Now())
+}
+
+// Remaining is how many more requests a user who made requests today may
+// make, -1 for no limit
+func (um *UsageMeter) Remaining(requests int) int {
+	quota := um.Quota()
+	if quota <= 0 {
+		return -1
+	}
+	remaining := quota - requests
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining
+}
+
+// setRateLimitHeaders advertises the user's quota state on every response.
+func (um *UsageMeter) setRateLimitHeaders(w http.ResponseWriter, requests int) {
+	quota := um.Quota()
+	if quota <= 0 {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(quota))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(um.Remaining(requests)))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(NextUsageReset(time.Now()).Unix(), 10))
+}
@@ -0,0 +1,200 @@
+package i18n
+
+// catalog is one language's translations
+type catalog struct {
+	// codes translates error codes whose message says nothing the code
+	// doesn't. Codes whose messages carry the details, like bad_request or
+	// command_failed, aren't here.
+	codes map[string]string
+	// messages translates common error messages, by their English text
+	messages map[string]string
+	// system holds the system message templates
+	system map[string]string
+}
+
+var catalogs = map[string]*catalog{
+	"en": {
+		system: map[string]string{
+			SystemMemberJoined: "{username} joined the hall",
+			SystemMemberLeft:   "{username} left the hall",
+			SystemRoomCreated:  "{username} created {room}",
+		},
+	},
+
+	"de": {
+		codes: map[string]string{
+			"unauthorized":         "Nicht angemeldet",
+			"method_not_allowed":   "Methode nicht erlaubt",
+			"rate_limited":         "Zu viele Anfragen, warte kurz",
+			"internal_error":       "Auf dem Server ist etwas schiefgelaufen",
+			"missing_token":        "Anmeldetoken fehlt",
+			"invalid_session":      "Ungültige oder abgelaufene Sitzung",
+			"too_many_connections": "Zu viele Verbindungen",
+			"username_taken":       "Der Benutzername ist schon vergeben",
+			"room_name_taken":      "In dieser Halle gibt es schon einen Raum mit diesem Namen",
+			"export_not_ready":     "Der Export ist noch nicht fertig",
+			"registration_closed":  "Die Registrierung ist geschlossen",
+			"invite_required":      "Zum Registrieren ist eine Einladung nötig",
+			"invalid_invite":       "Diese Einladung ist ungültig, aufgebraucht oder abgelaufen",
+			"captcha_required":     "Ein Captcha ist erforderlich",
+			"captcha_failed":       "Das Captcha wurde nicht gelöst",
+			"email_unverified":     "Bestätige zuerst deine E-Mail-Adresse",
+			"csrf_failed":          "CSRF-Token fehlt oder ist ungültig",
+			"draining":             "Der Server startet neu",
+			"resync_required":      "Diese Ereignisse sind nicht mehr verfügbar, lade neu",
+			"room_archived":        "Dieser Raum ist archiviert",
+			"automod_rejected":     "Deine Nachricht enthält gesperrte Inhalte",
+			"server_busy":          "Der Server ist ausgelastet, versuch es gleich noch einmal",
+			"spam_throttled":       "Das sieht zu sehr nach Spam aus, warte eine Weile",
+			"mention_rate_limited": "Diese Halle wurde gerade erst als Ganzes erwähnt",
+			"guest_read_only":      "Registriere dich dafür",
+			"voice_full":           "Dieser Sprachraum ist voll",
+			"not_in_voice":         "Tritt zuerst einem Sprachraum bei",
+			"not_voice_room":       "Das ist kein Sprachraum",
+			"not_in_room":          "Tritt zuerst dem Raum bei",
+			"unknown_peer":         "Dieser Teilnehmer ist nicht in deinem Sprachraum",
+		},
+		messages: map[string]string{
+			"Invalid credentials":                   "Benutzername oder Passwort ist falsch",
+			"Access denied":                         "Zugriff verweigert",
+			"Room not found":                        "Raum nicht gefunden",
+			"Hall not found":                        "Halle nicht gefunden",
+			"User not found":                        "Benutzer nicht gefunden",
+			"Message not found":                     "Nachricht nicht gefunden",
+			"Webhook not found":                     "Webhook nicht gefunden",
+			"Invite not found":                      "Einladung nicht gefunden",
+			"Invalid room ID":                       "Ungültige Raum-ID",
+			"Invalid hall ID":                       "Ungültige Hallen-ID",
+			"Invalid message ID":                    "Ungültige Nachrichten-ID",
+			"Invalid invite code or already member": "Ungültiger Einladungscode, oder du bist schon Mitglied",
+			"Guest access is disabled":              "Der Gastzugang ist deaktiviert",
+			"Cannot delete default hall":            "Die Standardhalle kann nicht gelöscht werden",
+			"Only hall owner can delete rooms":      "Nur der Besitzer der Halle kann Räume löschen",
+			"Expiry must be in the future":          "Das Ablaufdatum muss in der Zukunft liegen",
+			"This room is archived":                 "Dieser Raum ist archiviert",
+		},
+		system: map[string]string{
+			SystemMemberJoined: "{username} ist der Halle beigetreten",
+			SystemMemberLeft:   "{username} hat die Halle verlassen",
+			SystemRoomCreated:  "{username} hat {room} erstellt",
+		},
+	},
+
+	"es": {
+		codes: map[string]string{
+			"unauthorized":         "No has iniciado sesión",
+			"method_not_allowed":   "Método no permitido",
+			"rate_limited":         "Demasiadas solicitudes, espera un momento",
+			"internal_error":       "Algo salió mal en el servidor",
+			"missing_token":        "Falta el token de autenticación",
+			"invalid_session":      "Sesión no válida o caducada",
+			"too_many_connections": "Demasiadas conexiones",
+			"username_taken":       "El nombre de usuario ya está en uso",
+			"room_name_taken":      "Ya hay una sala con ese nombre en este hall",
+			"export_not_ready":     "La exportación aún no está lista",
+			"registration_closed":  "El registro está cerrado",
+			"invite_required":      "Necesitas una invitación para registrarte",
+			"invalid_invite":       "Esta invitación no es válida, está agotada o ha caducado",
+			"captcha_required":     "Se requiere un captcha",
+			"captcha_failed":       "El captcha no se resolvió",
+			"email_unverified":     "Verifica primero tu dirección de correo",
+			"csrf_failed":          "Falta el token CSRF o no es válido",
+			"draining":             "El servidor se está reiniciando",
+			"resync_required":      "Esos eventos ya no están disponibles, vuelve a cargar",
+			"room_archived":        "Esta sala está archivada",
+			"automod_rejected":     "Tu mensaje contiene contenido bloqueado",
+			"server_busy":          "El servidor está ocupado, inténtalo de nuevo en un momento",
+			"spam_throttled":       "Estás publicando demasiado como spam, espera un rato",
+			"mention_rate_limited": "Este hall se mencionó entero hace muy poco",
+			"guest_read_only":      "Regístrate para hacer eso",
+			"voice_full":           "Esta sala de voz está llena",
+			"not_in_voice":         "Únete primero a una sala de voz",
+			"not_voice_room":       "Esta no es una sala de voz",
+			"not_in_room":          "Únete primero a la sala",
+			"unknown_peer":         "Ese participante no está en tu sala de voz",
+		},
+		messages: map[string]string{
+			"Invalid credentials":                   "Usuario o contraseña incorrectos",
+			"Access denied":                         "Acceso denegado",
+			"Room not found":                        "Sala no encontrada",
+			"Hall not found":                        "Hall no encontrado",
+			"User not found":                        "Usuario no encontrado",
+			"Message not found":                     "Mensaje no encontrado",
+			"Webhook not found":                     "Webhook no encontrado",
+			"Invite not found":                      "Invitación no encontrada",
+			"Invalid room ID":                       "ID de sala no válido",
+			"Invalid hall ID":                       "ID de hall no válido",
+			"Invalid message ID":                    "ID de mensaje no válido",
+			"Invalid invite code or already member": "Código de invitación no válido, o ya eres miembro",
+			"Guest access is disabled":              "El acceso de invitados está desactivado",
+			"Cannot delete default hall":            "No se puede eliminar el hall predeterminado",
+			"Only hall owner can delete rooms":      "Solo el propietario del hall puede eliminar salas",
+			"Expiry must be in the future":          "La fecha de caducidad debe estar en el futuro",
+			"This room is archived":                 "Esta sala está archivada",
+		},
+		system: map[string]string{
+			SystemMemberJoined: "{username} se unió al hall",
+			SystemMemberLeft:   "{username} salió del hall",
+			SystemRoomCreated:  "{username} creó {room}",
+		},
+	},
+
+	"fr": {
+		codes: map[string]string{
+			"unauthorized":         "Vous n'êtes pas connecté",
+			"method_not_allowed":   "Méthode non autorisée",
+			"rate_limited":         "Trop de requêtes, patientez un instant",
+			"internal_error":       "Une erreur est survenue sur le serveur",
+			"missing_token":        "Jeton d'authentification manquant",
+			"invalid_session":      "Session invalide ou expirée",
+			"too_many_connections": "Trop de connexions",
+			"username_taken":       "Ce nom d'utilisateur est déjà pris",
+			"room_name_taken":      "Ce hall a déjà un salon de ce nom",
+			"export_not_ready":     "L'export n'est pas encore prêt",
+			"registration_closed":  "Les inscriptions sont fermées",
+			"invite_required":      "Une invitation est nécessaire pour s'inscrire",
+			"invalid_invite":       "Cette invitation est invalide, épuisée ou expirée",
+			"captcha_required":     "Un captcha est requis",
+			"captcha_failed":       "Le captcha n'a pas été résolu",
+			"email_unverified":     "Confirmez d'abord votre adresse e-mail",
+			"csrf_failed":          "Jeton CSRF manquant ou invalide",
+			"draining":             "Le serveur redémarre",
+			"resync_required":      "Ces événements ne sont plus disponibles, rechargez",
+			"room_archived":        "Ce salon est archivé",
+			"automod_rejected":     "Votre message contient du contenu bloqué",
+			"server_busy":          "Le serveur est surchargé, réessayez dans un instant",
+			"spam_throttled":       "Vos messages ressemblent trop à du spam, patientez un moment",
+			"mention_rate_limited": "Ce hall a été mentionné en entier trop récemment",
+			"guest_read_only":      "Inscrivez-vous pour faire cela",
+			"voice_full":           "Ce salon vocal est plein",
+			"not_in_voice":         "Rejoignez d'abord un salon vocal",
+			"not_voice_room":       "Ce n'est pas un salon vocal",
+			"not_in_room":          "Rejoignez d'abord le salon",
+			"unknown_peer":         "Ce participant n'est pas dans votre salon vocal",
+		},
+		messages: map[string]string{
+			"Invalid credentials":                   "Identifiant ou mot de passe incorrect",
+			"Access denied":                         "Accès refusé",
+			"Room not found":                        "Salon introuvable",
+			"Hall not found":                        "Hall introuvable",
+			"User not found":                        "Utilisateur introuvable",
+			"Message not found":                     "Message introuvable",
+			"Webhook not found":                     "Webhook introuvable",
+			"Invite not found":                      "Invitation introuvable",
+			"Invalid room ID":                       "Identifiant de salon invalide",
+			"Invalid hall ID":                       "Identifiant de hall invalide",
+			"Invalid message ID":                    "Identifiant de message invalide",
+			"Invalid invite code or already member": "Code d'invitation invalide, ou vous êtes déjà membre",
+			"Guest access is disabled":              "L'accès invité est désactivé",
+			"Cannot delete default hall":            "Impossible de supprimer le hall par défaut",
+			"Only hall owner can delete rooms":      "Seul le propriétaire du hall peut supprimer des salons",
+			"Expiry must be in the future":          "L'expiration doit être dans le futur",
+			"This room is archived":                 "Ce salon est archivé",
+		},
+		system: map[string]string{
+			SystemMemberJoined: "{username} a rejoint le hall",
+			SystemMemberLeft:   "{username} a quitté le hall",
+			SystemRoomCreated:  "{username} a créé {room}",
+		},
+	},
+}
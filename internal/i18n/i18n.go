@@ -0,0 +1,104 @@
+// Package i18n translates what people read in API responses: error messages
+// and the system messages the server posts. Error codes and message keys
+// stay the same in every language, so clients that branch on them don't
+// care which one they got.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language messages are written in
+const DefaultLanguage = "en"
+
+// Languages lists the supported languages in a stable order for
+// capabilities
+var Languages = []string{"de", "en", "es", "fr"}
+
+// Supported reports whether lang is one of Languages
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Negotiate picks the supported language the Accept-Language header likes
+// best, or fallback if it likes none of them. Only primary tags count, so
+// de-AT gets de.
+func Negotiate(header, fallback string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !Supported(primary) {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			choices = append(choices, choice{primary, q})
+		}
+	}
+	if len(choices) == 0 {
+		return fallback
+	}
+
+	// Stable, so equal weights keep the client's order
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+// Error translates an error message: the message itself if it's a common
+// one, else the code's message for codes that say it all, else the message
+// is left in English
+func Error(lang, code, message string) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		return message
+	}
+	if translated, ok := c.messages[message]; ok {
+		return translated
+	}
+	if translated, ok := c.codes[code]; ok {
+		return translated
+	}
+	return message
+}
+
+// System messages, see System
+const (
+	SystemMemberJoined = "member_joined" // {username}
+	SystemMemberLeft   = "member_left"   // {username}
+	SystemRoomCreated  = "room_created"  // {username}, {room}
+)
+
+// System writes a system message in lang, filling in its {placeholders}
+// from args. Unknown languages get the default one.
+func System(lang, key string, args map[string]string) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		c = catalogs[DefaultLanguage]
+	}
+	template, ok := c.system[key]
+	if !ok {
+		template = catalogs[DefaultLanguage].system[key]
+	}
+	// One pass, so a username with braces in it stays as it is
+	pairs := make([]string, 0, 2*len(args))
+	for name, value := range args {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
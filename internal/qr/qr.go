@@ -0,0 +1,419 @@
+// Package qr draws QR codes, just enough of them for links: byte mode at
+// error correction level M, versions 1 to 10, which holds up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// ErrTooLong is returned for data that doesn't fit in a version 10 code
+var ErrTooLong = errors.New("qr: data is too long")
+
+// QuietZone is the light border around a code, in modules, that scanners
+// need to find it
+const QuietZone = 4
+
+// Code is a QR code, a square of dark and light modules
+type Code struct {
+	Size     int // modules per side
+	modules  [][]bool
+	reserved [][]bool // function patterns, which data and masks leave alone
+}
+
+// Dark reports whether the module at column x, row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// version describes the blocks of a version at level M
+type version struct {
+	ecPerBlock int   // error correction codewords per block
+	blocks     []int // data codewords of each block
+	alignment  []int // centers of alignment patterns, on both axes
+}
+
+var versions = [...]version{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v version) dataCodewords() int {
+	total := 0
+	for _, n := range v.blocks {
+		total += n
+	}
+	return total
+}
+
+// Encode makes the smallest code that holds data
+func Encode(data []byte) (*Code, error) {
+	for number := 1; number < len(versions); number++ {
+		countBits := 8
+		if number >= 10 {
+			countBits = 16
+		}
+		capacity := versions[number].dataCodewords() * 8
+		if 4+countBits+8*len(data) > capacity {
+			continue
+		}
+
+		var bits bitBuffer
+		bits.append(0b0100, 4) // byte mode
+		bits.append(len(data), countBits)
+		for _, b := range data {
+			bits.append(int(b), 8)
+		}
+		return build(number, bits.codewords(capacity/8)), nil
+	}
+	return nil, ErrTooLong
+}
+
+// bitBuffer collects the bits of the data codewords
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 == 1)
+	}
+}
+
+// codewords ends the data with a terminator and pads it to n codewords
+func (b bitBuffer) codewords(n int) []byte {
+	for i := 0; i < 4 && len(b) < n*8; i++ {
+		b = append(b, false)
+	}
+	out := make([]byte, 0, n)
+	for i := 0; i < len(b); i += 8 {
+		var codeword byte
+		for j := 0; j < 8; j++ {
+			codeword <<= 1
+			if i+j < len(b) && b[i+j] {
+				codeword |= 1
+			}
+		}
+		out = append(out, codeword)
+	}
+	for pad := byte(0xEC); len(out) < n; pad ^= 0xEC ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out
+}
+
+func build(number int, data []byte) *Code {
+	v := versions[number]
+	size := 17 + 4*number
+	c := &Code{Size: size, modules: make([][]bool, size), reserved: make([][]bool, size)}
+	for y := range c.modules {
+		c.modules[y] = make([]bool, size)
+		c.reserved[y] = make([]bool, size)
+	}
+
+	c.drawFunctionPatterns(number)
+	c.drawCodewords(interleave(v, data))
+
+	// Keep the mask that leaves the fewest patterns scanners trip over
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask) // undoes it
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c
+}
+
+// interleave splits data into blocks, adds each block's error correction
+// and interleaves them all into the final sequence of codewords
+func interleave(v version, data []byte) []byte {
+	var blocks, ecBlocks [][]byte
+	generator := rsGenerator(v.ecPerBlock)
+	for _, n := range v.blocks {
+		blocks = append(blocks, data[:n])
+		ecBlocks = append(ecBlocks, rsRemainder(data[:n], generator))
+		data = data[n:]
+	}
+
+	var out []byte
+	longest := v.blocks[len(v.blocks)-1]
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.reserved[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns(number int) {
+	size := c.Size
+
+	// Timing patterns, drawn first so the finders cover their ends
+	for i := 0; i < size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				distance := max(abs(dx), abs(dy))
+				c.set(x, y, distance != 2 && distance != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they'd overlap the finders
+	positions := versions[number].alignment
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas, drawn for real once there's a mask
+	c.drawFormat(0)
+
+	if number >= 7 {
+		rem := number
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := number<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information: level M and
+// the mask
+func (c *Code) drawFormat(mask int) {
+	const levelM = 0b00
+	data := levelM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	size := c.Size
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, size-15+i, bit(i))
+	}
+	c.set(8, size-8, true) // always dark
+}
+
+// drawCodewords fills the modules that aren't reserved, two columns at a
+// time in a zigzag from the bottom right. Modules left over stay light.
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.reserved[y][x] || i >= len(data)*8 {
+					continue
+				}
+				c.modules[y][x] = data[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules the mask picks; applying it twice
+// undoes it
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.reserved[y][x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the four rules of the standard: long runs,
+// 2x2 blocks, finder-like patterns and an uneven share of dark modules
+func (c *Code) penalty() int {
+	size := c.Size
+	penalty := 0
+
+	line := make([]bool, size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < size; a++ {
+			for b := 0; b < size; b++ {
+				if vertical {
+					line[b] = c.modules[b][a]
+				} else {
+					line[b] = c.modules[a][b]
+				}
+			}
+
+			run := 1
+			for b := 1; b <= size; b++ {
+				if b < size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for b := 0; b+11 <= size; b++ {
+				if matches(line[b:b+11], finderBefore) || matches(line[b:b+11], finderAfter) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				m := c.modules[y][x]
+				if c.modules[y][x+1] == m && c.modules[y+1][x] == m && c.modules[y+1][x+1] == m {
+					penalty += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (size * size)
+	penalty += abs(percent-50) / 5 * 10
+
+	return penalty
+}
+
+// The 1:1:3:1:1 finder pattern with four light modules on either side
+var (
+	finderBefore = []bool{false, false, false, false, true, false, true, true, true, false, true}
+	finderAfter  = []bool{true, false, true, true, true, false, true, false, false, false, false}
+)
+
+func matches(line, pattern []bool) bool {
+	for i := range pattern {
+		if line[i] != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Image draws the code black on white with a quiet zone, scale pixels to a
+// module
+func (c *Code) Image(scale int) image.Image {
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				row := img.Pix[((y+QuietZone)*scale+dy)*img.Stride:]
+				for dx := 0; dx < scale; dx++ {
+					row[(x+QuietZone)*scale+dx] = 1
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,56 @@
+package qr
+
+// Reed-Solomon error correction over GF(256) with the QR polynomial
+// x^8 + x^4 + x^3 + x^2 + 1
+
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	gfExp[255] = gfExp[0]
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+// rsGenerator is the generator polynomial for n error correction codewords,
+// the product of (x - 2^i) for i below n, highest coefficient first
+func rsGenerator(n int) []byte {
+	generator := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(generator)+1)
+		for j, coefficient := range generator {
+			next[j] ^= coefficient
+			next[j+1] ^= gfMul(coefficient, gfExp[i])
+		}
+		generator = next
+	}
+	return generator
+}
+
+// rsRemainder is the error correction of data: the remainder of dividing
+// it, shifted up by the generator's degree, by the generator
+func rsRemainder(data, generator []byte) []byte {
+	remainder := make([]byte, len(generator)-1)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[len(remainder)-1] = 0
+		for i := range remainder {
+			remainder[i] ^= gfMul(generator[i+1], factor)
+		}
+	}
+	return remainder
+}
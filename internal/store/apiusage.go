@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// APIUsage is how many authenticated API requests a user made on one UTC day
+type APIUsage struct {
+	UserID   int        `json:"user_id"`
+	Day      string     `json:"day"`
+	Requests int        `json:"requests"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+// APIUsageDay is the UTC day, as "2006-01-02", that a request at t counts
+// towards
+func APIUsageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// RecordAPIRequest counts a request by the user at now, unless they've
+// already made quota of them that day (0 for no limit). It returns their
+// count for the day and whether this request was counted.
+func (d *Database) RecordAPIRequest(ctx context.Context, userID int, now time.Time, quota int) (int, bool, error) {
+	day := APIUsageDay(now)
+
+	var requests int
+	err := d.db.QueryRowContext(ctx, `
+		INSERT INTO api_usage (user_id, day, requests, last_used) VALUES (?, ?, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, day) DO UPDATE SET requests = api_usage.requests + 1, last_used = CURRENT_TIMESTAMP
+		WHERE ? <= 0 OR api_usage.requests < ?
+		RETURNING requests
+	`, userID, day, quota, quota).Scan(&requests)
+	if err == sql.ErrNoRows {
+		// Over quota, so the update didn't happen
+		usage, err := d.GetAPIUsage(ctx, userID, now)
+		return usage.Requests, false, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return requests, true, nil
+}
+
+// GetAPIUsage returns the user's request count for the UTC day of at
+func (d *Database) GetAPIUsage(ctx context.Context, userID int, at time.Time) (APIUsage, error) {
+	usage := APIUsage{UserID: userID, Day: APIUsageDay(at)}
+
+	var lastUsed sql.NullTime
+	err := d.db.QueryRowContext(ctx,
+		"SELECT requests, last_used FROM api_usage WHERE user_id = ? AND day = ?",
+		userID, usage.Day,
+	).Scan(&usage.Requests, &lastUsed)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+	if lastUsed.Valid {
+		usage.LastUsed = &lastUsed.Time
+	}
+	return usage, nil
+}
+
+// PruneAPIUsage deletes the counts for UTC days before the one of before
+func (d *Database) PruneAPIUsage(ctx context.Context, before time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM api_usage WHERE day < ?", APIUsageDay(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
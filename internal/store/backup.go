@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backups copy backupStepPages pages at a time, pausing backupStepPause in
+// between so writers aren't held up for the whole copy
+const (
+	backupStepPages = 1024
+	backupStepPause = 10 * time.Millisecond
+)
+
+// Backup writes a consistent snapshot of the database to a new SQLite file
+// at path with SQLite's online backup API, while it stays in use. A write
+// from another connection mid-copy makes SQLite start over, so the file is
+// always as of one moment. The snapshot is built next to path and only
+// renamed into place once it's complete.
+func (d *Database) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New("backup file already exists: " + path)
+	}
+	// Left over from a backup that was cut short
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := d.backupTo(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *Database) backupTo(ctx context.Context, path string) error {
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	src, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return dest.Raw(func(destConn interface{}) error {
+		return src.Raw(func(srcConn interface{}) error {
+			destSQLite, ok := destConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup needs the sqlite3 driver")
+			}
+			srcSQLite, ok := srcConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup needs the sqlite3 driver")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := backup.Step(backupStepPages)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				select {
+				case <-ctx.Done():
+					backup.Finish()
+					return ctx.Err()
+				case <-time.After(backupStepPause):
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Kinds of message component
+const (
+	ComponentButton = "button"
+	ComponentSelect = "select"
+)
+
+// Button styles; a button without one is ComponentStyleSecondary
+const (
+	ComponentStylePrimary   = "primary"
+	ComponentStyleSecondary = "secondary"
+	ComponentStyleSuccess   = "success"
+	ComponentStyleDanger    = "danger"
+)
+
+// Limits on the components of one message
+const (
+	MaxMessageComponents   = 10
+	MaxComponentOptions    = 25
+	maxComponentCustomID   = 100 // bytes
+	maxComponentLabel      = 80  // characters
+	maxComponentOptionText = 100 // characters, for option values and descriptions
+)
+
+// MessageComponent is a button or select menu on a message. Using one
+// doesn't change the message, it tells whoever posted it, who can then
+// reply or update the components.
+type MessageComponent struct {
+	Type        string            `json:"type"`
+	CustomID    string            `json:"custom_id"` // the poster's own name for it, unique in the message
+	Label       string            `json:"label,omitempty"`
+	Style       string            `json:"style,omitempty"` // buttons only
+	Disabled    bool              `json:"disabled,omitempty"`
+	Placeholder string            `json:"placeholder,omitempty"` // selects only
+	Options     []ComponentOption `json:"options,omitempty"`
+	MinValues   int               `json:"min_values,omitempty"`
+	MaxValues   int               `json:"max_values,omitempty"`
+}
+
+// ComponentOption is one choice in a select menu
+type ComponentOption struct {
+	Label       string `json:"label"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// ValidateComponents checks components posted with a message and fills in
+// the defaults: secondary buttons, and selects that take exactly one value
+func ValidateComponents(components []MessageComponent) error {
+	if len(components) > MaxMessageComponents {
+		return fmt.Errorf("messages can have at most %d components", MaxMessageComponents)
+	}
+
+	customIDs := make(map[string]bool, len(components))
+	for i := range components {
+		c := &components[i]
+		if c.CustomID == "" || len(c.CustomID) > maxComponentCustomID {
+			return fmt.Errorf("custom_id must be 1 to %d bytes", maxComponentCustomID)
+		}
+		if customIDs[c.CustomID] {
+			return fmt.Errorf("custom_id %q is used twice", c.CustomID)
+		}
+		customIDs[c.CustomID] = true
+		if utf8.RuneCountInString(c.Label) > maxComponentLabel || utf8.RuneCountInString(c.Placeholder) > maxComponentLabel {
+			return fmt.Errorf("labels are limited to %d characters", maxComponentLabel)
+		}
+
+		switch c.Type {
+		case ComponentButton:
+			if c.Label == "" {
+				return fmt.Errorf("button %q needs a label", c.CustomID)
+			}
+			if len(c.Options) > 0 || c.Placeholder != "" || c.MinValues != 0 || c.MaxValues != 0 {
+				return fmt.Errorf("button %q can't have options", c.CustomID)
+			}
+			switch c.Style {
+			case "":
+				c.Style = ComponentStyleSecondary
+			case ComponentStylePrimary, ComponentStyleSecondary, ComponentStyleSuccess, ComponentStyleDanger:
+			default:
+				return fmt.Errorf("unknown button style %q", c.Style)
+			}
+		case ComponentSelect:
+			if c.Style != "" {
+				return fmt.Errorf("select %q can't have a style", c.CustomID)
+			}
+			if err := validateComponentOptions(c); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown component type %q", c.Type)
+		}
+	}
+	return nil
+}
+
+func validateComponentOptions(c *MessageComponent) error {
+	if len(c.Options) == 0 || len(c.Options) > MaxComponentOptions {
+		return fmt.Errorf("select %q needs 1 to %d options", c.CustomID, MaxComponentOptions)
+	}
+
+	values := make(map[string]bool, len(c.Options))
+	for _, option := range c.Options {
+		if option.Label == "" || option.Value == "" {
+			return fmt.Errorf("options of select %q need a label and a value", c.CustomID)
+		}
+		if utf8.RuneCountInString(option.Label) > maxComponentLabel ||
+			utf8.RuneCountInString(option.Value) > maxComponentOptionText ||
+			utf8.RuneCountInString(option.Description) > maxComponentOptionText {
+			return fmt.Errorf("an option of select %q is too long", c.CustomID)
+		}
+		if values[option.Value] {
+			return fmt.Errorf("select %q has the value %q twice", c.CustomID, option.Value)
+		}
+		values[option.Value] = true
+	}
+
+	if c.MinValues == 0 && c.MaxValues == 0 {
+		c.MinValues, c.MaxValues = 1, 1
+	}
+	if c.MinValues < 0 || c.MaxValues < 1 || c.MinValues > c.MaxValues || c.MaxValues > len(c.Options) {
+		return fmt.Errorf("select %q needs 0 <= min_values <= max_values <= its options, and max_values >= 1", c.CustomID)
+	}
+	return nil
+}
+
+// FindComponent returns the message's component with customID, nil if it
+// has none
+func (m *Message) FindComponent(customID string) *MessageComponent {
+	for i := range m.Components {
+		if m.Components[i].CustomID == customID {
+			return &m.Components[i]
+		}
+	}
+	return nil
+}
+
+// CheckValues checks the values someone picked in a select, or that there
+// are none for a button
+func (c *MessageComponent) CheckValues(values []string) error {
+	if c.Type == ComponentButton {
+		if len(values) > 0 {
+			return fmt.Errorf("buttons don't take values")
+		}
+		return nil
+	}
+
+	if len(values) < c.MinValues || len(values) > c.MaxValues {
+		return fmt.Errorf("pick between %d and %d values", c.MinValues, c.MaxValues)
+	}
+	picked := make(map[string]bool, len(values))
+	for _, value := range values {
+		found := false
+		for _, option := range c.Options {
+			if option.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found || picked[value] {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		picked[value] = true
+	}
+	return nil
+}
+
+// encodeComponents is how components are kept in messages.components
+func encodeComponents(components []MessageComponent) (string, error) {
+	if len(components) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(components)
+	return string(encoded), err
+}
+
+func decodeComponents(encoded string) ([]MessageComponent, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var components []MessageComponent
+	err := json.Unmarshal([]byte(encoded), &components)
+	return components, err
+}
+
+// SetMessageComponents replaces a message's components, or removes them if
+// components is empty
+func (d *Database) SetMessageComponents(ctx context.Context, message *Message, components []MessageComponent) error {
+	encoded, err := encodeComponents(components)
+	if err != nil {
+		return err
+	}
+	if _, err := d.db.ExecContext(ctx, "UPDATE messages SET components = ? WHERE id = ?", encoded, message.ID); err != nil {
+		return err
+	}
+	d.cache.InvalidateRoom(message.RoomID)
+	return nil
+}
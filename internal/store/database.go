@@ -0,0 +1,3385 @@
+// Package store keeps everything in SQLite: the schema and its migrations,
+// the models and the queries on them, and the caches in front of them.
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Database struct {
+	db      *sql.DB
+	owned   bool // opened by NewDatabase rather than handed to Wrap
+	stmts   stmtCache
+	cache   *RoomCache // nil when message caching is off
+	ids     *IDGenerator
+	journal *Journal // nil when messages aren't journaled
+	logger  *log.Logger
+}
+
+// sqliteTimeFormat is how times are bound, always in UTC. It's the format
+// CURRENT_TIMESTAMP and the column defaults store rather than RFC 3339, so
+// every value in a column has one layout: comparing and ordering them as
+// text is comparing them as times, SQLite's date functions read them, and
+// the driver parses them back into time.Time. RFC 3339 is only for the API.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// Errors for names that are already taken, so handlers don't have to pick
+// apart driver errors
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrRoomNameTaken = errors.New("room name already taken in this hall")
+)
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// Options says which SQLite file NewDatabase opens and how
+type Options struct {
+	Path            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration // 0 keeps connections forever
+	CacheRooms      int           // rooms in the message cache, 0 turns it off
+	NodeID          int           // makes IDs unique between instances, 0 to MaxNodeID
+	Journal         *Journal      // nil doesn't journal messages; Close leaves it open
+	Logger          *log.Logger   // nil logs to the standard logger
+}
+
+func NewDatabase(opts Options) (*Database, error) {
+	db, err := sql.Open("sqlite3", utcDSN(opts.Path))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+
+	d := Wrap(db, opts)
+	d.owned = true
+	return d, nil
+}
+
+// utcDSN has the driver hand back times in UTC, whatever zone they were
+// stored in
+func utcDSN(path string) string {
+	if strings.Contains(path, "?") {
+		return path + "&_loc=UTC"
+	}
+	return path + "?_loc=UTC"
+}
+
+// Wrap uses a database the caller opened, like an in-memory one for tests.
+// Of opts only CacheRooms, NodeID, Journal and Logger apply, and Close
+// leaves db open.
+func Wrap(db *sql.DB, opts Options) *Database {
+	d := &Database{db: db, ids: NewIDGenerator(opts.NodeID), journal: opts.Journal, logger: opts.Logger}
+	if d.logger == nil {
+		d.logger = log.Default()
+	}
+	if opts.CacheRooms > 0 {
+		d.cache = NewRoomCache(opts.CacheRooms)
+	}
+	return d
+}
+
+func (d *Database) CreateUser(ctx context.Context, username, password string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	id := d.ids.Next()
+	_, err = d.db.ExecContext(ctx,
+		"INSERT INTO users (id, username, password_hash) VALUES (?, ?, ?)",
+		id, username, string(hashedPassword),
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetUserByID(ctx, id)
+}
+
+func (d *Database) UpdatePassword(ctx context.Context, userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE id = ?", string(hashedPassword), userID)
+	return err
+}
+
+func (d *Database) AuthenticateUser(ctx context.Context, username, password string) (*User, error) {
+	user, err := d.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	d.UpdateUserLastSeen(ctx, user.ID)
+	return user, nil
+}
+
+func (d *Database) GetUserByID(ctx context.Context, userID int) (*User, error) {
+	user := &User{}
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, username, password_hash, created_at, last_seen FROM users WHERE id = ?",
+		userID,
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt, &user.LastSeen)
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (d *Database) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, username, password_hash, created_at, last_seen FROM users WHERE username = ?",
+		username,
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt, &user.LastSeen)
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResolveUsername finds a user by their username or, failing that, by one
+// they renamed away from since renamedSince, so lookups by an old name keep
+// working for a while
+func (d *Database) ResolveUsername(ctx context.Context, username string, renamedSince time.Time) (*User, error) {
+	user, err := d.GetUserByUsername(ctx, username)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	var userID int
+	err = d.db.QueryRowContext(ctx, `
+		SELECT user_id FROM username_history
+		WHERE LOWER(old_username) = LOWER(?) AND changed_at > ?
+		ORDER BY changed_at DESC, id DESC LIMIT 1
+	`, username, renamedSince.UTC().Format(sqliteTimeFormat)).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetUserByID(ctx, userID)
+}
+
+// IsUsernameReserved reports whether someone other than exceptUserID gave
+// up username since reservedSince, which keeps it from being taken while
+// it still resolves to them
+func (d *Database) IsUsernameReserved(ctx context.Context, username string, exceptUserID int, reservedSince time.Time) (bool, error) {
+	var reserved bool
+	err := d.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM username_history
+			WHERE LOWER(old_username) = LOWER(?) AND user_id != ? AND changed_at > ?
+		)
+	`, username, exceptUserID, reservedSince.UTC().Format(sqliteTimeFormat)).Scan(&reserved)
+	return reserved, err
+}
+
+// RenameUser changes a user's username, recording the old one, and returns
+// the old one
+func (d *Database) RenameUser(ctx context.Context, userID int, username string) (string, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldUsername string
+	if err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = ?", userID).Scan(&oldUsername); err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET username = ? WHERE id = ?", username, userID)
+	if isUniqueViolation(err) {
+		return "", ErrUsernameTaken
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO username_history (user_id, old_username) VALUES (?, ?)",
+		userID, oldUsername,
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	// Cached messages carry the old name
+	d.cache.InvalidateMessages()
+	return oldUsername, nil
+}
+
+// GetUsernameHistory lists a user's renames since the given time, newest
+// first
+func (d *Database) GetUsernameHistory(ctx context.Context, userID int, since time.Time) ([]UsernameChange, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT old_username, changed_at FROM username_history
+		WHERE user_id = ? AND changed_at > ?
+		ORDER BY changed_at DESC, id DESC
+	`, userID, since.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]UsernameChange, 0)
+	for rows.Next() {
+		var change UsernameChange
+		if err := rows.Scan(&change.OldUsername, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// LastUsernameChange returns when the user last renamed themselves, or nil
+// if they never did
+func (d *Database) LastUsernameChange(ctx context.Context, userID int) (*time.Time, error) {
+	var changedAt time.Time
+	err := d.db.QueryRowContext(ctx,
+		"SELECT changed_at FROM username_history WHERE user_id = ? ORDER BY changed_at DESC, id DESC LIMIT 1",
+		userID,
+	).Scan(&changedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &changedAt, nil
+}
+
+// GetHallmateIDs returns everyone who shares a hall with the user, the user
+// included
+func (d *Database) GetHallmateIDs(ctx context.Context, userID int) ([]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT DISTINCT other.user_id FROM hall_members own
+		JOIN hall_members other ON other.hall_id = own.hall_id
+		WHERE own.user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (d *Database) UpdateUserLastSeen(ctx context.Context, userID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE users SET last_seen = CURRENT_TIMESTAMP WHERE id = ?",
+		userID,
+	)
+	return err
+}
+
+// UpdateUsersLastSeen writes several users' last_seen times in one
+// transaction, see ws.LastSeenBuffer
+func (d *Database) UpdateUsersLastSeen(ctx context.Context, seen map[int]time.Time) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "UPDATE users SET last_seen = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for userID, at := range seen {
+		if _, err := stmt.ExecContext(ctx, at.UTC().Format(sqliteTimeFormat), userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GenerateInviteCode() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func (d *Database) CreateHall(ctx context.Context, name string, ownerID int) (*Hall, error) {
+	inviteCode, err := GenerateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	id := d.ids.Next()
+	_, err = d.db.ExecContext(ctx,
+		"INSERT INTO halls (id, name, invite_code, owner_id) VALUES (?, ?, ?, ?)",
+		id, name, inviteCode, ownerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add owner as member
+	_, err = d.db.ExecContext(ctx,
+		"INSERT INTO hall_members (hall_id, user_id) VALUES (?, ?)",
+		id, ownerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetHallByID(ctx, id)
+}
+
+// CountOwnedHalls is how many halls the user owns, for the owned halls quota
+func (d *Database) CountOwnedHalls(ctx context.Context, userID int) (int, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM halls WHERE owner_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// GetHallUsage counts what the hall quotas apply to
+func (d *Database) GetHallUsage(ctx context.Context, hallID int) (HallUsage, error) {
+	var usage HallUsage
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM rooms WHERE hall_id = ?1),
+			(SELECT COUNT(*) FROM hall_members WHERE hall_id = ?1)
+	`, hallID).Scan(&usage.Rooms, &usage.Members)
+	return usage, err
+}
+
+// GetHallAttachmentBytes adds up the sizes the image and file messages in
+// the hall's rooms give
+func (d *Database) GetHallAttachmentBytes(ctx context.Context, hallID int) (int64, error) {
+	var total int64
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(json_extract(m.payload, '$.size')), 0)
+		FROM messages m
+		JOIN rooms r ON r.id = m.room_id
+		WHERE r.hall_id = ? AND m.kind IN ('image', 'file')
+	`, hallID).Scan(&total)
+	return total, err
+}
+
+func (d *Database) GetHallByID(ctx context.Context, hallID int) (*Hall, error) {
+	hall := &Hall{}
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, invite_code, owner_id, created_at FROM halls WHERE id = ?",
+		hallID,
+	).Scan(&hall.ID, &hall.Name, &hall.InviteCode, &hall.OwnerID, &hall.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return hall, nil
+}
+
+func (d *Database) GetHallByInviteCode(ctx context.Context, inviteCode string) (*Hall, error) {
+	hall := &Hall{}
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, invite_code, owner_id, created_at FROM halls WHERE invite_code = ?",
+		inviteCode,
+	).Scan(&hall.ID, &hall.Name, &hall.InviteCode, &hall.OwnerID, &hall.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return hall, nil
+}
+
+func (d *Database) JoinHall(ctx context.Context, userID int, inviteCode string) error {
+	hall, err := d.GetHallByInviteCode(ctx, inviteCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO hall_members (hall_id, user_id) VALUES (?, ?)",
+		hall.ID, userID,
+	)
+	return err
+}
+
+func (d *Database) LeaveHall(ctx context.Context, userID int, hallID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM hall_members WHERE hall_id = ? AND user_id = ?",
+		hallID, userID,
+	)
+	return err
+}
+
+func (d *Database) GetUserHalls(ctx context.Context, userID int) ([]Hall, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT h.id, h.name, h.invite_code, h.owner_id, h.created_at 
+		FROM halls h 
+		JOIN hall_members hm ON h.id = hm.hall_id 
+		WHERE hm.user_id = ?
+		ORDER BY h.created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	halls := make([]Hall, 0) // Initialize as empty slice, not nil
+	for rows.Next() {
+		var hall Hall
+		err := rows.Scan(&hall.ID, &hall.Name, &hall.InviteCode, &hall.OwnerID, &hall.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		halls = append(halls, hall)
+	}
+	return halls, nil
+}
+
+// ListUserHalls is GetUserHalls a page at a time, optionally filtered by a
+// name substring. It also returns how many halls match the filter, and the
+// cursor of the next page, 0 on the last one.
+func (d *Database) ListUserHalls(ctx context.Context, userID int, filter ListFilter) ([]Hall, int, int, error) {
+	var total int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM halls h
+		JOIN hall_members hm ON h.id = hm.hall_id
+		WHERE hm.user_id = ? AND h.name LIKE '%' || ? || '%'
+	`, userID, filter.Name).Scan(&total)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := `
+		SELECT h.id, h.name, h.invite_code, h.owner_id, h.created_at
+		FROM halls h
+		JOIN hall_members hm ON h.id = hm.hall_id
+		WHERE hm.user_id = ? AND h.name LIKE '%' || ? || '%'
+	`
+	args := []interface{}{userID, filter.Name}
+	if filter.Cursor > 0 {
+		query += " AND (h.created_at, h.id) < (SELECT created_at, id FROM halls WHERE id = ?)"
+		args = append(args, filter.Cursor)
+	}
+	query += " ORDER BY h.created_at DESC, h.id DESC"
+	if filter.Limit > 0 {
+		// One extra row tells us whether there's another page
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	halls := make([]Hall, 0)
+	for rows.Next() {
+		var hall Hall
+		if err := rows.Scan(&hall.ID, &hall.Name, &hall.InviteCode, &hall.OwnerID, &hall.CreatedAt); err != nil {
+			return nil, 0, 0, err
+		}
+		halls = append(halls, hall)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	nextCursor := 0
+	if filter.Limit > 0 && len(halls) > filter.Limit {
+		halls = halls[:filter.Limit]
+		nextCursor = halls[len(halls)-1].ID
+	}
+	return halls, total, nextCursor, nil
+}
+
+func (d *Database) CreateRoom(ctx context.Context, hallID int, name string, roomType string) (*Room, error) {
+	id := d.ids.Next()
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO rooms (id, hall_id, name, type) VALUES (?, ?, ?, ?)",
+		id, hallID, name, roomType,
+	)
+	if isUniqueViolation(err) {
+		return nil, ErrRoomNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetRoomByID(ctx, id)
+}
+
+const roomColumns = `
+	r.id, r.hall_id, r.name, r.type, r.created_at, COALESCE(rs.archived, 0),
+	COALESCE(rs.announcement, 0), COALESCE(rs.message_ttl_seconds, 0),
+	COALESCE(rs.public, 0), COALESCE(rs.topic, ''), re.expires_at, COALESCE(re.on_expiry, '')
+	FROM rooms r
+	LEFT JOIN room_settings rs ON rs.room_id = r.id
+	LEFT JOIN room_expiry re ON re.room_id = r.id
+`
+
+func scanRoom(scanner interface{ Scan(...interface{}) error }) (*Room, error) {
+	room := &Room{}
+	var expiresAt sql.NullTime
+	err := scanner.Scan(&room.ID, &room.HallID, &room.Name, &room.Type, &room.CreatedAt, &room.Archived, &room.Announcement, &room.MessageTTL, &room.Public, &room.Topic, &expiresAt, &room.OnExpiry)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		room.ExpiresAt = &expiresAt.Time
+	}
+	return room, nil
+}
+
+func (d *Database) GetRoomByID(ctx context.Context, roomID int) (*Room, error) {
+	stmt, err := d.stmt(ctx, queryRoomByID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRoom(stmt.QueryRowContext(ctx, roomID))
+}
+
+// GetHallRooms lists a hall's rooms; archived rooms are left out unless
+// includeArchived is set
+func (d *Database) GetHallRooms(ctx context.Context, hallID int, includeArchived bool) ([]Room, error) {
+	query := "SELECT " + roomColumns + " WHERE r.hall_id = ?"
+	if !includeArchived {
+		query += " AND COALESCE(rs.archived, 0) = 0"
+	}
+
+	rows, err := d.db.QueryContext(ctx, query+" ORDER BY r.created_at ASC", hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]Room, 0) // Initialize as empty slice, not nil
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+	return rooms, nil
+}
+
+// ListHallRooms is GetHallRooms a page at a time, optionally filtered by a
+// name substring. Like ListUserHalls it also returns the number of matching
+// rooms and the next page's cursor.
+func (d *Database) ListHallRooms(ctx context.Context, hallID int, includeArchived bool, filter ListFilter) ([]Room, int, int, error) {
+	where := " WHERE r.hall_id = ? AND r.name LIKE '%' || ? || '%'"
+	if !includeArchived {
+		where += " AND COALESCE(rs.archived, 0) = 0"
+	}
+	args := []interface{}{hallID, filter.Name}
+
+	var total int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM rooms r
+		LEFT JOIN room_settings rs ON rs.room_id = r.id
+	`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := "SELECT " + roomColumns + where
+	if filter.Cursor > 0 {
+		query += " AND (r.created_at, r.id) > (SELECT created_at, id FROM rooms WHERE id = ?)"
+		args = append(args, filter.Cursor)
+	}
+	query += " ORDER BY r.created_at ASC, r.id ASC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	rooms := make([]Room, 0)
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		rooms = append(rooms, *room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	nextCursor := 0
+	if filter.Limit > 0 && len(rooms) > filter.Limit {
+		rooms = rooms[:filter.Limit]
+		nextCursor = rooms[len(rooms)-1].ID
+	}
+	return rooms, total, nextCursor, nil
+}
+
+func (d *Database) IsUserInHall(ctx context.Context, userID, hallID int) (bool, error) {
+	stmt, err := d.stmt(ctx, queryIsUserInHall)
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = stmt.QueryRowContext(ctx, userID, hallID).Scan(&count)
+	return count > 0, err
+}
+
+// notExpired leaves out self-destructing messages (aliased m) that are past
+// their time but haven't been deleted yet
+const notExpired = "(m.expires_at IS NULL OR m.expires_at > CURRENT_TIMESTAMP)"
+
+// SaveMessage stores one message. The write's nonce is the sender's
+// idempotency key, empty if they didn't send one; storing a second message
+// with the same nonce fails. A self-destructing message gets an ExpiresAt,
+// nil keeps it until it's deleted some other way.
+func (d *Database) SaveMessage(ctx context.Context, write MessageWrite) (*Message, error) {
+	var expires interface{}
+	if write.ExpiresAt != nil {
+		expires = write.ExpiresAt.UTC().Format(sqliteTimeFormat)
+	}
+
+	messageType, kind := write.typeAndKind()
+	components, err := encodeComponents(write.Components)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := d.stmt(ctx, querySaveMessage)
+	if err != nil {
+		return nil, err
+	}
+	id := d.ids.Next()
+	_, err = stmt.ExecContext(ctx,
+		id, write.RoomID, write.UserID, write.Content, messageType, kind, string(write.Payload),
+		sql.NullString{String: write.Nonce, Valid: write.Nonce != ""}, expires, components,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := d.GetMessageByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.AddMessage(*message)
+	d.journal.append(JournalEntry{Message: message})
+	return message, nil
+}
+
+// SaveMessages stores several messages in one transaction, in order, and
+// returns them as stored. If any of them can't be stored, none are.
+func (d *Database) SaveMessages(ctx context.Context, writes []MessageWrite) ([]Message, error) {
+	stmt, err := d.stmt(ctx, querySaveMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert := tx.StmtContext(ctx, stmt)
+	ids := make([]interface{}, 0, len(writes))
+	for _, write := range writes {
+		var expires interface{}
+		if write.ExpiresAt != nil {
+			expires = write.ExpiresAt.UTC().Format(sqliteTimeFormat)
+		}
+
+		messageType, kind := write.typeAndKind()
+		components, err := encodeComponents(write.Components)
+		if err != nil {
+			return nil, err
+		}
+
+		id := d.ids.Next()
+		_, err = insert.ExecContext(ctx,
+			id, write.RoomID, write.UserID, write.Content, messageType, kind, string(write.Payload),
+			sql.NullString{String: write.Nonce, Valid: write.Nonce != ""}, expires, components,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := tx.QueryContext(ctx, messageSelect+`
+		WHERE m.id IN (`+placeholders+`)
+		ORDER BY m.id ASC
+	`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) != len(writes) {
+		return nil, fmt.Errorf("stored %d messages but read back %d", len(writes), len(messages))
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for i, message := range messages {
+		d.cache.AddMessage(message)
+		d.journal.append(JournalEntry{Message: &messages[i]})
+	}
+	return messages, nil
+}
+
+// SaveSystemMessage writes a message into a room as the system user. payload
+// is its SystemPayload, nil for none.
+func (d *Database) SaveSystemMessage(ctx context.Context, roomID int, content string, payload *SystemPayload, expiresAt *time.Time) (*Message, error) {
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format(sqliteTimeFormat)
+	}
+
+	var encoded string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+
+	var id int
+	err := d.db.QueryRowContext(ctx, `
+		INSERT INTO messages (id, room_id, user_id, content, type, kind, payload, expires_at)
+		SELECT ?, ?, id, ?, ?, ?, ?, ? FROM users WHERE username = 'system'
+		RETURNING id
+	`, d.ids.Next(), roomID, content, MessageTypeSystem, MessageKindSystem, encoded, expires).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := d.GetMessageByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.AddMessage(*message)
+	d.journal.append(JournalEntry{Message: message})
+	return message, nil
+}
+
+func (d *Database) GetMessageByID(ctx context.Context, messageID int) (*Message, error) {
+	stmt, err := d.stmt(ctx, queryMessageByID)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{}
+	var expiresAt sql.NullTime
+	var payload, components string
+	err = stmt.QueryRowContext(ctx, messageID).Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Content, &message.Type,
+		&message.Kind, &payload, &message.CreatedAt, &expiresAt, &components)
+
+	if err != nil {
+		return nil, err
+	}
+	message.Payload = rawPayload(payload)
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+	if message.Components, err = decodeComponents(components); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// messageSelect is the start of queries scanned by scanMessages
+const messageSelect = `
+	SELECT m.id, m.room_id, m.user_id, u.username, m.content, m.type, m.kind, m.payload, m.created_at, m.expires_at, m.components
+	FROM messages m
+	JOIN users u ON m.user_id = u.id
+`
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+
+	messages := make([]Message, 0) // Initialize as empty slice, not nil
+	for rows.Next() {
+		var message Message
+		var expiresAt sql.NullTime
+		var payload, components string
+		err := rows.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Content, &message.Type,
+			&message.Kind, &payload, &message.CreatedAt, &expiresAt, &components)
+		if err != nil {
+			return nil, err
+		}
+		message.Payload = rawPayload(payload)
+		if expiresAt.Valid {
+			message.ExpiresAt = &expiresAt.Time
+		}
+		if message.Components, err = decodeComponents(components); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// reverseMessages turns newest-first query results into chronological order
+func reverseMessages(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// GetRoomMessages returns a page of a room's history, oldest first, counted
+// back offset messages from the newest. Pages among the newest
+// roomCacheMessages come from the room cache when it's on.
+func (d *Database) GetRoomMessages(ctx context.Context, roomID int, limit int, offset int) ([]Message, error) {
+	if messages, ok := d.cache.Messages(roomID, limit, offset); ok {
+		return messages, nil
+	}
+	if d.cache != nil && offset+limit <= roomCacheMessages {
+		gen := d.cache.beginLoad(roomID)
+		newest, err := d.queryRoomMessages(ctx, roomID, roomCacheMessages, 0)
+		if err != nil {
+			return nil, err
+		}
+		d.cache.storeMessages(roomID, gen, newest, len(newest) < roomCacheMessages)
+		return messagePage(newest, limit, offset), nil
+	}
+	return d.queryRoomMessages(ctx, roomID, limit, offset)
+}
+
+func (d *Database) queryRoomMessages(ctx context.Context, roomID int, limit int, offset int) ([]Message, error) {
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		WHERE m.room_id = ? AND `+notExpired+`
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT ? OFFSET ?
+	`, roomID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(messages)
+	return messages, nil
+}
+
+// GetRoomMessagesAfter returns up to limit messages with IDs above afterID,
+// oldest first, for walking a room's history in batches
+func (d *Database) GetRoomMessagesAfter(ctx context.Context, roomID, afterID, limit int) ([]Message, error) {
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		WHERE m.room_id = ? AND m.id > ? AND `+notExpired+`
+		ORDER BY m.id ASC
+		LIMIT ?
+	`, roomID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// GetRoomMessagesAround returns up to limit messages centred on messageID,
+// in chronological order: about half from before it, then the message and
+// what follows
+func (d *Database) GetRoomMessagesAround(ctx context.Context, roomID, messageID, limit int) ([]Message, error) {
+	before := limit / 2
+
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		WHERE m.room_id = ? AND m.id < ? AND `+notExpired+`
+		ORDER BY m.id DESC
+		LIMIT ?
+	`, roomID, messageID, before)
+	if err != nil {
+		return nil, err
+	}
+	older, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	reverseMessages(older)
+
+	rows, err = d.db.QueryContext(ctx, messageSelect+`
+		WHERE m.room_id = ? AND m.id >= ? AND `+notExpired+`
+		ORDER BY m.id ASC
+		LIMIT ?
+	`, roomID, messageID, limit-len(older))
+	if err != nil {
+		return nil, err
+	}
+	newer, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(older, newer...), nil
+}
+
+// CreateRegistrationInvite makes a server-level invite that can be used
+// maxUses times (0 for unlimited) until expiresAt, if given
+func (d *Database) CreateRegistrationInvite(ctx context.Context, createdBy, maxUses int, expiresAt *time.Time) (*RegistrationInvite, error) {
+	token, err := GenerateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format(sqliteTimeFormat)
+	}
+
+	_, err = d.db.ExecContext(ctx,
+		"INSERT INTO registration_invites (token, created_by, max_uses, expires_at) VALUES (?, ?, ?, ?)",
+		token, createdBy, maxUses, expires,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetRegistrationInvite(ctx, token)
+}
+
+const registrationInviteColumns = "token, created_by, max_uses, uses, expires_at, created_at FROM registration_invites"
+
+func scanRegistrationInvite(scanner interface{ Scan(...interface{}) error }) (*RegistrationInvite, error) {
+	invite := &RegistrationInvite{}
+	var expiresAt sql.NullTime
+	err := scanner.Scan(&invite.Token, &invite.CreatedBy, &invite.MaxUses, &invite.Uses, &expiresAt, &invite.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	return invite, nil
+}
+
+func (d *Database) GetRegistrationInvite(ctx context.Context, token string) (*RegistrationInvite, error) {
+	return scanRegistrationInvite(d.db.QueryRowContext(ctx, "SELECT "+registrationInviteColumns+" WHERE token = ?", token))
+}
+
+// GetRegistrationInvites lists every server-level invite, used up and
+// expired ones included, newest first
+func (d *Database) GetRegistrationInvites(ctx context.Context) ([]RegistrationInvite, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+registrationInviteColumns+" ORDER BY created_at DESC, token")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := make([]RegistrationInvite, 0)
+	for rows.Next() {
+		invite, err := scanRegistrationInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// UseRegistrationInvite takes one use of an invite, and reports false if it
+// doesn't exist, is used up or expired
+func (d *Database) UseRegistrationInvite(ctx context.Context, token string) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE registration_invites SET uses = uses + 1
+		WHERE token = ? AND (max_uses = 0 OR uses < max_uses)
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+	`, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseRegistrationInvite gives back a use taken by a registration that
+// then failed
+func (d *Database) ReleaseRegistrationInvite(ctx context.Context, token string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE registration_invites SET uses = uses - 1 WHERE token = ? AND uses > 0",
+		token,
+	)
+	return err
+}
+
+func (d *Database) DeleteRegistrationInvite(ctx context.Context, token string) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM registration_invites WHERE token = ?", token)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// EnsureSystemUser creates the system user, who owns the default hall and
+// writes system messages, unless it exists
+func (d *Database) EnsureSystemUser(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO users (id, username, password_hash) 
+		VALUES (?, 'system', '$2a$10$dummy.hash.for.system.user')
+	`, d.ids.Next())
+	return err
+}
+
+// defaultHallQuery finds the default hall by name. It's owned by the system
+// user, so a hall someone else named the same isn't mistaken for it.
+const defaultHallQuery = `
+	SELECT h.id FROM halls h
+	JOIN users u ON u.id = h.owner_id
+	WHERE h.name = ? AND u.username = 'system'
+`
+
+// EnsureDefaultHall creates the default hall with the given rooms unless it
+// exists. Rooms are only created along with the hall.
+func (d *Database) EnsureDefaultHall(ctx context.Context, name string, rooms []string) error {
+	var hallID int
+	err := d.db.QueryRowContext(ctx, defaultHallQuery, name).Scan(&hallID)
+	if err == nil {
+		return nil // Already exists
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	// Get system user ID
+	var systemUserID int
+	err = d.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = ?", "system").Scan(&systemUserID)
+	if err != nil {
+		return err
+	}
+
+	hall, err := d.CreateHall(ctx, name, systemUserID)
+	if err != nil {
+		return err
+	}
+
+	for _, room := range rooms {
+		if _, err := d.CreateRoom(ctx, hall.ID, room, RoomTypeText); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDefaultHallID looks up the default hall by name
+func (d *Database) GetDefaultHallID(ctx context.Context, name string) (int, error) {
+	var hallID int
+	err := d.db.QueryRowContext(ctx, defaultHallQuery, name).Scan(&hallID)
+	return hallID, err
+}
+
+// AddUserToDefaultHall adds a user to the default hall of the given name and
+// returns its ID
+func (d *Database) AddUserToDefaultHall(ctx context.Context, name string, userID int) (int, error) {
+	hallID, err := d.GetDefaultHallID(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	// Add user to the hall
+	_, err = d.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO hall_members (hall_id, user_id) VALUES (?, ?)",
+		hallID, userID,
+	)
+	return hallID, err
+}
+
+// DeleteRoom deletes a room, or returns ErrLegalHold if that would take
+// messages under a legal hold with it
+func (d *Database) DeleteRoom(ctx context.Context, roomID int) error {
+	held, err := d.RoomOnLegalHold(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+
+	defer d.cache.InvalidateRoom(roomID)
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM rooms WHERE id = ?", roomID)
+	if err != nil {
+		return err
+	}
+
+	// Nothing can resume a room that's gone
+	_, err = d.db.ExecContext(ctx, "DELETE FROM room_events WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM room_sequences WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM notification_preferences WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM drafts WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM read_markers WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM voice_participants WHERE room_id = ?", roomID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "UPDATE hall_settings SET landing_room_id = NULL WHERE landing_room_id = ?", roomID)
+	return err
+}
+
+func (d *Database) RegenerateInviteCode(ctx context.Context, hallID int) (string, error) {
+	newCode, err := GenerateInviteCode()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = d.db.ExecContext(ctx, "UPDATE halls SET invite_code = ? WHERE id = ?", newCode, hallID)
+	if err != nil {
+		return "", err
+	}
+
+	return newCode, nil
+}
+
+// DeleteHall deletes a hall, or returns ErrLegalHold if anything in it is
+// under a legal hold
+func (d *Database) DeleteHall(ctx context.Context, hallID int) error {
+	held, err := d.HallOnLegalHold(ctx, hallID)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+
+	_, err = d.db.ExecContext(ctx, "DELETE FROM halls WHERE id = ?", hallID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhook_dead_letters WHERE webhook_id IN (SELECT id FROM webhooks WHERE hall_id = ?)", hallID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE hall_id = ?)", hallID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhooks WHERE hall_id = ?", hallID)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM hall_join_requests WHERE hall_id = ?", hallID)
+	return err
+}
+
+func (d *Database) GetRoomByName(ctx context.Context, hallID int, roomName string) (*Room, error) {
+	return scanRoom(d.db.QueryRowContext(ctx, "SELECT "+roomColumns+" WHERE r.hall_id = ? AND r.name = ?", hallID, roomName))
+}
+
+func (d *Database) GetDMPrivacy(ctx context.Context, userID int) (string, error) {
+	privacy := DMPrivacyEveryone
+	err := d.db.QueryRowContext(ctx,
+		"SELECT dm_privacy FROM user_settings WHERE user_id = ?",
+		userID,
+	).Scan(&privacy)
+	if err == sql.ErrNoRows {
+		return DMPrivacyEveryone, nil
+	}
+	return privacy, err
+}
+
+func (d *Database) SetDMPrivacy(ctx context.Context, userID int, privacy string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO user_settings (user_id, dm_privacy) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET dm_privacy = excluded.dm_privacy
+	`, userID, privacy)
+	return err
+}
+
+func (d *Database) SharesHall(ctx context.Context, userID, otherUserID int) (bool, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM hall_members a
+		JOIN hall_members b ON a.hall_id = b.hall_id
+		WHERE a.user_id = ? AND b.user_id = ?
+	`, userID, otherUserID).Scan(&count)
+	return count > 0, err
+}
+
+// dmPair orders two user IDs so each pair maps to a single conversation row
+func dmPair(userID, otherUserID int) (int, int) {
+	if userID < otherUserID {
+		return userID, otherUserID
+	}
+	return otherUserID, userID
+}
+
+// dmConversationColumns selects a conversation from the point of view of one
+// participant; it takes the viewer's user ID three times
+const dmConversationColumns = `
+	c.id,
+	CASE WHEN c.user_low = ? THEN c.user_high ELSE c.user_low END,
+	u.username, c.status, c.requested_by, c.created_at, c.declined_at,
+	COALESCE(s.muted, 0), COALESCE(s.archived, 0), COALESCE(s.unarchive_on_message, 0)
+	FROM dm_conversations c
+	JOIN users u ON u.id = CASE WHEN c.user_low = ? THEN c.user_high ELSE c.user_low END
+	LEFT JOIN dm_conversation_state s ON s.conversation_id = c.id AND s.user_id = ?
+`
+
+func scanDMConversation(scanner interface{ Scan(...interface{}) error }) (*DMConversation, error) {
+	conv := &DMConversation{}
+	err := scanner.Scan(&conv.ID, &conv.OtherUserID, &conv.OtherUsername, &conv.Status, &conv.RequestedBy, &conv.CreatedAt, &conv.DeclinedAt,
+		&conv.Muted, &conv.Archived, &conv.UnarchiveOnMessage)
+	if err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// GetDMConversation returns the conversation as seen by viewerID
+func (d *Database) GetDMConversation(ctx context.Context, conversationID, viewerID int) (*DMConversation, error) {
+	row := d.db.QueryRowContext(ctx,
+		"SELECT "+dmConversationColumns+" WHERE c.id = ? AND (c.user_low = ? OR c.user_high = ?)",
+		viewerID, viewerID, viewerID, conversationID, viewerID, viewerID,
+	)
+	return scanDMConversation(row)
+}
+
+func (d *Database) GetDMConversationBetween(ctx context.Context, viewerID, otherUserID int) (*DMConversation, error) {
+	low, high := dmPair(viewerID, otherUserID)
+	row := d.db.QueryRowContext(ctx,
+		"SELECT "+dmConversationColumns+" WHERE c.user_low = ? AND c.user_high = ?",
+		viewerID, viewerID, viewerID, low, high,
+	)
+	return scanDMConversation(row)
+}
+
+// SetDMConversationState stores one user's mute/archive settings for a
+// conversation
+func (d *Database) SetDMConversationState(ctx context.Context, conversationID, userID int, muted, archived, unarchiveOnMessage bool) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO dm_conversation_state (conversation_id, user_id, muted, archived, unarchive_on_message)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(conversation_id, user_id) DO UPDATE SET
+			muted = excluded.muted,
+			archived = excluded.archived,
+			unarchive_on_message = excluded.unarchive_on_message
+	`, conversationID, userID, muted, archived, unarchiveOnMessage)
+	return err
+}
+
+// UnarchiveDMConversationOnMessage brings an archived conversation back into
+// the inbox for participants who asked for that on new messages
+func (d *Database) UnarchiveDMConversationOnMessage(ctx context.Context, conversationID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE dm_conversation_state SET archived = 0 WHERE conversation_id = ? AND archived = 1 AND unarchive_on_message = 1",
+		conversationID,
+	)
+	return err
+}
+
+func (d *Database) CreateDMConversation(ctx context.Context, requesterID, otherUserID int, status string) (*DMConversation, error) {
+	low, high := dmPair(requesterID, otherUserID)
+	id := d.ids.Next()
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO dm_conversations (id, user_low, user_high, status, requested_by) VALUES (?, ?, ?, ?, ?)",
+		id, low, high, status, requesterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetDMConversation(ctx, id, requesterID)
+}
+
+// ReopenDMConversation starts a declined conversation over as a new one
+// requested by requesterID
+func (d *Database) ReopenDMConversation(ctx context.Context, conversationID, requesterID int, status string) (*DMConversation, error) {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE dm_conversations SET status = ?, requested_by = ?, declined_at = NULL, created_at = CURRENT_TIMESTAMP WHERE id = ?",
+		status, requesterID, conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.GetDMConversation(ctx, conversationID, requesterID)
+}
+
+// GetUserDMConversations lists one of a user's conversation lists: the inbox
+// (accepted conversations and requests the user sent, minus archived ones),
+// incoming requests, or archived conversations. Declined requests are in
+// none of them.
+func (d *Database) GetUserDMConversations(ctx context.Context, userID int, list string) ([]DMConversation, error) {
+	var filter string
+	switch list {
+	case DMListRequests:
+		filter = "c.status = 'pending' AND c.requested_by != ?"
+	case DMListArchived:
+		filter = "(c.status = 'accepted' OR (c.status = 'pending' AND c.requested_by = ?)) AND COALESCE(s.archived, 0) = 1"
+	default:
+		filter = "(c.status = 'accepted' OR (c.status = 'pending' AND c.requested_by = ?)) AND COALESCE(s.archived, 0) = 0"
+	}
+
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT "+dmConversationColumns+" WHERE (c.user_low = ? OR c.user_high = ?) AND ("+filter+") ORDER BY c.created_at DESC",
+		userID, userID, userID, userID, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := make([]DMConversation, 0)
+	for rows.Next() {
+		conv, err := scanDMConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, *conv)
+	}
+	return conversations, nil
+}
+
+func (d *Database) AcceptDMConversation(ctx context.Context, conversationID int) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE dm_conversations SET status = 'accepted' WHERE id = ?", conversationID)
+	return err
+}
+
+// DeclineDMConversation turns a message request down. Its messages are
+// deleted, but the conversation is kept so the requester can't simply ask
+// again.
+func (d *Database) DeclineDMConversation(ctx context.Context, conversationID int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM dm_messages WHERE conversation_id = ?", conversationID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE dm_conversations SET status = 'declined', declined_at = CURRENT_TIMESTAMP WHERE id = ?", conversationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *Database) SaveDMMessage(ctx context.Context, conversationID, userID int, content string, encrypted bool) (*DMMessage, error) {
+	id := d.ids.Next()
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO dm_messages (id, conversation_id, user_id, content, encrypted) VALUES (?, ?, ?, ?, ?)",
+		id, conversationID, userID, content, encrypted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &DMMessage{}
+	err = d.db.QueryRowContext(ctx, `
+		SELECT m.id, m.conversation_id, m.user_id, u.username, m.content, m.encrypted, m.created_at
+		FROM dm_messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.id = ?
+	`, id).Scan(&message.ID, &message.ConversationID, &message.UserID, &message.Username, &message.Content, &message.Encrypted, &message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.journal.append(JournalEntry{DMMessage: message})
+	return message, nil
+}
+
+func (d *Database) GetDMMessages(ctx context.Context, conversationID int, limit int, offset int) ([]DMMessage, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT m.id, m.conversation_id, m.user_id, u.username, m.content, m.encrypted, m.created_at
+		FROM dm_messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.conversation_id = ?
+		ORDER BY m.created_at DESC
+		LIMIT ? OFFSET ?
+	`, conversationID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]DMMessage, 0)
+	for rows.Next() {
+		var message DMMessage
+		err := rows.Scan(&message.ID, &message.ConversationID, &message.UserID, &message.Username, &message.Content, &message.Encrypted, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	// Reverse to get chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+func (d *Database) AddHallAdmin(ctx context.Context, hallID, userID, grantedBy int) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO hall_admins (hall_id, user_id, granted_by) VALUES (?, ?, ?)",
+		hallID, userID, grantedBy,
+	)
+	return err
+}
+
+// IsHallAdmin reports whether the user is the hall owner or has been granted
+// admin rights in it
+func (d *Database) IsHallAdmin(ctx context.Context, userID, hallID int) (bool, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM halls WHERE id = ? AND owner_id = ?)
+		     + (SELECT COUNT(*) FROM hall_admins WHERE hall_id = ? AND user_id = ?)
+	`, hallID, userID, hallID, userID).Scan(&count)
+	return count > 0, err
+}
+
+func (d *Database) AddAuditLog(ctx context.Context, hallID, actorID int, action, targetType string, targetID int, details string) error {
+	var actor interface{}
+	if actorID != 0 {
+		actor = actorID
+	}
+
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO audit_log (hall_id, actor_id, action, target_type, target_id, details) VALUES (?, ?, ?, ?, ?, ?)",
+		hallID, actor, action, targetType, targetID, details,
+	)
+	return err
+}
+
+func (d *Database) GetAuditLog(ctx context.Context, hallID int, limit int, offset int) ([]AuditLogEntry, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT a.id, a.hall_id, COALESCE(a.actor_id, 0), COALESCE(u.username, ''), a.action,
+		       a.target_type, a.target_id, a.details, a.created_at
+		FROM audit_log a
+		LEFT JOIN users u ON a.actor_id = u.id
+		WHERE a.hall_id = ?
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT ? OFFSET ?
+	`, hallID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditLogEntry, 0)
+	for rows.Next() {
+		var e AuditLogEntry
+		err := rows.Scan(&e.ID, &e.HallID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID, &e.Details, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (d *Database) CreateAutomodRule(ctx context.Context, hallID int, pattern string, isRegex bool, action string, createdBy int) (*AutomodRule, error) {
+	result, err := d.db.ExecContext(ctx,
+		"INSERT INTO automod_rules (hall_id, pattern, is_regex, action, created_by) VALUES (?, ?, ?, ?, ?)",
+		hallID, pattern, isRegex, action, createdBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &AutomodRule{}
+	err = d.db.QueryRowContext(ctx,
+		"SELECT id, hall_id, pattern, is_regex, action, created_by, created_at FROM automod_rules WHERE id = ?",
+		id,
+	).Scan(&rule.ID, &rule.HallID, &rule.Pattern, &rule.IsRegex, &rule.Action, &rule.CreatedBy, &rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (d *Database) GetAutomodRules(ctx context.Context, hallID int) ([]AutomodRule, error) {
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT id, hall_id, pattern, is_regex, action, created_by, created_at FROM automod_rules WHERE hall_id = ? ORDER BY id ASC",
+		hallID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]AutomodRule, 0)
+	for rows.Next() {
+		var rule AutomodRule
+		err := rows.Scan(&rule.ID, &rule.HallID, &rule.Pattern, &rule.IsRegex, &rule.Action, &rule.CreatedBy, &rule.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (d *Database) DeleteAutomodRule(ctx context.Context, hallID, ruleID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM automod_rules WHERE id = ? AND hall_id = ?", ruleID, hallID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (d *Database) FlagMessage(ctx context.Context, messageID, hallID int, reason string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO message_flags (message_id, hall_id, reason) VALUES (?, ?, ?)",
+		messageID, hallID, reason,
+	)
+	return err
+}
+
+func (d *Database) GetFlaggedMessages(ctx context.Context, hallID int, limit int, offset int) ([]FlaggedMessage, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT f.id, f.reason, f.created_at,
+		       m.id, m.room_id, m.user_id, u.username, m.content, m.type, m.kind, m.payload, m.created_at
+		FROM message_flags f
+		JOIN messages m ON f.message_id = m.id
+		JOIN users u ON m.user_id = u.id
+		WHERE f.hall_id = ?
+		ORDER BY f.created_at DESC, f.id DESC
+		LIMIT ? OFFSET ?
+	`, hallID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flagged := make([]FlaggedMessage, 0)
+	for rows.Next() {
+		var f FlaggedMessage
+		var payload string
+		m := &f.Message
+		err := rows.Scan(&f.ID, &f.Reason, &f.FlaggedAt, &m.ID, &m.RoomID, &m.UserID, &m.Username, &m.Content, &m.Type, &m.Kind, &payload, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		m.Payload = rawPayload(payload)
+		flagged = append(flagged, f)
+	}
+	return flagged, nil
+}
+
+// AddReaction records a reaction and reports whether it was new
+func (d *Database) AddReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji) VALUES (?, ?, ?)",
+		messageID, userID, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RemoveReaction deletes a reaction and reports whether it existed
+func (d *Database) RemoveReaction(ctx context.Context, messageID, userID int, emoji string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? AND emoji = ?",
+		messageID, userID, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetTopMessages returns the room's most-reacted messages posted since the
+// given time. The messages(room_id, created_at) index narrows the scan to the
+// period before reactions are counted per message.
+func (d *Database) GetTopMessages(ctx context.Context, roomID int, since time.Time, limit int) ([]TopMessage, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT m.id, m.room_id, m.user_id, u.username, m.content, m.type, m.kind, m.payload, m.created_at, COUNT(*) AS reaction_count
+		FROM messages m
+		JOIN message_reactions r ON r.message_id = m.id
+		JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = ? AND m.created_at >= ? AND `+notExpired+`
+		GROUP BY m.id
+		ORDER BY reaction_count DESC, m.id ASC
+		LIMIT ?
+	`, roomID, since.UTC().Format(sqliteTimeFormat), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	top := make([]TopMessage, 0)
+	for rows.Next() {
+		var t TopMessage
+		var payload string
+		m := &t.Message
+		err := rows.Scan(&m.ID, &m.RoomID, &m.UserID, &m.Username, &m.Content, &m.Type, &m.Kind, &payload, &m.CreatedAt, &t.ReactionCount)
+		if err != nil {
+			return nil, err
+		}
+		m.Payload = rawPayload(payload)
+		top = append(top, t)
+	}
+	return top, nil
+}
+
+// GetHallMemberIDsSeenSince returns the members of a hall seen since since,
+// the system user aside; the zero time gets all of them
+func (d *Database) GetHallMemberIDsSeenSince(ctx context.Context, hallID int, since time.Time) ([]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id FROM hall_members hm
+		JOIN users u ON u.id = hm.user_id
+		WHERE hm.hall_id = ? AND u.username != 'system' AND u.last_seen >= ?
+	`, hallID, since.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetHallAdminIDs returns the owner and every granted admin of a hall
+func (d *Database) GetHallAdminIDs(ctx context.Context, hallID int) ([]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT owner_id FROM halls WHERE id = ?
+		UNION
+		SELECT user_id FROM hall_admins WHERE hall_id = ?
+	`, hallID, hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// GetAutoArchivePolicy returns the hall's inactivity threshold in days (0
+// means disabled) and the rooms excluded from auto-archiving
+func (d *Database) GetAutoArchivePolicy(ctx context.Context, hallID int) (int, []int, error) {
+	days := 0
+	err := d.db.QueryRowContext(ctx, "SELECT auto_archive_days FROM hall_settings WHERE hall_id = ?", hallID).Scan(&days)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT rs.room_id FROM room_settings rs
+		JOIN rooms r ON r.id = rs.room_id
+		WHERE r.hall_id = ? AND rs.archive_exempt = 1
+		ORDER BY rs.room_id
+	`, hallID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	exempt := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, nil, err
+		}
+		exempt = append(exempt, id)
+	}
+	return days, exempt, nil
+}
+
+// SetAutoArchivePolicy replaces the hall's inactivity threshold and exclusion
+// list. Room IDs that aren't in the hall are ignored.
+func (d *Database) SetAutoArchivePolicy(ctx context.Context, hallID, days int, exempt []int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, auto_archive_days) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET auto_archive_days = excluded.auto_archive_days
+	`, hallID, days)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE room_settings SET archive_exempt = 0 WHERE room_id IN (SELECT id FROM rooms WHERE hall_id = ?)",
+		hallID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, roomID := range exempt {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO room_settings (room_id, archive_exempt)
+			SELECT id, 1 FROM rooms WHERE id = ? AND hall_id = ?
+			ON CONFLICT(room_id) DO UPDATE SET archive_exempt = 1
+		`, roomID, hallID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) SetRoomArchived(ctx context.Context, roomID int, archived bool) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, archived, archived_at)
+		VALUES (?, ?, CASE WHEN ? THEN CURRENT_TIMESTAMP END)
+		ON CONFLICT(room_id) DO UPDATE SET
+			archived = excluded.archived,
+			archived_at = excluded.archived_at,
+			archive_warned_at = NULL
+	`, roomID, archived, archived)
+	return err
+}
+
+// SetRoomMessageTTL sets how long messages in a room last, in seconds; 0
+// keeps them
+func (d *Database) SetRoomMessageTTL(ctx context.Context, roomID, seconds int) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, message_ttl_seconds) VALUES (?, ?)
+		ON CONFLICT(room_id) DO UPDATE SET message_ttl_seconds = excluded.message_ttl_seconds
+	`, roomID, seconds)
+	return err
+}
+
+// SetRoomAnnouncement flags a room as an announcement room or not. A
+// non-empty feedKey replaces the room's key, revoking its old feed tokens.
+func (d *Database) SetRoomAnnouncement(ctx context.Context, roomID int, announcement bool, feedKey string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, announcement, feed_key) VALUES (?, ?, NULLIF(?, ''))
+		ON CONFLICT(room_id) DO UPDATE SET
+			announcement = excluded.announcement,
+			feed_key = COALESCE(excluded.feed_key, room_settings.feed_key)
+	`, roomID, announcement, feedKey)
+	return err
+}
+
+// SetRoomTopic sets a room's topic, or clears it if topic is empty
+func (d *Database) SetRoomTopic(ctx context.Context, roomID int, topic string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, topic) VALUES (?, ?)
+		ON CONFLICT(room_id) DO UPDATE SET topic = excluded.topic
+	`, roomID, topic)
+	return err
+}
+
+// SetRoomPublic makes a room readable by guests or not
+func (d *Database) SetRoomPublic(ctx context.Context, roomID int, public bool) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, public) VALUES (?, ?)
+		ON CONFLICT(room_id) DO UPDATE SET public = excluded.public
+	`, roomID, public)
+	return err
+}
+
+// GetPublicRooms lists the unarchived public rooms of every hall
+func (d *Database) GetPublicRooms(ctx context.Context) ([]Room, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+roomColumns+`
+		WHERE COALESCE(rs.public, 0) = 1 AND COALESCE(rs.archived, 0) = 0
+		ORDER BY r.hall_id, r.created_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]Room, 0)
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+	return rooms, rows.Err()
+}
+
+// GetRoomFeedKey returns the key a room's feed tokens are signed over, empty
+// if it has none
+func (d *Database) GetRoomFeedKey(ctx context.Context, roomID int) (string, error) {
+	var key sql.NullString
+	err := d.db.QueryRowContext(ctx,
+		"SELECT feed_key FROM room_settings WHERE room_id = ?",
+		roomID,
+	).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return key.String, err
+}
+
+func (d *Database) MarkRoomArchiveWarned(ctx context.Context, roomID int) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_settings (room_id, archive_warned_at) VALUES (?, CURRENT_TIMESTAMP)
+		ON CONFLICT(room_id) DO UPDATE SET archive_warned_at = CURRENT_TIMESTAMP
+	`, roomID)
+	return err
+}
+
+// GetAutoArchiveCandidates returns unarchived, non-exempt rooms in halls with
+// an auto-archive policy that are either past the inactivity threshold (Due)
+// or within warnBefore of it and haven't been warned about since their last
+// activity (WarnDue).
+func (d *Database) GetAutoArchiveCandidates(ctx context.Context, warnBefore time.Duration) ([]ArchiveCandidate, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, hall_id, name, created_at, days,
+		       last_activity < datetime('now', printf('-%d days', days)),
+		       last_activity < datetime('now', printf('-%d seconds', days * 86400 - ?))
+		           AND (warned_at IS NULL OR warned_at < last_activity)
+		FROM (
+			SELECT r.id, r.hall_id, r.name, r.created_at, hs.auto_archive_days AS days,
+			       rs.archive_warned_at AS warned_at,
+			       COALESCE((SELECT MAX(m.created_at) FROM messages m WHERE m.room_id = r.id), r.created_at) AS last_activity
+			FROM rooms r
+			JOIN hall_settings hs ON hs.hall_id = r.hall_id
+			LEFT JOIN room_settings rs ON rs.room_id = r.id
+			WHERE hs.auto_archive_days > 0
+			  AND COALESCE(rs.archived, 0) = 0
+			  AND COALESCE(rs.archive_exempt, 0) = 0
+		)
+	`, int(warnBefore.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make([]ArchiveCandidate, 0)
+	for rows.Next() {
+		var c ArchiveCandidate
+		err := rows.Scan(&c.Room.ID, &c.Room.HallID, &c.Room.Name, &c.Room.CreatedAt, &c.Days, &c.Due, &c.WarnDue)
+		if err != nil {
+			return nil, err
+		}
+		if c.Due || c.WarnDue {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+// SetRoomExpiry makes a room temporary; once expiresAt passes the room is
+// archived or deleted depending on onExpiry
+func (d *Database) SetRoomExpiry(ctx context.Context, roomID int, expiresAt time.Time, onExpiry string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO room_expiry (room_id, expires_at, on_expiry) VALUES (?, ?, ?)
+		ON CONFLICT(room_id) DO UPDATE SET
+			expires_at = excluded.expires_at,
+			on_expiry = excluded.on_expiry
+	`, roomID, expiresAt.UTC().Format(sqliteTimeFormat), onExpiry)
+	return err
+}
+
+func (d *Database) ClearRoomExpiry(ctx context.Context, roomID int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM room_expiry WHERE room_id = ?", roomID)
+	return err
+}
+
+// GetExpiredRooms returns temporary rooms whose expiry has passed
+func (d *Database) GetExpiredRooms(ctx context.Context) ([]Room, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+roomColumns+" WHERE re.expires_at <= datetime('now')")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := make([]Room, 0)
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+	return rooms, nil
+}
+
+func (d *Database) GetHallSettings(ctx context.Context, hallID int) (HallSettings, error) {
+	settings := HallSettings{RoomCreation: RoomCreationMembers, JoinMode: JoinModeOpen, MentionEveryone: MentionEveryoneAdmins}
+	var landingRoomID sql.NullInt64
+	err := d.db.QueryRowContext(ctx,
+		"SELECT room_creation, welcome_message, landing_room_id, join_mode, mention_everyone FROM hall_settings WHERE hall_id = ?",
+		hallID,
+	).Scan(&settings.RoomCreation, &settings.WelcomeMessage, &landingRoomID, &settings.JoinMode, &settings.MentionEveryone)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if landingRoomID.Valid {
+		id := int(landingRoomID.Int64)
+		settings.LandingRoomID = &id
+	}
+	return settings, err
+}
+
+func (d *Database) SetRoomCreation(ctx context.Context, hallID int, roomCreation string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, room_creation) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET room_creation = excluded.room_creation
+	`, hallID, roomCreation)
+	return err
+}
+
+func (d *Database) SetMentionEveryone(ctx context.Context, hallID int, mentionEveryone string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, mention_everyone) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET mention_everyone = excluded.mention_everyone
+	`, hallID, mentionEveryone)
+	return err
+}
+
+func (d *Database) SetWelcomeMessage(ctx context.Context, hallID int, message string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, welcome_message) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET welcome_message = excluded.welcome_message
+	`, hallID, message)
+	return err
+}
+
+// SetLandingRoom sets the room new members land in, 0 to go back to the
+// hall's oldest text room
+func (d *Database) SetLandingRoom(ctx context.Context, hallID, roomID int) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, landing_room_id) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET landing_room_id = excluded.landing_room_id
+	`, hallID, sql.NullInt64{Int64: int64(roomID), Valid: roomID != 0})
+	return err
+}
+
+func (d *Database) GetRetentionPolicy(ctx context.Context, hallID int) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	err := d.db.QueryRowContext(ctx,
+		"SELECT retention_days, retention_max_messages FROM hall_settings WHERE hall_id = ?",
+		hallID,
+	).Scan(&policy.Days, &policy.MaxMessagesPerRoom)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	return policy, err
+}
+
+func (d *Database) SetRetentionPolicy(ctx context.Context, hallID int, policy RetentionPolicy) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, retention_days, retention_max_messages) VALUES (?, ?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET
+			retention_days = excluded.retention_days,
+			retention_max_messages = excluded.retention_max_messages
+	`, hallID, policy.Days, policy.MaxMessagesPerRoom)
+	return err
+}
+
+func (d *Database) GetSpamPolicy(ctx context.Context, hallID int) (SpamPolicy, error) {
+	var policy SpamPolicy
+	err := d.db.QueryRowContext(ctx,
+		"SELECT spam_flag_score, spam_throttle_score, spam_delete_score FROM hall_settings WHERE hall_id = ?",
+		hallID,
+	).Scan(&policy.FlagScore, &policy.ThrottleScore, &policy.DeleteScore)
+	if err == sql.ErrNoRows {
+		return policy, nil
+	}
+	return policy, err
+}
+
+func (d *Database) SetSpamPolicy(ctx context.Context, hallID int, policy SpamPolicy) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, spam_flag_score, spam_throttle_score, spam_delete_score) VALUES (?, ?, ?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET
+			spam_flag_score = excluded.spam_flag_score,
+			spam_throttle_score = excluded.spam_throttle_score,
+			spam_delete_score = excluded.spam_delete_score
+	`, hallID, policy.FlagScore, policy.ThrottleScore, policy.DeleteScore)
+	return err
+}
+
+// GetRetentionPolicies returns every hall that has a retention rule set
+func (d *Database) GetRetentionPolicies(ctx context.Context) (map[int]RetentionPolicy, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT hall_id, retention_days, retention_max_messages FROM hall_settings
+		WHERE retention_days > 0 OR retention_max_messages > 0
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := make(map[int]RetentionPolicy)
+	for rows.Next() {
+		var hallID int
+		var policy RetentionPolicy
+		if err := rows.Scan(&hallID, &policy.Days, &policy.MaxMessagesPerRoom); err != nil {
+			return nil, err
+		}
+		policies[hallID] = policy
+	}
+	return policies, nil
+}
+
+// PruneHallMessages deletes up to limit messages in the hall that fall outside
+// its retention policy, along with their reactions and flags, and returns how
+// many were deleted. Call it repeatedly until it returns less than limit so
+// no single transaction holds the write lock for long. Messages under a legal
+// hold are kept, though they still count towards the newest ones of a room.
+func (d *Database) PruneHallMessages(ctx context.Context, hallID int, policy RetentionPolicy, limit int) (int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id FROM (
+			SELECT m.id, m.created_at, `+heldMessage+` AS held,
+			       ROW_NUMBER() OVER (PARTITION BY m.room_id ORDER BY m.id DESC) AS newest_rank
+			FROM messages m
+			JOIN rooms r ON r.id = m.room_id
+			WHERE r.hall_id = ?
+		)
+		WHERE NOT held
+		  AND ((? > 0 AND created_at < datetime('now', printf('-%d days', ?)))
+		   OR (? > 0 AND newest_rank > ?))
+		LIMIT ?
+	`, hallID, policy.Days, policy.Days, policy.MaxMessagesPerRoom, policy.MaxMessagesPerRoom, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]interface{}, 0, limit)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	return len(ids), d.deleteMessages(ctx, ids)
+}
+
+// GetHallMessagesByIDs returns those of the given messages that are in one of
+// hallID's rooms, in ID order. Expired ones are included, since they're still
+// there to delete.
+func (d *Database) GetHallMessagesByIDs(ctx context.Context, hallID int, messageIDs []int) ([]Message, error) {
+	if len(messageIDs) == 0 {
+		return make([]Message, 0), nil
+	}
+
+	args := make([]interface{}, 0, len(messageIDs)+1)
+	args = append(args, hallID)
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(messageIDs)), ",")
+
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		JOIN rooms r ON r.id = m.room_id
+		WHERE r.hall_id = ? AND m.id IN (`+placeholders+`)
+		ORDER BY m.id ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// DeleteMessages deletes messages by ID along with their reactions and
+// flags. If any of them is under a legal hold none are deleted and it
+// returns ErrLegalHold.
+func (d *Database) DeleteMessages(ctx context.Context, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id
+	}
+	return d.deleteMessages(ctx, ids)
+}
+
+// deleteMessages deletes messages by ID along with their reactions and flags
+func (d *Database) deleteMessages(ctx context.Context, ids []interface{}) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var held bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM messages m WHERE m.id IN ("+placeholders+") AND "+heldMessage+")", ids...).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+
+	// Foreign keys aren't enforced, so dependent rows go explicitly
+	for _, table := range []string{"message_reactions", "message_flags"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE message_id IN ("+placeholders+")", ids...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if d.cache != nil {
+		messageIDs := make([]int, len(ids))
+		for i, id := range ids {
+			messageIDs[i] = id.(int)
+		}
+		d.cache.RemoveMessages(messageIDs)
+	}
+	return nil
+}
+
+// DeleteExpiredMessages deletes up to limit self-destructing messages that
+// are past their time, and returns them with only ID and RoomID set. Those
+// under a legal hold stay, hidden like any expired message.
+func (d *Database) DeleteExpiredMessages(ctx context.Context, limit int) ([]Message, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT m.id, m.room_id FROM messages m
+		WHERE m.expires_at IS NOT NULL AND m.expires_at <= CURRENT_TIMESTAMP AND NOT `+heldMessage+`
+		ORDER BY m.expires_at
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make([]Message, 0)
+	ids := make([]interface{}, 0)
+	for rows.Next() {
+		var message Message
+		if err := rows.Scan(&message.ID, &message.RoomID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, message)
+		ids = append(ids, message.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return expired, nil
+	}
+
+	return expired, d.deleteMessages(ctx, ids)
+}
+
+// GetHallMembers lists a hall's members in the order they joined
+func (d *Database) GetHallMembers(ctx context.Context, hallID int) ([]HallMemberInfo, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id, u.username, hm.joined_at
+		FROM hall_members hm
+		JOIN users u ON u.id = hm.user_id
+		WHERE hm.hall_id = ?
+		ORDER BY hm.joined_at ASC, u.id ASC
+	`, hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]HallMemberInfo, 0)
+	for rows.Next() {
+		var member HallMemberInfo
+		if err := rows.Scan(&member.UserID, &member.Username, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// GetRoomMembers lists who can see a room of hallID, online members first
+// and then by name. Every room is open to its whole hall, so these are the
+// hall's members. Anyone seen since onlineSince counts as online. It also
+// returns how many members there are and how many of them are online.
+func (d *Database) GetRoomMembers(ctx context.Context, hallID int, onlineSince time.Time, limit, offset int) ([]RoomMember, int, int, error) {
+	cutoff := onlineSince.UTC().Format(sqliteTimeFormat)
+
+	var total, online int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(u.last_seen >= ?), 0)
+		FROM hall_members hm
+		JOIN users u ON u.id = hm.user_id
+		WHERE hm.hall_id = ? AND u.username != 'system'
+	`, cutoff, hallID).Scan(&total, &online)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id, u.username, hm.joined_at, u.last_seen >= ?1 AS online,
+			CASE
+				WHEN h.owner_id = u.id THEN ?2
+				WHEN ha.user_id IS NOT NULL THEN ?3
+				ELSE ?4
+			END
+		FROM hall_members hm
+		JOIN users u ON u.id = hm.user_id
+		JOIN halls h ON h.id = hm.hall_id
+		LEFT JOIN hall_admins ha ON ha.hall_id = hm.hall_id AND ha.user_id = hm.user_id
+		WHERE hm.hall_id = ?5 AND u.username != 'system'
+		ORDER BY online DESC, u.username COLLATE NOCASE
+		LIMIT ?6 OFFSET ?7
+	`, cutoff, HallRoleOwner, HallRoleAdmin, HallRoleMember, hallID, limit, offset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	members := make([]RoomMember, 0)
+	for rows.Next() {
+		var member RoomMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.JoinedAt, &member.Online, &member.Role); err != nil {
+			return nil, 0, 0, err
+		}
+		members = append(members, member)
+	}
+	return members, total, online, rows.Err()
+}
+
+// EachRoomMessage calls fn for every message in a room, oldest first, without
+// loading the whole history into memory
+func (d *Database) EachRoomMessage(ctx context.Context, roomID int, fn func(Message) error) error {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT m.id, m.room_id, m.user_id, u.username, m.content, m.type, m.kind, m.payload, m.created_at
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = ? AND `+notExpired+`
+		ORDER BY m.id ASC
+	`, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message Message
+		var payload string
+		err := rows.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Content, &message.Type, &message.Kind, &payload, &message.CreatedAt)
+		if err != nil {
+			return err
+		}
+		message.Payload = rawPayload(payload)
+		if err := fn(message); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close closes the database, or only the statements prepared on it if it
+// came from Wrap
+func (d *Database) Close() error {
+	d.closeStmts()
+	if !d.owned {
+		return nil
+	}
+	return d.db.Close()
+}
+
+// CacheStats reports on the message cache, which is empty when it's off
+func (d *Database) CacheStats() RoomCacheStats {
+	return d.cache.Stats()
+}
+
+// ImportMessages inserts messages with their own timestamps in one
+// transaction. It's meant for bulk loads like the seed command.
+func (d *Database) ImportMessages(ctx context.Context, messages []Message) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO messages (id, room_id, user_id, content, created_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, message := range messages {
+		createdAt := message.CreatedAt.UTC().Format(sqliteTimeFormat)
+		if _, err := stmt.ExecContext(ctx, d.ids.Next(), message.RoomID, message.UserID, message.Content, createdAt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Imported messages land anywhere in a room's history
+	d.cache.InvalidateMessages()
+	return nil
+}
+
+func (d *Database) IsInstanceAdmin(ctx context.Context, userID int) (bool, error) {
+	var isAdmin bool
+	err := d.db.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = ?", userID).Scan(&isAdmin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return isAdmin, err
+}
+
+func (d *Database) SetInstanceAdmin(ctx context.Context, userID int, isAdmin bool) error {
+	result, err := d.db.ExecContext(ctx, "UPDATE users SET is_admin = ? WHERE id = ?", isAdmin, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListUsers returns accounts ordered by ID, optionally filtered by a
+// username substring
+func (d *Database) ListUsers(ctx context.Context, search string, limit, offset int) ([]AdminUserInfo, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id, u.username, u.created_at, u.last_seen, u.is_admin,
+			(SELECT COUNT(*) FROM hall_members hm WHERE hm.user_id = u.id),
+			(SELECT COUNT(*) FROM messages m WHERE m.user_id = u.id)
+		FROM users u
+		WHERE u.username != 'system' AND u.username LIKE '%' || ? || '%'
+		ORDER BY u.id ASC
+		LIMIT ? OFFSET ?
+	`, search, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]AdminUserInfo, 0)
+	for rows.Next() {
+		var user AdminUserInfo
+		if err := rows.Scan(&user.ID, &user.Username, &user.CreatedAt, &user.LastSeen, &user.IsAdmin, &user.Halls, &user.Messages); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ListHalls returns every hall ordered by ID
+func (d *Database) ListHalls(ctx context.Context, limit, offset int) ([]AdminHallInfo, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT h.id, h.name, h.invite_code, h.owner_id, h.created_at, COALESCE(u.username, ''),
+			(SELECT COUNT(*) FROM hall_members hm WHERE hm.hall_id = h.id),
+			(SELECT COUNT(*) FROM rooms r WHERE r.hall_id = h.id),
+			(SELECT COUNT(*) FROM messages m JOIN rooms r ON r.id = m.room_id WHERE r.hall_id = h.id)
+		FROM halls h
+		LEFT JOIN users u ON u.id = h.owner_id
+		ORDER BY h.id ASC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	halls := make([]AdminHallInfo, 0)
+	for rows.Next() {
+		var hall AdminHallInfo
+		if err := rows.Scan(&hall.ID, &hall.Name, &hall.InviteCode, &hall.OwnerID, &hall.CreatedAt, &hall.OwnerUsername, &hall.Members, &hall.Rooms, &hall.Messages); err != nil {
+			return nil, err
+		}
+		halls = append(halls, hall)
+	}
+	return halls, nil
+}
+
+func (d *Database) GetInstanceStats(ctx context.Context) (InstanceStats, error) {
+	var stats InstanceStats
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE username != 'system'),
+			(SELECT COUNT(*) FROM users WHERE is_admin = 1),
+			(SELECT COUNT(*) FROM halls),
+			(SELECT COUNT(*) FROM rooms),
+			(SELECT COUNT(*) FROM messages),
+			(SELECT COUNT(*) FROM dm_messages)
+	`).Scan(&stats.Users, &stats.Admins, &stats.Halls, &stats.Rooms, &stats.Messages, &stats.DMMessages)
+	return stats, err
+}
+
+// DeleteUser removes an account with everything it wrote and the halls it
+// owns. Foreign keys aren't enforced, so dependent rows are deleted here. It
+// returns ErrLegalHold if any of that is under a legal hold.
+func (d *Database) DeleteUser(ctx context.Context, userID int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var held bool
+	if err := tx.QueryRowContext(ctx, userOnLegalHold, userID).Scan(&held); err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+
+	statements := []string{
+		// Halls the user owns, with their rooms and everything in them
+		`DELETE FROM message_reactions WHERE message_id IN (
+			SELECT m.id FROM messages m JOIN rooms r ON r.id = m.room_id
+			JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM message_flags WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM messages WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM room_settings WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM room_expiry WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM room_events WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM room_sequences WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM notification_preferences WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM drafts WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM read_markers WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM voice_participants WHERE room_id IN (
+			SELECT r.id FROM rooms r JOIN halls h ON h.id = r.hall_id WHERE h.owner_id = ?1)`,
+		`DELETE FROM rooms WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM hall_members WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM hall_admins WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM hall_settings WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM hall_join_requests WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM automod_rules WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM audit_log WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1)`,
+		`DELETE FROM halls WHERE owner_id = ?1`,
+
+		// The user's messages elsewhere
+		`DELETE FROM message_reactions WHERE user_id = ?1
+			OR message_id IN (SELECT id FROM messages WHERE user_id = ?1)`,
+		`DELETE FROM message_flags WHERE message_id IN (SELECT id FROM messages WHERE user_id = ?1)`,
+		`DELETE FROM messages WHERE user_id = ?1`,
+
+		// Direct messages
+		`DELETE FROM dm_messages WHERE conversation_id IN (
+			SELECT id FROM dm_conversations WHERE user_low = ?1 OR user_high = ?1)`,
+		`DELETE FROM dm_conversation_state WHERE conversation_id IN (
+			SELECT id FROM dm_conversations WHERE user_low = ?1 OR user_high = ?1)`,
+		`DELETE FROM dm_conversations WHERE user_low = ?1 OR user_high = ?1`,
+
+		`DELETE FROM hall_members WHERE user_id = ?1`,
+		`DELETE FROM hall_admins WHERE user_id = ?1`,
+		`DELETE FROM hall_join_requests WHERE user_id = ?1`,
+		`DELETE FROM user_settings WHERE user_id = ?1`,
+		`DELETE FROM email_notifications WHERE user_id = ?1`,
+		`DELETE FROM notification_preferences WHERE user_id = ?1`,
+		`DELETE FROM dnd_windows WHERE user_id = ?1`,
+		`DELETE FROM drafts WHERE user_id = ?1`,
+		`DELETE FROM read_markers WHERE user_id = ?1`,
+		`DELETE FROM api_usage WHERE user_id = ?1`,
+		`DELETE FROM sessions WHERE user_id = ?1`,
+		`DELETE FROM ws_subscriptions WHERE user_id = ?1`,
+		`DELETE FROM voice_participants WHERE user_id = ?1`,
+		`DELETE FROM one_time_prekeys WHERE user_id = ?1`,
+		`DELETE FROM device_keys WHERE user_id = ?1`,
+		`DELETE FROM registration_invites WHERE created_by = ?1`,
+		`DELETE FROM username_history WHERE user_id = ?1`,
+		`DELETE FROM users WHERE id = ?1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, userID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	d.cache.Clear()
+	return nil
+}
+
+// AppendRoomEvent stores a room event under the room's next sequence number
+// and returns that number
+func (d *Database) AppendRoomEvent(ctx context.Context, roomID int, eventType string, payload []byte) (int64, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO room_sequences (room_id, seq) VALUES (?, 1)
+		ON CONFLICT(room_id) DO UPDATE SET seq = seq + 1
+		RETURNING seq
+	`, roomID).Scan(&seq)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO room_events (room_id, seq, type, payload) VALUES (?, ?, ?, ?)",
+		roomID, seq, eventType, string(payload),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	d.cache.AddEvent(RoomEvent{
+		RoomID:    roomID,
+		Seq:       seq,
+		Type:      eventType,
+		Payload:   json.RawMessage(payload),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	return seq, nil
+}
+
+// GetRoomSeq returns the sequence number of a room's latest event, 0 if it
+// has none
+func (d *Database) GetRoomSeq(ctx context.Context, roomID int) (int64, error) {
+	if seq, ok := d.cache.Seq(roomID); ok {
+		return seq, nil
+	}
+	return getRoomSeq(ctx, d.db, roomID)
+}
+
+// roomQuerier is what *sql.DB and *sql.Tx have in common for reads
+type roomQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getRoomSeq(ctx context.Context, q roomQuerier, roomID int) (int64, error) {
+	var seq int64
+	err := q.QueryRowContext(ctx, "SELECT seq FROM room_sequences WHERE room_id = ?", roomID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// GetRoomEventsSince returns up to limit stored events after seq, oldest
+// first. With the room cache on, the room's newest events are loaded into it
+// and resumes that don't reach further back are answered from there.
+func (d *Database) GetRoomEventsSince(ctx context.Context, roomID int, seq int64, limit int) ([]RoomEvent, error) {
+	if events, ok := d.cache.EventsSince(roomID, seq, limit); ok {
+		return events, nil
+	}
+	if d.cache != nil {
+		if err := d.loadRoomEvents(ctx, roomID); err != nil {
+			return nil, err
+		}
+		if events, ok := d.cache.EventsSince(roomID, seq, limit); ok {
+			return events, nil
+		}
+	}
+
+	return scanRoomEvents(d.db.QueryContext(ctx, `
+		SELECT room_id, seq, type, payload, created_at
+		FROM room_events
+		WHERE room_id = ? AND seq > ?
+		ORDER BY seq ASC
+		LIMIT ?
+	`, roomID, seq, limit))
+}
+
+// loadRoomEvents puts a room's sequence number and newest events in the
+// cache, read in one transaction so they agree
+func (d *Database) loadRoomEvents(ctx context.Context, roomID int) error {
+	gen := d.cache.beginLoad(roomID)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := getRoomSeq(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+	events, err := scanRoomEvents(tx.QueryContext(ctx, `
+		SELECT room_id, seq, type, payload, created_at
+		FROM room_events
+		WHERE room_id = ?
+		ORDER BY seq DESC
+		LIMIT ?
+	`, roomID, roomCacheEvents))
+	if err != nil {
+		return err
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	d.cache.storeEvents(roomID, gen, seq, events, len(events) < roomCacheEvents)
+	return nil
+}
+
+func scanRoomEvents(rows *sql.Rows, err error) ([]RoomEvent, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]RoomEvent, 0)
+	for rows.Next() {
+		var event RoomEvent
+		var payload string
+		if err := rows.Scan(&event.RoomID, &event.Seq, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// PruneRoomEvents deletes stored events older than before
+func (d *Database) PruneRoomEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM room_events WHERE created_at < ?",
+		before.UTC().Format(sqliteTimeFormat),
+	)
+	if err != nil {
+		return 0, err
+	}
+	d.cache.PruneEvents(before)
+	return result.RowsAffected()
+}
+
+// GetMessageByNonce finds the message a user already sent with a nonce
+func (d *Database) GetMessageByNonce(ctx context.Context, userID int, nonce string) (*Message, error) {
+	var messageID int
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id FROM messages WHERE user_id = ? AND nonce = ?",
+		userID, nonce,
+	).Scan(&messageID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetMessageByID(ctx, messageID)
+}
+
+// GetUserSettings returns a user's settings, with the defaults for anything
+// they never set
+func (d *Database) GetUserSettings(ctx context.Context, userID int) (UserSettings, error) {
+	settings := UserSettings{DMPrivacy: DMPrivacyEveryone, EmailNotifications: true, Timezone: "UTC", DNDWindows: []DNDWindow{}}
+	var email sql.NullString
+	err := d.db.QueryRowContext(ctx,
+		"SELECT dm_privacy, email, email_notifications, email_verified, timezone FROM user_settings WHERE user_id = ?",
+		userID,
+	).Scan(&settings.DMPrivacy, &email, &settings.EmailNotifications, &settings.EmailVerified, &settings.Timezone)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	settings.Email = email.String
+
+	if settings.DNDWindows, err = d.GetDNDWindows(ctx, userID); err != nil {
+		return settings, err
+	}
+	settings.DND = DNDActive(settings.DNDWindows, settings.Timezone, time.Now())
+	return settings, nil
+}
+
+// SetEmailSettings stores where to email a user and whether to; an empty
+// email clears it. A new email starts out unverified.
+func (d *Database) SetEmailSettings(ctx context.Context, userID int, email string, notifications bool) error {
+	var emailValue interface{}
+	if email != "" {
+		emailValue = email
+	}
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO user_settings (user_id, email, email_notifications) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET email = excluded.email, email_notifications = excluded.email_notifications,
+			email_verified = CASE WHEN email IS excluded.email THEN email_verified ELSE 0 END,
+			email_verify_token = CASE WHEN email IS excluded.email THEN email_verify_token END,
+			email_verify_expires_at = CASE WHEN email IS excluded.email THEN email_verify_expires_at END
+	`, userID, emailValue, notifications)
+	return err
+}
+
+// SetEmailVerifyToken stores the token that verifies a user's unverified
+// email until expiresAt. It reports false, storing nothing, if there's no
+// such email or the current token was issued after notBefore.
+func (d *Database) SetEmailVerifyToken(ctx context.Context, userID int, token string, expiresAt, notBefore time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE user_settings SET email_verify_token = ?, email_verify_expires_at = ?
+		WHERE user_id = ? AND email IS NOT NULL AND email_verified = 0
+		  AND (email_verify_expires_at IS NULL OR email_verify_expires_at <= ?)
+	`, token, expiresAt.UTC().Format(sqliteTimeFormat), userID, notBefore.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// VerifyEmail marks the email a token was issued for as verified and returns
+// whose it is. It returns sql.ErrNoRows for unknown or expired tokens.
+func (d *Database) VerifyEmail(ctx context.Context, token string) (int, error) {
+	var userID int
+	err := d.db.QueryRowContext(ctx, `
+		UPDATE user_settings SET email_verified = 1, email_verify_token = NULL, email_verify_expires_at = NULL
+		WHERE email_verify_token = ? AND email_verify_expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id
+	`, token).Scan(&userID)
+	return userID, err
+}
+
+// GetEmailRecipients returns which of the given users want email
+// notifications and have an address to send them to
+func (d *Database) GetEmailRecipients(ctx context.Context, userIDs []int) ([]EmailRecipient, error) {
+	recipients := make([]EmailRecipient, 0)
+	if len(userIDs) == 0 {
+		return recipients, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIDs)), ",")
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id, u.username, us.email, u.last_seen
+		FROM users u
+		JOIN user_settings us ON us.user_id = u.id
+		WHERE u.id IN (`+placeholders+`) AND us.email IS NOT NULL AND us.email_notifications = 1
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipient EmailRecipient
+		if err := rows.Scan(&recipient.UserID, &recipient.Username, &recipient.Email, &recipient.LastSeen); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, rows.Err()
+}
+
+// GetHallMemberIDsByUsername resolves usernames to the IDs of the ones that
+// are members of a hall, ignoring case. Names someone renamed away from since
+// renamedSince resolve to them, unless another user has taken the name since.
+func (d *Database) GetHallMemberIDsByUsername(ctx context.Context, hallID int, usernames []string, renamedSince time.Time) ([]int, error) {
+	ids := make([]int, 0)
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(usernames)), ",")
+	names := make([]interface{}, 0, len(usernames))
+	for _, name := range usernames {
+		names = append(names, strings.ToLower(name))
+	}
+	args := append([]interface{}{hallID}, names...)
+	args = append(args, renamedSince.UTC().Format(sqliteTimeFormat))
+	args = append(args, names...)
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT u.id FROM users u
+		JOIN hall_members hm ON hm.user_id = u.id
+		WHERE hm.hall_id = ? AND (LOWER(u.username) IN (`+placeholders+`) OR u.id IN (
+			SELECT uh.user_id FROM username_history uh
+			WHERE uh.changed_at > ? AND LOWER(uh.old_username) IN (`+placeholders+`)
+			  AND NOT EXISTS (SELECT 1 FROM users taken WHERE LOWER(taken.username) = LOWER(uh.old_username))
+		))
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (d *Database) QueueEmailNotification(ctx context.Context, n EmailNotification) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO email_notifications (user_id, kind, actor_name, room_name, hall_name, excerpt)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, n.UserID, n.Kind, n.ActorName, n.RoomName, n.HallName, n.Excerpt)
+	return err
+}
+
+// GetDueEmailDigests returns the users whose oldest pending notification was
+// queued before cutoff
+func (d *Database) GetDueEmailDigests(ctx context.Context, cutoff time.Time) ([]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT user_id FROM email_notifications
+		GROUP BY user_id
+		HAVING MIN(created_at) <= ?
+	`, cutoff.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPendingEmailNotifications lists a user's queued notifications, oldest
+// first
+func (d *Database) GetPendingEmailNotifications(ctx context.Context, userID int) ([]EmailNotification, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, user_id, kind, actor_name, room_name, hall_name, excerpt, created_at
+		FROM email_notifications
+		WHERE user_id = ?
+		ORDER BY id ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]EmailNotification, 0)
+	for rows.Next() {
+		var n EmailNotification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.ActorName, &n.RoomName, &n.HallName, &n.Excerpt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// DeleteEmailNotifications removes a user's queued notifications up to and
+// including lastID, once they were sent or aren't wanted anymore
+func (d *Database) DeleteEmailNotifications(ctx context.Context, userID, lastID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM email_notifications WHERE user_id = ? AND id <= ?",
+		userID, lastID,
+	)
+	return err
+}
+
+// PruneEmailNotifications drops notifications queued before cutoff that
+// never went out
+func (d *Database) PruneEmailNotifications(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM email_notifications WHERE created_at < ?",
+		cutoff.UTC().Format(sqliteTimeFormat),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetNotificationPreferences lists the hall and room notification levels a
+// user has set
+func (d *Database) GetNotificationPreferences(ctx context.Context, userID int) (NotificationPreferences, error) {
+	prefs := NotificationPreferences{
+		Halls: make([]NotificationPreference, 0),
+		Rooms: make([]NotificationPreference, 0),
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT hall_id, room_id, level FROM notification_preferences
+		WHERE user_id = ? ORDER BY hall_id, room_id
+	`, userID)
+	if err != nil {
+		return prefs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pref NotificationPreference
+		if err := rows.Scan(&pref.HallID, &pref.RoomID, &pref.Level); err != nil {
+			return prefs, err
+		}
+		if pref.RoomID == 0 {
+			prefs.Halls = append(prefs.Halls, pref)
+		} else {
+			prefs.Rooms = append(prefs.Rooms, pref)
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// SetNotificationPreference stores a user's level for a hall, or for a room
+// when pref.RoomID is set. An empty level removes the preference.
+func (d *Database) SetNotificationPreference(ctx context.Context, userID int, pref NotificationPreference) error {
+	if pref.Level == "" {
+		_, err := d.db.ExecContext(ctx,
+			"DELETE FROM notification_preferences WHERE user_id = ? AND hall_id = ? AND room_id = ?",
+			userID, pref.HallID, pref.RoomID,
+		)
+		return err
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO notification_preferences (user_id, hall_id, room_id, level) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, hall_id, room_id) DO UPDATE SET level = excluded.level, updated_at = CURRENT_TIMESTAMP
+	`, userID, pref.HallID, pref.RoomID, pref.Level)
+	return err
+}
+
+// GetNotificationLevels returns how much each of the given users wants to
+// hear about a room, taking the hall's level where the room has none
+func (d *Database) GetNotificationLevels(ctx context.Context, hallID, roomID int, userIDs []int) (map[int]string, error) {
+	levels := make(map[int]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return levels, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIDs)), ",")
+	args := []interface{}{hallID, roomID}
+	for _, id := range userIDs {
+		args = append(args, id)
+	}
+
+	// Hall rows (room_id 0) sort first, so room rows overwrite them
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT user_id, level FROM notification_preferences
+		WHERE hall_id = ? AND room_id IN (0, ?) AND user_id IN (`+placeholders+`)
+		ORDER BY room_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		var level string
+		if err := rows.Scan(&userID, &level); err != nil {
+			return nil, err
+		}
+		levels[userID] = level
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range userIDs {
+		if _, ok := levels[id]; !ok {
+			levels[id] = NotificationLevelAll
+		}
+	}
+	return levels, nil
+}
+
+// GetDraft returns a user's draft in a room, empty if there's none
+func (d *Database) GetDraft(ctx context.Context, userID, roomID int) (*Draft, error) {
+	draft := &Draft{RoomID: roomID}
+	var updatedAt time.Time
+	err := d.db.QueryRowContext(ctx,
+		"SELECT content, updated_at FROM drafts WHERE user_id = ? AND room_id = ?",
+		userID, roomID,
+	).Scan(&draft.Content, &updatedAt)
+	if err == sql.ErrNoRows {
+		return draft, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	draft.UpdatedAt = &updatedAt
+	return draft, nil
+}
+
+// GetDrafts lists a user's drafts in rooms of the halls they're still in,
+// most recently edited first
+func (d *Database) GetDrafts(ctx context.Context, userID int) ([]Draft, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT d.room_id, d.content, d.updated_at FROM drafts d
+		JOIN rooms r ON r.id = d.room_id
+		JOIN hall_members hm ON hm.hall_id = r.hall_id AND hm.user_id = d.user_id
+		WHERE d.user_id = ?
+		ORDER BY d.updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := make([]Draft, 0)
+	for rows.Next() {
+		var draft Draft
+		var updatedAt time.Time
+		if err := rows.Scan(&draft.RoomID, &draft.Content, &updatedAt); err != nil {
+			return nil, err
+		}
+		draft.UpdatedAt = &updatedAt
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+// SetDraft stores a user's draft in a room, replacing the one before. Empty
+// content removes the draft.
+func (d *Database) SetDraft(ctx context.Context, userID, roomID int, content string) error {
+	if content == "" {
+		_, err := d.db.ExecContext(ctx, "DELETE FROM drafts WHERE user_id = ? AND room_id = ?", userID, roomID)
+		return err
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO drafts (user_id, room_id, content) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, room_id) DO UPDATE SET content = excluded.content, updated_at = CURRENT_TIMESTAMP
+	`, userID, roomID, content)
+	return err
+}
+
+const voiceParticipantColumns = `
+	v.peer_id, v.room_id, v.user_id, u.username, v.joined_at
+	FROM voice_participants v
+	JOIN users u ON u.id = v.user_id
+`
+
+func scanVoiceParticipants(rows *sql.Rows) ([]VoiceParticipant, error) {
+	defer rows.Close()
+
+	participants := make([]VoiceParticipant, 0)
+	for rows.Next() {
+		var p VoiceParticipant
+		if err := rows.Scan(&p.PeerID, &p.RoomID, &p.UserID, &p.Username, &p.JoinedAt); err != nil {
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// AddVoiceParticipant puts a connection into a voice room
+func (d *Database) AddVoiceParticipant(ctx context.Context, peerID string, roomID, userID int) (*VoiceParticipant, error) {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO voice_participants (peer_id, room_id, user_id) VALUES (?, ?, ?)",
+		peerID, roomID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetVoiceParticipant(ctx, peerID)
+}
+
+func (d *Database) GetVoiceParticipant(ctx context.Context, peerID string) (*VoiceParticipant, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+voiceParticipantColumns+" WHERE v.peer_id = ?", peerID)
+	if err != nil {
+		return nil, err
+	}
+	participants, err := scanVoiceParticipants(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(participants) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &participants[0], nil
+}
+
+// GetVoiceParticipants lists who's in a voice room, in the order they joined
+func (d *Database) GetVoiceParticipants(ctx context.Context, roomID int) ([]VoiceParticipant, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+voiceParticipantColumns+" WHERE v.room_id = ? ORDER BY v.joined_at, v.peer_id", roomID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVoiceParticipants(rows)
+}
+
+// RemoveVoiceParticipant takes a connection out of its voice room, and
+// reports whether it was still in one
+func (d *Database) RemoveVoiceParticipant(ctx context.Context, peerID string) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM voice_participants WHERE peer_id = ?", peerID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// TouchVoiceParticipant records that a participant's connection is alive
+func (d *Database) TouchVoiceParticipant(ctx context.Context, peerID string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE voice_participants SET seen_at = CURRENT_TIMESTAMP WHERE peer_id = ?", peerID)
+	return err
+}
+
+// DeleteStaleVoiceParticipants removes participants not seen since before
+// and returns them. Several instances can prune at once; each participant
+// is only returned by the one that removed it.
+func (d *Database) DeleteStaleVoiceParticipants(ctx context.Context, before time.Time) ([]VoiceParticipant, error) {
+	cutoff := before.UTC().Format(sqliteTimeFormat)
+	rows, err := d.db.QueryContext(ctx, "SELECT "+voiceParticipantColumns+" WHERE v.seen_at < ?", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	stale, err := scanVoiceParticipants(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]VoiceParticipant, 0, len(stale))
+	for _, p := range stale {
+		result, err := d.db.ExecContext(ctx, "DELETE FROM voice_participants WHERE peer_id = ? AND seen_at < ?", p.PeerID, cutoff)
+		if err != nil {
+			return removed, err
+		}
+		if n, err := result.RowsAffected(); err == nil && n > 0 {
+			removed = append(removed, p)
+		}
+	}
+	return removed, nil
+}
+
+// GetDeviceKeys lists the devices a user published keys for, oldest first.
+// withCounts adds how many one-time prekeys each has left.
+func (d *Database) GetDeviceKeys(ctx context.Context, userID int, withCounts bool) ([]DeviceKeys, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT k.user_id, k.device_id, k.identity_key, k.signed_prekey, k.prekey_signature, k.updated_at,
+			(SELECT COUNT(*) FROM one_time_prekeys o WHERE o.user_id = k.user_id AND o.device_id = k.device_id)
+		FROM device_keys k
+		WHERE k.user_id = ?
+		ORDER BY k.rowid
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]DeviceKeys, 0)
+	for rows.Next() {
+		var keys DeviceKeys
+		var count int
+		if err := rows.Scan(&keys.UserID, &keys.DeviceID, &keys.IdentityKey, &keys.SignedPrekey, &keys.PrekeySignature, &keys.UpdatedAt, &count); err != nil {
+			return nil, err
+		}
+		if withCounts {
+			keys.OneTimePrekeys = &count
+		}
+		devices = append(devices, keys)
+	}
+	return devices, rows.Err()
+}
+
+// SetDeviceKeys publishes a device's keys, replacing what it had before
+func (d *Database) SetDeviceKeys(ctx context.Context, keys DeviceKeys) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO device_keys (user_id, device_id, identity_key, signed_prekey, prekey_signature)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, device_id) DO UPDATE SET
+			identity_key = excluded.identity_key,
+			signed_prekey = excluded.signed_prekey,
+			prekey_signature = excluded.prekey_signature,
+			updated_at = CURRENT_TIMESTAMP
+	`, keys.UserID, keys.DeviceID, keys.IdentityKey, keys.SignedPrekey, keys.PrekeySignature)
+	return err
+}
+
+// AddOneTimePrekeys stores more one-time prekeys for a device. Keys it
+// already has (by key ID) are left alone, so uploads can be retried.
+func (d *Database) AddOneTimePrekeys(ctx context.Context, userID int, deviceID string, prekeys []OneTimePrekey) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, prekey := range prekeys {
+		_, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO one_time_prekeys (user_id, device_id, key_id, public_key) VALUES (?, ?, ?, ?)",
+			userID, deviceID, prekey.KeyID, prekey.PublicKey,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClaimOneTimePrekey hands out one of a device's one-time prekeys and
+// deletes it, so no two claims get the same one. It returns nil when the
+// device has none left.
+func (d *Database) ClaimOneTimePrekey(ctx context.Context, userID int, deviceID string) (*OneTimePrekey, error) {
+	prekey := &OneTimePrekey{}
+	err := d.db.QueryRowContext(ctx, `
+		DELETE FROM one_time_prekeys WHERE id = (
+			SELECT id FROM one_time_prekeys WHERE user_id = ? AND device_id = ? ORDER BY id LIMIT 1
+		)
+		RETURNING key_id, public_key
+	`, userID, deviceID).Scan(&prekey.KeyID, &prekey.PublicKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prekey, nil
+}
+
+// DeleteDeviceKeys removes a device and its one-time prekeys, and reports
+// whether there was such a device
+func (d *Database) DeleteDeviceKeys(ctx context.Context, userID int, deviceID string) (bool, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM device_keys WHERE user_id = ? AND device_id = ?", userID, deviceID)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM one_time_prekeys WHERE user_id = ? AND device_id = ?", userID, deviceID); err != nil {
+		return false, err
+	}
+	return deleted > 0, tx.Commit()
+}
+
+// GetDMPartnerIDs lists everyone a user has a DM conversation with, not
+// counting declined requests
+func (d *Database) GetDMPartnerIDs(ctx context.Context, userID int) ([]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT CASE WHEN user_low = ?1 THEN user_high ELSE user_low END
+		FROM dm_conversations
+		WHERE (user_low = ?1 OR user_high = ?1) AND status != 'declined'
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones work without the system's zoneinfo
+)
+
+// MaxDNDWindows is how many do-not-disturb windows a user can have
+const MaxDNDWindows = 14
+
+// dndDays are the names of the weekdays in DNDWindow.Days, Sunday first like
+// time.Weekday
+var dndDays = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// DNDWindow is a time of day a user doesn't want to be notified, as
+// "HH:MM" in their time zone. It starts on the listed days, every day if
+// there are none, and an End that isn't after Start is on the next day.
+type DNDWindow struct {
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// dndWindow is a DNDWindow as stored
+type dndWindow struct {
+	days       int // bitmask, Sunday is 1
+	start, end int // minutes into the day
+}
+
+func parseClock(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func (w DNDWindow) parse() (dndWindow, error) {
+	var parsed dndWindow
+	for _, day := range w.Days {
+		found := false
+		for i, name := range dndDays {
+			if strings.EqualFold(day, name) {
+				parsed.days |= 1 << i
+				found = true
+			}
+		}
+		if !found {
+			return parsed, fmt.Errorf("%q isn't a day, use sun, mon, tue, wed, thu, fri or sat", day)
+		}
+	}
+	if parsed.days == 0 {
+		parsed.days = 1<<7 - 1
+	}
+
+	var ok bool
+	if parsed.start, ok = parseClock(w.Start); !ok {
+		return parsed, fmt.Errorf("start must be a time of day like 22:00")
+	}
+	if parsed.end, ok = parseClock(w.End); !ok {
+		return parsed, fmt.Errorf("end must be a time of day like 07:00")
+	}
+	if parsed.start == parsed.end {
+		return parsed, fmt.Errorf("start and end must differ")
+	}
+	return parsed, nil
+}
+
+func (w dndWindow) window() DNDWindow {
+	window := DNDWindow{Start: formatClock(w.start), End: formatClock(w.end)}
+	if w.days != 1<<7-1 {
+		for i, name := range dndDays {
+			if w.days&(1<<i) != 0 {
+				window.Days = append(window.Days, name)
+			}
+		}
+	}
+	return window
+}
+
+// ValidateDNDWindows checks windows a user wants to set
+func ValidateDNDWindows(windows []DNDWindow) error {
+	if len(windows) > MaxDNDWindows {
+		return fmt.Errorf("at most %d DND windows are allowed", MaxDNDWindows)
+	}
+	for i, window := range windows {
+		if _, err := window.parse(); err != nil {
+			return fmt.Errorf("dnd_windows[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// ValidTimezone reports whether name is an IANA time zone
+func ValidTimezone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return err == nil && name != "" && name != "Local"
+}
+
+// DNDActive reports whether at is inside one of windows, read in timezone
+func DNDActive(windows []DNDWindow, timezone string, at time.Time) bool {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	at = at.In(location)
+	minute := at.Hour()*60 + at.Minute()
+	today := 1 << int(at.Weekday())
+	yesterday := 1 << ((int(at.Weekday()) + 6) % 7)
+
+	for _, window := range windows {
+		w, err := window.parse()
+		if err != nil {
+			continue
+		}
+		if w.start < w.end {
+			if w.days&today != 0 && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// Past midnight: the late part is today's, the early part yesterday's
+		if (w.days&today != 0 && minute >= w.start) || (w.days&yesterday != 0 && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDNDWindows returns a user's do-not-disturb windows in the order they
+// were set
+func (d *Database) GetDNDWindows(ctx context.Context, userID int) ([]DNDWindow, error) {
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT days, start_minute, end_minute FROM dnd_windows WHERE user_id = ? ORDER BY position",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := make([]DNDWindow, 0)
+	for rows.Next() {
+		var w dndWindow
+		if err := rows.Scan(&w.days, &w.start, &w.end); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w.window())
+	}
+	return windows, rows.Err()
+}
+
+// SetDNDSchedule replaces a user's time zone and do-not-disturb windows,
+// which must have passed ValidTimezone and ValidateDNDWindows
+func (d *Database) SetDNDSchedule(ctx context.Context, userID int, timezone string, windows []DNDWindow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_settings (user_id, timezone) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET timezone = excluded.timezone
+	`, userID, timezone); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM dnd_windows WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for i, window := range windows {
+		w, err := window.parse()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO dnd_windows (user_id, position, days, start_minute, end_minute) VALUES (?, ?, ?, ?, ?)",
+			userID, i, w.days, w.start, w.end,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,36 @@
+package store
+
+import "time"
+
+// Self-destructing messages live between MinMessageTTL and MaxMessageTTL
+const (
+	MinMessageTTL = 5 * time.Second
+	MaxMessageTTL = 7 * 24 * time.Hour
+)
+
+// ValidMessageTTL reports whether seconds is a TTL messages can have; 0
+// means none
+func ValidMessageTTL(seconds int) bool {
+	ttl := time.Duration(seconds) * time.Second
+	return seconds == 0 || (ttl >= MinMessageTTL && ttl <= MaxMessageTTL)
+}
+
+// MessageExpiry works out when a message sent to room with the requested
+// TTL (0 for none) is deleted. The room's policy is an upper bound, a
+// sender can only make their message disappear sooner.
+func MessageExpiry(room *Room, requested int) *time.Time {
+	ttl := requested
+	if room.MessageTTL > 0 && (ttl == 0 || ttl > room.MessageTTL) {
+		ttl = room.MessageTTL
+	}
+	if ttl == 0 {
+		return nil
+	}
+	expiresAt := time.Now().UTC().Add(time.Duration(ttl) * time.Second)
+	return &expiresAt
+}
+
+// IsExpired reports whether message is past its TTL but not deleted yet
+func IsExpired(message *Message) bool {
+	return message.ExpiresAt != nil && !message.ExpiresAt.After(time.Now())
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// JoinRequest is someone waiting to be let into a hall whose JoinMode is
+// approval
+type JoinRequest struct {
+	HallID    int       `json:"hall_id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const joinRequestColumns = `
+	SELECT jr.hall_id, jr.user_id, u.username, jr.created_at
+	FROM hall_join_requests jr JOIN users u ON u.id = jr.user_id`
+
+func scanJoinRequest(row interface{ Scan(...interface{}) error }) (*JoinRequest, error) {
+	var request JoinRequest
+	if err := row.Scan(&request.HallID, &request.UserID, &request.Username, &request.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// CreateJoinRequest asks to join a hall. created is false if the user had
+// already asked, in which case the earlier request is returned.
+func (d *Database) CreateJoinRequest(ctx context.Context, hallID, userID int) (request *JoinRequest, created bool, err error) {
+	result, err := d.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO hall_join_requests (hall_id, user_id) VALUES (?, ?)",
+		hallID, userID,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	request, err = d.GetJoinRequest(ctx, hallID, userID)
+	return request, n > 0, err
+}
+
+func (d *Database) GetJoinRequest(ctx context.Context, hallID, userID int) (*JoinRequest, error) {
+	return scanJoinRequest(d.db.QueryRowContext(ctx, joinRequestColumns+" WHERE jr.hall_id = ? AND jr.user_id = ?", hallID, userID))
+}
+
+// GetJoinRequests lists a hall's pending join requests, oldest first
+func (d *Database) GetJoinRequests(ctx context.Context, hallID int) ([]JoinRequest, error) {
+	rows, err := d.db.QueryContext(ctx, joinRequestColumns+" WHERE jr.hall_id = ? ORDER BY jr.created_at, jr.user_id", hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]JoinRequest, 0)
+	for rows.Next() {
+		request, err := scanJoinRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *request)
+	}
+	return requests, rows.Err()
+}
+
+// ApproveJoinRequest makes the requester a member of the hall. It's false if
+// there was no such request, e.g. because another admin got to it first.
+func (d *Database) ApproveJoinRequest(ctx context.Context, hallID, userID int) (bool, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM hall_join_requests WHERE hall_id = ? AND user_id = ?", hallID, userID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO hall_members (hall_id, user_id) VALUES (?, ?)", hallID, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeleteJoinRequest denies or withdraws a join request. It's false if there
+// was no such request.
+func (d *Database) DeleteJoinRequest(ctx context.Context, hallID, userID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM hall_join_requests WHERE hall_id = ? AND user_id = ?", hallID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (d *Database) SetJoinMode(ctx context.Context, hallID int, joinMode string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO hall_settings (hall_id, join_mode) VALUES (?, ?)
+		ON CONFLICT(hall_id) DO UPDATE SET join_mode = excluded.join_mode
+	`, hallID, joinMode)
+	return err
+}
@@ -0,0 +1,323 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// journalFile is the journal being written; rotated ones are named after
+// it with the time they were rotated, and gzipped
+const (
+	journalFile   = "messages.jsonl"
+	journalPrefix = "messages-"
+	journalSuffix = ".jsonl.gz"
+)
+
+// JournalEntry is one line of the message journal: a room message or a DM
+// as it was stored
+type JournalEntry struct {
+	Message   *Message   `json:"message,omitempty"`
+	DMMessage *DMMessage `json:"dm_message,omitempty"`
+}
+
+// Journal appends every message the database stores to JSONL files, apart
+// from the database, so history can be rebuilt or audited if the database
+// is lost. The current file is rotated once it reaches maxBytes and rotated
+// files are gzipped, the oldest removed past keep (0 keeps them all).
+// Nothing is ever taken out of them, deleted messages included.
+type Journal struct {
+	dir      string
+	maxBytes int64
+	keep     int
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	logger   *log.Logger
+}
+
+// OpenJournal appends to the journal in dir, creating it if need be
+func OpenJournal(dir string, maxBytes int64, keep int, logger *log.Logger) (*Journal, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, maxBytes: maxBytes, keep: keep, logger: logger}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	// Files rotated just before a crash may not have been compressed yet
+	leftovers, err := filepath.Glob(filepath.Join(dir, journalPrefix+"*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range leftovers {
+		go j.compress(path)
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	file, err := os.OpenFile(filepath.Join(j.dir, journalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file, j.size = file, info.Size()
+	return nil
+}
+
+// append writes an entry, rotating first if the file is full. Failing to
+// journal doesn't fail the write it's for, so it's only logged.
+func (j *Journal) append(entry JournalEntry) {
+	if j == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		j.logger.Printf("Failed to journal message: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return
+	}
+	if j.size > 0 && j.size+int64(len(line)) > j.maxBytes {
+		if err := j.rotate(); err != nil {
+			j.logger.Printf("Failed to rotate message journal: %v", err)
+			if j.file == nil {
+				return
+			}
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		j.logger.Printf("Failed to journal message: %v", err)
+	}
+}
+
+// rotate moves the current file aside and starts a new one, then
+// compresses the old one in the background. The caller holds the lock.
+func (j *Journal) rotate() error {
+	rotated := filepath.Join(j.dir, journalPrefix+time.Now().UTC().Format("20060102T150405.000000000Z")+".jsonl")
+	// If this fails, appending to the full file beats losing entries
+	if err := os.Rename(filepath.Join(j.dir, journalFile), rotated); err != nil {
+		return err
+	}
+	if err := j.file.Close(); err != nil {
+		j.logger.Printf("Failed to close message journal: %v", err)
+	}
+	j.file = nil
+	if err := j.open(); err != nil {
+		return err
+	}
+
+	go j.compress(rotated)
+	return nil
+}
+
+// compress gzips a rotated file and then prunes old ones
+func (j *Journal) compress(path string) {
+	if err := gzipFile(path, path[:len(path)-len(".jsonl")]+journalSuffix); err != nil {
+		j.logger.Printf("Failed to compress %s: %v", path, err)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		j.logger.Printf("Failed to remove %s: %v", path, err)
+	}
+	j.prune()
+}
+
+func gzipFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// prune removes the oldest rotated files beyond keep
+func (j *Journal) prune() {
+	if j.keep == 0 {
+		return
+	}
+	rotated, err := rotatedJournalFiles(j.dir)
+	if err != nil {
+		j.logger.Printf("Failed to list message journal: %v", err)
+		return
+	}
+	for len(rotated) > j.keep {
+		if err := os.Remove(rotated[0]); err != nil {
+			j.logger.Printf("Failed to remove %s: %v", rotated[0], err)
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// Close stops journaling; later writes aren't journaled
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// JournalFiles lists the journal's files in dir oldest first: the rotated
+// ones, then the current one if it exists
+func JournalFiles(dir string) ([]string, error) {
+	files, err := rotatedJournalFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); err == nil {
+		files = append(files, filepath.Join(dir, journalFile))
+	}
+	return files, nil
+}
+
+// rotatedJournalFiles lists the compressed journal files in dir, oldest
+// first
+func rotatedJournalFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, journalPrefix) && strings.HasSuffix(name, journalSuffix) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	// Rotation times sort as text
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadJournal calls fn with each entry of a journal file, gzipped or not.
+// A last line cut short is skipped.
+func ReadJournal(path string, fn func(JournalEntry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var broken error
+	for line := 1; scanner.Scan(); line++ {
+		// A crash can leave the last line half written, but only the last
+		if broken != nil {
+			return broken
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			broken = fmt.Errorf("%s:%d: %w", path, line, err)
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// RestoreJournalEntry stores a journaled message again under its own ID and
+// time, unless the database already has it. It reports whether it was
+// missing.
+func (d *Database) RestoreJournalEntry(ctx context.Context, entry JournalEntry) (bool, error) {
+	var result sql.Result
+	var err error
+	switch {
+	case entry.Message != nil:
+		m := entry.Message
+		var expires interface{}
+		if m.ExpiresAt != nil {
+			expires = m.ExpiresAt.UTC().Format(sqliteTimeFormat)
+		}
+		components, encodeErr := encodeComponents(m.Components)
+		if encodeErr != nil {
+			return false, encodeErr
+		}
+		result, err = d.db.ExecContext(ctx, `
+			INSERT OR IGNORE INTO messages (id, room_id, user_id, content, type, kind, payload, created_at, expires_at, components)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, m.ID, m.RoomID, m.UserID, m.Content, m.Type, m.Kind, string(m.Payload), m.CreatedAt.UTC().Format(sqliteTimeFormat), expires, components)
+	case entry.DMMessage != nil:
+		m := entry.DMMessage
+		result, err = d.db.ExecContext(ctx, `
+			INSERT OR IGNORE INTO dm_messages (id, conversation_id, user_id, content, encrypted, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, m.ID, m.ConversationID, m.UserID, m.Content, m.Encrypted, m.CreatedAt.UTC().Format(sqliteTimeFormat))
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	restored, err := result.RowsAffected()
+	return restored > 0, err
+}
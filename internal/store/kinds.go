@@ -0,0 +1,248 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of message content. Type says who wrote a message and how; Kind says
+// what it holds, and every kind but text carries a Payload of its own shape.
+// The content of other kinds is a plain-text fallback for clients that don't
+// know the kind, and can be empty.
+const (
+	MessageKindText   = "text"
+	MessageKindImage  = "image"
+	MessageKindFile   = "file"
+	MessageKindSystem = "system" // written by the server, never sent by clients
+	MessageKindPoll   = "poll"
+	MessageKindCall   = "call"
+)
+
+// MessageKinds lists the kinds clients can send, in a stable order for
+// capabilities
+var MessageKinds = []string{MessageKindText, MessageKindImage, MessageKindFile, MessageKindPoll, MessageKindCall}
+
+// Limits on payloads
+const (
+	maxPayloadURL       = 2048 // bytes
+	maxPayloadText      = 300  // characters, for names, alt text and poll questions
+	maxPollOptionLength = 100  // characters
+	MinPollOptions      = 2
+	MaxPollOptions      = 10
+	maxCallDuration     = 7 * 24 * 60 * 60 // seconds
+)
+
+// ImagePayload is the payload of an image message
+type ImagePayload struct {
+	URL      string `json:"url"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Alt      string `json:"alt,omitempty"`
+	MimeType string `json:"mime_type,omitempty"` // image/*
+	Size     int64  `json:"size,omitempty"`      // bytes
+}
+
+// FilePayload is the payload of a file message
+type FilePayload struct {
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"` // bytes
+}
+
+// PollPayload is the payload of a poll. Votes aren't kept by the server; a
+// poll usually comes with a select component, see components.go.
+type PollPayload struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Multiple bool     `json:"multiple,omitempty"` // several options can be picked
+}
+
+// Call statuses
+const (
+	CallStarted = "started"
+	CallEnded   = "ended"
+	CallMissed  = "missed"
+)
+
+// CallPayload is the payload of a call message, a note in the room's history
+// that a call happened
+type CallPayload struct {
+	Status          string `json:"status"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // ended calls only
+}
+
+// SystemPayload is the payload of the system messages the server writes
+// from a template, so clients can show them in their own language: Key is
+// one of the i18n.System* keys, and Args fills in its placeholders.
+// Messages hall admins wrote, like welcome messages, have none.
+type SystemPayload struct {
+	Key  string            `json:"key"`
+	Args map[string]string `json:"args"`
+}
+
+// ValidateMessageKind checks a message's kind and payload as a client sent
+// them, and returns the kind, text if it was empty, with the payload
+// normalized: unknown fields are dropped, and text messages have none.
+func ValidateMessageKind(kind string, payload json.RawMessage) (string, json.RawMessage, error) {
+	if kind == "" {
+		kind = MessageKindText
+	}
+
+	var target interface {
+		validate() error
+	}
+	switch kind {
+	case MessageKindText:
+		if len(payload) > 0 && string(payload) != "null" {
+			return "", nil, fmt.Errorf("text messages don't have a payload")
+		}
+		return kind, nil, nil
+	case MessageKindImage:
+		target = &ImagePayload{}
+	case MessageKindFile:
+		target = &FilePayload{}
+	case MessageKindPoll:
+		target = &PollPayload{}
+	case MessageKindCall:
+		target = &CallPayload{}
+	case MessageKindSystem:
+		return "", nil, fmt.Errorf("system messages can't be sent")
+	default:
+		return "", nil, fmt.Errorf("unknown message kind %q", kind)
+	}
+
+	if len(payload) == 0 {
+		return "", nil, fmt.Errorf("%s messages need a payload", kind)
+	}
+	if err := json.Unmarshal(payload, target); err != nil {
+		return "", nil, fmt.Errorf("invalid %s payload", kind)
+	}
+	if err := target.validate(); err != nil {
+		return "", nil, err
+	}
+	normalized, err := json.Marshal(target)
+	if err != nil {
+		return "", nil, err
+	}
+	return kind, normalized, nil
+}
+
+// AttachmentSize is the size in bytes an image or file message's payload
+// gives, 0 for other kinds or when it doesn't say
+func AttachmentSize(kind string, payload json.RawMessage) int64 {
+	var attachment struct {
+		Size int64 `json:"size"`
+	}
+	if kind != MessageKindImage && kind != MessageKindFile {
+		return 0
+	}
+	if err := json.Unmarshal(payload, &attachment); err != nil {
+		return 0
+	}
+	return attachment.Size
+}
+
+// validatePayloadURL accepts absolute http(s) URLs
+func validatePayloadURL(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("url is required")
+	}
+	if len(raw) > maxPayloadURL {
+		return fmt.Errorf("url is limited to %d bytes", maxPayloadURL)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	return nil
+}
+
+func validateMimeType(mimeType, prefix string) error {
+	if mimeType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil || !strings.HasPrefix(mediaType, prefix) {
+		return fmt.Errorf("invalid mime_type %q", mimeType)
+	}
+	return nil
+}
+
+func (p *ImagePayload) validate() error {
+	if err := validatePayloadURL(p.URL); err != nil {
+		return err
+	}
+	if p.Width < 0 || p.Height < 0 || p.Size < 0 {
+		return fmt.Errorf("width, height and size can't be negative")
+	}
+	if utf8.RuneCountInString(p.Alt) > maxPayloadText {
+		return fmt.Errorf("alt is limited to %d characters", maxPayloadText)
+	}
+	return validateMimeType(p.MimeType, "image/")
+}
+
+func (p *FilePayload) validate() error {
+	if err := validatePayloadURL(p.URL); err != nil {
+		return err
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" || utf8.RuneCountInString(p.Name) > maxPayloadText {
+		return fmt.Errorf("name must be 1 to %d characters", maxPayloadText)
+	}
+	if p.Size < 0 {
+		return fmt.Errorf("size can't be negative")
+	}
+	return validateMimeType(p.MimeType, "")
+}
+
+func (p *PollPayload) validate() error {
+	p.Question = strings.TrimSpace(p.Question)
+	if p.Question == "" || utf8.RuneCountInString(p.Question) > maxPayloadText {
+		return fmt.Errorf("question must be 1 to %d characters", maxPayloadText)
+	}
+	if len(p.Options) < MinPollOptions || len(p.Options) > MaxPollOptions {
+		return fmt.Errorf("polls need %d to %d options", MinPollOptions, MaxPollOptions)
+	}
+	seen := make(map[string]bool, len(p.Options))
+	for i, option := range p.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength {
+			return fmt.Errorf("poll options must be 1 to %d characters", maxPollOptionLength)
+		}
+		if seen[option] {
+			return fmt.Errorf("poll option %q is there twice", option)
+		}
+		seen[option] = true
+		p.Options[i] = option
+	}
+	return nil
+}
+
+func (p *CallPayload) validate() error {
+	switch p.Status {
+	case CallStarted, CallMissed:
+		if p.DurationSeconds != 0 {
+			return fmt.Errorf("only ended calls have a duration")
+		}
+	case CallEnded:
+		if p.DurationSeconds < 0 || p.DurationSeconds > maxCallDuration {
+			return fmt.Errorf("duration_seconds must be between 0 and %d", maxCallDuration)
+		}
+	default:
+		return fmt.Errorf("call status must be %s, %s or %s", CallStarted, CallEnded, CallMissed)
+	}
+	return nil
+}
+
+// rawPayload is a payload as stored, nil for none
+func rawPayload(stored string) json.RawMessage {
+	if stored == "" {
+		return nil
+	}
+	return json.RawMessage(stored)
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrLegalHold is returned for deletes that would remove messages under a
+// legal hold
+var ErrLegalHold = errors.New("messages are under a legal hold")
+
+// LegalHold keeps messages from being pruned or deleted: every message in
+// RoomID, or every message UserID wrote in HallID. Only one of the two is set.
+type LegalHold struct {
+	ID        int       `json:"id"`
+	HallID    int       `json:"hall_id"`
+	RoomID    int       `json:"room_id,omitempty"`
+	UserID    int       `json:"user_id,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// heldMessage is true for messages (aliased m) under a legal hold
+const heldMessage = `EXISTS (
+	SELECT 1 FROM legal_holds lh JOIN rooms hr ON hr.id = m.room_id
+	WHERE lh.room_id = m.room_id OR (lh.user_id = m.user_id AND lh.hall_id = hr.hall_id)
+)`
+
+const legalHoldColumns = "SELECT id, hall_id, room_id, user_id, reason, created_by, created_at FROM legal_holds"
+
+func scanLegalHold(row interface{ Scan(...interface{}) error }) (*LegalHold, error) {
+	var hold LegalHold
+	var roomID, userID sql.NullInt64
+	if err := row.Scan(&hold.ID, &hold.HallID, &roomID, &userID, &hold.Reason, &hold.CreatedBy, &hold.CreatedAt); err != nil {
+		return nil, err
+	}
+	hold.RoomID = int(roomID.Int64)
+	hold.UserID = int(userID.Int64)
+	return &hold, nil
+}
+
+// CreateLegalHold puts a room, or a user's messages in a hall, on hold
+func (d *Database) CreateLegalHold(ctx context.Context, hallID, roomID, userID int, reason string, createdBy int) (*LegalHold, error) {
+	result, err := d.db.ExecContext(ctx,
+		"INSERT INTO legal_holds (hall_id, room_id, user_id, reason, created_by) VALUES (?, ?, ?, ?, ?)",
+		hallID,
+		sql.NullInt64{Int64: int64(roomID), Valid: roomID != 0},
+		sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		reason, createdBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return d.GetLegalHold(ctx, int(id))
+}
+
+func (d *Database) GetLegalHold(ctx context.Context, holdID int) (*LegalHold, error) {
+	return scanLegalHold(d.db.QueryRowContext(ctx, legalHoldColumns+" WHERE id = ?", holdID))
+}
+
+// GetLegalHolds lists the holds in a hall, or in every hall if hallID is 0,
+// oldest first
+func (d *Database) GetLegalHolds(ctx context.Context, hallID int) ([]LegalHold, error) {
+	rows, err := d.db.QueryContext(ctx, legalHoldColumns+" WHERE ?1 = 0 OR hall_id = ?1 ORDER BY id", hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make([]LegalHold, 0)
+	for rows.Next() {
+		hold, err := scanLegalHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *hold)
+	}
+	return holds, rows.Err()
+}
+
+// ReleaseLegalHold lifts a hold. Messages it covered can be pruned again on
+// the next retention run.
+func (d *Database) ReleaseLegalHold(ctx context.Context, holdID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM legal_holds WHERE id = ?", holdID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetHeldMessagesAfter returns up to limit of the messages a hold covers with
+// IDs above afterID, in ID order. Self-destructing messages past their time
+// are included, since the hold keeps them.
+func (d *Database) GetHeldMessagesAfter(ctx context.Context, hold *LegalHold, afterID, limit int) ([]Message, error) {
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		JOIN rooms r ON r.id = m.room_id
+		WHERE r.hall_id = ? AND (m.room_id = ? OR m.user_id = ?) AND m.id > ?
+		ORDER BY m.id ASC
+		LIMIT ?
+	`, hold.HallID, hold.RoomID, hold.UserID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// RoomOnLegalHold reports whether deleting the room would take held messages
+// with it: the room is on hold, or someone on hold wrote in it
+func (d *Database) RoomOnLegalHold(ctx context.Context, roomID int) (bool, error) {
+	var held bool
+	err := d.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM legal_holds WHERE room_id = ?1)
+		    OR EXISTS (SELECT 1 FROM messages m WHERE m.room_id = ?1 AND `+heldMessage+`)
+	`, roomID).Scan(&held)
+	return held, err
+}
+
+// HallOnLegalHold reports whether anything in the hall is on hold
+func (d *Database) HallOnLegalHold(ctx context.Context, hallID int) (bool, error) {
+	var held bool
+	err := d.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM legal_holds WHERE hall_id = ?)", hallID).Scan(&held)
+	return held, err
+}
+
+// userOnLegalHold reports whether deleting the account would take held
+// messages with it: the user is on hold, a hall they own has a hold, or they
+// wrote in a room on hold
+const userOnLegalHold = `
+	SELECT EXISTS (SELECT 1 FROM legal_holds WHERE user_id = ?1)
+	    OR EXISTS (SELECT 1 FROM legal_holds WHERE hall_id IN (SELECT id FROM halls WHERE owner_id = ?1))
+	    OR EXISTS (SELECT 1 FROM messages m WHERE m.user_id = ?1 AND ` + heldMessage + `)
+`
+
+func (d *Database) UserOnLegalHold(ctx context.Context, userID int) (bool, error) {
+	var held bool
+	err := d.db.QueryRowContext(ctx, userOnLegalHold, userID).Scan(&held)
+	return held, err
+}
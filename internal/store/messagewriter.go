@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A room's writer commits at most messageWriteBatch messages per
+// transaction, and at most messageWriteQueue can wait for it before senders
+// are told to back off. Each batch gets messageWriteTimeout.
+const (
+	messageWriteBatch   = 64
+	messageWriteQueue   = 1024
+	messageWriteTimeout = 5 * time.Second
+)
+
+// ErrWriteQueueFull is returned by Submit when a room has too many messages
+// waiting to be written
+var ErrWriteQueueFull = errors.New("message write queue is full")
+
+// ErrWriterClosed is returned by Submit once the writer is closed
+var ErrWriterClosed = errors.New("message writer is closed")
+
+// MessageWrite is a room message waiting to be stored
+type MessageWrite struct {
+	RoomID     int
+	UserID     int
+	Content    string
+	Type       string // MessageTypeUser if empty
+	Kind       string // MessageKindText if empty
+	Payload    json.RawMessage
+	Nonce      string // empty if the sender didn't send one
+	ExpiresAt  *time.Time
+	Components []MessageComponent
+
+	done func(*Message, error)
+}
+
+// typeAndKind fills in the defaults for the write's type and kind
+func (w MessageWrite) typeAndKind() (string, string) {
+	messageType, kind := w.Type, w.Kind
+	if messageType == "" {
+		messageType = MessageTypeUser
+	}
+	if kind == "" {
+		kind = MessageKindText
+	}
+	return messageType, kind
+}
+
+// MessageWriter takes storing room messages off the websocket readers. Each
+// room with messages waiting has a goroutine that writes whatever has piled
+// up in one transaction, so a busy room pays for one commit per batch
+// instead of one per message, and its messages are stored and reported in
+// the order they were sent.
+type MessageWriter struct {
+	db *Database
+
+	mu      sync.Mutex
+	queues  map[int][]MessageWrite // a room has a writer while it has an entry
+	closed  bool
+	running sync.WaitGroup // the rooms' writers
+}
+
+func NewMessageWriter(db *Database) *MessageWriter {
+	return &MessageWriter{
+		db:     db,
+		queues: make(map[int][]MessageWrite),
+	}
+}
+
+// Submit queues a message for its room's writer. done is called from the
+// writer's goroutine once the message's transaction has committed, or with
+// the error if it couldn't be stored.
+func (w *MessageWriter) Submit(write MessageWrite, done func(*Message, error)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	pending, running := w.queues[write.RoomID]
+	if len(pending) >= messageWriteQueue {
+		return ErrWriteQueueFull
+	}
+
+	write.done = done
+	w.queues[write.RoomID] = append(pending, write)
+	if !running {
+		w.running.Add(1)
+		go w.drain(write.RoomID)
+	}
+	return nil
+}
+
+// Close stops taking messages and waits until the ones already queued are
+// written, or ctx is done
+func (w *MessageWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	written := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(written)
+	}()
+	select {
+	case <-written:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain writes a room's queue in batches until it's empty
+func (w *MessageWriter) drain(roomID int) {
+	defer w.running.Done()
+
+	for {
+		w.mu.Lock()
+		pending := w.queues[roomID]
+		if len(pending) == 0 {
+			delete(w.queues, roomID)
+			w.mu.Unlock()
+			return
+		}
+		batch := pending
+		if len(batch) > messageWriteBatch {
+			batch = batch[:messageWriteBatch]
+		}
+		w.queues[roomID] = append([]MessageWrite(nil), pending[len(batch):]...)
+		w.mu.Unlock()
+
+		w.write(batch)
+	}
+}
+
+func (w *MessageWriter) write(batch []MessageWrite) {
+	ctx, cancel := context.WithTimeout(context.Background(), messageWriteTimeout)
+	defer cancel()
+
+	messages, err := w.db.SaveMessages(ctx, batch)
+	if err == nil {
+		for i, write := range batch {
+			write.done(&messages[i], nil)
+		}
+		return
+	}
+	if len(batch) == 1 {
+		batch[0].done(nil, err)
+		return
+	}
+
+	// One bad message, like a reused nonce, fails the whole transaction, so
+	// the batch is retried one by one to find out whose it was
+	w.db.logger.Printf("Batch of %d messages failed, writing them one by one: %v", len(batch), err)
+	for _, write := range batch {
+		write.done(w.db.SaveMessage(ctx, write))
+	}
+}
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS message_flags;
+DROP TABLE IF EXISTS automod_rules;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS hall_admins;
+DROP TABLE IF EXISTS dm_conversation_state;
+DROP TABLE IF EXISTS dm_messages;
+DROP TABLE IF EXISTS dm_conversations;
+DROP TABLE IF EXISTS user_settings;
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS room_expiry;
+DROP TABLE IF EXISTS room_settings;
+DROP TABLE IF EXISTS hall_settings;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS hall_members;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS halls;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,172 @@
+-- Initial schema. Uses IF NOT EXISTS so databases created before migrations
+-- existed are adopted as version 1.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) UNIQUE NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS halls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    invite_code VARCHAR(20) UNIQUE NOT NULL,
+    owner_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (owner_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
+    UNIQUE(hall_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS hall_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(hall_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS hall_settings (
+    hall_id INTEGER PRIMARY KEY,
+    auto_archive_days INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS room_settings (
+    room_id INTEGER PRIMARY KEY,
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    archived_at DATETIME,
+    archive_exempt BOOLEAN NOT NULL DEFAULT 0,
+    archive_warned_at DATETIME,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS room_expiry (
+    room_id INTEGER PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+    on_expiry TEXT NOT NULL DEFAULT 'archive',
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji VARCHAR(32) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id INTEGER PRIMARY KEY,
+    dm_privacy VARCHAR(20) NOT NULL DEFAULT 'everyone',
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS dm_conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_low INTEGER NOT NULL,
+    user_high INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'accepted',
+    requested_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_low) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_high) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_low, user_high)
+);
+
+CREATE TABLE IF NOT EXISTS dm_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS dm_conversation_state (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    muted BOOLEAN NOT NULL DEFAULT 0,
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    unarchive_on_message BOOLEAN NOT NULL DEFAULT 0,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS hall_admins (
+    hall_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    granted_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hall_id, user_id),
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    actor_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL DEFAULT '',
+    target_id INTEGER NOT NULL DEFAULT 0,
+    details TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS automod_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    pattern TEXT NOT NULL,
+    is_regex BOOLEAN NOT NULL DEFAULT 0,
+    action VARCHAR(20) NOT NULL,
+    created_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_flags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    hall_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_room_created ON messages(room_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_hall_members_hall ON hall_members(hall_id);
+CREATE INDEX IF NOT EXISTS idx_hall_members_user ON hall_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_rooms_hall ON rooms(hall_id);
+CREATE INDEX IF NOT EXISTS idx_dm_messages_conversation ON dm_messages(conversation_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_message_reactions_message ON message_reactions(message_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_hall ON audit_log(hall_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_automod_rules_hall ON automod_rules(hall_id);
+CREATE INDEX IF NOT EXISTS idx_message_flags_hall ON message_flags(hall_id, created_at);
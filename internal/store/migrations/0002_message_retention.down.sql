@@ -0,0 +1,2 @@
+ALTER TABLE hall_settings DROP COLUMN retention_max_messages;
+ALTER TABLE hall_settings DROP COLUMN retention_days;
@@ -0,0 +1,4 @@
+-- Per-hall message retention: prune messages older than retention_days and/or
+-- beyond the newest retention_max_messages per room. 0 disables either rule.
+ALTER TABLE hall_settings ADD COLUMN retention_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE hall_settings ADD COLUMN retention_max_messages INTEGER NOT NULL DEFAULT 0;
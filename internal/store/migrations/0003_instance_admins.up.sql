@@ -0,0 +1,2 @@
+-- Server-wide administrators, who can manage every hall and account
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS room_events;
+DROP TABLE IF EXISTS room_sequences;
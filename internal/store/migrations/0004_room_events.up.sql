@@ -0,0 +1,18 @@
+-- Per-room event sequence numbers. The counter lives apart from the events
+-- so pruning old events never makes a room's sequence go backwards.
+CREATE TABLE IF NOT EXISTS room_sequences (
+    room_id INTEGER PRIMARY KEY,
+    seq INTEGER NOT NULL DEFAULT 0
+);
+
+-- Recent room events, replayed to websocket clients that resume
+CREATE TABLE IF NOT EXISTS room_events (
+    room_id INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, seq)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_events_created ON room_events(created_at);
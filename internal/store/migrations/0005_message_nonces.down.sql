@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_user_nonce;
+ALTER TABLE messages DROP COLUMN nonce;
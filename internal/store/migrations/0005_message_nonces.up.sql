@@ -0,0 +1,4 @@
+-- Client-generated idempotency keys for sent messages, so a retried
+-- send_message is only stored once per user
+ALTER TABLE messages ADD COLUMN nonce TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_user_nonce ON messages(user_id, nonce) WHERE nonce IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_notifications;
+ALTER TABLE user_settings DROP COLUMN email_notifications;
+ALTER TABLE user_settings DROP COLUMN email;
@@ -0,0 +1,18 @@
+-- Where to email a user, and whether they want notification digests
+ALTER TABLE user_settings ADD COLUMN email VARCHAR(254);
+ALTER TABLE user_settings ADD COLUMN email_notifications BOOLEAN NOT NULL DEFAULT 1;
+
+-- Mentions and DMs received while offline, waiting to go out in a digest.
+-- Rows are deleted once sent.
+CREATE TABLE IF NOT EXISTS email_notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL, -- mention or dm
+    actor_name VARCHAR(50) NOT NULL,
+    room_name VARCHAR(100) NOT NULL DEFAULT '', -- for mentions
+    hall_name VARCHAR(100) NOT NULL DEFAULT '',
+    excerpt TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_notifications_user ON email_notifications(user_id, created_at);
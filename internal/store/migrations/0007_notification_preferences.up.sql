@@ -0,0 +1,10 @@
+-- How much a user wants to hear about a hall or one of its rooms. room_id 0
+-- is the hall-wide preference; a room preference overrides it.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER NOT NULL,
+    hall_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL DEFAULT 0,
+    level VARCHAR(20) NOT NULL, -- all, mentions or muted
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, hall_id, room_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE room_settings DROP COLUMN feed_key;
+ALTER TABLE room_settings DROP COLUMN announcement;
@@ -0,0 +1,4 @@
+-- Announcement rooms are published as an Atom feed. Feed tokens are signed
+-- over feed_key, so replacing it revokes the old ones.
+ALTER TABLE room_settings ADD COLUMN announcement BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE room_settings ADD COLUMN feed_key VARCHAR(32);
@@ -0,0 +1,3 @@
+ALTER TABLE room_settings DROP COLUMN message_ttl_seconds;
+DROP INDEX IF EXISTS idx_messages_expires;
+ALTER TABLE messages DROP COLUMN expires_at;
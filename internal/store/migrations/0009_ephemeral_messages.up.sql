@@ -0,0 +1,5 @@
+-- Self-destructing messages: a message with expires_at is deleted once it
+-- passes. Rooms can give every message a TTL (seconds, 0 is none).
+ALTER TABLE messages ADD COLUMN expires_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL;
+ALTER TABLE room_settings ADD COLUMN message_ttl_seconds INTEGER NOT NULL DEFAULT 0;
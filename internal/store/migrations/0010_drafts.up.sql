@@ -0,0 +1,9 @@
+-- Half-typed messages, one per user and room, so they follow the user
+-- between devices
+CREATE TABLE IF NOT EXISTS drafts (
+    user_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, room_id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_voice_participants_room;
+DROP TABLE IF EXISTS voice_participants;
+ALTER TABLE rooms DROP COLUMN type;
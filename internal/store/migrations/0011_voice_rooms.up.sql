@@ -0,0 +1,12 @@
+-- Voice rooms, and who's connected to them. A participant is one connection,
+-- known to other peers by its random peer_id; seen_at is refreshed by the
+-- connection's pings so participants of a crashed instance get pruned.
+ALTER TABLE rooms ADD COLUMN type VARCHAR(10) NOT NULL DEFAULT 'text';
+CREATE TABLE IF NOT EXISTS voice_participants (
+    peer_id VARCHAR(32) PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_voice_participants_room ON voice_participants(room_id);
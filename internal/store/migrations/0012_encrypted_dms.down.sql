@@ -0,0 +1,3 @@
+ALTER TABLE dm_messages DROP COLUMN encrypted;
+DROP TABLE IF EXISTS one_time_prekeys;
+DROP TABLE IF EXISTS device_keys;
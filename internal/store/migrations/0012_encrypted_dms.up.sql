@@ -0,0 +1,21 @@
+-- End-to-end encrypted DMs. Devices publish their public keys here for
+-- others to fetch; each one-time prekey is handed out once. Encrypted DMs
+-- hold ciphertext the server can't read.
+CREATE TABLE IF NOT EXISTS device_keys (
+    user_id INTEGER NOT NULL,
+    device_id VARCHAR(64) NOT NULL,
+    identity_key TEXT NOT NULL,
+    signed_prekey TEXT NOT NULL,
+    prekey_signature TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, device_id)
+);
+CREATE TABLE IF NOT EXISTS one_time_prekeys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    device_id VARCHAR(64) NOT NULL,
+    key_id VARCHAR(64) NOT NULL,
+    public_key TEXT NOT NULL,
+    UNIQUE(user_id, device_id, key_id)
+);
+ALTER TABLE dm_messages ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT 0;
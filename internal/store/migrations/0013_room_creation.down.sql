@@ -0,0 +1 @@
+ALTER TABLE hall_settings DROP COLUMN room_creation;
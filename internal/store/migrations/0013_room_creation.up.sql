@@ -0,0 +1,3 @@
+-- Who may create rooms in a hall: members (everyone) or admins (the owner and
+-- granted admins)
+ALTER TABLE hall_settings ADD COLUMN room_creation VARCHAR(10) NOT NULL DEFAULT 'members';
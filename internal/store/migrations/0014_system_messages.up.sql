@@ -0,0 +1,3 @@
+-- Who wrote a message: a user, or the server announcing hall and room
+-- activity such as joins and new rooms
+ALTER TABLE messages ADD COLUMN type VARCHAR(10) NOT NULL DEFAULT 'user';
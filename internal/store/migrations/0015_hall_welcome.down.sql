@@ -0,0 +1,2 @@
+ALTER TABLE hall_settings DROP COLUMN landing_room_id;
+ALTER TABLE hall_settings DROP COLUMN welcome_message;
@@ -0,0 +1,4 @@
+-- What's posted when someone joins a hall, and the room they land in. Without
+-- a landing room members land in the hall's oldest text room.
+ALTER TABLE hall_settings ADD COLUMN welcome_message TEXT NOT NULL DEFAULT '';
+ALTER TABLE hall_settings ADD COLUMN landing_room_id INTEGER;
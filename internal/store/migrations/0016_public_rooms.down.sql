@@ -0,0 +1 @@
+ALTER TABLE room_settings DROP COLUMN public;
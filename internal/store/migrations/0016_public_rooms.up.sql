@@ -0,0 +1,2 @@
+-- Rooms anyone can read without an account, when guest access is on
+ALTER TABLE room_settings ADD COLUMN public BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- Server-level invites, needed to register when registration is invite-only
+CREATE TABLE registration_invites (
+    token VARCHAR(32) PRIMARY KEY,
+    created_by INTEGER NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 1, -- 0 for unlimited
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME,                 -- NULL never expires
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_user_settings_email_verify_token;
+ALTER TABLE user_settings DROP COLUMN email_verify_expires_at;
+ALTER TABLE user_settings DROP COLUMN email_verify_token;
+ALTER TABLE user_settings DROP COLUMN email_verified;
@@ -0,0 +1,6 @@
+-- Whether a user's email is confirmed, and the token mailed out to confirm
+-- it. Changing the email makes it unconfirmed again.
+ALTER TABLE user_settings ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE user_settings ADD COLUMN email_verify_token VARCHAR(64);
+ALTER TABLE user_settings ADD COLUMN email_verify_expires_at DATETIME;
+CREATE INDEX idx_user_settings_email_verify_token ON user_settings(email_verify_token);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_username_history_user;
+DROP TABLE username_history;
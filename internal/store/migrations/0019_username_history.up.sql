@@ -0,0 +1,9 @@
+-- Usernames people had before renaming themselves. Recent ones still
+-- resolve to them and can't be taken by anyone else.
+CREATE TABLE username_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    old_username VARCHAR(50) NOT NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_username_history_user ON username_history(user_id, changed_at);
@@ -0,0 +1,3 @@
+ALTER TABLE hall_settings DROP COLUMN spam_delete_score;
+ALTER TABLE hall_settings DROP COLUMN spam_throttle_score;
+ALTER TABLE hall_settings DROP COLUMN spam_flag_score;
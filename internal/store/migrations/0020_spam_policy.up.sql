@@ -0,0 +1,5 @@
+-- Spam scores at which a hall flags a message, throttles its sender or drops
+-- it. 0 turns a threshold off, and a hall with all three off isn't scored.
+ALTER TABLE hall_settings ADD COLUMN spam_flag_score INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE hall_settings ADD COLUMN spam_throttle_score INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE hall_settings ADD COLUMN spam_delete_score INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+-- Nothing to undo: the times are the same, only written consistently
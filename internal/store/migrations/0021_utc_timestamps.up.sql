@@ -0,0 +1,34 @@
+-- Rewrites every timestamp as UTC "YYYY-MM-DD HH:MM:SS", the format
+-- CURRENT_TIMESTAMP writes and queries compare against. Older versions could
+-- store times with a zone offset or fractional seconds, which sort wrongly
+-- next to the rest as text and come back in that zone. Values SQLite can't
+-- read as a time are left alone.
+
+UPDATE users SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE users SET last_seen = strftime('%Y-%m-%d %H:%M:%S', last_seen) WHERE last_seen != strftime('%Y-%m-%d %H:%M:%S', last_seen);
+UPDATE halls SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE rooms SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE hall_members SET joined_at = strftime('%Y-%m-%d %H:%M:%S', joined_at) WHERE joined_at != strftime('%Y-%m-%d %H:%M:%S', joined_at);
+UPDATE messages SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE messages SET expires_at = strftime('%Y-%m-%d %H:%M:%S', expires_at) WHERE expires_at != strftime('%Y-%m-%d %H:%M:%S', expires_at);
+UPDATE room_settings SET archived_at = strftime('%Y-%m-%d %H:%M:%S', archived_at) WHERE archived_at != strftime('%Y-%m-%d %H:%M:%S', archived_at);
+UPDATE room_settings SET archive_warned_at = strftime('%Y-%m-%d %H:%M:%S', archive_warned_at) WHERE archive_warned_at != strftime('%Y-%m-%d %H:%M:%S', archive_warned_at);
+UPDATE room_expiry SET expires_at = strftime('%Y-%m-%d %H:%M:%S', expires_at) WHERE expires_at != strftime('%Y-%m-%d %H:%M:%S', expires_at);
+UPDATE message_reactions SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE dm_conversations SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE dm_messages SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE hall_admins SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE audit_log SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE automod_rules SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE message_flags SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE room_events SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE email_notifications SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE notification_preferences SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) WHERE updated_at != strftime('%Y-%m-%d %H:%M:%S', updated_at);
+UPDATE drafts SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) WHERE updated_at != strftime('%Y-%m-%d %H:%M:%S', updated_at);
+UPDATE voice_participants SET joined_at = strftime('%Y-%m-%d %H:%M:%S', joined_at) WHERE joined_at != strftime('%Y-%m-%d %H:%M:%S', joined_at);
+UPDATE voice_participants SET seen_at = strftime('%Y-%m-%d %H:%M:%S', seen_at) WHERE seen_at != strftime('%Y-%m-%d %H:%M:%S', seen_at);
+UPDATE device_keys SET updated_at = strftime('%Y-%m-%d %H:%M:%S', updated_at) WHERE updated_at != strftime('%Y-%m-%d %H:%M:%S', updated_at);
+UPDATE registration_invites SET expires_at = strftime('%Y-%m-%d %H:%M:%S', expires_at) WHERE expires_at != strftime('%Y-%m-%d %H:%M:%S', expires_at);
+UPDATE registration_invites SET created_at = strftime('%Y-%m-%d %H:%M:%S', created_at) WHERE created_at != strftime('%Y-%m-%d %H:%M:%S', created_at);
+UPDATE user_settings SET email_verify_expires_at = strftime('%Y-%m-%d %H:%M:%S', email_verify_expires_at) WHERE email_verify_expires_at != strftime('%Y-%m-%d %H:%M:%S', email_verify_expires_at);
+UPDATE username_history SET changed_at = strftime('%Y-%m-%d %H:%M:%S', changed_at) WHERE changed_at != strftime('%Y-%m-%d %H:%M:%S', changed_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_legal_holds_user;
+DROP INDEX IF EXISTS idx_legal_holds_room;
+DROP INDEX IF EXISTS idx_legal_holds_hall;
+DROP TABLE legal_holds;
@@ -0,0 +1,15 @@
+-- Legal holds keep a room's messages, or one user's messages in a hall, from
+-- being pruned or deleted until the hold is released. Exactly one of
+-- room_id and user_id is set.
+CREATE TABLE legal_holds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    room_id INTEGER,
+    user_id INTEGER,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_legal_holds_hall ON legal_holds(hall_id);
+CREATE INDEX idx_legal_holds_room ON legal_holds(room_id);
+CREATE INDEX idx_legal_holds_user ON legal_holds(user_id);
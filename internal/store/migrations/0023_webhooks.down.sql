@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_webhook_dead_letters_webhook;
+DROP TABLE webhook_dead_letters;
+DROP INDEX IF EXISTS idx_webhooks_hall;
+DROP TABLE webhooks;
@@ -0,0 +1,27 @@
+-- Outgoing webhooks: hall events POSTed to url, signed with secret. events
+-- is a comma-separated list of event types, empty for all of them.
+CREATE TABLE webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '',
+    created_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_webhooks_hall ON webhooks(hall_id);
+
+-- Deliveries that failed for good, kept so they can be looked into and sent
+-- again
+CREATE TABLE webhook_dead_letters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL,
+    delivery_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    failed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_webhook_dead_letters_webhook ON webhook_dead_letters(webhook_id);
@@ -0,0 +1,2 @@
+-- A line about what a room is for, set with /topic
+ALTER TABLE room_settings ADD COLUMN topic TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Buttons and select menus attached to a message, as JSON; empty for none
+ALTER TABLE messages ADD COLUMN components TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE messages DROP COLUMN payload;
+ALTER TABLE messages DROP COLUMN kind;
@@ -0,0 +1,5 @@
+-- What a message holds, and the kind's own fields as JSON; text messages
+-- have no payload
+ALTER TABLE messages ADD COLUMN kind TEXT NOT NULL DEFAULT 'text';
+ALTER TABLE messages ADD COLUMN payload TEXT NOT NULL DEFAULT '';
+UPDATE messages SET kind = 'system' WHERE type = 'system';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_hall_join_requests_user;
+DROP TABLE hall_join_requests;
+ALTER TABLE hall_settings DROP COLUMN join_mode;
@@ -0,0 +1,13 @@
+-- How people join a hall with its invite code: right away (open), or by
+-- asking and waiting for an admin to approve (approval)
+ALTER TABLE hall_settings ADD COLUMN join_mode VARCHAR(10) NOT NULL DEFAULT 'open';
+
+-- Pending requests to join halls in approval mode. Approved and denied ones
+-- are deleted.
+CREATE TABLE hall_join_requests (
+    hall_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hall_id, user_id)
+);
+CREATE INDEX idx_hall_join_requests_user ON hall_join_requests(user_id);
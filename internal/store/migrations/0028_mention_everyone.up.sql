@@ -0,0 +1,3 @@
+-- Who may notify a whole hall with @everyone and @here: admins (the owner
+-- and granted admins) or members (everyone)
+ALTER TABLE hall_settings ADD COLUMN mention_everyone VARCHAR(10) NOT NULL DEFAULT 'admins';
@@ -0,0 +1,2 @@
+DROP TABLE dnd_windows;
+ALTER TABLE user_settings DROP COLUMN timezone;
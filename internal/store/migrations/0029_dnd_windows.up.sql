@@ -0,0 +1,14 @@
+-- The IANA time zone a user's do-not-disturb windows are in
+ALTER TABLE user_settings ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- Do-not-disturb windows: while one is on, email digests wait. days is a
+-- bitmask of the weekdays a window starts on, Sunday being 1; a window
+-- whose end_minute isn't after its start_minute runs past midnight.
+CREATE TABLE dnd_windows (
+    user_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    days INTEGER NOT NULL,
+    start_minute INTEGER NOT NULL,
+    end_minute INTEGER NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
@@ -0,0 +1,9 @@
+-- How far each user has read in each room: the newest message they've seen.
+-- Messages after it, other than their own, are unread.
+CREATE TABLE read_markers (
+    user_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, room_id)
+);
@@ -0,0 +1,2 @@
+DELETE FROM dm_conversations WHERE status = 'declined';
+ALTER TABLE dm_conversations DROP COLUMN declined_at;
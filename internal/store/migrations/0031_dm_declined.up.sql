@@ -0,0 +1,3 @@
+-- Declined message requests are kept with status 'declined' rather than
+-- deleted, so the requester can't ask again until a while after declined_at
+ALTER TABLE dm_conversations ADD COLUMN declined_at DATETIME;
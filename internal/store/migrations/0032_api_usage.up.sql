@@ -0,0 +1,9 @@
+-- Authenticated API requests per user per UTC day, metered against the
+-- daily quota. Instances sharing the database count into the same rows.
+CREATE TABLE api_usage (
+    user_id INTEGER NOT NULL,
+    day TEXT NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    last_used DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, day)
+);
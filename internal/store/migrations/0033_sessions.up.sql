@@ -0,0 +1,19 @@
+-- Sessions from logging in and tokens made for integrations, shared by every
+-- instance using the database. Only a SHA-256 hash of each token is kept.
+CREATE TABLE sessions (
+    id VARCHAR(32) PRIMARY KEY,          -- what the session is referred to by
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    user_id INTEGER NOT NULL,
+    csrf_token VARCHAR(64) NOT NULL DEFAULT '', -- cookie sessions only
+    name VARCHAR(100) NOT NULL DEFAULT '',      -- scoped tokens only
+    scopes TEXT,                         -- space separated; NULL may do anything
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    last_used DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+CREATE INDEX idx_sessions_expires ON sessions(expires_at);
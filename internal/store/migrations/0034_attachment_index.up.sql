@@ -0,0 +1,3 @@
+-- Image and file messages by room, for summing a hall's attachment sizes
+-- against its storage quota
+CREATE INDEX IF NOT EXISTS idx_messages_attachments ON messages(room_id) WHERE kind IN ('image', 'file');
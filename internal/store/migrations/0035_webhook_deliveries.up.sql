@@ -0,0 +1,14 @@
+-- Webhook deliveries waiting to be sent or retried, so a restart picks them
+-- up again. Each is resumed by the instance (node_id) that queued it, and
+-- deleted once it's delivered or dead-lettered.
+CREATE TABLE webhook_deliveries (
+    webhook_id INTEGER NOT NULL,
+    delivery_id TEXT NOT NULL,
+    node_id INTEGER NOT NULL DEFAULT 0,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    PRIMARY KEY (webhook_id, delivery_id)
+);
+CREATE INDEX idx_webhook_deliveries_node ON webhook_deliveries(node_id, next_attempt_at);
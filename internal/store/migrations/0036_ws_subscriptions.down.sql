@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_ws_subscriptions_saved;
+DROP TABLE ws_subscriptions;
@@ -0,0 +1,12 @@
+-- The rooms (with their seq at the time) and halls a user's ws connection
+-- on one device followed when it closed, so the next connection from that
+-- device, on any instance, is joined to them again. rooms and halls are JSON.
+CREATE TABLE ws_subscriptions (
+    user_id INTEGER NOT NULL,
+    device TEXT NOT NULL,
+    rooms TEXT NOT NULL DEFAULT '{}',
+    halls TEXT NOT NULL DEFAULT '[]',
+    saved_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, device)
+);
+CREATE INDEX idx_ws_subscriptions_saved ON ws_subscriptions(saved_at);
@@ -0,0 +1,428 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type User struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// UsernameChange is a username someone renamed away from
+type UsernameChange struct {
+	OldUsername string    `json:"old_username"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+type Hall struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	InviteCode string    `json:"invite_code"`
+	OwnerID    int       `json:"owner_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HallUsage is how much of its quotas a hall uses
+type HallUsage struct {
+	Rooms   int
+	Members int
+}
+
+// ListFilter narrows the hall and room listings. Cursor is the ID of the
+// last item on the previous page; a zero Limit returns everything.
+type ListFilter struct {
+	Name   string
+	Limit  int
+	Cursor int
+}
+
+// Who may create rooms in a hall
+const (
+	RoomCreationMembers = "members"
+	RoomCreationAdmins  = "admins" // the owner and granted admins
+)
+
+// How people join a hall with its invite code
+const (
+	JoinModeOpen     = "open"
+	JoinModeApproval = "approval" // they ask, and an admin lets them in or not
+)
+
+// Who may mention @everyone and @here in a hall
+const (
+	MentionEveryoneAdmins  = "admins" // the owner and granted admins
+	MentionEveryoneMembers = "members"
+)
+
+// HallSettings are a hall's settings every member can see
+type HallSettings struct {
+	RoomCreation    string `json:"room_creation"`
+	WelcomeMessage  string `json:"welcome_message"`           // {username} is the newcomer
+	LandingRoomID   *int   `json:"landing_room_id,omitempty"` // unset lands in the oldest text room
+	JoinMode        string `json:"join_mode"`
+	MentionEveryone string `json:"mention_everyone"`
+}
+
+// HallSettingsData is sent to a hall's members with hall_settings_updated
+type HallSettingsData struct {
+	HallID   int          `json:"hall_id"`
+	Settings HallSettings `json:"settings"`
+}
+
+type Room struct {
+	ID           int        `json:"id"`
+	HallID       int        `json:"hall_id"`
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Archived     bool       `json:"archived"`
+	Announcement bool       `json:"announcement"`
+	MessageTTL   int        `json:"message_ttl_seconds"` // 0 unless messages disappear
+	Public       bool       `json:"public"`              // readable by guests, see ws/guest.go
+	Topic        string     `json:"topic"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	OnExpiry     string     `json:"on_expiry,omitempty"`
+}
+
+// Kinds of room. Voice rooms also carry WebRTC signaling, see ws/voice.go.
+const (
+	RoomTypeText  = "text"
+	RoomTypeVoice = "voice"
+)
+
+// What happens to a temporary room when it expires
+const (
+	RoomExpiryArchive = "archive"
+	RoomExpiryDelete  = "delete"
+)
+
+type Message struct {
+	ID        int             `json:"id"`
+	RoomID    int             `json:"room_id"`
+	UserID    int             `json:"user_id"`
+	Username  string          `json:"username"`
+	Content   string          `json:"content"`
+	Type      string          `json:"type"`
+	Kind      string          `json:"kind"`              // what it holds, see kinds.go
+	Payload   json.RawMessage `json:"payload,omitempty"` // the kind's own fields
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"` // self-destructing messages
+
+	Components []MessageComponent `json:"components,omitempty"` // buttons and selects, see components.go
+}
+
+// Kinds of message. System messages are written by the server, as the system
+// user, to show hall and room activity in the room's history. Actions are
+// sent with /me and shown as something the sender did.
+const (
+	MessageTypeUser   = "user"
+	MessageTypeSystem = "system"
+	MessageTypeAction = "action"
+)
+
+type ArchiveCandidate struct {
+	Room    Room
+	Days    int
+	Due     bool
+	WarnDue bool
+}
+
+// RetentionPolicy limits how long a hall keeps messages; 0 disables a rule
+type RetentionPolicy struct {
+	Days               int `json:"days"`
+	MaxMessagesPerRoom int `json:"max_messages_per_room"`
+}
+
+type TopMessage struct {
+	Message       Message `json:"message"`
+	ReactionCount int     `json:"reaction_count"`
+}
+
+type HallMember struct {
+	ID       int       `json:"id"`
+	HallID   int       `json:"hall_id"`
+	UserID   int       `json:"user_id"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type HallMemberInfo struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Hall roles, as shown in member lists
+const (
+	HallRoleOwner  = "owner"
+	HallRoleAdmin  = "admin"
+	HallRoleMember = "member"
+)
+
+// RoomMember is someone who can see a room, for member lists
+type RoomMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Online   bool      `json:"online"`
+	JoinedAt time.Time `json:"joined_at"` // when they joined the hall
+}
+
+// AdminUserInfo is an account as instance admins see it
+type AdminUserInfo struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	IsAdmin   bool      `json:"is_admin"`
+	Halls     int       `json:"halls"`
+	Messages  int       `json:"messages"`
+}
+
+// AdminHallInfo is a hall as instance admins see it
+type AdminHallInfo struct {
+	Hall
+	OwnerUsername string `json:"owner_username"`
+	Members       int    `json:"members"`
+	Rooms         int    `json:"rooms"`
+	Messages      int    `json:"messages"`
+}
+
+// RegistrationInvite lets people register when registration is
+// invite-only, see server/registration.go
+type RegistrationInvite struct {
+	Token     string     `json:"token"`
+	CreatedBy int        `json:"created_by"`
+	MaxUses   int        `json:"max_uses"` // 0 for unlimited
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// InstanceStats are the row counts reported by the instance admin API
+type InstanceStats struct {
+	Users      int `json:"users"`
+	Admins     int `json:"admins"`
+	Halls      int `json:"halls"`
+	Rooms      int `json:"rooms"`
+	Messages   int `json:"messages"`
+	DMMessages int `json:"dm_messages"`
+}
+
+// UserSettings are a user's own preferences, from /api/settings
+type UserSettings struct {
+	DMPrivacy          string      `json:"dm_privacy"`
+	Email              string      `json:"email"` // empty when not set
+	EmailNotifications bool        `json:"email_notifications"`
+	EmailVerified      bool        `json:"email_verified"`
+	Timezone           string      `json:"timezone"` // IANA, what DNDWindows are in
+	DNDWindows         []DNDWindow `json:"dnd_windows"`
+	DND                bool        `json:"dnd"` // in one of DNDWindows right now
+}
+
+// Notification levels, for a hall or a room. Rooms without a preference
+// follow their hall, and halls without one default to all.
+const (
+	NotificationLevelAll      = "all"      // every message
+	NotificationLevelMentions = "mentions" // only when mentioned
+	NotificationLevelMuted    = "muted"    // nothing, mentions included
+)
+
+// NotificationPreference is a user's notification level for a hall, or for
+// one room in it when RoomID is set
+type NotificationPreference struct {
+	HallID int    `json:"hall_id"`
+	RoomID int    `json:"room_id,omitempty"`
+	Level  string `json:"level"`
+}
+
+// NotificationPreferences lists every preference a user has set
+type NotificationPreferences struct {
+	Halls []NotificationPreference `json:"halls"`
+	Rooms []NotificationPreference `json:"rooms"`
+}
+
+// Draft is a message a user has started typing in a room. A room without a
+// draft has empty Content and no UpdatedAt.
+type Draft struct {
+	RoomID    int        `json:"room_id"`
+	Content   string     `json:"content"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Kinds of email notification
+const (
+	EmailNotificationMention = "mention"
+	EmailNotificationDM      = "dm"
+)
+
+// EmailNotification is a mention or DM waiting to be emailed in a digest
+type EmailNotification struct {
+	ID        int
+	UserID    int
+	Kind      string
+	ActorName string
+	RoomName  string
+	HallName  string
+	Excerpt   string
+	CreatedAt time.Time
+}
+
+// EmailRecipient is a user who can be emailed
+type EmailRecipient struct {
+	UserID   int
+	Username string
+	Email    string
+	LastSeen time.Time
+}
+
+type AuditLogEntry struct {
+	ID         int       `json:"id"`
+	HallID     int       `json:"hall_id"`
+	ActorID    int       `json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   int       `json:"target_id"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// What automod does with a message matching a rule
+const (
+	AutomodActionReject = "reject" // refuse the message and tell the sender
+	AutomodActionFlag   = "flag"   // deliver it but put it in the flagged list
+	AutomodActionDelete = "delete" // silently drop it
+)
+
+type AutomodRule struct {
+	ID        int       `json:"id"`
+	HallID    int       `json:"hall_id"`
+	Pattern   string    `json:"pattern"`
+	IsRegex   bool      `json:"is_regex"`
+	Action    string    `json:"action"`
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SpamPolicy is the spam scores at which a hall flags a message, throttles
+// its sender or drops it; 0 turns a threshold off. See ws/spam.go.
+type SpamPolicy struct {
+	FlagScore     int `json:"flag_score"`
+	ThrottleScore int `json:"throttle_score"`
+	DeleteScore   int `json:"delete_score"`
+}
+
+// Enabled reports whether any of the policy's thresholds is set
+func (p SpamPolicy) Enabled() bool {
+	return p.FlagScore > 0 || p.ThrottleScore > 0 || p.DeleteScore > 0
+}
+
+// What the spam scorer does with a message over a hall's thresholds
+const (
+	SpamActionFlag     = "flag"     // deliver it but put it in the flagged list
+	SpamActionThrottle = "throttle" // refuse it and keep the sender from posting in the hall for a while
+	SpamActionDelete   = "delete"   // silently drop it
+)
+
+type FlaggedMessage struct {
+	ID        int       `json:"id"`
+	Reason    string    `json:"reason"`
+	FlaggedAt time.Time `json:"flagged_at"`
+	Message   Message   `json:"message"`
+}
+
+// Who may start a DM conversation with a user
+const (
+	DMPrivacyEveryone = "everyone"
+	DMPrivacyHalls    = "halls"
+	DMPrivacyNobody   = "nobody"
+)
+
+// DM conversation status; pending conversations are message requests, and
+// declined ones are requests the recipient turned down
+const (
+	DMStatusAccepted = "accepted"
+	DMStatusPending  = "pending"
+	DMStatusDeclined = "declined"
+)
+
+type DMConversation struct {
+	ID            int       `json:"id"`
+	OtherUserID   int       `json:"other_user_id"`
+	OtherUsername string    `json:"other_username"`
+	Status        string    `json:"status"`
+	RequestedBy   int       `json:"requested_by"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	DeclinedAt *time.Time `json:"declined_at,omitempty"` // declined requests only
+
+	// Per-viewer state
+	Muted              bool `json:"muted"`
+	Archived           bool `json:"archived"`
+	UnarchiveOnMessage bool `json:"unarchive_on_message"`
+}
+
+// Conversation lists for GetUserDMConversations
+const (
+	DMListInbox    = "inbox"
+	DMListRequests = "requests"
+	DMListArchived = "archived"
+)
+
+type DMMessage struct {
+	ID             int       `json:"id"`
+	ConversationID int       `json:"conversation_id"`
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	Content        string    `json:"content"`
+	Encrypted      bool      `json:"encrypted"` // Content is ciphertext only the devices in the conversation can read
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// DeviceKeys are the public keys a device publishes so others can open end
+// to end encrypted DM sessions with it. The server stores and hands them out
+// without looking inside.
+type DeviceKeys struct {
+	UserID          int       `json:"user_id"`
+	DeviceID        string    `json:"device_id"`
+	IdentityKey     string    `json:"identity_key"`
+	SignedPrekey    string    `json:"signed_prekey"`
+	PrekeySignature string    `json:"prekey_signature"`
+	OneTimePrekeys  *int      `json:"one_time_prekeys,omitempty"` // how many are left, only shown to the owner
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type OneTimePrekey struct {
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// ClaimedKeys is what it takes to open a session with one device.
+// OneTimePrekey is nil once the device has run out.
+type ClaimedKeys struct {
+	DeviceKeys
+	OneTimePrekey *OneTimePrekey `json:"one_time_prekey"`
+}
+
+// RoomEvent is a stored room broadcast, kept for replaying on resume
+type RoomEvent struct {
+	RoomID    int             `json:"room_id"`
+	Seq       int64           `json:"seq"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// VoiceParticipant is a connection in a voice room, sent with voice_joined
+type VoiceParticipant struct {
+	PeerID   string    `json:"peer_id"`
+	RoomID   int       `json:"room_id"`
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	JoinedAt time.Time `json:"joined_at"`
+}
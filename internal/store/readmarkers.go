@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// ReadState is how far a user has read in a room. Both are nil until they
+// set a read marker there.
+type ReadState struct {
+	LastReadMessageID    *int `json:"last_read_message_id"`
+	FirstUnreadMessageID *int `json:"first_unread_message_id"` // nil when there's nothing new
+}
+
+// SetReadMarker marks a room read up to messageID for a user. Markers only
+// move forward, so a device that's behind can't undo what another one read;
+// it reports whether this one did.
+func (d *Database) SetReadMarker(ctx context.Context, userID, roomID, messageID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `
+		INSERT INTO read_markers (user_id, room_id, message_id) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, room_id) DO UPDATE SET message_id = excluded.message_id, updated_at = CURRENT_TIMESTAMP
+		WHERE excluded.message_id > read_markers.message_id
+	`, userID, roomID, messageID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetReadStates returns a user's read state in each of roomIDs that has a
+// read marker. The first unread message is the oldest after the marker that
+// someone else sent and hasn't expired.
+func (d *Database) GetReadStates(ctx context.Context, userID int, roomIDs []int) (map[int]ReadState, error) {
+	states := make(map[int]ReadState)
+	if len(roomIDs) == 0 {
+		return states, nil
+	}
+
+	args := []interface{}{userID}
+	for _, roomID := range roomIDs {
+		args = append(args, roomID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(roomIDs)), ",")
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT rm.room_id, rm.message_id, (
+			SELECT MIN(m.id) FROM messages m
+			WHERE m.room_id = rm.room_id AND m.id > rm.message_id AND m.user_id != rm.user_id AND `+notExpired+`
+		)
+		FROM read_markers rm
+		WHERE rm.user_id = ? AND rm.room_id IN (`+placeholders+`)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID, lastRead int
+		var state ReadState
+		if err := rows.Scan(&roomID, &lastRead, &state.FirstUnreadMessageID); err != nil {
+			return nil, err
+		}
+		state.LastReadMessageID = &lastRead
+		states[roomID] = state
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,380 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The room cache keeps up to roomCacheMessages of a room's newest messages,
+// enough for the biggest page of GET /api/messages/{room_id}, and up to
+// roomCacheEvents of its newest stored events for resume.
+const (
+	roomCacheMessages = 100
+	roomCacheEvents   = 100
+)
+
+// RoomCache keeps the newest messages and events of recently read rooms in
+// memory, so the latest page of history and most resume replays don't touch
+// SQLite. A room is loaded on its first read, and past maxRooms the least
+// recently used one is dropped. Database keeps it current on every write it
+// makes; a nil *RoomCache caches nothing.
+type RoomCache struct {
+	mu       sync.Mutex
+	rooms    map[int]*cachedRoom
+	lru      *list.List // room IDs, most recently used first
+	maxRooms int
+	gen      uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// cachedRoom is what's loaded of one room. gen changes on every write to the
+// room, so a load that raced with a write is thrown away rather than stored.
+type cachedRoom struct {
+	elem *list.Element
+	gen  uint64
+
+	messagesLoaded bool
+	messages       []Message // oldest first
+	allMessages    bool      // messages is the whole room, not just its newest
+
+	eventsLoaded bool
+	events       []RoomEvent // oldest first
+	allEvents    bool        // events is every stored event of the room
+	seq          int64
+}
+
+func NewRoomCache(maxRooms int) *RoomCache {
+	return &RoomCache{
+		rooms:    make(map[int]*cachedRoom),
+		lru:      list.New(),
+		maxRooms: maxRooms,
+	}
+}
+
+// RoomCacheStats counts reads the cache answered and ones that went to the
+// database
+type RoomCacheStats struct {
+	Rooms  int
+	Hits   int64
+	Misses int64
+}
+
+func (c *RoomCache) Stats() RoomCacheStats {
+	if c == nil {
+		return RoomCacheStats{}
+	}
+	c.mu.Lock()
+	rooms := len(c.rooms)
+	c.mu.Unlock()
+	return RoomCacheStats{Rooms: rooms, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// nextGen must be called with mu held
+func (c *RoomCache) nextGen() uint64 {
+	c.gen++
+	return c.gen
+}
+
+// use returns the room's entry, creating it if needed, and marks it most
+// recently used. It must be called with mu held.
+func (c *RoomCache) use(roomID int) *cachedRoom {
+	if room, ok := c.rooms[roomID]; ok {
+		c.lru.MoveToFront(room.elem)
+		return room
+	}
+
+	room := &cachedRoom{elem: c.lru.PushFront(roomID), gen: c.nextGen()}
+	c.rooms[roomID] = room
+	for len(c.rooms) > c.maxRooms {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.rooms, oldest.Value.(int))
+	}
+	return room
+}
+
+// beginLoad returns the generation to hand back when storing what's loaded
+// from the database for roomID
+func (c *RoomCache) beginLoad(roomID int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.use(roomID).gen
+}
+
+// Messages returns a page of a room's history like GetRoomMessages, and
+// false if the cache can't answer it
+func (c *RoomCache) Messages(roomID, limit, offset int) ([]Message, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[roomID]
+	if !ok || !room.messagesLoaded || (offset+limit > len(room.messages) && !room.allMessages) {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	// Expired messages still count towards offsets until they're deleted,
+	// so a room holding any goes back to the database
+	for i := range room.messages {
+		if IsExpired(&room.messages[i]) {
+			room.messagesLoaded, room.messages = false, nil
+			c.misses.Add(1)
+			return nil, false
+		}
+	}
+
+	c.lru.MoveToFront(room.elem)
+	c.hits.Add(1)
+	return messagePage(room.messages, limit, offset), true
+}
+
+// messagePage cuts the page limit messages long ending offset messages
+// before the newest out of messages, oldest first, as a copy
+func messagePage(messages []Message, limit, offset int) []Message {
+	end := len(messages) - offset
+	if end < 0 {
+		end = 0
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	return append(make([]Message, 0, end-start), messages[start:end]...)
+}
+
+// storeMessages keeps a room's newest messages, oldest first, unless the
+// room was written to since gen
+func (c *RoomCache) storeMessages(roomID int, gen uint64, messages []Message, all bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[roomID]
+	if !ok || room.gen != gen {
+		return
+	}
+	room.messagesLoaded, room.messages, room.allMessages = true, messages, all
+}
+
+// AddMessage appends a newly saved message to its room. One that arrives out
+// of order makes the room load again instead.
+func (c *RoomCache) AddMessage(message Message) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[message.RoomID]
+	if !ok {
+		return
+	}
+	room.gen = c.nextGen()
+	if !room.messagesLoaded {
+		return
+	}
+
+	for _, cached := range room.messages {
+		if cached.ID == message.ID {
+			return // loaded after it was saved
+		}
+	}
+	if n := len(room.messages); n > 0 {
+		last := room.messages[n-1]
+		if message.ID < last.ID || message.CreatedAt.Before(last.CreatedAt) {
+			room.messagesLoaded, room.messages = false, nil
+			return
+		}
+	}
+
+	room.messages = append(room.messages, message)
+	if len(room.messages) > roomCacheMessages {
+		room.messages = append([]Message(nil), room.messages[len(room.messages)-roomCacheMessages:]...)
+		room.allMessages = false
+	}
+}
+
+// RemoveMessages drops deleted messages from whichever rooms hold them
+func (c *RoomCache) RemoveMessages(messageIDs []int) {
+	if c == nil {
+		return
+	}
+	deleted := make(map[int]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		deleted[id] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, room := range c.rooms {
+		room.gen = c.nextGen()
+		kept := room.messages[:0]
+		for _, message := range room.messages {
+			if !deleted[message.ID] {
+				kept = append(kept, message)
+			}
+		}
+		room.messages = kept
+	}
+}
+
+// InvalidateMessages drops every cached message, for writes that touch
+// messages across rooms, like renames
+func (c *RoomCache) InvalidateMessages() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, room := range c.rooms {
+		room.gen = c.nextGen()
+		room.messagesLoaded, room.messages = false, nil
+	}
+}
+
+// InvalidateRoom forgets everything about a room
+func (c *RoomCache) InvalidateRoom(roomID int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if room, ok := c.rooms[roomID]; ok {
+		c.lru.Remove(room.elem)
+		delete(c.rooms, roomID)
+	}
+}
+
+// Clear forgets everything
+func (c *RoomCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rooms = make(map[int]*cachedRoom)
+	c.lru.Init()
+}
+
+// Seq returns a room's latest sequence number, and false if it isn't cached
+func (c *RoomCache) Seq(roomID int) (int64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[roomID]
+	if !ok || !room.eventsLoaded {
+		return 0, false
+	}
+	return room.seq, true
+}
+
+// EventsSince returns up to limit events after seq like GetRoomEventsSince,
+// and false if the cache doesn't reach back that far
+func (c *RoomCache) EventsSince(roomID int, seq int64, limit int) ([]RoomEvent, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[roomID]
+	if !ok || !room.eventsLoaded {
+		c.misses.Add(1)
+		return nil, false
+	}
+	covered := room.allEvents || seq >= room.seq ||
+		(len(room.events) > 0 && seq >= room.events[0].Seq-1)
+	if !covered {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(room.elem)
+	c.hits.Add(1)
+	events := make([]RoomEvent, 0)
+	for _, event := range room.events {
+		if event.Seq > seq && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+// storeEvents keeps a room's newest events, oldest first, and its sequence
+// number, unless the room was written to since gen
+func (c *RoomCache) storeEvents(roomID int, gen uint64, seq int64, events []RoomEvent, all bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[roomID]
+	if !ok || room.gen != gen {
+		return
+	}
+	room.eventsLoaded, room.events, room.allEvents, room.seq = true, events, all, seq
+}
+
+// AddEvent appends a newly stored event to its room. A gap in the sequence
+// makes the room load again instead.
+func (c *RoomCache) AddEvent(event RoomEvent) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	room, ok := c.rooms[event.RoomID]
+	if !ok {
+		return
+	}
+	room.gen = c.nextGen()
+	if !room.eventsLoaded || event.Seq <= room.seq {
+		return
+	}
+	if event.Seq != room.seq+1 {
+		room.eventsLoaded, room.events = false, nil
+		return
+	}
+
+	room.events = append(room.events, event)
+	room.seq = event.Seq
+	if len(room.events) > roomCacheEvents {
+		room.events = append([]RoomEvent(nil), room.events[len(room.events)-roomCacheEvents:]...)
+		room.allEvents = false
+	}
+}
+
+// PruneEvents drops cached events older than before, as PruneRoomEvents
+// does in the database
+func (c *RoomCache) PruneEvents(before time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, room := range c.rooms {
+		room.gen = c.nextGen()
+		i := 0
+		for i < len(room.events) && room.events[i].CreatedAt.Before(before) {
+			i++
+		}
+		if i > 0 {
+			// The database lost everything older too, so what's left is all
+			// there is
+			room.events, room.allEvents = room.events[i:], true
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MaxRoomNameLength is what CleanRoomName truncates room names to
+const MaxRoomNameLength = 20
+
+// CleanRoomName processes room name according to rules
+func CleanRoomName(name string) string {
+	// Add # prefix if not present
+	if !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+
+	// Convert to lowercase
+	name = strings.ToLower(name)
+
+	// Replace spaces with dashes
+	name = strings.ReplaceAll(name, " ", "-")
+
+	// Remove invalid symbols (!@#$%^&*()_=) but keep # at start and allow dashes
+	reg := regexp.MustCompile(`[!@$%^&*()_=]+`)
+	name = reg.ReplaceAllString(name, "")
+
+	// Handle double dashes - replace with single dash
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+
+	// Remove trailing dashes
+	name = strings.TrimRight(name, "-")
+
+	// Limit to 20 characters
+	if len(name) > MaxRoomNameLength {
+		name = name[:MaxRoomNameLength]
+		// Remove trailing dash if cut created one
+		name = strings.TrimRight(name, "-")
+	}
+
+	// Must have at least # plus one character
+	if len(name) <= 1 {
+		return ""
+	}
+
+	return name
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrSessionNotFound is returned for a token that isn't a live session's
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a stored login or integration token. The token itself is only
+// known to whoever it was handed to; the database keeps its hash.
+type Session struct {
+	ID        string
+	UserID    int
+	Username  string // the user's current name
+	CSRFToken string
+	Name      string
+	Scopes    []string // nil for sessions that may do anything
+	UserAgent string
+	IP        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	LastUsed  time.Time
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const sessionColumns = `s.id, s.user_id, u.username, s.csrf_token, s.name, s.scopes, s.user_agent, s.ip,
+	s.created_at, s.expires_at, s.last_used`
+
+func scanSession(scanner interface{ Scan(...interface{}) error }) (*Session, error) {
+	session := &Session{}
+	var scopes sql.NullString
+	err := scanner.Scan(&session.ID, &session.UserID, &session.Username, &session.CSRFToken, &session.Name, &scopes,
+		&session.UserAgent, &session.IP, &session.CreatedAt, &session.ExpiresAt, &session.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+	if scopes.Valid {
+		session.Scopes = append([]string{}, strings.Fields(scopes.String)...)
+	}
+	return session, nil
+}
+
+// CreateSession stores a session that token authenticates
+func (d *Database) CreateSession(ctx context.Context, session *Session, token string) error {
+	var scopes sql.NullString
+	if session.Scopes != nil {
+		scopes = sql.NullString{String: strings.Join(session.Scopes, " "), Valid: true}
+	}
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO sessions (id, token_hash, user_id, csrf_token, name, scopes, user_agent, ip, created_at, expires_at, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, hashSessionToken(token), session.UserID, session.CSRFToken, session.Name, scopes,
+		session.UserAgent, session.IP,
+		session.CreatedAt.UTC().Format(sqliteTimeFormat),
+		session.ExpiresAt.UTC().Format(sqliteTimeFormat),
+		session.LastUsed.UTC().Format(sqliteTimeFormat),
+	)
+	return err
+}
+
+// GetSessionByToken returns the session token authenticates, expired or
+// not, or ErrSessionNotFound
+func (d *Database) GetSessionByToken(ctx context.Context, token string) (*Session, error) {
+	session, err := scanSession(d.db.QueryRowContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?
+	`, hashSessionToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// GetUserSessions returns the user's sessions that haven't expired by now,
+// oldest first
+func (d *Database) GetUserSessions(ctx context.Context, userID int, now time.Time) ([]Session, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT `+sessionColumns+`
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.user_id = ? AND s.expires_at > ?
+		ORDER BY s.created_at, s.id
+	`, userID, now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was used at lastUsed from ip
+func (d *Database) TouchSession(ctx context.Context, sessionID string, lastUsed time.Time, ip string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE sessions SET last_used = ?, ip = ? WHERE id = ?",
+		lastUsed.UTC().Format(sqliteTimeFormat), ip, sessionID,
+	)
+	return err
+}
+
+// DeleteSessions deletes the user's sessions with the given IDs and returns
+// the IDs of those that existed
+func (d *Database) DeleteSessions(ctx context.Context, userID int, sessionIDs []string) ([]string, error) {
+	deleted := make([]string, 0, len(sessionIDs))
+	if len(sessionIDs) == 0 {
+		return deleted, nil
+	}
+
+	args := []interface{}{userID}
+	for _, id := range sessionIDs {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(sessionIDs)), ",")
+	rows, err := d.db.QueryContext(ctx,
+		"DELETE FROM sessions WHERE user_id = ? AND id IN ("+placeholders+") RETURNING id",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	return deleted, rows.Err()
+}
+
+// CountSessions returns how many sessions haven't expired by now
+func (d *Database) CountSessions(ctx context.Context, now time.Time) (int, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM sessions WHERE expires_at > ?",
+		now.UTC().Format(sqliteTimeFormat),
+	).Scan(&count)
+	return count, err
+}
+
+// PruneSessions deletes sessions that expired by before
+func (d *Database) PruneSessions(ctx context.Context, before time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= ?", before.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// IDs of users, halls, rooms, messages and DMs are snowflakes generated here
+// rather than SQLite's AUTOINCREMENT: milliseconds since snowflakeEpoch, then
+// the node that made the ID, then a sequence number for IDs made in the same
+// millisecond. They sort by creation time, can be made by several writers at
+// once without asking the database, and don't give away how many there are.
+// The layout is kept to 53 bits so IDs stay exact as JSON numbers in
+// JavaScript; that lasts until 2093. Rows from before snowflakes keep their
+// small IDs, which sort before every snowflake.
+const (
+	snowflakeNodeBits     = 5
+	snowflakeSequenceBits = 7
+
+	// MaxNodeID is the highest node ID, so at most MaxNodeID+1 instances
+	// can write at once
+	MaxNodeID = 1<<snowflakeNodeBits - 1
+
+	maxSnowflakeSequence = 1<<snowflakeSequenceBits - 1
+)
+
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// IDGenerator makes snowflake IDs for one node. Instances sharing a database
+// need a node ID each, or they can make the same ID.
+type IDGenerator struct {
+	node     int64
+	mutex    sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+func NewIDGenerator(node int) *IDGenerator {
+	return &IDGenerator{node: int64(node) & MaxNodeID}
+}
+
+// Next returns a new ID, higher than any this generator returned before. If
+// the clock goes back or over 128 IDs are made in a millisecond, it borrows
+// from the next millisecond instead of waiting.
+func (g *IDGenerator) Next() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Since(snowflakeEpoch).Milliseconds()
+	if now > g.lastMs {
+		g.lastMs = now
+		g.sequence = 0
+	} else if g.sequence < maxSnowflakeSequence {
+		g.sequence++
+	} else {
+		g.lastMs++
+		g.sequence = 0
+	}
+
+	return int(g.lastMs<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence)
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Queries run for nearly every message. They're prepared once, the first
+// time they're used, rather than re-parsed by SQLite on each call. Preparing
+// lazily keeps NewDatabase usable before Migrate has created the tables.
+const (
+	querySaveMessage = "INSERT INTO messages (id, room_id, user_id, content, type, kind, payload, nonce, expires_at, components) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	queryMessageByID = `
+		SELECT m.id, m.room_id, m.user_id, u.username, m.content, m.type, m.kind, m.payload, m.created_at, m.expires_at, m.components
+		FROM messages m 
+		JOIN users u ON m.user_id = u.id 
+		WHERE m.id = ?
+	`
+
+	queryIsUserInHall = "SELECT COUNT(*) FROM hall_members WHERE user_id = ? AND hall_id = ?"
+
+	queryRoomByID = "SELECT " + roomColumns + " WHERE r.id = ?"
+)
+
+// stmtCache holds prepared statements keyed by their query text
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// stmt returns the prepared statement for query, preparing it on first use.
+// A failed prepare isn't cached, so it's retried on the next call.
+func (d *Database) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	d.stmts.mu.Lock()
+	defer d.stmts.mu.Unlock()
+
+	if stmt, ok := d.stmts.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := d.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if d.stmts.stmts == nil {
+		d.stmts.stmts = make(map[string]*sql.Stmt)
+	}
+	d.stmts.stmts[query] = stmt
+	return stmt, nil
+}
+
+// closeStmts closes every cached statement and empties the cache
+func (d *Database) closeStmts() {
+	d.stmts.mu.Lock()
+	defer d.stmts.mu.Unlock()
+
+	for _, stmt := range d.stmts.stmts {
+		stmt.Close()
+	}
+	d.stmts.stmts = nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDatabase opens a migrated database in a temporary directory
+func newTestDatabase(tb testing.TB) *Database {
+	tb.Helper()
+
+	d := openTestDatabase(tb)
+	if err := d.Migrate(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+	return d
+}
+
+// openTestDatabase opens an empty database in a temporary directory
+func openTestDatabase(tb testing.TB) *Database {
+	tb.Helper()
+
+	d, err := NewDatabase(Options{
+		Path:         filepath.Join(tb.TempDir(), "test.db"),
+		MaxOpenConns: 8,
+		MaxIdleConns: 8,
+		Logger:       log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { d.Close() })
+	return d
+}
+
+// benchFixture is a user in a hall with one room holding one message
+type benchFixture struct {
+	db        *Database
+	userID    int
+	hallID    int
+	roomID    int
+	messageID int
+}
+
+func newBenchFixture(b *testing.B) benchFixture {
+	b.Helper()
+	ctx := context.Background()
+	d := newTestDatabase(b)
+
+	user, err := d.CreateUser(ctx, "bench", "Password123!x")
+	if err != nil {
+		b.Fatal(err)
+	}
+	hall, err := d.CreateHall(ctx, "bench", user.ID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	room, err := d.CreateRoom(ctx, hall.ID, "general", RoomTypeText)
+	if err != nil {
+		b.Fatal(err)
+	}
+	message, err := d.SaveMessage(ctx, MessageWrite{RoomID: room.ID, UserID: user.ID, Content: "hello"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return benchFixture{db: d, userID: user.ID, hallID: hall.ID, roomID: room.ID, messageID: message.ID}
+}
+
+// The two halves of these benchmarks do the same work with the same query
+// text and arguments, one through the statement cache and the other straight
+// on the *sql.DB, as the methods did before statements were cached.
+
+func BenchmarkSaveMessage(b *testing.B) {
+	ctx := context.Background()
+	f := newBenchFixture(b)
+	write := MessageWrite{RoomID: f.roomID, UserID: f.userID, Content: "benchmark message"}
+	messageType, kind := write.typeAndKind()
+	args := func(id int) []interface{} {
+		return []interface{}{id, write.RoomID, write.UserID, write.Content, messageType, kind, "", sql.NullString{}, nil, ""}
+	}
+
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			insert, err := f.db.stmt(ctx, querySaveMessage)
+			if err != nil {
+				b.Fatal(err)
+			}
+			id := f.db.ids.Next()
+			if _, err := insert.ExecContext(ctx, args(id)...); err != nil {
+				b.Fatal(err)
+			}
+			selectMessage, err := f.db.stmt(ctx, queryMessageByID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := scanBenchMessage(selectMessage.QueryRowContext(ctx, id)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			id := f.db.ids.Next()
+			if _, err := f.db.db.ExecContext(ctx, querySaveMessage, args(id)...); err != nil {
+				b.Fatal(err)
+			}
+			if err := scanBenchMessage(f.db.db.QueryRowContext(ctx, queryMessageByID, id)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetMessageByID(b *testing.B) {
+	ctx := context.Background()
+	f := newBenchFixture(b)
+
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			selectMessage, err := f.db.stmt(ctx, queryMessageByID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := scanBenchMessage(selectMessage.QueryRowContext(ctx, f.messageID)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := scanBenchMessage(f.db.db.QueryRowContext(ctx, queryMessageByID, f.messageID)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkIsUserInHall(b *testing.B) {
+	ctx := context.Background()
+	f := newBenchFixture(b)
+
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if isMember, err := f.db.IsUserInHall(ctx, f.userID, f.hallID); err != nil || !isMember {
+				b.Fatal(isMember, err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var count int
+			if err := f.db.db.QueryRowContext(ctx, queryIsUserInHall, f.userID, f.hallID).Scan(&count); err != nil || count == 0 {
+				b.Fatal(count, err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetRoomByID(b *testing.B) {
+	ctx := context.Background()
+	f := newBenchFixture(b)
+
+	b.Run("prepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := f.db.GetRoomByID(ctx, f.roomID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := scanRoom(f.db.db.QueryRowContext(ctx, queryRoomByID, f.roomID)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// scanBenchMessage reads a message row like GetMessageByID does
+func scanBenchMessage(row *sql.Row) error {
+	message := &Message{}
+	var expiresAt sql.NullTime
+	var payload, components string
+	err := row.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Content, &message.Type,
+		&message.Kind, &payload, &message.CreatedAt, &expiresAt, &components)
+	if err != nil {
+		return err
+	}
+	message.Payload = rawPayload(payload)
+	if expiresAt.Valid {
+		message.ExpiresAt = &expiresAt.Time
+	}
+	message.Components, err = decodeComponents(components)
+	return err
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SavedSubscriptions are the rooms and halls a user's ws connection on one
+// device followed when it closed
+type SavedSubscriptions struct {
+	UserID  int
+	Device  string
+	Rooms   map[int]int64 // room ID to its seq at the time
+	Halls   []int         // followed with join_hall
+	SavedAt time.Time
+}
+
+// SaveSubscriptions stores what a device followed, replacing what it
+// followed before
+func (d *Database) SaveSubscriptions(ctx context.Context, saved *SavedSubscriptions) error {
+	rooms, err := json.Marshal(saved.Rooms)
+	if err != nil {
+		return err
+	}
+	halls, err := json.Marshal(saved.Halls)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO ws_subscriptions (user_id, device, rooms, halls, saved_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, device) DO UPDATE SET
+			rooms = excluded.rooms, halls = excluded.halls, saved_at = excluded.saved_at
+	`, saved.UserID, saved.Device, string(rooms), string(halls), saved.SavedAt.UTC().Format(sqliteTimeFormat))
+	return err
+}
+
+// TakeSubscriptions returns and deletes what the user's device followed, or
+// nil if nothing was saved for it after since
+func (d *Database) TakeSubscriptions(ctx context.Context, userID int, device string, since time.Time) (*SavedSubscriptions, error) {
+	saved := &SavedSubscriptions{UserID: userID, Device: device}
+	var rooms, halls string
+	err := d.db.QueryRowContext(ctx, `
+		DELETE FROM ws_subscriptions WHERE user_id = ? AND device = ?
+		RETURNING rooms, halls, saved_at
+	`, userID, device).Scan(&rooms, &halls, &saved.SavedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !saved.SavedAt.After(since) {
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(rooms), &saved.Rooms); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(halls), &saved.Halls); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// PruneSubscriptions deletes subscriptions saved before before that nobody
+// came back for
+func (d *Database) PruneSubscriptions(ctx context.Context, before time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM ws_subscriptions WHERE saved_at < ?", before.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+// migrateTo applies the migrations up to and including version
+func migrateTo(t *testing.T, d *Database, version int) {
+	t.Helper()
+	ctx := context.Background()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ensureMigrationsTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		err := d.runMigration(ctx, m.Up, func(tx execer) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("migration %d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+}
+
+// rawTime reads a column as the text SQLite stores, without the driver
+// parsing it
+func rawTime(t *testing.T, d *Database, query string, args ...interface{}) string {
+	t.Helper()
+
+	var raw string
+	if err := d.db.QueryRowContext(context.Background(), query, args...).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestUTCTimestampsMigration(t *testing.T) {
+	ctx := context.Background()
+	d := openTestDatabase(t)
+	migrateTo(t, d, 20)
+
+	// Written by versions that bound time.Time values as they were, in
+	// America/New_York around both of its 2024 DST changes
+	cases := []struct {
+		name   string
+		stored string
+		want   string // empty if SQLite can't read stored as a time
+	}{
+		{"before spring forward", "2024-03-10 01:59:59-05:00", "2024-03-10 06:59:59"},
+		{"after spring forward", "2024-03-10 03:00:01.25-04:00", "2024-03-10 07:00:01"},
+		{"T separator", "2024-03-10T03:30:00.5-04:00", "2024-03-10 07:30:00"},
+		{"first 01:30 of fall back", "2024-11-03 01:30:00-04:00", "2024-11-03 05:30:00"},
+		{"second 01:10 of fall back", "2024-11-03 01:10:00-05:00", "2024-11-03 06:10:00"},
+		{"fractional seconds", "2024-11-03 05:45:00.987654", "2024-11-03 05:45:00"},
+		{"zulu", "2024-11-03T05:40:00Z", "2024-11-03 05:40:00"},
+		{"already UTC", "2024-11-03 05:50:00", "2024-11-03 05:50:00"},
+		{"not a time", "sometime", ""},
+	}
+
+	userIDs := make(map[string]int, len(cases))
+	for _, c := range cases {
+		id := d.ids.Next()
+		_, err := d.db.ExecContext(ctx,
+			"INSERT INTO users (id, username, password_hash, last_seen) VALUES (?, ?, 'x', ?)",
+			id, c.name, c.stored,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIDs[c.name] = id
+	}
+
+	if err := d.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var valid []string
+	for _, c := range cases {
+		raw := rawTime(t, d, "SELECT last_seen || '' FROM users WHERE id = ?", userIDs[c.name])
+		if c.want == "" {
+			if raw != c.stored {
+				t.Errorf("%s: got %q, want it left as %q", c.name, raw, c.stored)
+			}
+			continue
+		}
+		if raw != c.want {
+			t.Errorf("%s: stored %q migrated to %q, want %q", c.name, c.stored, raw, c.want)
+		}
+		valid = append(valid, c.name)
+
+		user, err := d.GetUserByID(ctx, userIDs[c.name])
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		want, _ := time.Parse(sqliteTimeFormat, c.want)
+		if user.LastSeen.Location() != time.UTC || !user.LastSeen.Equal(want) {
+			t.Errorf("%s: read back %v, want %v in UTC", c.name, user.LastSeen, want)
+		}
+	}
+
+	// Text order is time order once every value is in one zone and format
+	wants := make(map[string]string, len(cases))
+	for _, c := range cases {
+		wants[c.name] = c.want
+	}
+	sort.Slice(valid, func(i, j int) bool { return wants[valid[i]] < wants[valid[j]] })
+	rows, err := d.db.QueryContext(ctx, "SELECT username FROM users WHERE last_seen != 'sometime' ORDER BY last_seen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var sorted []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		sorted = append(sorted, name)
+	}
+	if len(sorted) != len(valid) {
+		t.Fatalf("got %d rows, want %d", len(sorted), len(valid))
+	}
+	for i := range sorted {
+		if sorted[i] != valid[i] {
+			t.Fatalf("ORDER BY last_seen gave %v, want %v", sorted, valid)
+		}
+	}
+}
+
+func TestTimesWrittenFromLocalZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	local := time.Local
+	time.Local = newYork
+	t.Cleanup(func() { time.Local = local })
+
+	ctx := context.Background()
+	d := newTestDatabase(t)
+	user, err := d.CreateUser(ctx, "alice", "Password123!x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hall, err := d.CreateHall(ctx, "hall", user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := d.CreateRoom(ctx, hall.ID, "room", RoomTypeText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 01:30 on 2024-11-03 happens twice; Date picks the first, in EDT
+	firstHalfPast := time.Date(2024, 11, 3, 1, 30, 0, 0, time.Local)
+	// Listed in wall clock order, which isn't time order around fall back
+	times := []time.Time{
+		time.Date(2024, 3, 10, 1, 59, 30, 0, time.Local), // EST, 06:59:30Z
+		time.Date(2024, 3, 10, 3, 0, 30, 0, time.Local),  // EDT, 07:00:30Z
+		firstHalfPast.Add(40 * time.Minute),              // 01:10 again, in EST, 06:10Z
+		firstHalfPast.Add(0),                             // 01:30 EDT, 05:30Z
+		firstHalfPast.Add(15 * time.Minute),              // 01:45 EDT, 05:45Z
+	}
+
+	messageIDs := make([]int, len(times))
+	for i, at := range times {
+		if at.Location() != newYork {
+			t.Fatalf("time %d is in %v, want America/New_York", i, at.Location())
+		}
+		expiresAt := at
+		message, err := d.SaveMessage(ctx, MessageWrite{RoomID: room.ID, UserID: user.ID, Content: "hi", ExpiresAt: &expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		messageIDs[i] = message.ID
+
+		raw := rawTime(t, d, "SELECT expires_at || '' FROM messages WHERE id = ?", message.ID)
+		if want := at.UTC().Format(sqliteTimeFormat); raw != want {
+			t.Errorf("%v stored as %q, want %q", at, raw, want)
+		}
+
+		stored, err := d.GetMessageByID(ctx, message.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ExpiresAt == nil || stored.ExpiresAt.Location() != time.UTC || !stored.ExpiresAt.Equal(at) {
+			t.Errorf("%v read back as %v, want the same time in UTC", at, stored.ExpiresAt)
+		}
+
+		if err := d.UpdateUsersLastSeen(ctx, map[int]time.Time{user.ID: at}); err != nil {
+			t.Fatal(err)
+		}
+		seen, err := d.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen.LastSeen.Location() != time.UTC || !seen.LastSeen.Equal(at) {
+			t.Errorf("last_seen %v read back as %v, want the same time in UTC", at, seen.LastSeen)
+		}
+	}
+
+	want := make([]int, len(times))
+	for i := range want {
+		want[i] = i
+	}
+	sort.Slice(want, func(i, j int) bool { return times[want[i]].Before(times[want[j]]) })
+
+	rows, err := d.db.QueryContext(ctx, "SELECT id FROM messages WHERE room_id = ? ORDER BY expires_at", room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != messageIDs[want[i]] {
+			t.Fatalf("ORDER BY expires_at gave %v, want the messages in time order %v", got, want)
+		}
+	}
+}
+
+func TestSQLiteTimeFormatMatchesCurrentTimestamp(t *testing.T) {
+	d := newTestDatabase(t)
+
+	raw := rawTime(t, d, "SELECT CURRENT_TIMESTAMP")
+	if _, err := time.Parse(sqliteTimeFormat, raw); err != nil {
+		t.Fatalf("CURRENT_TIMESTAMP gave %q, which sqliteTimeFormat doesn't match: %v", raw, err)
+	}
+}
+
+func TestUTCDSN(t *testing.T) {
+	cases := map[string]string{
+		"chat.db":               "chat.db?_loc=UTC",
+		"file:chat.db?_fk=1":    "file:chat.db?_fk=1&_loc=UTC",
+		":memory:":              ":memory:?_loc=UTC",
+		"file::memory:?cache=x": "file::memory:?cache=x&_loc=UTC",
+	}
+	for path, want := range cases {
+		if got := utcDSN(path); got != want {
+			t.Errorf("utcDSN(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// ArchiveDay is a day, in UTC, on which a room has messages for its web
+// archive
+type ArchiveDay struct {
+	Date     string `json:"date"` // YYYY-MM-DD
+	Messages int    `json:"messages"`
+}
+
+// archivedMessage picks the messages a web archive shows: what people
+// wrote, without server activity or anything set to disappear
+const archivedMessage = "m.type != '" + MessageTypeSystem + "' AND m.expires_at IS NULL"
+
+// GetArchiveDays lists the days a room has archived messages on, oldest
+// first
+func (d *Database) GetArchiveDays(ctx context.Context, roomID int) ([]ArchiveDay, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT date(m.created_at), COUNT(*) FROM messages m
+		WHERE m.room_id = ? AND `+archivedMessage+`
+		GROUP BY 1 ORDER BY 1
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make([]ArchiveDay, 0)
+	for rows.Next() {
+		var day ArchiveDay
+		if err := rows.Scan(&day.Date, &day.Messages); err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
+
+// GetArchivedMessages returns up to limit of a room's archived messages from
+// the UTC day starting at day, oldest first, after the message afterID
+func (d *Database) GetArchivedMessages(ctx context.Context, roomID int, day time.Time, afterID, limit int) ([]Message, error) {
+	rows, err := d.db.QueryContext(ctx, messageSelect+`
+		WHERE m.room_id = ? AND m.created_at >= ? AND m.created_at < ? AND m.id > ? AND `+archivedMessage+`
+		ORDER BY m.id ASC
+		LIMIT ?
+	`, roomID, day.UTC().Format(sqliteTimeFormat), day.AddDate(0, 0, 1).UTC().Format(sqliteTimeFormat), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Webhook is an endpoint a hall's events are POSTed to, signed with Secret
+type Webhook struct {
+	ID        int       `json:"id"`
+	HallID    int       `json:"hall_id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`      // only shown when the webhook is created
+	Events    []string  `json:"events"` // empty for every event
+	CreatedBy int       `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether the webhook is subscribed to the event type
+func (h *Webhook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeadLetter is a delivery that failed for good: every attempt failed,
+// or the endpoint turned it down in a way retrying won't fix
+type WebhookDeadLetter struct {
+	ID         int       `json:"id"`
+	WebhookID  int       `json:"webhook_id"`
+	DeliveryID string    `json:"delivery_id"`
+	Event      string    `json:"event"`
+	Payload    string    `json:"payload"`
+	Attempts   int       `json:"attempts"`
+	LastStatus int       `json:"last_status,omitempty"` // 0 if no response came back
+	LastError  string    `json:"last_error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// WebhookDelivery is a delivery waiting for its first or next attempt
+type WebhookDelivery struct {
+	WebhookID   int
+	DeliveryID  string
+	NodeID      int // of the instance that queued it
+	Event       string
+	Payload     string
+	Attempts    int // made so far
+	NextAttempt time.Time
+}
+
+const webhookColumns = "SELECT id, hall_id, url, secret, events, created_by, created_at FROM webhooks"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var hook Webhook
+	var events string
+	if err := row.Scan(&hook.ID, &hook.HallID, &hook.URL, &hook.Secret, &events, &hook.CreatedBy, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	hook.Events = make([]string, 0)
+	if events != "" {
+		hook.Events = strings.Split(events, ",")
+	}
+	return &hook, nil
+}
+
+func (d *Database) CreateWebhook(ctx context.Context, hallID int, url, secret string, events []string, createdBy int) (*Webhook, error) {
+	result, err := d.db.ExecContext(ctx,
+		"INSERT INTO webhooks (hall_id, url, secret, events, created_by) VALUES (?, ?, ?, ?, ?)",
+		hallID, url, secret, strings.Join(events, ","), createdBy,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return d.GetWebhook(ctx, int(id))
+}
+
+func (d *Database) GetWebhook(ctx context.Context, webhookID int) (*Webhook, error) {
+	return scanWebhook(d.db.QueryRowContext(ctx, webhookColumns+" WHERE id = ?", webhookID))
+}
+
+// GetHallWebhooks lists a hall's webhooks, oldest first
+func (d *Database) GetHallWebhooks(ctx context.Context, hallID int) ([]Webhook, error) {
+	rows, err := d.db.QueryContext(ctx, webhookColumns+" WHERE hall_id = ? ORDER BY id", hallID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := make([]Webhook, 0)
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook along with its dead letters and pending
+// deliveries
+func (d *Database) DeleteWebhook(ctx context.Context, webhookID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", webhookID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhook_dead_letters WHERE webhook_id = ?", webhookID)
+	if err != nil {
+		return false, err
+	}
+	_, err = d.db.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", webhookID)
+	return n > 0, err
+}
+
+// SaveWebhookDelivery stores a pending delivery, or updates its attempts and
+// next attempt if it's already stored
+func (d *Database) SaveWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, delivery_id, node_id, event, payload, attempts, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(webhook_id, delivery_id) DO UPDATE SET
+			attempts = excluded.attempts, next_attempt_at = excluded.next_attempt_at
+	`, delivery.WebhookID, delivery.DeliveryID, delivery.NodeID, delivery.Event, delivery.Payload,
+		delivery.Attempts, delivery.NextAttempt.UTC().Format(sqliteTimeFormat),
+	)
+	return err
+}
+
+// GetWebhookDeliveries lists the deliveries the instance with nodeID has
+// pending, soonest first
+func (d *Database) GetWebhookDeliveries(ctx context.Context, nodeID int) ([]WebhookDelivery, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT webhook_id, delivery_id, node_id, event, payload, attempts, next_attempt_at
+		FROM webhook_deliveries
+		WHERE node_id = ?
+		ORDER BY next_attempt_at
+	`, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := rows.Scan(&delivery.WebhookID, &delivery.DeliveryID, &delivery.NodeID, &delivery.Event,
+			&delivery.Payload, &delivery.Attempts, &delivery.NextAttempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// PruneWebhookDeliveries deletes pending deliveries whose next attempt was
+// due before before, left behind by instances that haven't come back
+func (d *Database) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE next_attempt_at < ?",
+		before.UTC().Format(sqliteTimeFormat),
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// DeleteWebhookDelivery forgets a delivery that's done with, delivered or
+// not
+func (d *Database) DeleteWebhookDelivery(ctx context.Context, webhookID int, deliveryID string) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE webhook_id = ? AND delivery_id = ?",
+		webhookID, deliveryID,
+	)
+	return err
+}
+
+func (d *Database) AddWebhookDeadLetter(ctx context.Context, letter *WebhookDeadLetter) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO webhook_dead_letters (webhook_id, delivery_id, event, payload, attempts, last_status, last_error)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, letter.WebhookID, letter.DeliveryID, letter.Event, letter.Payload, letter.Attempts, letter.LastStatus, letter.LastError)
+	return err
+}
+
+const webhookDeadLetterColumns = "SELECT id, webhook_id, delivery_id, event, payload, attempts, last_status, last_error, failed_at FROM webhook_dead_letters"
+
+func scanWebhookDeadLetter(row interface{ Scan(...interface{}) error }) (*WebhookDeadLetter, error) {
+	var letter WebhookDeadLetter
+	err := row.Scan(&letter.ID, &letter.WebhookID, &letter.DeliveryID, &letter.Event, &letter.Payload,
+		&letter.Attempts, &letter.LastStatus, &letter.LastError, &letter.FailedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// GetWebhookDeadLetters lists a page of a webhook's dead letters, newest
+// first
+func (d *Database) GetWebhookDeadLetters(ctx context.Context, webhookID, limit, offset int) ([]WebhookDeadLetter, error) {
+	rows, err := d.db.QueryContext(ctx,
+		webhookDeadLetterColumns+" WHERE webhook_id = ? ORDER BY id DESC LIMIT ? OFFSET ?",
+		webhookID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := make([]WebhookDeadLetter, 0)
+	for rows.Next() {
+		letter, err := scanWebhookDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *letter)
+	}
+	return letters, rows.Err()
+}
+
+func (d *Database) GetWebhookDeadLetter(ctx context.Context, letterID int) (*WebhookDeadLetter, error) {
+	return scanWebhookDeadLetter(d.db.QueryRowContext(ctx, webhookDeadLetterColumns+" WHERE id = ?", letterID))
+}
+
+func (d *Database) DeleteWebhookDeadLetter(ctx context.Context, letterID int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM webhook_dead_letters WHERE id = ?", letterID)
+	return err
+}
@@ -0,0 +1,145 @@
+package ws
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"chatapp/internal/store"
+)
+
+const (
+	// automodIdle is how long a hall's compiled rules are kept after its
+	// last message
+	automodIdle = time.Hour
+
+	// automodExcerptLength is how many characters of a message automod's
+	// audit log entries quote
+	automodExcerptLength = 40
+)
+
+// Automod checks messages against a hall's blocked word and regex rules.
+// Compiled rules are cached by hall and rule ID; a rule that's deleted or
+// changed is dropped on the hall's next check, and a hall that stops
+// sending messages is dropped by Prune.
+type Automod struct {
+	db    *store.Database
+	halls map[int]*automodHall
+	mutex sync.Mutex
+}
+
+// automodHall is a hall's compiled rules by rule ID
+type automodHall struct {
+	rules    map[int]compiledAutomodRule
+	lastUsed time.Time
+}
+
+type compiledAutomodRule struct {
+	pattern string
+	isRegex bool
+	re      *regexp.Regexp // nil if the pattern doesn't compile
+}
+
+func NewAutomod(db *store.Database) *Automod {
+	return &Automod{
+		db:    db,
+		halls: make(map[int]*automodHall),
+	}
+}
+
+// CompileAutomodPattern turns a rule into a case-insensitive regexp. Plain
+// words only match whole words, so "ass" doesn't catch "class".
+func CompileAutomodPattern(pattern string, isRegex bool) (*regexp.Regexp, error) {
+	if isRegex {
+		return regexp.Compile("(?i)" + pattern)
+	}
+	return regexp.Compile(`(?i)\b` + regexp.QuoteMeta(pattern) + `\b`)
+}
+
+// compile returns the regexps for the hall's current rules, in the order of
+// rules, nil for any that don't compile. Cached rules that aren't among
+// rules any more are dropped.
+func (a *Automod) compile(hallID int, rules []store.AutomodRule) []*regexp.Regexp {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(rules) == 0 {
+		delete(a.halls, hallID)
+		return nil
+	}
+
+	hall, ok := a.halls[hallID]
+	if !ok {
+		hall = &automodHall{rules: make(map[int]compiledAutomodRule)}
+		a.halls[hallID] = hall
+	}
+	hall.lastUsed = time.Now()
+
+	current := make(map[int]compiledAutomodRule, len(rules))
+	compiled := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		cached, ok := hall.rules[rule.ID]
+		if !ok || cached.pattern != rule.Pattern || cached.isRegex != rule.IsRegex {
+			re, _ := CompileAutomodPattern(rule.Pattern, rule.IsRegex)
+			cached = compiledAutomodRule{pattern: rule.Pattern, isRegex: rule.IsRegex, re: re}
+		}
+		current[rule.ID] = cached
+		compiled[i] = cached.re
+	}
+	hall.rules = current
+	return compiled
+}
+
+// Prune drops the compiled rules of halls that haven't had a message
+// checked in automodIdle
+func (a *Automod) Prune() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for hallID, hall := range a.halls {
+		if now.Sub(hall.lastUsed) > automodIdle {
+			delete(a.halls, hallID)
+		}
+	}
+}
+
+// automodExcerpt shortens a message automod turned away to one line for the
+// audit log, which shouldn't keep all of it
+func automodExcerpt(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(content) <= automodExcerptLength {
+		return content
+	}
+	return string([]rune(content)[:automodExcerptLength]) + "…"
+}
+
+// automodSeverity orders actions so the strictest matching rule wins
+var automodSeverity = map[string]int{
+	store.AutomodActionFlag:   1,
+	store.AutomodActionReject: 2,
+	store.AutomodActionDelete: 3,
+}
+
+// Check returns the strictest rule in the hall matching content, or nil.
+func (a *Automod) Check(ctx context.Context, hallID int, content string) (*store.AutomodRule, error) {
+	rules, err := a.db.GetAutomodRules(ctx, hallID)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := a.compile(hallID, rules)
+	var matched *store.AutomodRule
+	for i, re := range compiled {
+		if re == nil || !re.MatchString(content) {
+			continue
+		}
+		if matched == nil || automodSeverity[rules[i].Action] > automodSeverity[matched.Action] {
+			matched = &rules[i]
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,48 @@
+package ws
+
+import "encoding/json"
+
+// BrokerMessage is one event fanned out to websocket clients. Exactly one of
+// RoomID, UserID and UserIDs is set; Payload is the encoded WSMessage and
+// Type its type, so connections can be skipped by intent without decoding it.
+type BrokerMessage struct {
+	RoomID  int             `json:"room_id,omitempty"`
+	UserID  int             `json:"user_id,omitempty"`
+	UserIDs []int           `json:"user_ids,omitempty"`
+	Type    string          `json:"type,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Broker carries broadcasts between API instances. Every instance subscribes
+// and delivers what it receives to its own clients, including messages it
+// published itself.
+type Broker interface {
+	Publish(msg BrokerMessage) error
+	// Subscribe registers the delivery handler; it's called once at startup
+	Subscribe(handler func(BrokerMessage))
+	Close() error
+}
+
+// LocalBroker delivers straight to this process, for single-node setups
+type LocalBroker struct {
+	handler func(BrokerMessage)
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(msg BrokerMessage) error {
+	if b.handler != nil {
+		b.handler(msg)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(handler func(BrokerMessage)) {
+	b.handler = handler
+}
+
+func (b *LocalBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a wire encoding for ws frames. Events are built as JSON once and
+// shared between every client (and instance), so codecs convert from that
+// JSON on the way out rather than encoding structs themselves.
+type Codec interface {
+	Name() string
+	// FrameType is websocket.TextMessage or websocket.BinaryMessage
+	FrameType() int
+	Encode(jsonFrame []byte) ([]byte, error)
+	Decode(frame []byte) (WSMessage, error)
+}
+
+// wsCodecs are the encodings clients can pick with ?encoding=
+var wsCodecs = map[string]Codec{
+	"json":    JSONCodec{},
+	"msgpack": MsgpackCodec{},
+}
+
+// CodecNames lists the encodings in a stable order for capabilities
+var CodecNames = []string{"json", "msgpack"}
+
+func wsCodecFor(name string) (Codec, bool) {
+	if name == "" {
+		return JSONCodec{}, true
+	}
+	codec, ok := wsCodecs[name]
+	return codec, ok
+}
+
+// JSONCodec is the default encoding
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) FrameType() int { return websocket.TextMessage }
+
+func (JSONCodec) Encode(jsonFrame []byte) ([]byte, error) {
+	return jsonFrame, nil
+}
+
+func (JSONCodec) Decode(frame []byte) (WSMessage, error) {
+	var msg WSMessage
+	err := json.Unmarshal(frame, &msg)
+	return msg, err
+}
+
+// MsgpackCodec sends the same events as MessagePack maps, for clients that
+// care about bandwidth. Timestamps stay RFC 3339 strings.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string { return "msgpack" }
+
+func (MsgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (MsgpackCodec) Encode(jsonFrame []byte) ([]byte, error) {
+	// UseNumber keeps integers integers instead of turning them into floats
+	decoder := json.NewDecoder(bytes.NewReader(jsonFrame))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(fromJSONNumbers(value))
+}
+
+func (MsgpackCodec) Decode(frame []byte) (WSMessage, error) {
+	var value interface{}
+	if err := msgpack.Unmarshal(frame, &value); err != nil {
+		return WSMessage{}, err
+	}
+
+	// Handlers decode Data through JSON, so go through it here too
+	jsonFrame, err := json.Marshal(value)
+	if err != nil {
+		return WSMessage{}, fmt.Errorf("msgpack frame can't be represented as JSON: %w", err)
+	}
+	return JSONCodec{}.Decode(jsonFrame)
+}
+
+// fromJSONNumbers replaces json.Numbers with int64 or float64 values
+func fromJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = fromJSONNumbers(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromJSONNumbers(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
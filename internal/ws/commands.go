@@ -0,0 +1,250 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"chatapp/internal/store"
+)
+
+// A message like "/name args" runs the slash command name instead of being
+// sent. Only lowercase names count, so "/usr/bin" and "/ hi" are sent as they
+// are; "//text" sends "/text".
+var commandPattern = regexp.MustCompile(`^/([a-z][a-z0-9_-]{0,31})(?:\s+([\s\S]*))?$`)
+
+// maxTopicLength caps room topics, in characters
+const maxTopicLength = 250
+
+// Command is a slash command. Run's error is shown to the caller as it is.
+type Command struct {
+	Name        string `json:"name"` // without the slash
+	Usage       string `json:"usage"`
+	Description string `json:"description"`
+
+	Run func(ctx context.Context, call CommandCall) (CommandResult, error) `json:"-"`
+}
+
+// CommandCall is a slash command someone sent in a room
+type CommandCall struct {
+	Name     string
+	Args     string // everything after the name, trimmed
+	HallID   int
+	RoomID   int
+	UserID   int
+	Username string
+}
+
+// CommandResult is what a command does: Reply is shown only to the caller,
+// and Send is sent to the room as the caller's message, an action if Action
+// is set. Sent messages go through plugins, automod and the spam checks like
+// any other.
+type CommandResult struct {
+	Reply  string
+	Send   string
+	Action bool
+}
+
+// CommandProvider is a plugin that adds slash commands, e.g. a bot's.
+// Commands can't replace the built-in ones or each other.
+type CommandProvider interface {
+	Commands() []Command
+}
+
+// parseCommand splits "/name args" into its parts
+func parseCommand(content string) (name, args string, ok bool) {
+	match := commandPattern.FindStringSubmatch(content)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], strings.TrimSpace(match[2]), true
+}
+
+// registerCommands sets up the built-in commands, then the plugins'
+func (m *Manager) registerCommands() {
+	m.commands = make(map[string]Command)
+	for _, command := range m.builtinCommands() {
+		m.commands[command.Name] = command
+	}
+	for _, plugin := range m.plugins.plugins {
+		provider, ok := plugin.(CommandProvider)
+		if !ok {
+			continue
+		}
+		for _, command := range provider.Commands() {
+			if _, taken := m.commands[command.Name]; taken || !commandPattern.MatchString("/"+command.Name) || command.Run == nil {
+				m.logger.Printf("Plugin %s: skipping command /%s", plugin.Name(), command.Name)
+				continue
+			}
+			m.commands[command.Name] = command
+		}
+	}
+}
+
+// Commands lists the slash commands by name
+func (m *Manager) Commands() []Command {
+	commands := make([]Command, 0, len(m.commands))
+	for _, command := range m.commands {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+func (m *Manager) builtinCommands() []Command {
+	return []Command{
+		{
+			Name:        "me",
+			Usage:       "/me <action>",
+			Description: "Send a message as something you're doing",
+			Run: func(ctx context.Context, call CommandCall) (CommandResult, error) {
+				if call.Args == "" {
+					return CommandResult{}, errors.New("Usage: /me <action>")
+				}
+				return CommandResult{Send: call.Args, Action: true}, nil
+			},
+		},
+		{
+			Name:        "shrug",
+			Usage:       "/shrug [message]",
+			Description: `Send a message with ¯\_(ツ)_/¯ on the end`,
+			Run: func(ctx context.Context, call CommandCall) (CommandResult, error) {
+				return CommandResult{Send: strings.TrimSpace(call.Args + ` ¯\_(ツ)_/¯`)}, nil
+			},
+		},
+		{
+			Name:        "topic",
+			Usage:       "/topic [topic]",
+			Description: "Show the room's topic, or set it (hall admins)",
+			Run:         m.runTopicCommand,
+		},
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List the slash commands",
+			Run: func(ctx context.Context, call CommandCall) (CommandResult, error) {
+				var help strings.Builder
+				for i, command := range m.Commands() {
+					if i > 0 {
+						help.WriteString("\n")
+					}
+					fmt.Fprintf(&help, "%s: %s", command.Usage, command.Description)
+				}
+				return CommandResult{Reply: help.String()}, nil
+			},
+		},
+	}
+}
+
+// runTopicCommand shows the room's topic, or sets it for hall admins; "-"
+// clears it
+func (m *Manager) runTopicCommand(ctx context.Context, call CommandCall) (CommandResult, error) {
+	room, err := m.db.GetRoomByID(ctx, call.RoomID)
+	if err != nil {
+		return CommandResult{}, errors.New("Failed to load the room")
+	}
+
+	if call.Args == "" {
+		if room.Topic == "" {
+			return CommandResult{Reply: "This room has no topic"}, nil
+		}
+		return CommandResult{Reply: "Topic: " + room.Topic}, nil
+	}
+
+	isAdmin, err := m.db.IsHallAdmin(ctx, call.UserID, call.HallID)
+	if err != nil || !isAdmin {
+		return CommandResult{}, errors.New("Only hall admins can set the topic")
+	}
+
+	topic := call.Args
+	if topic == "-" {
+		topic = ""
+	}
+	if strings.ContainsAny(topic, "\r\n") {
+		return CommandResult{}, errors.New("Topics must be one line")
+	}
+	if utf8.RuneCountInString(topic) > maxTopicLength {
+		return CommandResult{}, fmt.Errorf("Topics are limited to %d characters", maxTopicLength)
+	}
+
+	if err := m.db.SetRoomTopic(ctx, room.ID, topic); err != nil {
+		m.logger.Printf("Failed to set topic of room %d: %v", room.ID, err)
+		return CommandResult{}, errors.New("Failed to set the topic")
+	}
+	m.BroadcastToRoom(room.ID, "room_topic_updated", RoomTopicData{
+		RoomID:   room.ID,
+		Topic:    topic,
+		UserID:   call.UserID,
+		Username: call.Username,
+	})
+
+	if topic == "" {
+		return CommandResult{Reply: "Topic cleared"}, nil
+	}
+	return CommandResult{Reply: "Topic set"}, nil
+}
+
+// runCommand runs a slash command sent in room. If the command sends a
+// message, its content and type are returned with ok set; anything else has
+// been dealt with.
+func (c *Client) runCommand(ctx context.Context, room *store.Room, sendData SendMessageData, name, args string) (content, messageType string, ok bool) {
+	command, exists := c.manager.commands[name]
+	if !exists {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "unknown_command",
+			Message: fmt.Sprintf("Unknown command /%s, try /help", name),
+		})
+		return "", "", false
+	}
+
+	call := CommandCall{
+		Name:     name,
+		Args:     args,
+		HallID:   room.HallID,
+		RoomID:   room.ID,
+		UserID:   c.session.UserID,
+		Username: c.session.Username,
+	}
+	result, err := c.manager.plugins.runCommand(ctx, command, call)
+	if err != nil {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "command_failed",
+			Message: err.Error(),
+		})
+		return "", "", false
+	}
+
+	if result.Reply != "" {
+		c.sendEvent(WSMessage{Type: "command_response", RoomID: room.ID, Data: CommandResponseData{
+			RoomID:  room.ID,
+			Command: name,
+			Text:    result.Reply,
+			Nonce:   sendData.Nonce,
+		}})
+	}
+	if result.Send == "" {
+		return "", "", false
+	}
+	if result.Action {
+		return result.Send, store.MessageTypeAction, true
+	}
+	return result.Send, store.MessageTypeUser, true
+}
+
+// runCommand runs a command like a hook, so one that panics doesn't take
+// the server down
+func (p *Plugins) runCommand(ctx context.Context, command Command, call CommandCall) (result CommandResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Printf("Command /%s panicked: %v", command.Name, r)
+			err = errors.New("The command failed")
+		}
+	}()
+	return command.Run(ctx, call)
+}
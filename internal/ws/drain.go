@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Draining an instance before it's stopped closes its connections a few at a
+// time over the drain period, each after a reconnect frame, so clients move
+// to other instances gradually instead of all reconnecting at once when the
+// process exits.
+const (
+	// drainReconnectJitter caps the random delay clients are told to wait
+	// before reconnecting
+	drainReconnectJitter = 5 * time.Second
+	// drainCloseDelay gives the writer time to send the reconnect frame
+	// before the connection is closed
+	drainCloseDelay = time.Second
+	// drainPollInterval is how often Drain checks whether everyone is gone
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// Draining reports whether Drain was called; new connections are turned away
+// from then on
+func (m *Manager) Draining() bool {
+	return m.draining.Load()
+}
+
+// Drain stops taking new connections and closes the current ones spread over
+// period, telling each client to reconnect to url (or wherever it came from,
+// if url is empty). It returns once every client is gone or ctx is done.
+func (m *Manager) Drain(ctx context.Context, period time.Duration, url string) {
+	if !m.draining.CompareAndSwap(false, true) {
+		m.waitForClients(ctx)
+		return
+	}
+
+	m.mutex.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+	m.logger.Printf("Draining %d connections over %s", len(clients), period)
+
+	var interval time.Duration
+	if len(clients) > 0 {
+		interval = period / time.Duration(len(clients))
+	}
+	rand.Shuffle(len(clients), func(i, j int) { clients[i], clients[j] = clients[j], clients[i] })
+
+	for i, client := range clients {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		delay := time.Duration(rand.Int63n(int64(drainReconnectJitter)))
+		client.sendEventAsync(WSMessage{Type: "reconnect", Data: ReconnectData{DelayMs: delay.Milliseconds(), URL: url}})
+		time.AfterFunc(drainCloseDelay, func() {
+			client.disconnectWithCode(wsCloseDraining, "server is restarting")
+		})
+	}
+
+	m.waitForClients(ctx)
+	m.logger.Printf("Drained all connections")
+}
+
+// waitForClients returns once no clients are connected or ctx is done
+func (m *Manager) waitForClients(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.mutex.RLock()
+		remaining := len(m.clients)
+		m.mutex.RUnlock()
+		if remaining == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
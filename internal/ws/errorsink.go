@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"runtime"
+)
+
+// ErrorSink hears about failures an operator should look into, like panics
+// and messages that couldn't be saved, e.g. to pass them on to Sentry. It's
+// called from request handlers and the ws read loops, so it mustn't block.
+type ErrorSink interface {
+	CaptureError(report ErrorReport)
+}
+
+// ErrorReport is one failure and what's known about where it happened
+type ErrorReport struct {
+	Err       error
+	Source    string    // "http", "ws", "webhook" or "backup"
+	Stack     []uintptr // where a panic happened, see PanicStack
+	RequestID string
+	Method    string // of the HTTP request
+	URL       string
+	UserID    int // 0 when nobody's signed in
+	Username  string
+	Tags      map[string]string // e.g. hall_id or the ws message type
+}
+
+// PanicStack returns the stack of the panic being recovered, newest call
+// first, for ErrorReport.Stack. Call it from the deferred function that
+// recovers.
+func PanicStack() []uintptr {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	for i, pc := range pcs {
+		if fn := runtime.FuncForPC(pc - 1); fn != nil && fn.Name() == "runtime.gopanic" {
+			return pcs[i+1:]
+		}
+	}
+	return pcs
+}
+
+// captureError passes report to the error sink, if there is one
+func (m *Manager) captureError(report ErrorReport) {
+	if m.errors != nil {
+		m.errors.CaptureError(report)
+	}
+}
+
+// captureError is Manager.captureError with the client's account filled in
+func (c *Client) captureError(report ErrorReport) {
+	report.Source = "ws"
+	if !c.guest {
+		report.UserID = c.session.UserID
+		report.Username = c.session.Username
+	}
+	c.manager.captureError(report)
+}
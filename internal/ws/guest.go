@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"context"
+
+	"chatapp/internal/store"
+)
+
+// With guest_access on, visitors without an account can read the rooms hall
+// admins made public: over REST under /api/public, and live over a websocket
+// opened with ?guest=true instead of a token. Guests can follow rooms but
+// not post, react or see anything outside public rooms.
+
+// GuestUsername is what guest connections go by in logs and their hello
+const GuestUsername = "guest"
+
+// guestMessageTypes are the ws messages a guest may send
+var guestMessageTypes = map[string]bool{
+	"join_room":  true,
+	"leave_room": true,
+	"join_hall":  true,
+	"leave_hall": true,
+	"resume":     true,
+	"ping":       true,
+}
+
+// canRead reports whether the client may follow a room: members of its hall
+// can, guests only if it's public
+func (c *Client) canRead(ctx context.Context, room *store.Room) bool {
+	if c.guest {
+		return room.Public && !room.Archived
+	}
+	isMember, err := c.manager.db.IsUserInHall(ctx, c.session.UserID, room.HallID)
+	return err == nil && isMember
+}
+
+// RemoveGuestsFromRoom stops live delivery of a room to guests, e.g. once it
+// stops being public
+func (m *Manager) RemoveGuestsFromRoom(roomID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var guests []*Client
+	for _, client := range m.rooms[roomID] {
+		if client.guest {
+			guests = append(guests, client)
+		}
+	}
+	for _, client := range guests {
+		m.removeClientFromRoom(client, roomID)
+	}
+}
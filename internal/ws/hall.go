@@ -0,0 +1,131 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// HallJoinedData answers join_hall with the rooms the client now follows
+type HallJoinedData struct {
+	HallID  int   `json:"hall_id"`
+	RoomIDs []int `json:"room_ids"`
+}
+
+// inRoom reports whether the client follows a room. Rooms can be added from
+// other goroutines, see observeRoomCreated, so this takes the lock.
+func (c *Client) inRoom(roomID int) bool {
+	c.manager.mutex.RLock()
+	defer c.manager.mutex.RUnlock()
+
+	return c.rooms[roomID]
+}
+
+// handleJoinHall follows every room of a hall the client can read, as if it
+// had sent join_room for each, and the rooms created in it from then on.
+// Archived rooms are left out; join_room still works for them.
+func (c *Client) handleJoinHall(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var hallData struct {
+		HallID int `json:"hall_id"`
+	}
+	if err := json.Unmarshal(jsonData, &hallData); err != nil {
+		c.manager.logger.Printf("Invalid join_hall data: %v", err)
+		return
+	}
+
+	if !c.guest {
+		isMember, err := c.manager.db.IsUserInHall(ctx, c.session.UserID, hallData.HallID)
+		if err != nil || !isMember {
+			c.sendError(WSErrorData{Code: "not_in_hall", Message: "Join the hall before following it"})
+			return
+		}
+	}
+
+	rooms, err := c.manager.db.GetHallRooms(ctx, hallData.HallID, false)
+	if err != nil {
+		c.manager.logger.Printf("Failed to fetch rooms of hall %d: %v", hallData.HallID, err)
+		return
+	}
+
+	// Guests only follow the public rooms and aren't told about new ones,
+	// so for them a hall without any looks the same as a missing one
+	roomIDs := make([]int, 0, len(rooms))
+	for i := range rooms {
+		if c.canRead(ctx, &rooms[i]) {
+			roomIDs = append(roomIDs, rooms[i].ID)
+		}
+	}
+
+	c.manager.mutex.Lock()
+	for _, roomID := range roomIDs {
+		c.manager.subscribe(c, roomID)
+	}
+	if !c.guest {
+		if c.halls == nil {
+			c.halls = make(map[int]bool)
+		}
+		c.halls[hallData.HallID] = true
+	}
+	c.manager.mutex.Unlock()
+
+	c.sendEvent(WSMessage{Type: "hall_joined", Data: HallJoinedData{HallID: hallData.HallID, RoomIDs: roomIDs}})
+	c.manager.logger.Printf("User %s joined %d rooms of hall %d", c.session.Username, len(roomIDs), hallData.HallID)
+}
+
+// handleLeaveHall stops following a hall's rooms, whether they were joined
+// with join_hall or one by one
+func (c *Client) handleLeaveHall(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var hallData struct {
+		HallID int `json:"hall_id"`
+	}
+	if err := json.Unmarshal(jsonData, &hallData); err != nil {
+		c.manager.logger.Printf("Invalid leave_hall data: %v", err)
+		return
+	}
+
+	rooms, err := c.manager.db.GetHallRooms(ctx, hallData.HallID, true)
+	if err != nil {
+		c.manager.logger.Printf("Failed to fetch rooms of hall %d: %v", hallData.HallID, err)
+		return
+	}
+
+	c.manager.mutex.Lock()
+	delete(c.halls, hallData.HallID)
+	for _, room := range rooms {
+		c.manager.removeClientFromRoom(c, room.ID)
+	}
+	c.manager.mutex.Unlock()
+
+	if c.voiceRoom != nil && c.voiceRoom.HallID == hallData.HallID {
+		c.leaveVoice(ctx)
+	}
+
+	c.manager.logger.Printf("User %s left hall %d", c.session.Username, hallData.HallID)
+}
+
+// observeRoomCreated adds a room created in a hall to the local clients that
+// follow the hall. Only the hall's members get room_created, so the
+// recipients are who may read it.
+func (m *Manager) observeRoomCreated(userIDs []int, payload []byte) {
+	var message struct {
+		Data RoomCreatedData `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil || message.Data.Room == nil {
+		m.logger.Printf("Invalid room_created event: %v", err)
+		return
+	}
+	recipients := make(map[int]bool, len(userIDs))
+	for _, userID := range userIDs {
+		recipients[userID] = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for client := range m.clients {
+		if client.halls[message.Data.HallID] && recipients[client.session.UserID] {
+			m.subscribe(client, message.Data.Room.ID)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ws
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Intents are the categories of events a connection asked for with
+// ?intents=, so a bot in busy halls isn't sent (and the server doesn't
+// encode) events it would throw away. Events outside every category, like
+// room_created or errors and acks, always go out.
+type Intents uint8
+
+const (
+	IntentMessages Intents = 1 << iota
+	IntentPresence
+	IntentTyping
+	IntentMembership
+
+	AllIntents = IntentMessages | IntentPresence | IntentTyping | IntentMembership
+)
+
+// IntentNames lists the intents in a stable order for capabilities
+var IntentNames = []string{"messages", "presence", "typing", "membership"}
+
+var intentsByName = map[string]Intents{
+	"messages":   IntentMessages,
+	"presence":   IntentPresence,
+	"typing":     IntentTyping,
+	"membership": IntentMembership,
+}
+
+// eventIntents maps event types to the intent that covers them
+var eventIntents = map[string]Intents{
+	"new_message":                IntentMessages,
+	"message_deleted":            IntentMessages,
+	"messages_bulk_deleted":      IntentMessages,
+	"message_ttl_updated":        IntentMessages,
+	"message_components_updated": IntentMessages,
+	"reaction_added":             IntentMessages,
+	"reaction_removed":           IntentMessages,
+	"presence":                   IntentPresence,
+	"voice_joined":               IntentPresence,
+	"voice_left":                 IntentPresence,
+	"typing":                     IntentTyping,
+	"member_joined":              IntentMembership,
+	"member_left":                IntentMembership,
+	"join_request_created":       IntentMembership,
+	"join_request_approved":      IntentMembership,
+	"join_request_denied":        IntentMembership,
+}
+
+// parseIntents reads a comma-separated list of intents; an empty one asks
+// for everything
+func parseIntents(value string) (Intents, error) {
+	if value == "" {
+		return AllIntents, nil
+	}
+
+	var intents Intents
+	for _, name := range strings.Split(value, ",") {
+		intent, ok := intentsByName[strings.TrimSpace(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown intent %q", name)
+		}
+		intents |= intent
+	}
+	return intents, nil
+}
+
+// Names lists the intents in the set
+func (i Intents) Names() []string {
+	names := make([]string, 0, len(IntentNames))
+	for _, name := range IntentNames {
+		if i&intentsByName[name] != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// wants reports whether a connection with these intents gets events of
+// eventType
+func (i Intents) wants(eventType string) bool {
+	intent, ok := eventIntents[eventType]
+	return !ok || i&intent != 0
+}
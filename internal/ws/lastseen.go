@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// Presence touches from ws pings and SSE heartbeats are collected in memory
+// and written every lastSeenFlushInterval, in one transaction, instead of
+// one UPDATE per ping. The interval stays well under server.notifyOfflineAfter so
+// nobody looks offline just because their last ping wasn't written yet.
+const (
+	lastSeenFlushInterval = 10 * time.Second
+	lastSeenFlushTimeout  = 10 * time.Second
+)
+
+// LastSeenBuffer batches last_seen updates
+type LastSeenBuffer struct {
+	db      *store.Database
+	mutex   sync.Mutex
+	pending map[int]time.Time
+	logger  *log.Logger
+}
+
+func NewLastSeenBuffer(db *store.Database, logger *log.Logger) *LastSeenBuffer {
+	return &LastSeenBuffer{
+		db:      db,
+		logger:  logger,
+		pending: make(map[int]time.Time),
+	}
+}
+
+// Touch records that userID was just seen
+func (b *LastSeenBuffer) Touch(userID int) {
+	b.mutex.Lock()
+	b.pending[userID] = time.Now()
+	b.mutex.Unlock()
+}
+
+// Flush writes everything touched since the last flush. Touches that fail to
+// be written are kept for the next one, unless a newer touch replaced them.
+func (b *LastSeenBuffer) Flush(ctx context.Context) error {
+	b.mutex.Lock()
+	if len(b.pending) == 0 {
+		b.mutex.Unlock()
+		return nil
+	}
+	batch := b.pending
+	b.pending = make(map[int]time.Time, len(batch))
+	b.mutex.Unlock()
+
+	err := b.db.UpdateUsersLastSeen(ctx, batch)
+	if err != nil {
+		b.mutex.Lock()
+		for userID, seen := range batch {
+			if _, ok := b.pending[userID]; !ok {
+				b.pending[userID] = seen
+			}
+		}
+		b.mutex.Unlock()
+	}
+	return err
+}
+
+// Run flushes every lastSeenFlushInterval until ctx is done, then once more
+// so nothing touched before that is lost
+func (b *LastSeenBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(lastSeenFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flushLogged()
+		case <-ctx.Done():
+			b.flushLogged()
+			return
+		}
+	}
+}
+
+func (b *LastSeenBuffer) flushLogged() {
+	ctx, cancel := context.WithTimeout(context.Background(), lastSeenFlushTimeout)
+	defer cancel()
+	if err := b.Flush(ctx); err != nil {
+		b.logger.Printf("Failed to write last seen times: %v", err)
+	}
+}
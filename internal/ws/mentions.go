@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// MentionPattern matches @username, but not the middle of an email address
+var MentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.-])@([A-Za-z0-9_.-]+)`)
+
+// Mentioning a whole hall: @everyone notifies all of its members, @here the
+// ones online. Who may do it is up to the hall, see
+// store.HallSettings.MentionEveryone.
+const (
+	MentionEveryone = "everyone"
+	MentionHere     = "here"
+)
+
+// massMentionCooldown is how long after one mass mention a hall can't get
+// another
+const massMentionCooldown = 5 * time.Minute
+
+// MassMention returns MentionEveryone or MentionHere if content mentions
+// either, @everyone winning, and "" otherwise
+func MassMention(content string) string {
+	mention := ""
+	for _, match := range MentionPattern.FindAllStringSubmatch(content, -1) {
+		// "@here." at the end of a sentence counts
+		switch strings.ToLower(strings.TrimRight(match[1], ".-")) {
+		case MentionEveryone:
+			return MentionEveryone
+		case MentionHere:
+			mention = MentionHere
+		}
+	}
+	return mention
+}
+
+// massMentionCooldowns remembers until when each hall can't be mentioned as
+// a whole again. Like spam throttles they're kept per instance.
+type massMentionCooldowns struct {
+	mutex sync.Mutex
+	until map[int]time.Time // by hall
+}
+
+// take starts the hall's cooldown, or returns how much of it is left
+func (m *massMentionCooldowns) take(hallID int) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if until, ok := m.until[hallID]; ok && until.After(now) {
+		return until.Sub(now)
+	}
+	if m.until == nil {
+		m.until = make(map[int]time.Time)
+	}
+	m.until[hallID] = now.Add(massMentionCooldown)
+	return 0
+}
+
+// prune forgets cooldowns that are over
+func (m *massMentionCooldowns) prune() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	for hallID, until := range m.until {
+		if !until.After(now) {
+			delete(m.until, hallID)
+		}
+	}
+}
+
+// checkMassMention works out whether a message about to be sent mentions
+// its whole hall. From someone the hall doesn't let do that, @everyone and
+// @here are just text. Otherwise a hall still cooling down from the last
+// one turns the message away: the sender is told and it returns false.
+func (c *Client) checkMassMention(ctx context.Context, room *store.Room, sendData SendMessageData) (string, bool) {
+	mention := MassMention(sendData.Content)
+	if mention == "" {
+		return "", true
+	}
+
+	settings, err := c.manager.db.GetHallSettings(ctx, room.HallID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to load settings of hall %d: %v", room.HallID, err)
+		return "", true
+	}
+	if settings.MentionEveryone != store.MentionEveryoneMembers {
+		admin, err := c.manager.db.IsHallAdmin(ctx, c.session.UserID, room.HallID)
+		if err != nil {
+			c.manager.logger.Printf("Failed to check admin of hall %d: %v", room.HallID, err)
+		}
+		if !admin {
+			return "", true
+		}
+	}
+
+	if wait := c.manager.massMentions.take(room.HallID); wait > 0 {
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "mention_rate_limited",
+			Message:      "This hall was mentioned as a whole too recently",
+			RetryAfterMs: wait.Milliseconds(),
+		})
+		return "", false
+	}
+	return mention, true
+}
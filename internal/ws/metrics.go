@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// fanoutBuckets are the upper bounds, in seconds, of the fan-out latency
+// histogram. Queueing a frame for a room's clients should take microseconds;
+// the top buckets are there to show lock contention.
+var fanoutBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25}
+
+// LatencyHistogram is a fixed-bucket histogram that can be updated from many
+// goroutines without a lock
+type LatencyHistogram struct {
+	bounds []float64
+	counts []atomic.Int64 // one per bound, plus one for +Inf
+	sum    atomic.Int64   // nanoseconds
+}
+
+func newLatencyHistogram(bounds []float64) *LatencyHistogram {
+	return &LatencyHistogram{
+		bounds: bounds,
+		counts: make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// WritePrometheus writes the histogram as cumulative buckets
+func (h *LatencyHistogram) WritePrometheus(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(time.Duration(h.sum.Load()).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, cumulative)
+}
+
+// ConnectionCounts splits the open connections by kind
+type ConnectionCounts struct {
+	WebSocket int `json:"websocket"`
+	SSE       int `json:"sse"`
+	Guests    int `json:"guests"`
+}
+
+// ConnectionCounts counts the open connections, and how many clients each
+// room with any has joined to it
+func (m *Manager) ConnectionCounts() (ConnectionCounts, map[int]int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var counts ConnectionCounts
+	for client := range m.clients {
+		if client.conn == nil {
+			counts.SSE++
+		} else {
+			counts.WebSocket++
+		}
+		if client.guest {
+			counts.Guests++
+		}
+	}
+
+	subscribers := make(map[int]int, len(m.rooms))
+	for roomID, clients := range m.rooms {
+		if len(clients) > 0 {
+			subscribers[roomID] = len(clients)
+		}
+	}
+	return counts, subscribers
+}
+
+// FanoutLatency is how long broadcasts took to queue for local clients
+func (m *Manager) FanoutLatency() *LatencyHistogram {
+	return m.fanoutLatency
+}
@@ -0,0 +1,265 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// DeliveryStats is how websocket fan-out has been keeping up
+type DeliveryStats struct {
+	Broadcasts      int64 `json:"broadcasts"`
+	FramesQueued    int64 `json:"frames_queued"`
+	FramesDropped   int64 `json:"frames_dropped"`
+	SlowDisconnects int64 `json:"slow_disconnects"`
+}
+
+// DeviceKeysUpdatedData tells a user's DM partners their devices changed
+type DeviceKeysUpdatedData struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// WebSocket message types
+type WSMessage struct {
+	Type   string      `json:"type"`
+	Seq    int64       `json:"seq,omitempty"`     // per-room sequence number of room events
+	RoomID int         `json:"room_id,omitempty"` // set on room events
+	Data   interface{} `json:"data"`
+}
+
+type JoinRoomData struct {
+	HallID int `json:"hall_id"`
+	RoomID int `json:"room_id"`
+}
+
+type SendMessageData struct {
+	RoomID  int             `json:"room_id"`
+	Content string          `json:"content"`
+	Nonce   string          `json:"nonce,omitempty"`       // client-generated, deduplicates retries
+	TTL     int             `json:"ttl_seconds,omitempty"` // deletes the message after this long
+	Kind    string          `json:"kind,omitempty"`        // text if empty
+	Payload json.RawMessage `json:"payload,omitempty"`     // the kind's own fields
+
+	Components []store.MessageComponent `json:"components,omitempty"` // buttons and selects
+}
+
+type BroadcastMessageData struct {
+	Message store.Message `json:"message"`
+	RoomID  int           `json:"room_id"`
+	Nonce   string        `json:"nonce,omitempty"`
+}
+
+// AckData confirms a send_message to its sender. Duplicate is set when the
+// nonce was seen before and the original message is returned instead.
+type AckData struct {
+	Nonce     string `json:"nonce,omitempty"`
+	RoomID    int    `json:"room_id"`
+	MessageID int    `json:"message_id"`
+	Seq       int64  `json:"seq,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+type DMMessageData struct {
+	Conversation store.DMConversation `json:"conversation"`
+	Message      store.DMMessage      `json:"message"`
+}
+
+type ReactionData struct {
+	MessageID int    `json:"message_id"`
+	RoomID    int    `json:"room_id"`
+	UserID    int    `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// RoomCreatedData is sent to a hall's members with room_created
+type RoomCreatedData struct {
+	HallID int         `json:"hall_id"`
+	Room   *store.Room `json:"room"`
+}
+
+// HallMemberData is sent to a hall's members with member_joined and
+// member_left
+type HallMemberData struct {
+	HallID   int    `json:"hall_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// JoinRequestResolvedData is sent to whoever asked to join a hall with
+// join_request_approved or join_request_denied
+type JoinRequestResolvedData struct {
+	HallID   int    `json:"hall_id"`
+	HallName string `json:"hall_name"`
+}
+
+// UserRenamedData is sent with user_renamed to everyone sharing a hall with
+// someone who changed their username
+type UserRenamedData struct {
+	UserID      int    `json:"user_id"`
+	OldUsername string `json:"old_username"`
+	Username    string `json:"username"`
+}
+
+// sessionsRevoked goes between instances over the broker, never to clients,
+// so every instance closes connections using sessions one of them revoked
+const sessionsRevoked = "sessions_revoked"
+
+type SessionsRevokedData struct {
+	SessionIDs []string `json:"session_ids"`
+}
+
+// HelloData is the first frame on every ws connection
+type HelloData struct {
+	ProtocolVersion     int          `json:"protocol_version"`
+	Encoding            string       `json:"encoding"` // json or msgpack
+	Intents             []string     `json:"intents"`  // the event categories this connection gets
+	Language            string       `json:"language"` // what error messages are written in
+	SupportedVersions   []int        `json:"supported_versions"`
+	HeartbeatIntervalMs int64        `json:"heartbeat_interval_ms"` // send a ping at least this often
+	HeartbeatTimeoutMs  int64        `json:"heartbeat_timeout_ms"`  // the connection is closed after this long without one
+	MaxFrameBytes       int64        `json:"max_frame_bytes"`
+	Session             HelloSession `json:"session"`
+}
+
+type HelloSession struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Guest     bool      `json:"guest,omitempty"`  // read-only, without an account
+	Scopes    []string  `json:"scopes,omitempty"` // only for scoped tokens
+}
+
+// ReconnectData is sent when the server is about to close the connection for
+// a restart: wait DelayMs, then reconnect (to URL if set) and resume
+type ReconnectData struct {
+	DelayMs int64  `json:"delay_ms"`
+	URL     string `json:"url,omitempty"`
+}
+
+// ResumeData is sent with resume: the last seq the client saw in each room
+type ResumeData struct {
+	Rooms []RoomPosition `json:"rooms"`
+}
+
+type RoomPosition struct {
+	RoomID int   `json:"room_id"`
+	Seq    int64 `json:"seq"`
+}
+
+// ResumedData is sent with resumed and resync_required
+type ResumedData struct {
+	RoomID   int   `json:"room_id"`
+	Seq      int64 `json:"seq"`
+	Replayed int   `json:"replayed,omitempty"`
+}
+
+type WSErrorData struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+	Nonce        string `json:"nonce,omitempty"` // of the send_message that failed
+}
+
+// MessageDeletedData is sent with message_deleted
+type MessageDeletedData struct {
+	MessageID int    `json:"message_id"`
+	RoomID    int    `json:"room_id"`
+	Reason    string `json:"reason"` // "expired" for self-destructing messages, "moderation" when a hall admin deleted it
+}
+
+// MessageComponentsData is sent with message_components_updated, when
+// whoever posted a message changes its buttons or selects
+type MessageComponentsData struct {
+	MessageID  int                      `json:"message_id"`
+	RoomID     int                      `json:"room_id"`
+	Components []store.MessageComponent `json:"components"` // empty once removed
+}
+
+// InteractionData is sent with interaction_created to whoever posted the
+// message, when someone clicks one of its buttons or picks from a select
+type InteractionData struct {
+	ID            string    `json:"id"`
+	MessageID     int       `json:"message_id"`
+	RoomID        int       `json:"room_id"`
+	HallID        int       `json:"hall_id"`
+	CustomID      string    `json:"custom_id"`
+	ComponentType string    `json:"component_type"`
+	Values        []string  `json:"values"` // what was picked in a select, empty for buttons
+	UserID        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// MessagesBulkDeletedData is sent with messages_bulk_deleted when a hall
+// admin deletes several messages of a room at once
+type MessagesBulkDeletedData struct {
+	RoomID     int    `json:"room_id"`
+	MessageIDs []int  `json:"message_ids"`
+	Reason     string `json:"reason"` // always "moderation"
+}
+
+// MessageTTLData is sent with message_ttl_updated when a room's
+// disappearing-message policy changes
+type MessageTTLData struct {
+	RoomID     int `json:"room_id"`
+	MessageTTL int `json:"message_ttl_seconds"`
+}
+
+// VoiceStateData answers voice_join with the new participant's own peer ID
+// and everyone already there
+type VoiceStateData struct {
+	RoomID       int                      `json:"room_id"`
+	PeerID       string                   `json:"peer_id"`
+	Participants []store.VoiceParticipant `json:"participants"`
+}
+
+// VoiceLeftData is sent with voice_left
+type VoiceLeftData struct {
+	RoomID int    `json:"room_id"`
+	PeerID string `json:"peer_id"`
+	UserID int    `json:"user_id"`
+	Reason string `json:"reason,omitempty"` // "timeout" when the connection went quiet
+}
+
+// VoiceSignalData relays an offer, answer or ICE candidate between two
+// peers. The server fills in the From fields; Payload is passed on as is.
+type VoiceSignalData struct {
+	RoomID     int             `json:"room_id"`
+	ToPeer     string          `json:"to_peer"`
+	FromPeer   string          `json:"from_peer,omitempty"`
+	FromUserID int             `json:"from_user_id,omitempty"`
+	Kind       string          `json:"kind"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// CommandResponseData is a slash command's reply, sent with
+// command_response to the one connection that ran it
+type CommandResponseData struct {
+	RoomID  int    `json:"room_id"`
+	Command string `json:"command"`
+	Text    string `json:"text"`
+	Nonce   string `json:"nonce,omitempty"` // of the send_message that ran it
+}
+
+// RoomTopicData is sent with room_topic_updated
+type RoomTopicData struct {
+	RoomID   int    `json:"room_id"`
+	Topic    string `json:"topic"` // empty once cleared
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// MentionData is sent to a user mentioned in a room they haven't muted
+type MentionData struct {
+	HallID      int           `json:"hall_id"`
+	Message     store.Message `json:"message"`
+	MassMention string        `json:"mass_mention,omitempty"` // everyone or here, if the whole hall was
+}
+
+type PresenceData struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status"` // "online" or "offline"
+}
@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsConnectTimeout bounds the first connection at startup
+const natsConnectTimeout = 5 * time.Second
+
+// NATSBroker fans broadcasts out over a NATS subject so every API instance
+// behind a load balancer reaches its own clients. Core NATS is at-most-once,
+// like Redis pub/sub: anything published while an instance is disconnected
+// is lost to its clients, who catch up with resume.
+type NATSBroker struct {
+	conn    *nats.Conn
+	subject string
+	sub     *nats.Subscription
+	logger  *log.Logger
+}
+
+func NewNATSBroker(url, subject string, logger *log.Logger) (*NATSBroker, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("commons-api"),
+		nats.Timeout(natsConnectTimeout),
+		nats.MaxReconnects(-1), // keep trying for as long as the process runs
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Printf("Disconnected from NATS: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logger.Printf("Reconnected to NATS at %s", conn.ConnectedUrlRedacted())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSBroker{conn: conn, subject: subject, logger: logger}, nil
+}
+
+func (b *NATSBroker) Publish(msg BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(b.subject, data)
+}
+
+// Subscribe delivers messages from the subject until Close. The client
+// resubscribes on its own after reconnecting.
+func (b *NATSBroker) Subscribe(handler func(BrokerMessage)) {
+	sub, err := b.conn.Subscribe(b.subject, func(message *nats.Msg) {
+		var msg BrokerMessage
+		if err := json.Unmarshal(message.Data, &msg); err != nil {
+			b.logger.Printf("Dropping malformed broker message: %v", err)
+			return
+		}
+		handler(msg)
+	})
+	if err != nil {
+		b.logger.Printf("Failed to subscribe to NATS subject %s: %v", b.subject, err)
+		return
+	}
+	b.sub = sub
+}
+
+func (b *NATSBroker) Close() error {
+	if b.sub != nil {
+		b.sub.Unsubscribe()
+	}
+	b.conn.Close()
+	return nil
+}
@@ -0,0 +1,170 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// Plugin adds behaviour to the server without changing its handlers. A
+// plugin implements whichever of MessagePreHook, MessagePostHook and
+// UserJoinHook it needs; hooks run in the order plugins were registered.
+type Plugin interface {
+	Name() string
+}
+
+// MessagePreHook runs before a room message is stored. It can change the
+// content, or return an error to turn the message away; the sender is shown
+// the error's text. Automod and the spam checks see the changed content.
+type MessagePreHook interface {
+	OnMessagePre(ctx context.Context, message *PluginMessage) error
+}
+
+// MessagePostHook runs once a room message is stored and broadcast. It runs
+// on the room's writer, so anything slow belongs on its own goroutine.
+type MessagePostHook interface {
+	OnMessagePost(ctx context.Context, message PluginMessage)
+}
+
+// UserJoinHook runs before someone joins a hall, and can return an error to
+// keep them out
+type UserJoinHook interface {
+	OnUserJoin(ctx context.Context, join PluginJoin) error
+}
+
+// PluginMessage is a room message as hooks see it
+type PluginMessage struct {
+	ID       int // 0 before it's stored
+	HallID   int
+	RoomID   int
+	UserID   int
+	Username string
+	Content  string
+	Kind     string // see store.MessageKinds; hooks can't change it
+}
+
+// PluginJoin is someone about to join a hall
+type PluginJoin struct {
+	HallID   int
+	UserID   int
+	Username string
+}
+
+// A PluginError is a hook turning something away
+type PluginError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *PluginError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	registeredMutex   sync.Mutex
+	registeredPlugins []Plugin
+)
+
+// RegisterPlugin compiles a plugin into every server started afterwards,
+// usually from the plugin package's init
+func RegisterPlugin(p Plugin) {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	registeredPlugins = append(registeredPlugins, p)
+}
+
+// RegisteredPlugins returns the plugins passed to RegisterPlugin
+func RegisteredPlugins() []Plugin {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	return append([]Plugin(nil), registeredPlugins...)
+}
+
+// Plugins runs the hooks of a set of plugins. A hook that panics is logged
+// and skipped rather than taking the server down.
+type Plugins struct {
+	plugins []Plugin
+	logger  *log.Logger
+}
+
+func NewPlugins(plugins []Plugin, logger *log.Logger) *Plugins {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &Plugins{plugins: plugins, logger: logger}
+}
+
+// MessagePre runs every OnMessagePre hook on message, stopping at the first
+// that turns it away
+func (p *Plugins) MessagePre(ctx context.Context, message *PluginMessage) error {
+	for _, plugin := range p.plugins {
+		hook, ok := plugin.(MessagePreHook)
+		if !ok {
+			continue
+		}
+		if err := p.call(plugin, func() error { return hook.OnMessagePre(ctx, message) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MessagePost runs every OnMessagePost hook
+func (p *Plugins) MessagePost(ctx context.Context, message PluginMessage) {
+	for _, plugin := range p.plugins {
+		if hook, ok := plugin.(MessagePostHook); ok {
+			p.call(plugin, func() error {
+				hook.OnMessagePost(ctx, message)
+				return nil
+			})
+		}
+	}
+}
+
+// UserJoin runs every OnUserJoin hook, stopping at the first that turns the
+// join away
+func (p *Plugins) UserJoin(ctx context.Context, join PluginJoin) error {
+	for _, plugin := range p.plugins {
+		hook, ok := plugin.(UserJoinHook)
+		if !ok {
+			continue
+		}
+		if err := p.call(plugin, func() error { return hook.OnUserJoin(ctx, join) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call runs one hook, wrapping what it returns in a PluginError
+func (p *Plugins) call(plugin Plugin, hook func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Printf("Plugin %s panicked: %v", plugin.Name(), r)
+			err = nil
+		}
+	}()
+
+	if err := hook(); err != nil {
+		var pluginErr *PluginError
+		if errors.As(err, &pluginErr) {
+			return err
+		}
+		return &PluginError{Plugin: plugin.Name(), Err: err}
+	}
+	return nil
+}
+
+// rejectedMessage is what the sender of a message a plugin turned away is
+// told
+func rejectedMessage(err error) string {
+	if msg := err.Error(); msg != "" {
+		return msg
+	}
+	return "Your message was rejected"
+}
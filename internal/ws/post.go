@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"chatapp/internal/auth"
+)
+
+// A message posted over REST gets back at most a command reply and then an
+// ack or an error
+const postFrames = 4
+
+// PostResult is how a message posted with PostMessage went: Ack once it's
+// stored, or Error if it was turned away, and Command if it ran a slash
+// command. Neither Ack nor Error is set when a command didn't send
+// anything, or automod or the spam filter dropped the message quietly.
+type PostResult struct {
+	Ack     *AckData
+	Command *CommandResponseData
+	Error   *WSErrorData
+}
+
+// postLimiters rate limit PostMessage like send_message, per user rather
+// than per connection
+type postLimiters struct {
+	mutex sync.Mutex
+	users map[int]*RateLimiter
+}
+
+func (p *postLimiters) get(userID int) *RateLimiter {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.users == nil {
+		p.users = make(map[int]*RateLimiter)
+	}
+	limiter, ok := p.users[userID]
+	if !ok {
+		limiter = NewRateLimiter(MessageLimit, MessageWindow, MessageBurst)
+		p.users[userID] = limiter
+	}
+	return limiter
+}
+
+// prune forgets the limiters of users who haven't posted in a while
+func (p *postLimiters) prune() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for userID, limiter := range p.users {
+		if limiter.refilled() {
+			delete(p.users, userID)
+		}
+	}
+}
+
+// PostMessage sends a message for session as if one of its connections had
+// sent send_message: through the same commands, plugins, automod, spam and
+// mention checks, broadcast the same way, nonce and all. It waits until the
+// message is stored or ctx ends. The caller checks the user may post in the
+// room.
+func (m *Manager) PostMessage(ctx context.Context, session *auth.Session, data SendMessageData) (PostResult, error) {
+	// A client that never connects: what it would have been sent comes
+	// back on its queue instead
+	client := &Client{
+		session:    session,
+		send:       make(chan []byte, postFrames),
+		manager:    m,
+		rooms:      map[int]bool{data.RoomID: true},
+		lastPing:   time.Now(),
+		limiter:    m.postLimiters.get(session.UserID),
+		protocol:   wsVersion,
+		intents:    AllIntents,
+		stopStream: func() {},
+		since:      time.Now(),
+		post:       true,
+	}
+	queued := client.sendMessage(ctx, data)
+
+	var result PostResult
+	for {
+		var frame []byte
+		if queued {
+			select {
+			case frame = <-client.send:
+			case <-ctx.Done():
+				return result, ctx.Err()
+			}
+		} else {
+			select {
+			case frame = <-client.send:
+			default:
+				return result, nil
+			}
+		}
+
+		var message struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(frame, &message); err != nil {
+			return result, err
+		}
+		switch message.Type {
+		case "command_response":
+			result.Command = &CommandResponseData{}
+			if err := json.Unmarshal(message.Data, result.Command); err != nil {
+				return result, err
+			}
+		case "ack":
+			result.Ack = &AckData{}
+			return result, json.Unmarshal(message.Data, result.Ack)
+		case "error":
+			result.Error = &WSErrorData{}
+			return result, json.Unmarshal(message.Data, result.Error)
+		}
+	}
+}
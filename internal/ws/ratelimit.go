@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket: it refills at limit tokens per interval and
+// holds at most burst tokens, so short bursts are allowed but the sustained
+// rate is capped.
+type RateLimiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func NewRateLimiter(limit int, interval time.Duration, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   float64(limit) / interval.Seconds(),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token if one is available. When it isn't, it returns false
+// and how long until the next token.
+func (rl *RateLimiter) Allow() (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+	return false, wait
+}
+
+// refilled reports whether the bucket is full again, so replacing the
+// limiter with a new one would change nothing
+func (rl *RateLimiter) refilled() bool {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return rl.tokens+time.Since(rl.last).Seconds()*rl.rate >= rl.burst
+}
@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPublishTimeout bounds one publish; broadcasts aren't tied to a request
+const redisPublishTimeout = 2 * time.Second
+
+// RedisBroker fans broadcasts out over a Redis pub/sub channel so every API
+// instance behind a load balancer reaches its own clients
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+	pubsub  *redis.PubSub
+	logger  *log.Logger
+}
+
+func NewRedisBroker(url, channel string, logger *log.Logger) (*RedisBroker, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisBroker{client: client, channel: channel, logger: logger}, nil
+}
+
+func (b *RedisBroker) Publish(msg BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+// Subscribe delivers messages from the channel until Close. go-redis
+// reconnects and resubscribes on its own if the connection drops.
+func (b *RedisBroker) Subscribe(handler func(BrokerMessage)) {
+	b.pubsub = b.client.Subscribe(context.Background(), b.channel)
+
+	go func() {
+		for message := range b.pubsub.Channel() {
+			var msg BrokerMessage
+			if err := json.Unmarshal([]byte(message.Payload), &msg); err != nil {
+				b.logger.Printf("Dropping malformed broker message: %v", err)
+				continue
+			}
+			handler(msg)
+		}
+	}()
+}
+
+func (b *RedisBroker) Close() error {
+	if b.pubsub != nil {
+		b.pubsub.Close()
+	}
+	return b.client.Close()
+}
@@ -0,0 +1,21 @@
+package ws
+
+import "chatapp/internal/auth"
+
+// wsMessageScopes are what scoped tokens need to send each ws message;
+// connecting at all takes read:messages
+var wsMessageScopes = map[string]string{
+	"join_room":       auth.ScopeReadMessages,
+	"leave_room":      auth.ScopeReadMessages,
+	"join_hall":       auth.ScopeReadMessages,
+	"leave_hall":      auth.ScopeReadMessages,
+	"resume":          auth.ScopeReadMessages,
+	"ping":            auth.ScopeReadMessages,
+	"send_message":    auth.ScopeWriteMessages,
+	"add_reaction":    auth.ScopeWriteMessages,
+	"remove_reaction": auth.ScopeWriteMessages,
+	"voice_join":      auth.ScopeWriteMessages,
+	"voice_leave":     auth.ScopeWriteMessages,
+	"voice_signal":    auth.ScopeWriteMessages,
+	"typing":          auth.ScopeWriteMessages,
+}
@@ -0,0 +1,247 @@
+package ws
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// spamWindow is how far back the scorer remembers what each user sent
+const spamWindow = 10 * time.Minute
+
+// spamThrottle is how long someone over a hall's throttle score is kept from
+// posting in that hall
+const spamThrottle = time.Minute
+
+// SpamInput is what a spam check gets to look at
+type SpamInput struct {
+	HallID       int
+	RoomID       int
+	UserID       int
+	AccountAge   time.Duration
+	Content      string
+	ContentHash  [32]byte
+	RecentSends  int // messages the user sent anywhere in the last minute
+	RecentCopies int // earlier messages in spamWindow with the same content
+}
+
+// SpamCheck is one spam heuristic. It adds to a message's score and says why;
+// a zero score means it found nothing.
+type SpamCheck interface {
+	Score(input SpamInput) (int, string)
+}
+
+// SpamVerdict is a message's total score and the reasons behind it
+type SpamVerdict struct {
+	Score   int
+	Reasons []string
+}
+
+func (v SpamVerdict) String() string {
+	return fmt.Sprintf("spam score %d: %s", v.Score, strings.Join(v.Reasons, ", "))
+}
+
+// duplicateCheck catches the same message pasted over and over, in one room
+// or across several
+type duplicateCheck struct{}
+
+func (duplicateCheck) Score(input SpamInput) (int, string) {
+	if input.RecentCopies == 0 {
+		return 0, ""
+	}
+	copies := input.RecentCopies
+	if copies > 3 {
+		copies = 3
+	}
+	return 3 * copies, fmt.Sprintf("duplicate x%d", input.RecentCopies+1)
+}
+
+var spamLinkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// linkCheck counts links; one is normal, a message full of them usually isn't
+type linkCheck struct{}
+
+func (linkCheck) Score(input SpamInput) (int, string) {
+	links := len(spamLinkPattern.FindAllStringIndex(input.Content, -1))
+	if links < 2 {
+		return 0, ""
+	}
+	return links - 1, fmt.Sprintf("%d links", links)
+}
+
+// newAccountCheck is wary of accounts younger than a day, the more so the
+// faster they post
+type newAccountCheck struct{}
+
+func (newAccountCheck) Score(input SpamInput) (int, string) {
+	if input.AccountAge >= 24*time.Hour {
+		return 0, ""
+	}
+	score := 1
+	if input.RecentSends > 3 {
+		score += input.RecentSends - 3
+	}
+	return score, "new account"
+}
+
+// DefaultSpamChecks are the heuristics every hall's messages are scored with
+func DefaultSpamChecks() []SpamCheck {
+	return []SpamCheck{duplicateCheck{}, linkCheck{}, newAccountCheck{}}
+}
+
+type spamSend struct {
+	hash [32]byte
+	at   time.Time
+}
+
+// SpamScorer scores messages against its checks, remembering recent sends
+// per user for the checks that need history, and tracks who is throttled.
+// Halls without a store.SpamPolicy aren't scored at all.
+type SpamScorer struct {
+	db     *store.Database
+	checks []SpamCheck
+
+	mutex     sync.Mutex
+	recent    map[int][]spamSend   // by user
+	accounts  map[int]time.Time    // account creation times, by user
+	throttled map[[2]int]time.Time // hall and user to the end of the throttle
+}
+
+func NewSpamScorer(db *store.Database, checks []SpamCheck) *SpamScorer {
+	return &SpamScorer{
+		db:        db,
+		checks:    checks,
+		recent:    make(map[int][]spamSend),
+		accounts:  make(map[int]time.Time),
+		throttled: make(map[[2]int]time.Time),
+	}
+}
+
+// Throttled reports how much longer userID is kept from posting in hallID
+func (s *SpamScorer) Throttled(hallID, userID int) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	until, ok := s.throttled[[2]int{hallID, userID}]
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait
+	}
+	delete(s.throttled, [2]int{hallID, userID})
+	return 0
+}
+
+// Throttle keeps userID from posting in hallID for spamThrottle
+func (s *SpamScorer) Throttle(hallID, userID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.throttled[[2]int{hallID, userID}] = time.Now().Add(spamThrottle)
+}
+
+func (s *SpamScorer) accountCreated(ctx context.Context, userID int) (time.Time, error) {
+	s.mutex.Lock()
+	created, ok := s.accounts[userID]
+	s.mutex.Unlock()
+	if ok {
+		return created, nil
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.mutex.Lock()
+	s.accounts[userID] = user.CreatedAt
+	s.mutex.Unlock()
+	return user.CreatedAt, nil
+}
+
+// Score scores a message and remembers it, so repeats are caught even when
+// the first copy was turned away
+func (s *SpamScorer) Score(ctx context.Context, hallID, roomID, userID int, content string) (SpamVerdict, error) {
+	created, err := s.accountCreated(ctx, userID)
+	if err != nil {
+		return SpamVerdict{}, err
+	}
+
+	now := time.Now()
+	input := SpamInput{
+		HallID:      hallID,
+		RoomID:      roomID,
+		UserID:      userID,
+		AccountAge:  now.Sub(created),
+		Content:     content,
+		ContentHash: sha256.Sum256([]byte(strings.ToLower(strings.Join(strings.Fields(content), " ")))),
+	}
+
+	s.mutex.Lock()
+	kept := s.recent[userID][:0]
+	for _, send := range s.recent[userID] {
+		if now.Sub(send.at) > spamWindow {
+			continue
+		}
+		kept = append(kept, send)
+		if send.hash == input.ContentHash {
+			input.RecentCopies++
+		}
+		if now.Sub(send.at) <= time.Minute {
+			input.RecentSends++
+		}
+	}
+	s.recent[userID] = append(kept, spamSend{hash: input.ContentHash, at: now})
+	s.mutex.Unlock()
+
+	var verdict SpamVerdict
+	for _, check := range s.checks {
+		if score, reason := check.Score(input); score > 0 {
+			verdict.Score += score
+			verdict.Reasons = append(verdict.Reasons, reason)
+		}
+	}
+	return verdict, nil
+}
+
+// Action is what policy says to do about the verdict, the strictest
+// threshold it reaches, or "" if it reaches none
+func (v SpamVerdict) Action(p store.SpamPolicy) string {
+	reaches := func(threshold int) bool {
+		return threshold > 0 && v.Score >= threshold
+	}
+	switch {
+	case reaches(p.DeleteScore):
+		return store.SpamActionDelete
+	case reaches(p.ThrottleScore):
+		return store.SpamActionThrottle
+	case reaches(p.FlagScore):
+		return store.SpamActionFlag
+	}
+	return ""
+}
+
+// Prune forgets sends, account ages and throttles that no longer matter
+func (s *SpamScorer) Prune() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for userID, sends := range s.recent {
+		if len(sends) == 0 || now.Sub(sends[len(sends)-1].at) > spamWindow {
+			delete(s.recent, userID)
+			delete(s.accounts, userID)
+		}
+	}
+	for key, until := range s.throttled {
+		if now.After(until) {
+			delete(s.throttled, key)
+		}
+	}
+}
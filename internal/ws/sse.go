@@ -0,0 +1,203 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// sseRetry is how long browsers wait before reconnecting a dropped stream
+const sseRetry = 3 * time.Second
+
+// HandleEventStream serves room events as Server-Sent Events, for clients
+// that can't open a websocket. The stream is registered like a ws client, so
+// it gets the same broadcasts through the same broker. positions holds the
+// last seq seen per room (from Last-Event-ID); other rooms start from now.
+func (m *Manager) HandleEventStream(w http.ResponseWriter, r *http.Request, session *auth.Session, roomIDs []int, positions map[int]int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.RespondError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	if m.Draining() {
+		w.Header().Set("Retry-After", "1")
+		api.RespondErrorCode(w, api.ErrCodeDraining, "Server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	client := &Client{
+		session:    session,
+		send:       make(chan []byte, 256),
+		manager:    m,
+		rooms:      make(map[int]bool),
+		lastPing:   time.Now(),
+		protocol:   wsVersion,
+		intents:    AllIntents,
+		lang:       m.clientLanguage(r),
+		stopStream: func() { stopOnce.Do(func() { close(stop) }) },
+		ip:         api.ClientIP(r),
+		since:      time.Now(),
+	}
+
+	client.sendEvent(WSMessage{Type: "hello", Data: m.newHello(session, wsVersion, "json", AllIntents, client.lang, false)})
+	if !m.admit(client) {
+		api.RespondErrorCode(w, api.ErrCodeTooManyConnections, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+
+	m.lastSeen.Touch(session.UserID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+
+	// Joining and replaying goes through resume, in the background since the
+	// replay can be bigger than the send buffer this loop drains
+	resumeCtx, cancelResume := context.WithTimeout(context.Background(), wsQueryTimeout)
+	resumeDone := make(chan struct{})
+	go func() {
+		defer close(resumeDone)
+		var resume ResumeData
+		for _, roomID := range roomIDs {
+			seq, ok := positions[roomID]
+			if !ok {
+				current, err := m.db.GetRoomSeq(resumeCtx, roomID)
+				if err != nil {
+					m.logger.Printf("Failed to read sequence of room %d: %v", roomID, err)
+				}
+				seq = current
+			}
+			resume.Rooms = append(resume.Rooms, RoomPosition{RoomID: roomID, Seq: seq})
+		}
+		client.handleResume(resumeCtx, resume)
+	}()
+
+	defer func() {
+		cancelResume()
+		<-resumeDone
+		m.unregister <- client
+	}()
+
+	cursor := make(map[int]int64, len(positions))
+	for roomID, seq := range positions {
+		cursor[roomID] = seq
+	}
+
+	heartbeat := time.NewTicker(wsHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case frame, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := writeSSEFrame(w, frame, cursor); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			client.lastPing = time.Now()
+			m.lastSeen.Touch(session.UserID)
+
+		case <-stop:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEFrame writes one ws frame as an SSE event. Room events move the
+// cursor, which goes out as the event id so a reconnecting EventSource sends
+// back where it was in every room.
+func writeSSEFrame(w http.ResponseWriter, frame []byte, cursor map[int]int64) error {
+	var header struct {
+		Type   string      `json:"type"`
+		Seq    int64       `json:"seq"`
+		RoomID int         `json:"room_id"`
+		Data   ResumedData `json:"data"`
+	}
+	if err := json.Unmarshal(frame, &header); err != nil {
+		return err
+	}
+
+	// resumed and resync_required say where each room starts
+	moved := false
+	switch {
+	case header.Type == "resumed" || header.Type == "resync_required":
+		cursor[header.Data.RoomID] = header.Data.Seq
+		moved = true
+	case header.Seq > 0 && header.RoomID > 0:
+		if header.Seq > cursor[header.RoomID] {
+			cursor[header.RoomID] = header.Seq
+		}
+		moved = true
+	}
+	if moved {
+		if _, err := fmt.Fprintf(w, "id: %s\n", formatSSECursor(cursor)); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", header.Type, frame)
+	return err
+}
+
+// SSE cursors look like "1:42,5:10": room ID and last seq, per room
+func formatSSECursor(cursor map[int]int64) string {
+	roomIDs := make([]int, 0, len(cursor))
+	for roomID := range cursor {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Ints(roomIDs)
+
+	parts := make([]string, len(roomIDs))
+	for i, roomID := range roomIDs {
+		parts[i] = fmt.Sprintf("%d:%d", roomID, cursor[roomID])
+	}
+	return strings.Join(parts, ",")
+}
+
+func ParseSSECursor(value string) (map[int]int64, error) {
+	cursor := make(map[int]int64)
+	if value == "" {
+		return cursor, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		roomStr, seqStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("bad cursor entry %q", part)
+		}
+		roomID, err := strconv.Atoi(roomStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad room in cursor entry %q", part)
+		}
+		seq, err := strconv.ParseInt(seqStr, 10, 64)
+		if err != nil || seq < 0 {
+			return nil, fmt.Errorf("bad seq in cursor entry %q", part)
+		}
+		cursor[roomID] = seq
+	}
+	return cursor, nil
+}
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// A closed connection's rooms are kept for SubscriptionRetention, as long as
+// the events resume replays, so a reconnect from the same device is joined
+// to them again without sending join_room for each
+const SubscriptionRetention = 24 * time.Hour
+
+// deviceIDPattern is what a connection's ?device_id= looks like
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// clientDevice is the device a connection's subscriptions are saved under:
+// the device_id it gave, or its session's ID
+func clientDevice(r *http.Request, session *auth.Session) string {
+	if device := r.URL.Query().Get("device_id"); deviceIDPattern.MatchString(device) {
+		return device
+	}
+	return session.ID
+}
+
+// RestoredRoom is a room rejoined on reconnect, with how far it moved on
+// while the client was away: the events after Since up to Seq, which
+// GET /api/rooms/{room_id}/events?after= returns
+type RestoredRoom struct {
+	RoomID int   `json:"room_id"`
+	Seq    int64 `json:"seq"`
+	Since  int64 `json:"since"` // 0 for rooms created in a followed hall meanwhile
+	Missed int64 `json:"missed"`
+}
+
+// SubscriptionsRestoredData is sent with subscriptions_restored once a
+// reconnected client was rejoined to its rooms
+type SubscriptionsRestoredData struct {
+	Rooms   []RestoredRoom `json:"rooms"`
+	Halls   []int          `json:"halls"`
+	Dropped []int          `json:"dropped"` // rooms that are gone or the client can't read anymore
+}
+
+// saveSubscriptions stores the closing connection's rooms and halls for its
+// device. Guests, connections replaced by a newer one and devices still
+// connected to this instance keep nothing.
+func (c *Client) saveSubscriptions() {
+	if c.guest {
+		return
+	}
+
+	m := c.manager
+	m.mutex.RLock()
+	if c.evicted || m.deviceConnectedElsewhere(c) {
+		m.mutex.RUnlock()
+		return
+	}
+	roomIDs := make([]int, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	halls := make([]int, 0, len(c.halls))
+	for hallID := range c.halls {
+		halls = append(halls, hallID)
+	}
+	m.mutex.RUnlock()
+
+	if len(roomIDs) == 0 && len(halls) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+	defer cancel()
+
+	saved := &store.SavedSubscriptions{
+		UserID:  c.session.UserID,
+		Device:  c.device,
+		Rooms:   make(map[int]int64, len(roomIDs)),
+		Halls:   halls,
+		SavedAt: time.Now(),
+	}
+	for _, roomID := range roomIDs {
+		seq, err := m.db.GetRoomSeq(ctx, roomID)
+		if err != nil {
+			m.logger.Printf("Failed to read sequence of room %d: %v", roomID, err)
+			continue
+		}
+		saved.Rooms[roomID] = seq
+	}
+	if err := m.db.SaveSubscriptions(ctx, saved); err != nil {
+		m.logger.Printf("Failed to save subscriptions of %s: %v", c.session.Username, err)
+	}
+}
+
+// deviceConnectedElsewhere reports whether another connection here is the
+// same user on the client's device; the caller holds the mutex
+func (m *Manager) deviceConnectedElsewhere(client *Client) bool {
+	for other := range m.clients {
+		if other != client && other.conn != nil && other.session.UserID == client.session.UserID && other.device == client.device {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreSubscriptions rejoins a reconnected client to the rooms and halls
+// its device followed before, checking it can still read them, and sends
+// subscriptions_restored. Rooms created in a followed hall while it was
+// away are joined too.
+func (c *Client) restoreSubscriptions() {
+	if c.guest {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+	defer cancel()
+
+	saved, err := c.manager.db.TakeSubscriptions(ctx, c.session.UserID, c.device, time.Now().Add(-SubscriptionRetention))
+	if err != nil {
+		c.manager.logger.Printf("Failed to load subscriptions of %s: %v", c.session.Username, err)
+		return
+	}
+	if saved == nil {
+		return
+	}
+
+	restored := SubscriptionsRestoredData{Rooms: []RestoredRoom{}, Halls: []int{}, Dropped: []int{}}
+	joined := make(map[int]bool)
+	rejoin := func(roomID int, since int64) {
+		latest, err := c.manager.db.GetRoomSeq(ctx, roomID)
+		if err != nil {
+			c.manager.logger.Printf("Failed to read sequence of room %d: %v", roomID, err)
+			restored.Dropped = append(restored.Dropped, roomID)
+			return
+		}
+		c.manager.addClientToRoom(c, roomID)
+		joined[roomID] = true
+		restored.Rooms = append(restored.Rooms, RestoredRoom{RoomID: roomID, Seq: latest, Since: since, Missed: max(latest-since, 0)})
+	}
+
+	for roomID, since := range saved.Rooms {
+		room, err := c.manager.db.GetRoomByID(ctx, roomID)
+		if err != nil || !c.canRead(ctx, room) {
+			restored.Dropped = append(restored.Dropped, roomID)
+			continue
+		}
+		rejoin(roomID, since)
+	}
+
+	for _, hallID := range saved.Halls {
+		isMember, err := c.manager.db.IsUserInHall(ctx, c.session.UserID, hallID)
+		if err != nil || !isMember {
+			continue
+		}
+		rooms, err := c.manager.db.GetHallRooms(ctx, hallID, false)
+		if err != nil {
+			c.manager.logger.Printf("Failed to fetch rooms of hall %d: %v", hallID, err)
+			continue
+		}
+		for _, room := range rooms {
+			if !joined[room.ID] {
+				rejoin(room.ID, 0)
+			}
+		}
+
+		c.manager.mutex.Lock()
+		if c.halls == nil {
+			c.halls = make(map[int]bool)
+		}
+		c.halls[hallID] = true
+		c.manager.mutex.Unlock()
+		restored.Halls = append(restored.Halls, hallID)
+	}
+
+	sort.Slice(restored.Rooms, func(i, j int) bool { return restored.Rooms[i].RoomID < restored.Rooms[j].RoomID })
+	sort.Ints(restored.Halls)
+	sort.Ints(restored.Dropped)
+	c.sendEvent(WSMessage{Type: "subscriptions_restored", Data: restored})
+	c.manager.logger.Printf("User %s rejoined %d rooms after reconnecting", c.session.Username, len(restored.Rooms))
+}
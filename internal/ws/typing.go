@@ -0,0 +1,181 @@
+package ws
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Someone shows as typing for typingTimeout after their last typing frame,
+// so clients keep sending one every few seconds while the user types. A
+// frame within typingRepeat of the last one isn't broadcast again.
+const (
+	typingTimeout = 8 * time.Second
+	typingRepeat  = 3 * time.Second
+)
+
+// TypingData is sent with typing when someone starts or stops typing in a
+// room
+type TypingData struct {
+	RoomID   int    `json:"room_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Typing   bool   `json:"typing"` // false once they stopped, e.g. sent the message
+}
+
+// Typer is someone typing in a room, until ExpiresAt unless they keep at it
+type Typer struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// typingTracker remembers who's typing where. Every instance fills it from
+// the typing events the broker delivers, so it covers the whole cluster.
+type typingTracker struct {
+	mutex sync.Mutex
+	rooms map[int]map[int]Typer // by room, then user
+}
+
+// update records a typing event
+func (t *typingTracker) update(data TypingData) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !data.Typing {
+		delete(t.rooms[data.RoomID], data.UserID)
+		return
+	}
+	if t.rooms == nil {
+		t.rooms = make(map[int]map[int]Typer)
+	}
+	if t.rooms[data.RoomID] == nil {
+		t.rooms[data.RoomID] = make(map[int]Typer)
+	}
+	t.rooms[data.RoomID][data.UserID] = Typer{
+		UserID:    data.UserID,
+		Username:  data.Username,
+		ExpiresAt: time.Now().Add(typingTimeout).UTC(),
+	}
+}
+
+// recent reports whether the user's typing in the room was announced less
+// than typingRepeat ago
+func (t *typingTracker) recent(roomID, userID int) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	typer, ok := t.rooms[roomID][userID]
+	return ok && time.Until(typer.ExpiresAt) > typingTimeout-typingRepeat
+}
+
+// typers lists who's typing in a room by username
+func (t *typingTracker) typers(roomID int) []Typer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	typers := []Typer{}
+	for _, typer := range t.rooms[roomID] {
+		if typer.ExpiresAt.After(now) {
+			typers = append(typers, typer)
+		}
+	}
+	sort.Slice(typers, func(i, j int) bool { return typers[i].Username < typers[j].Username })
+	return typers
+}
+
+// prune forgets typers who stopped without saying so
+func (t *typingTracker) prune() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	for roomID, typers := range t.rooms {
+		for userID, typer := range typers {
+			if !typer.ExpiresAt.After(now) {
+				delete(typers, userID)
+			}
+		}
+		if len(typers) == 0 {
+			delete(t.rooms, roomID)
+		}
+	}
+}
+
+// observeTyping records a typing event delivered by the broker
+func (m *Manager) observeTyping(payload []byte) {
+	var message struct {
+		Data TypingData `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		m.logger.Printf("Invalid typing event: %v", err)
+		return
+	}
+	m.typing.update(message.Data)
+}
+
+// Typers lists who's typing in a room right now, on any instance
+func (m *Manager) Typers(roomID int) []Typer {
+	return m.typing.typers(roomID)
+}
+
+// RoomSubscribers counts the people following a room live on this
+// instance: accounts once however many connections they have, guests once
+// per connection
+func (m *Manager) RoomSubscribers(roomID int) int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	users := make(map[int]bool)
+	count := 0
+	for _, client := range m.rooms[roomID] {
+		if client.guest {
+			count++
+			continue
+		}
+		if !users[client.session.UserID] {
+			users[client.session.UserID] = true
+			count++
+		}
+	}
+	return count
+}
+
+// handleTyping announces that the client's user started or stopped typing
+// in a joined room. Typing events aren't stored, so they have no seq and
+// aren't replayed on resume.
+func (c *Client) handleTyping(data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	typing := struct {
+		RoomID int   `json:"room_id"`
+		Typing *bool `json:"typing"`
+	}{}
+	if err := json.Unmarshal(jsonData, &typing); err != nil {
+		c.manager.logger.Printf("Invalid typing data: %v", err)
+		return
+	}
+
+	if !c.inRoom(typing.RoomID) {
+		c.sendError(WSErrorData{Code: "not_in_room", Message: "Join the room before typing in it"})
+		return
+	}
+
+	event := TypingData{
+		RoomID:   typing.RoomID,
+		UserID:   c.session.UserID,
+		Username: c.session.Username,
+		Typing:   typing.Typing == nil || *typing.Typing,
+	}
+	if event.Typing && c.manager.typing.recent(event.RoomID, event.UserID) {
+		return
+	}
+
+	jsonData, err := json.Marshal(WSMessage{Type: "typing", RoomID: event.RoomID, Data: event})
+	if err != nil {
+		c.manager.logger.Printf("Failed to marshal typing event: %v", err)
+		return
+	}
+	c.manager.publish(BrokerMessage{RoomID: event.RoomID, Type: "typing", Payload: jsonData})
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ws protocol versions this server speaks. Bump wsVersion when a change
+// would break existing clients; raise wsMinVersion once an old one is dropped.
+const (
+	wsVersion    = 1
+	wsMinVersion = 1
+)
+
+// MessageTooLong reports whether content is over limit characters
+func MessageTooLong(content string, limit int) bool {
+	return utf8.RuneCountInString(content) > limit
+}
+
+// Versions lists every ws protocol version this server accepts, oldest first
+func Versions() []int {
+	versions := make([]int, 0, wsVersion-wsMinVersion+1)
+	for v := wsMinVersion; v <= wsVersion; v++ {
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// negotiateWSVersion picks the newest version out of the comma-separated
+// list a client sent in ?v=. Clients that don't send one get the current
+// version.
+func negotiateWSVersion(offered string) (int, bool) {
+	if offered == "" {
+		return wsVersion, true
+	}
+
+	best := 0
+	for _, field := range strings.Split(offered, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			continue
+		}
+		if v >= wsMinVersion && v <= wsVersion && v > best {
+			best = v
+		}
+	}
+	return best, best != 0
+}
@@ -0,0 +1,172 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// Voice rooms carry the signaling for WebRTC calls over the websocket:
+// clients join with voice_join and then trade offers, answers and ICE
+// candidates with each peer through voice_signal. The media goes peer to
+// peer and never touches the server. Every client connects to every other,
+// so rooms are capped at maxVoiceParticipants.
+const (
+	maxVoiceParticipants = 16
+	voicePruneTimeout    = 10 * time.Second
+)
+
+// voiceSignalKinds are the voice_signal kinds the server relays
+var voiceSignalKinds = map[string]bool{
+	"offer":         true,
+	"answer":        true,
+	"ice_candidate": true,
+}
+
+func (c *Client) handleVoiceJoin(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var joinData struct {
+		RoomID int `json:"room_id"`
+	}
+	if err := json.Unmarshal(jsonData, &joinData); err != nil {
+		c.manager.logger.Printf("Invalid voice_join data: %v", err)
+		return
+	}
+
+	// Same as for sending messages, the room has to be joined first
+	if !c.inRoom(joinData.RoomID) {
+		c.sendError(WSErrorData{Code: "not_in_room", Message: "Join the room before its voice channel"})
+		return
+	}
+
+	room, err := c.manager.db.GetRoomByID(ctx, joinData.RoomID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to load room %d: %v", joinData.RoomID, err)
+		return
+	}
+	if room.Type != store.RoomTypeVoice {
+		c.sendError(WSErrorData{Code: "not_voice_room", Message: "This isn't a voice room"})
+		return
+	}
+	if room.Archived {
+		c.sendError(WSErrorData{Code: "room_archived", Message: "This room is archived"})
+		return
+	}
+
+	// A connection is in one voice room at a time
+	c.leaveVoice(ctx)
+
+	participants, err := c.manager.db.GetVoiceParticipants(ctx, room.ID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to load voice participants of room %d: %v", room.ID, err)
+		return
+	}
+	if len(participants) >= maxVoiceParticipants {
+		c.sendError(WSErrorData{Code: "voice_full", Message: "This voice room is full"})
+		return
+	}
+
+	peerID, err := store.GenerateInviteCode()
+	if err != nil {
+		c.manager.logger.Printf("Failed to generate peer ID: %v", err)
+		return
+	}
+	participant, err := c.manager.db.AddVoiceParticipant(ctx, peerID, room.ID, c.session.UserID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to join voice room %d: %v", room.ID, err)
+		return
+	}
+	c.voicePeer = peerID
+	c.voiceRoom = room
+
+	// The newcomer calls everyone already there
+	c.sendEvent(WSMessage{Type: "voice_state", RoomID: room.ID, Data: VoiceStateData{
+		RoomID:       room.ID,
+		PeerID:       peerID,
+		Participants: participants,
+	}})
+	c.manager.BroadcastToHall(ctx, room.HallID, "voice_joined", participant)
+}
+
+func (c *Client) handleVoiceSignal(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var signal VoiceSignalData
+	if err := json.Unmarshal(jsonData, &signal); err != nil {
+		c.manager.logger.Printf("Invalid voice_signal data: %v", err)
+		return
+	}
+
+	if c.voicePeer == "" {
+		c.sendError(WSErrorData{Code: "not_in_voice", Message: "Join a voice room first"})
+		return
+	}
+	if !voiceSignalKinds[signal.Kind] {
+		c.sendError(WSErrorData{Code: "invalid_signal", Message: "kind must be offer, answer or ice_candidate"})
+		return
+	}
+
+	target, err := c.manager.db.GetVoiceParticipant(ctx, signal.ToPeer)
+	if err != nil || target.RoomID != c.voiceRoom.ID || target.PeerID == c.voicePeer {
+		c.sendError(WSErrorData{Code: "unknown_peer", Message: "That peer isn't in your voice room"})
+		return
+	}
+
+	signal.RoomID = c.voiceRoom.ID
+	signal.FromPeer = c.voicePeer
+	signal.FromUserID = c.session.UserID
+
+	// Goes to all of the user's connections, the one with to_peer takes it
+	c.manager.SendToUser(target.UserID, "voice_signal", signal)
+}
+
+// leaveVoice takes the client out of its voice room, if it's in one
+func (c *Client) leaveVoice(ctx context.Context) {
+	if c.voicePeer == "" {
+		return
+	}
+	peerID, room := c.voicePeer, c.voiceRoom
+	c.voicePeer, c.voiceRoom = "", nil
+
+	removed, err := c.manager.db.RemoveVoiceParticipant(ctx, peerID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to leave voice room %d: %v", room.ID, err)
+		return
+	}
+	// Already pruned, and announced then
+	if !removed {
+		return
+	}
+
+	c.manager.BroadcastToHall(ctx, room.HallID, "voice_left", VoiceLeftData{
+		RoomID: room.ID,
+		PeerID: peerID,
+		UserID: c.session.UserID,
+	})
+}
+
+// pruneVoiceParticipants removes participants whose connection stopped
+// pinging, e.g. because the instance holding it died
+func (m *Manager) pruneVoiceParticipants() {
+	ctx, cancel := context.WithTimeout(context.Background(), voicePruneTimeout)
+	defer cancel()
+
+	stale, err := m.db.DeleteStaleVoiceParticipants(ctx, time.Now().Add(-HeartbeatTimeout))
+	if err != nil {
+		m.logger.Printf("Failed to prune voice participants: %v", err)
+	}
+
+	for _, participant := range stale {
+		room, err := m.db.GetRoomByID(ctx, participant.RoomID)
+		if err != nil {
+			continue
+		}
+		m.BroadcastToHall(ctx, room.HallID, "voice_left", VoiceLeftData{
+			RoomID: participant.RoomID,
+			PeerID: participant.PeerID,
+			UserID: participant.UserID,
+			Reason: "timeout",
+		})
+	}
+}
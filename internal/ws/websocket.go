@@ -0,0 +1,1674 @@
+// Package ws delivers events to clients live, over websockets or
+// server-sent events, takes the messages they send and shares broadcasts
+// with other instances through a Broker.
+package ws
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+)
+
+type Manager struct {
+	db           *store.Database
+	auth         *auth.Manager
+	automod      *Automod
+	spam         *SpamScorer
+	massMentions massMentionCooldowns
+	postLimiters postLimiters // per user, for PostMessage
+	typing       typingTracker
+	lastSeen     *LastSeenBuffer
+	stop         context.CancelFunc // stops the background work, see Close
+	stopped      chan struct{}      // closed once it has
+	writer       *store.MessageWriter
+	notifier     Notifier
+	events       EventSink
+	errors       ErrorSink
+	plugins      *Plugins
+	commands     map[string]Command
+	broker       Broker
+	upgrader     websocket.Upgrader
+	compression  bool
+	compressMin  int // frames shorter than this are sent uncompressed
+	maxFrame     int64
+	limits       atomic.Pointer[limits] // see Reconfigure
+	logger       *log.Logger
+	draining     atomic.Bool
+	clients      map[*Client]bool
+	rooms        map[int][]*Client
+	unregister   chan *Client
+	mutex        sync.RWMutex
+
+	// Numbering and publishing a room event happen under the room's stripe
+	// of these, so events go out in seq order; see BroadcastToRoom
+	sequencing [roomSequencingStripes]sync.Mutex
+
+	// Delivery counters, see DeliveryStats
+	broadcasts      atomic.Int64
+	framesQueued    atomic.Int64
+	framesDropped   atomic.Int64
+	slowDisconnects atomic.Int64
+	fanoutLatency   *LatencyHistogram
+}
+
+// Client is one connection receiving events: a websocket, or an event
+// stream (SSE) in which case conn is nil and stopStream ends it
+type Client struct {
+	conn       *websocket.Conn
+	stopStream func()
+	session    *auth.Session
+	send       chan []byte
+	manager    *Manager
+	rooms      map[int]bool
+	halls      map[int]bool // followed with join_hall; guarded by the manager's mutex
+	lastPing   time.Time
+	limiter    *RateLimiter
+	protocol   int // negotiated ws protocol version
+	codec      Codec
+	intents    Intents     // event categories the client asked for
+	lang       string      // what error messages are written in
+	slow       atomic.Bool // set once the client is being dropped for falling behind
+	ip         string
+	since      time.Time // when it connected
+	evicted    bool      // closed for a newer connection; guarded by the manager's mutex
+	voicePeer  string    // peer ID in the voice room the client is in, if any
+	voiceRoom  *store.Room
+	guest      bool // read-only visitor without an account, see guest.go
+	post       bool // never connected, sends one message for PostMessage
+
+	// resubscribe rejoins the rooms the device followed before it
+	// reconnected, unless it asked not to with ?resubscribe=false
+	resubscribe bool
+	device      string // what subscriptions are saved under, see clientDevice
+}
+
+// Per-client send_message flood protection: a sustained rate of
+// MessageLimit messages per MessageWindow, with bursts up to MessageBurst.
+const (
+	MessageLimit  = 10
+	MessageWindow = 10 * time.Second
+	MessageBurst  = 15
+)
+
+// wsQueryTimeout bounds the database work done for one incoming ws message
+const wsQueryTimeout = 5 * time.Second
+
+// Clients send a ping at least every wsHeartbeatInterval; connections quiet
+// for HeartbeatTimeout are closed.
+const (
+	wsHeartbeatInterval = 30 * time.Second
+	HeartbeatTimeout    = 60 * time.Second
+)
+
+// Frames over the configured limit are discarded and answered with an error.
+// Only ones over wsFrameHardLimit times the limit are too big to be worth
+// reading at all and close the connection.
+const wsFrameHardLimit = 4
+
+// errFrameTooLarge is returned by readFrame for frames over the limit
+var errFrameTooLarge = errors.New("frame too large")
+
+// Close codes telling clients why the server closed their connection
+const (
+	wsCloseUnsupportedVersion  = 4000
+	wsCloseUnsupportedEncoding = 4001
+	wsCloseTooManyConnections  = 4002 // over the per-account or per-IP limit
+	wsCloseEvicted             = 4003 // closed to make room for a newer connection
+	wsCloseDraining            = 4004 // the server is restarting, see Drain
+	wsCloseUnknownIntent       = 4005
+)
+
+// Room events are kept for RoomEventRetention so briefly disconnected
+// clients can resume; at most resumeMaxEvents are replayed per room.
+const (
+	RoomEventRetention = 24 * time.Hour
+	resumeMaxEvents    = 500
+)
+
+// roomSequencingStripes is how many locks rooms share to publish their
+// events in order
+const roomSequencingStripes = 64
+
+// maxNonceLength caps the client-generated nonce on send_message
+const maxNonceLength = 64
+
+// replayPollInterval is how often a replay waiting for room in a client's
+// send queue checks again
+const replayPollInterval = 10 * time.Millisecond
+
+// Options are the settings and limits NewManager applies to connections
+type Options struct {
+	Compression           bool
+	CompressionThreshold  int // frames shorter than this are sent uncompressed
+	MaxFrameBytes         int64
+	MaxMessageLength      int   // characters
+	MaxHallAttachments    int64 // bytes of image and file messages per hall, 0 is unlimited
+	MaxConnectionsPerUser int   // 0 is unlimited
+	MaxConnectionsPerIP   int
+	EvictOldest           bool        // over a limit, close the oldest connection instead of the new one
+	DefaultLanguage       string      // errors for clients that ask for no supported language
+	Logger                *log.Logger // nil logs to the standard logger
+	Events                EventSink   // nil if only clients hear about events
+	Errors                ErrorSink   // nil if errors are only logged
+	Plugins               *Plugins    // nil runs no plugins
+}
+
+// EventSink hears about the room and hall events broadcast from this
+// instance, e.g. to pass them on to webhooks. Events relayed from other
+// instances through the broker aren't included, so each is heard once. It's
+// called while events are being sent, so it mustn't block.
+type EventSink interface {
+	RoomEvent(roomID int, msgType string, data json.RawMessage)
+	HallEvent(hallID int, msgType string, data json.RawMessage)
+}
+
+// Notifier tells people about messages that mention them while
+// they're away. massMention is MentionEveryone or MentionHere when the
+// message may mention the whole hall.
+type Notifier interface {
+	MentionedUsers(ctx context.Context, room *store.Room, message *store.Message, massMention string) []int
+	NotifyMentions(ctx context.Context, room *store.Room, message *store.Message, mentioned []int)
+}
+
+func NewManager(db *store.Database, auth *auth.Manager, broker Broker, notifier Notifier, opts Options) *Manager {
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	manager := &Manager{
+		db:       db,
+		auth:     auth,
+		automod:  NewAutomod(db),
+		spam:     NewSpamScorer(db, DefaultSpamChecks()),
+		lastSeen: NewLastSeenBuffer(db, logger),
+		writer:   store.NewMessageWriter(db),
+		notifier: notifier,
+		events:   opts.Events,
+		errors:   opts.Errors,
+		plugins:  opts.Plugins,
+		broker:   broker,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow all origins for development
+			},
+			EnableCompression: opts.Compression,
+		},
+		compression: opts.Compression,
+		compressMin: opts.CompressionThreshold,
+		maxFrame:    opts.MaxFrameBytes,
+		logger:      logger,
+		clients:     make(map[*Client]bool),
+		rooms:       make(map[int][]*Client),
+		unregister:  make(chan *Client),
+
+		fanoutLatency: newLatencyHistogram(fanoutBuckets),
+	}
+
+	if manager.plugins == nil {
+		manager.plugins = NewPlugins(nil, logger)
+	}
+	manager.Reconfigure(opts)
+	manager.registerCommands()
+
+	broker.Subscribe(manager.deliver)
+	go manager.run()
+
+	ctx, stop := context.WithCancel(context.Background())
+	manager.stop = stop
+	manager.stopped = make(chan struct{})
+	go func() {
+		defer close(manager.stopped)
+		manager.lastSeen.Run(ctx)
+	}()
+	return manager
+}
+
+// Close stops the manager's background work, waits for the messages
+// already queued to be stored and writes out the last seen times still
+// buffered, giving up when ctx is done. Messages sent afterwards are turned
+// away; connections still open aren't closed.
+func (m *Manager) Close(ctx context.Context) error {
+	err := m.writer.Close(ctx)
+	m.stop()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-m.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limits are the Options Reconfigure can change while clients are connected
+type limits struct {
+	maxMessage  int   // characters
+	attachments int64 // bytes per hall, 0 is unlimited
+	maxPerUser  int   // connections, 0 is unlimited
+	maxPerIP    int
+	evictOldest bool // over the limit, close the oldest connection instead of the new one
+	language    string
+}
+
+// Reconfigure takes opts' message length, attachment and connection limits
+// and default language, leaving connections that are already open alone
+// even if a lowered limit now counts them as too many. The rest of opts is
+// only read by NewManager.
+func (m *Manager) Reconfigure(opts Options) {
+	m.limits.Store(&limits{
+		maxMessage:  opts.MaxMessageLength,
+		attachments: opts.MaxHallAttachments,
+		maxPerUser:  opts.MaxConnectionsPerUser,
+		maxPerIP:    opts.MaxConnectionsPerIP,
+		evictOldest: opts.EvictOldest,
+		language:    opts.DefaultLanguage,
+	})
+}
+
+func (m *Manager) run() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case client := <-m.unregister:
+			m.mutex.Lock()
+			if _, ok := m.clients[client]; ok {
+				delete(m.clients, client)
+				close(client.send)
+
+				// Remove client from all rooms
+				for roomID := range client.rooms {
+					m.removeClientFromRoom(client, roomID)
+				}
+			}
+			m.mutex.Unlock()
+			m.logger.Printf("Client disconnected: %s", client.session.Username)
+
+			if client.voicePeer != "" {
+				go func(client *Client) {
+					ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+					defer cancel()
+					client.leaveVoice(ctx)
+				}(client)
+			}
+
+		case <-ticker.C:
+			m.checkClientHealth()
+			m.spam.Prune()
+			m.automod.Prune()
+			m.massMentions.prune()
+			m.typing.prune()
+			m.postLimiters.prune()
+			go m.pruneVoiceParticipants()
+		}
+	}
+}
+
+func (m *Manager) checkClientHealth() {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for client := range m.clients {
+		if time.Since(client.lastPing) > HeartbeatTimeout {
+			client.disconnect()
+		}
+	}
+}
+
+func (m *Manager) addClientToRoom(client *Client, roomID int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.subscribe(client, roomID)
+}
+
+// subscribe adds a client to a room; the caller holds the mutex
+func (m *Manager) subscribe(client *Client, roomID int) {
+	if m.rooms[roomID] == nil {
+		m.rooms[roomID] = make([]*Client, 0)
+	}
+
+	// Check if client already in room
+	for _, c := range m.rooms[roomID] {
+		if c == client {
+			return
+		}
+	}
+
+	m.rooms[roomID] = append(m.rooms[roomID], client)
+	client.rooms[roomID] = true
+}
+
+func (m *Manager) removeClientFromRoom(client *Client, roomID int) {
+	if m.rooms[roomID] == nil {
+		return
+	}
+
+	for i, c := range m.rooms[roomID] {
+		if c == client {
+			m.rooms[roomID] = append(m.rooms[roomID][:i], m.rooms[roomID][i+1:]...)
+			break
+		}
+	}
+
+	delete(client.rooms, roomID)
+}
+
+// BroadcastToRoom sends an event to everyone in a room. The event is stored
+// under the room's next sequence number first, so clients that miss it can
+// get it back with resume. It returns the sequence number, 0 if storing
+// failed.
+//
+// Events of one room are numbered and published one at a time, so this
+// instance delivers them in seq order and a client seeing a seq skipped
+// knows it missed something. Events published by other instances can still
+// interleave, out of order by a few.
+func (m *Manager) BroadcastToRoom(roomID int, msgType string, data interface{}) int64 {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		m.logger.Printf("Failed to marshal broadcast message: %v", err)
+		return 0
+	}
+
+	message := WSMessage{
+		Type:   msgType,
+		RoomID: roomID,
+		Data:   json.RawMessage(payload),
+	}
+
+	lock := &m.sequencing[roomID%roomSequencingStripes]
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Still deliver live if storing fails, just without a sequence number
+	ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+	defer cancel()
+	if seq, err := m.db.AppendRoomEvent(ctx, roomID, msgType, payload); err != nil {
+		m.logger.Printf("Failed to store event for room %d: %v", roomID, err)
+	} else {
+		message.Seq = seq
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		m.logger.Printf("Failed to marshal broadcast message: %v", err)
+		return 0
+	}
+
+	m.publish(BrokerMessage{RoomID: roomID, Type: msgType, Payload: jsonData})
+	if m.events != nil {
+		m.events.RoomEvent(roomID, msgType, payload)
+	}
+	return message.Seq
+}
+
+// SendToUser delivers an event to every connection of a user, regardless of
+// which rooms they have joined.
+func (m *Manager) SendToUser(userID int, msgType string, data interface{}) {
+	jsonData, err := json.Marshal(WSMessage{Type: msgType, Data: data})
+	if err != nil {
+		m.logger.Printf("Failed to marshal user message: %v", err)
+		return
+	}
+
+	m.publish(BrokerMessage{UserID: userID, Type: msgType, Payload: jsonData})
+}
+
+// SendToUsers is SendToUser for several users at once
+func (m *Manager) SendToUsers(userIDs []int, msgType string, data interface{}) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	jsonData, err := json.Marshal(WSMessage{Type: msgType, Data: data})
+	if err != nil {
+		m.logger.Printf("Failed to marshal user message: %v", err)
+		return
+	}
+
+	m.publish(BrokerMessage{UserIDs: userIDs, Type: msgType, Payload: jsonData})
+}
+
+// BroadcastToHall delivers an event to every connection of every member of a
+// hall, whether or not they joined any of its rooms. extraUserIDs also get it,
+// e.g. someone who just left. Hall events aren't stored, so they can't be
+// resumed.
+func (m *Manager) BroadcastToHall(ctx context.Context, hallID int, msgType string, data interface{}, extraUserIDs ...int) {
+	if m.events != nil {
+		if payload, err := json.Marshal(data); err == nil {
+			m.events.HallEvent(hallID, msgType, payload)
+		}
+	}
+
+	members, err := m.db.GetHallMembers(ctx, hallID)
+	if err != nil {
+		m.logger.Printf("Failed to fetch members of hall %d: %v", hallID, err)
+		return
+	}
+
+	userIDs := append([]int(nil), extraUserIDs...)
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	jsonData, err := json.Marshal(WSMessage{Type: msgType, Data: data})
+	if err != nil {
+		m.logger.Printf("Failed to marshal hall message: %v", err)
+		return
+	}
+
+	m.publish(BrokerMessage{UserIDs: userIDs, Type: msgType, Payload: jsonData})
+}
+
+func (m *Manager) publish(msg BrokerMessage) {
+	if err := m.broker.Publish(msg); err != nil {
+		m.logger.Printf("Failed to publish broadcast: %v", err)
+		m.captureError(ErrorReport{Err: fmt.Errorf("publishing broadcast: %w", err), Source: "ws"})
+	}
+}
+
+// deliver hands a message from the broker to this instance's clients
+func (m *Manager) deliver(msg BrokerMessage) {
+	start := time.Now()
+	defer func() {
+		m.broadcasts.Add(1)
+		m.fanoutLatency.Observe(time.Since(start))
+	}()
+
+	if msg.RoomID != 0 {
+		if msg.Type == "typing" {
+			m.observeTyping(msg.Payload)
+		}
+		m.sendToLocalRoom(msg.RoomID, msg.Type, msg.Payload)
+		return
+	}
+	if len(msg.UserIDs) > 0 {
+		switch msg.Type {
+		case "room_created":
+			m.observeRoomCreated(msg.UserIDs, msg.Payload)
+		case "user_renamed":
+			m.observeUserRenamed(msg.Payload)
+		}
+		m.sendToLocalUsers(msg.UserIDs, msg.Type, msg.Payload)
+		return
+	}
+	if msg.Type == sessionsRevoked {
+		// Only for instances, not clients
+		m.observeSessionsRevoked(msg.UserID, msg.Payload)
+		return
+	}
+	m.sendToLocalUsers([]int{msg.UserID}, msg.Type, msg.Payload)
+}
+
+// sendToLocalRoom and sendToLocalUsers hold the read lock while queueing, so
+// unregister can't close a client's queue in the middle. Clients without the
+// intent for msgType are skipped.
+func (m *Manager) sendToLocalRoom(roomID int, msgType string, jsonData []byte) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, client := range m.rooms[roomID] {
+		if client.intents.wants(msgType) {
+			client.enqueue(jsonData)
+		}
+	}
+}
+
+func (m *Manager) sendToLocalUsers(userIDs []int, msgType string, jsonData []byte) {
+	recipients := make(map[int]bool, len(userIDs))
+	for _, userID := range userIDs {
+		recipients[userID] = true
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for client := range m.clients {
+		if client.guest || !recipients[client.session.UserID] || !client.intents.wants(msgType) {
+			continue
+		}
+		client.enqueue(jsonData)
+	}
+}
+
+// ClientCount returns how many websocket connections are open
+func (m *Manager) ClientCount() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.clients)
+}
+
+// DeliveryStats counts broadcasts and the frames queued for them, frames
+// dropped because a client's send queue was full, and the slow clients
+// disconnected for it, since startup
+func (m *Manager) DeliveryStats() DeliveryStats {
+	return DeliveryStats{
+		Broadcasts:      m.broadcasts.Load(),
+		FramesQueued:    m.framesQueued.Load(),
+		FramesDropped:   m.framesDropped.Load(),
+		SlowDisconnects: m.slowDisconnects.Load(),
+	}
+}
+
+// DisconnectSessions closes every connection, on any instance, authenticated
+// with one of the user's given sessions, e.g. after they were revoked.
+func (m *Manager) DisconnectSessions(userID int, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	jsonData, err := json.Marshal(WSMessage{Type: sessionsRevoked, Data: SessionsRevokedData{SessionIDs: sessionIDs}})
+	if err != nil {
+		m.logger.Printf("Failed to marshal revoked sessions: %v", err)
+		return
+	}
+	if err := m.broker.Publish(BrokerMessage{UserID: userID, Type: sessionsRevoked, Payload: jsonData}); err != nil {
+		// The sessions are gone, so at least nothing here keeps using them
+		m.logger.Printf("Failed to publish revoked sessions: %v", err)
+		m.captureError(ErrorReport{Err: fmt.Errorf("publishing revoked sessions: %w", err), Source: "ws"})
+		m.disconnectLocalSessions(userID, sessionIDs)
+	}
+}
+
+// observeSessionsRevoked closes this instance's connections using sessions
+// another, or this, instance revoked
+func (m *Manager) observeSessionsRevoked(userID int, payload []byte) {
+	var message struct {
+		Data SessionsRevokedData `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		m.logger.Printf("Invalid sessions_revoked event: %v", err)
+		return
+	}
+	m.disconnectLocalSessions(userID, message.Data.SessionIDs)
+}
+
+func (m *Manager) disconnectLocalSessions(userID int, sessionIDs []string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for client := range m.clients {
+		if !client.guest && client.session.UserID == userID && revoked[client.session.ID] {
+			client.disconnect()
+		}
+	}
+}
+
+// observeUserRenamed updates the username on this instance's connections of
+// a user who renamed themselves
+func (m *Manager) observeUserRenamed(payload []byte) {
+	var message struct {
+		Data UserRenamedData `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		m.logger.Printf("Invalid user_renamed event: %v", err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for client := range m.clients {
+		if !client.guest && client.session.UserID == message.Data.UserID {
+			client.session.Username = message.Data.Username
+		}
+	}
+}
+
+// admit registers a client unless that puts its account or IP over the
+// connection limit. In evict mode it closes the oldest connections to make
+// room instead, so it always succeeds.
+func (m *Manager) admit(client *Client) bool {
+	var evicted []*Client
+
+	m.mutex.Lock()
+	for {
+		oldest, over := m.overConnectionLimit(client)
+		if !over {
+			break
+		}
+		if !m.limits.Load().evictOldest {
+			m.mutex.Unlock()
+			m.logger.Printf("Rejecting connection of %s from %s: too many connections", client.session.Username, client.ip)
+			return false
+		}
+		oldest.evicted = true
+		evicted = append(evicted, oldest)
+	}
+	m.clients[client] = true
+	m.mutex.Unlock()
+
+	m.logger.Printf("Client connected: %s", client.session.Username)
+
+	// Closing writes to the connection, so not while holding the lock
+	for _, c := range evicted {
+		m.logger.Printf("Evicting oldest connection of %s from %s", c.session.Username, c.ip)
+		c.disconnectWithCode(wsCloseEvicted, "replaced by a newer connection")
+	}
+	return true
+}
+
+// overConnectionLimit reports whether one more connection would put client's
+// account or IP over the limit, and if so which connection counting against
+// it is the oldest. The caller holds the lock.
+func (m *Manager) overConnectionLimit(client *Client) (*Client, bool) {
+	var userCount, ipCount int
+	var oldestOfUser, oldestOfIP *Client
+	for c := range m.clients {
+		if c.evicted {
+			continue
+		}
+		// Guests have no account to count against
+		if !client.guest && !c.guest && c.session.UserID == client.session.UserID {
+			userCount++
+			if oldestOfUser == nil || c.since.Before(oldestOfUser.since) {
+				oldestOfUser = c
+			}
+		}
+		if c.ip == client.ip {
+			ipCount++
+			if oldestOfIP == nil || c.since.Before(oldestOfIP.since) {
+				oldestOfIP = c
+			}
+		}
+	}
+
+	limits := m.limits.Load()
+	if limits.maxPerUser > 0 && userCount >= limits.maxPerUser {
+		return oldestOfUser, true
+	}
+	if limits.maxPerIP > 0 && ipCount >= limits.maxPerIP {
+		return oldestOfIP, true
+	}
+	return nil, false
+}
+
+func (m *Manager) HandleConnection(w http.ResponseWriter, r *http.Request, session *auth.Session, guest bool) {
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		m.logger.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	// Browsers can't see why an upgrade was refused, so incompatible
+	// clients are told with a close code instead
+	protocol, ok := negotiateWSVersion(r.URL.Query().Get("v"))
+	if !ok {
+		reason := fmt.Sprintf("unsupported protocol version, server speaks %d-%d", wsMinVersion, wsVersion)
+		closeWithCode(conn, wsCloseUnsupportedVersion, reason)
+		return
+	}
+
+	codec, ok := wsCodecFor(r.URL.Query().Get("encoding"))
+	if !ok {
+		reason := "unsupported encoding, server speaks " + strings.Join(CodecNames, ", ")
+		closeWithCode(conn, wsCloseUnsupportedEncoding, reason)
+		return
+	}
+
+	intents, err := parseIntents(r.URL.Query().Get("intents"))
+	if err != nil {
+		reason := err.Error() + ", server knows " + strings.Join(IntentNames, ", ")
+		closeWithCode(conn, wsCloseUnknownIntent, reason)
+		return
+	}
+
+	if m.Draining() {
+		closeWithCode(conn, wsCloseDraining, "server is restarting")
+		return
+	}
+
+	client := &Client{
+		conn:     conn,
+		session:  session,
+		send:     make(chan []byte, 256),
+		manager:  m,
+		rooms:    make(map[int]bool),
+		lastPing: time.Now(),
+		limiter:  NewRateLimiter(MessageLimit, MessageWindow, MessageBurst),
+		protocol: protocol,
+		codec:    codec,
+		intents:  intents,
+		lang:     m.clientLanguage(r),
+		ip:       api.ClientIP(r),
+		since:    time.Now(),
+		guest:    guest,
+
+		resubscribe: r.URL.Query().Get("resubscribe") != "false",
+		device:      clientDevice(r, session),
+	}
+
+	// Queued before registering so it's always the first frame
+	client.sendEvent(WSMessage{Type: "hello", Data: m.newHello(session, protocol, codec.Name(), intents, client.lang, guest)})
+
+	if !m.admit(client) {
+		closeWithCode(conn, wsCloseTooManyConnections, "too many connections")
+		return
+	}
+
+	// Online from now on, as far as email notifications go
+	if !guest {
+		m.lastSeen.Touch(session.UserID)
+	}
+
+	// Start goroutines for handling the client
+	go client.writePump()
+	go client.readPump()
+}
+
+// clientLanguage is the language a connection asked for with ?lang=, else
+// the one its Accept-Language likes best
+func (m *Manager) clientLanguage(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); i18n.Supported(lang) {
+		return lang
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"), m.limits.Load().language)
+}
+
+func (m *Manager) newHello(session *auth.Session, protocol int, encoding string, intents Intents, lang string, guest bool) HelloData {
+	return HelloData{
+		ProtocolVersion:     protocol,
+		Encoding:            encoding,
+		Intents:             intents.Names(),
+		Language:            lang,
+		SupportedVersions:   Versions(),
+		HeartbeatIntervalMs: wsHeartbeatInterval.Milliseconds(),
+		HeartbeatTimeoutMs:  HeartbeatTimeout.Milliseconds(),
+		MaxFrameBytes:       m.maxFrame,
+		Session: HelloSession{
+			ID:        session.ID,
+			UserID:    session.UserID,
+			Username:  session.Username,
+			ExpiresAt: session.ExpiresAt,
+			Guest:     guest,
+			Scopes:    session.Scopes,
+		},
+	}
+}
+
+// disconnect drops the client, whichever transport it's on
+func (c *Client) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		return
+	}
+	c.stopStream()
+}
+
+// disconnectWithCode is disconnect with a close code saying why. Event
+// streams have no close codes and just end.
+func (c *Client) disconnectWithCode(code int, reason string) {
+	if c.conn != nil {
+		closeWithCode(c.conn, code, reason)
+		return
+	}
+	c.stopStream()
+}
+
+// closeWithCode closes a connection that was just upgraded, telling the
+// client why
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	conn.Close()
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.saveSubscriptions()
+		c.manager.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(c.manager.maxFrame * wsFrameHardLimit)
+	c.conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.lastPing = time.Now()
+		c.conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+		return nil
+	})
+
+	if c.resubscribe {
+		c.restoreSubscriptions()
+	}
+
+	for {
+		messageBytes, err := c.readFrame()
+		if err == errFrameTooLarge {
+			c.sendError(WSErrorData{
+				Code:    "message_too_long",
+				Message: fmt.Sprintf("Frames are limited to %d bytes", c.manager.maxFrame),
+			})
+			continue
+		}
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.manager.logger.Printf("WebSocket error: %v", err)
+			}
+			break
+		}
+
+		msg, err := c.codec.Decode(messageBytes)
+		if err != nil {
+			c.manager.logger.Printf("Invalid %s from client: %v", c.codec.Name(), err)
+			continue
+		}
+
+		c.handleMessage(msg)
+	}
+}
+
+// readFrame reads the next frame. Frames over the limit are read to the end
+// and thrown away, so the connection can carry on with the next one.
+func (c *Client) readFrame() ([]byte, error) {
+	_, reader, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := io.ReadAll(io.LimitReader(reader, c.manager.maxFrame+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(frame)) > c.manager.maxFrame {
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return nil, err
+		}
+		return nil, errFrameTooLarge
+	}
+	return frame, nil
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(54 * time.Second)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			frame, err := c.codec.Encode(message)
+			if err != nil {
+				c.manager.logger.Printf("Failed to encode %s frame: %v", c.codec.Name(), err)
+				continue
+			}
+
+			// Only has an effect if the client negotiated permessage-deflate
+			if c.manager.compression {
+				c.conn.EnableWriteCompression(len(frame) >= c.manager.compressMin)
+			}
+			c.conn.WriteMessage(c.codec.FrameType(), frame)
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) handleMessage(msg WSMessage) {
+	// Bound the database work for each message so a slow query can't stall
+	// the read loop
+	ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+	defer cancel()
+
+	// A bug in one handler shouldn't take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			c.manager.logger.Printf("Handling %s from %s panicked: %v", msg.Type, c.session.Username, r)
+			c.captureError(ErrorReport{
+				Err:   fmt.Errorf("panic: %v", r),
+				Stack: PanicStack(),
+				Tags:  map[string]string{"ws_message": msg.Type},
+			})
+		}
+	}()
+
+	if c.guest && !guestMessageTypes[msg.Type] {
+		c.sendError(WSErrorData{Code: "guest_read_only", Message: "Register to do that"})
+		return
+	}
+	if scope := wsMessageScopes[msg.Type]; !c.session.HasScope(scope) {
+		c.sendError(WSErrorData{Code: api.ErrCodeInsufficientScope, Message: "This token needs the " + scope + " scope"})
+		return
+	}
+
+	switch msg.Type {
+	case "join_room":
+		c.handleJoinRoom(ctx, msg.Data)
+	case "leave_room":
+		c.handleLeaveRoom(ctx, msg.Data)
+	case "join_hall":
+		c.handleJoinHall(ctx, msg.Data)
+	case "leave_hall":
+		c.handleLeaveHall(ctx, msg.Data)
+	case "send_message":
+		c.handleSendMessage(ctx, msg.Data)
+	case "add_reaction":
+		c.handleReaction(ctx, msg.Data, true)
+	case "remove_reaction":
+		c.handleReaction(ctx, msg.Data, false)
+	case "resume":
+		c.handleResume(ctx, msg.Data)
+	case "voice_join":
+		c.handleVoiceJoin(ctx, msg.Data)
+	case "voice_leave":
+		c.leaveVoice(ctx)
+	case "voice_signal":
+		c.handleVoiceSignal(ctx, msg.Data)
+	case "typing":
+		c.handleTyping(msg.Data)
+	case "ping":
+		c.lastPing = time.Now()
+		if !c.guest {
+			c.manager.lastSeen.Touch(c.session.UserID)
+		}
+		if c.voicePeer != "" {
+			c.manager.db.TouchVoiceParticipant(ctx, c.voicePeer)
+		}
+	default:
+		c.manager.logger.Printf("Unknown message type: %s", msg.Type)
+	}
+}
+
+// sendError reports a failed client action with a structured error event
+func (c *Client) sendError(data WSErrorData) {
+	data.Message = i18n.Error(c.lang, data.Code, data.Message)
+	c.sendEvent(WSMessage{Type: "error", Data: data})
+}
+
+// sendEvent queues an event for this client only
+func (c *Client) sendEvent(message WSMessage) {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		c.manager.logger.Printf("Failed to marshal %s event: %v", message.Type, err)
+		return
+	}
+
+	c.enqueue(jsonData)
+}
+
+// sendEventAsync is sendEvent for goroutines other than the client's own
+// reader. The event is dropped if the client has already disconnected.
+func (c *Client) sendEventAsync(message WSMessage) {
+	c.manager.mutex.RLock()
+	defer c.manager.mutex.RUnlock()
+
+	if c.manager.clients[c] || c.post {
+		c.sendEvent(message)
+	}
+}
+
+// enqueue hands a frame to the client's writer without ever blocking the
+// sender. A client whose queue is full has fallen too far behind to catch up
+// live, so the frame is dropped and the client disconnected; it can come
+// back with resume and miss nothing. Callers other than the client's own
+// reader must hold the manager's read lock.
+func (c *Client) enqueue(frame []byte) {
+	select {
+	case c.send <- frame:
+		c.manager.framesQueued.Add(1)
+		return
+	default:
+	}
+
+	c.manager.framesDropped.Add(1)
+	if c.slow.CompareAndSwap(false, true) {
+		c.manager.slowDisconnects.Add(1)
+		c.manager.logger.Printf("Disconnecting slow client %s", c.session.Username)
+		c.disconnect()
+	}
+}
+
+// enqueueReplay queues a replayed event, waiting for room rather than
+// dropping it. It only fills the queue halfway, so live events arriving
+// meanwhile don't find it full and get the client dropped.
+func (c *Client) enqueueReplay(ctx context.Context, frame []byte) bool {
+	ticker := time.NewTicker(replayPollInterval)
+	defer ticker.Stop()
+
+	for len(c.send) >= cap(c.send)/2 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	c.enqueue(frame)
+	return true
+}
+
+// handleResume rejoins rooms after a reconnect and replays the events the
+// client missed since the sequence numbers it last saw. Live events can
+// arrive while the replay is still going, so clients should skip any seq
+// they already have.
+func (c *Client) handleResume(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var resumeData ResumeData
+	if err := json.Unmarshal(jsonData, &resumeData); err != nil {
+		c.manager.logger.Printf("Invalid resume data: %v", err)
+		return
+	}
+
+	for _, position := range resumeData.Rooms {
+		room, err := c.manager.db.GetRoomByID(ctx, position.RoomID)
+		if err != nil {
+			c.sendEvent(WSMessage{Type: "resync_required", Data: ResumedData{RoomID: position.RoomID}})
+			continue
+		}
+
+		if !c.canRead(ctx, room) {
+			c.manager.logger.Printf("User %s denied access to hall %d", c.session.Username, room.HallID)
+			c.sendEvent(WSMessage{Type: "resync_required", Data: ResumedData{RoomID: room.ID}})
+			continue
+		}
+
+		// Join before reading so nothing falls between the replay and live
+		// delivery
+		c.manager.addClientToRoom(c, room.ID)
+
+		latest, err := c.manager.db.GetRoomSeq(ctx, room.ID)
+		if err != nil {
+			c.manager.logger.Printf("Failed to read sequence of room %d: %v", room.ID, err)
+			c.sendEvent(WSMessage{Type: "resync_required", Data: ResumedData{RoomID: room.ID}})
+			continue
+		}
+
+		events, err := c.manager.db.GetRoomEventsSince(ctx, room.ID, position.Seq, resumeMaxEvents+1)
+		if err != nil {
+			c.manager.logger.Printf("Failed to load events of room %d: %v", room.ID, err)
+			c.sendEvent(WSMessage{Type: "resync_required", Data: ResumedData{RoomID: room.ID, Seq: latest}})
+			continue
+		}
+
+		// Too far behind, or the events it needs were already pruned: the
+		// client has to refetch over REST
+		pruned := position.Seq < latest && (len(events) == 0 || events[0].Seq != position.Seq+1)
+		if len(events) > resumeMaxEvents || pruned || position.Seq > latest {
+			c.sendEvent(WSMessage{Type: "resync_required", Data: ResumedData{RoomID: room.ID, Seq: latest}})
+			continue
+		}
+
+		// Replays can be bigger than the send buffer, so wait for room in it
+		// rather than dropping events
+		replayed := 0
+		for _, event := range events {
+			if !c.intents.wants(event.Type) {
+				continue
+			}
+			jsonData, err := json.Marshal(WSMessage{Type: event.Type, Seq: event.Seq, RoomID: event.RoomID, Data: event.Payload})
+			if err != nil {
+				c.manager.logger.Printf("Failed to marshal replayed event: %v", err)
+				return
+			}
+			if !c.enqueueReplay(ctx, jsonData) {
+				c.manager.logger.Printf("Gave up replaying room %d to slow client %s", room.ID, c.session.Username)
+				return
+			}
+			replayed++
+		}
+		c.sendEvent(WSMessage{Type: "resumed", Data: ResumedData{RoomID: room.ID, Seq: latest, Replayed: replayed}})
+	}
+}
+
+func (c *Client) handleJoinRoom(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var joinData JoinRoomData
+	if err := json.Unmarshal(jsonData, &joinData); err != nil {
+		c.manager.logger.Printf("Invalid join_room data: %v", err)
+		return
+	}
+
+	// Verify room exists in hall
+	room, err := c.manager.db.GetRoomByID(ctx, joinData.RoomID)
+	if err != nil || room.HallID != joinData.HallID {
+		c.manager.logger.Printf("Room %d not found in hall %d", joinData.RoomID, joinData.HallID)
+		return
+	}
+
+	// Verify user is member of hall, or a guest in a public room
+	if !c.canRead(ctx, room) {
+		c.manager.logger.Printf("User %s denied access to hall %d", c.session.Username, joinData.HallID)
+		return
+	}
+
+	c.manager.addClientToRoom(c, joinData.RoomID)
+	c.manager.logger.Printf("User %s joined room %d", c.session.Username, joinData.RoomID)
+}
+
+func (c *Client) handleLeaveRoom(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var roomData struct {
+		RoomID int `json:"room_id"`
+	}
+	if err := json.Unmarshal(jsonData, &roomData); err != nil {
+		c.manager.logger.Printf("Invalid leave_room data: %v", err)
+		return
+	}
+
+	c.manager.mutex.Lock()
+	c.manager.removeClientFromRoom(c, roomData.RoomID)
+	c.manager.mutex.Unlock()
+
+	if c.voiceRoom != nil && c.voiceRoom.ID == roomData.RoomID {
+		c.leaveVoice(ctx)
+	}
+
+	c.manager.logger.Printf("User %s left room %d", c.session.Username, roomData.RoomID)
+}
+
+func (c *Client) handleReaction(ctx context.Context, data interface{}, add bool) {
+	jsonData, _ := json.Marshal(data)
+	var reactionData struct {
+		MessageID int    `json:"message_id"`
+		Emoji     string `json:"emoji"`
+	}
+	if err := json.Unmarshal(jsonData, &reactionData); err != nil {
+		c.manager.logger.Printf("Invalid reaction data: %v", err)
+		return
+	}
+
+	if reactionData.Emoji == "" || len(reactionData.Emoji) > 32 {
+		return
+	}
+
+	message, err := c.manager.db.GetMessageByID(ctx, reactionData.MessageID)
+	if err != nil {
+		c.manager.logger.Printf("Message %d not found for reaction", reactionData.MessageID)
+		return
+	}
+
+	//only members currently in the room can react
+	if !c.inRoom(message.RoomID) {
+		c.manager.logger.Printf("User %s not in room %d", c.session.Username, message.RoomID)
+		return
+	}
+
+	var changed bool
+	eventType := "reaction_added"
+	if add {
+		changed, err = c.manager.db.AddReaction(ctx, message.ID, c.session.UserID, reactionData.Emoji)
+	} else {
+		eventType = "reaction_removed"
+		changed, err = c.manager.db.RemoveReaction(ctx, message.ID, c.session.UserID, reactionData.Emoji)
+	}
+	if err != nil {
+		c.manager.logger.Printf("Failed to update reaction: %v", err)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	c.manager.BroadcastToRoom(message.RoomID, eventType, ReactionData{
+		MessageID: message.ID,
+		RoomID:    message.RoomID,
+		UserID:    c.session.UserID,
+		Emoji:     reactionData.Emoji,
+	})
+}
+
+func (c *Client) handleSendMessage(ctx context.Context, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	var sendData SendMessageData
+	if err := json.Unmarshal(jsonData, &sendData); err != nil {
+		c.manager.logger.Printf("Invalid send_message data: %v", err)
+		return
+	}
+
+	c.sendMessage(ctx, sendData)
+}
+
+// attachmentFits checks an image or file message against its hall's
+// attachment quota, telling the client if it doesn't fit. While there's a
+// quota attachments have to give their size.
+func (c *Client) attachmentFits(ctx context.Context, room *store.Room, kind string, payload json.RawMessage, nonce string) bool {
+	limit := c.manager.limits.Load().attachments
+	if limit <= 0 || (kind != store.MessageKindImage && kind != store.MessageKindFile) {
+		return true
+	}
+
+	size := store.AttachmentSize(kind, payload)
+	if size == 0 {
+		c.sendError(WSErrorData{
+			Nonce:   nonce,
+			Code:    "invalid_payload",
+			Message: "size is required for attachments",
+		})
+		return false
+	}
+
+	used, err := c.manager.db.GetHallAttachmentBytes(ctx, room.HallID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to add up attachments in hall %d: %v", room.HallID, err)
+		c.sendError(WSErrorData{
+			Nonce:   nonce,
+			Code:    api.ErrCodeInternal,
+			Message: "Failed to save the message, try again",
+		})
+		return false
+	}
+	if used+size > limit {
+		c.sendError(WSErrorData{
+			Nonce:   nonce,
+			Code:    api.ErrCodeQuotaExceeded,
+			Message: fmt.Sprintf("This hall's attachments are limited to %d bytes", limit),
+		})
+		return false
+	}
+	return true
+}
+
+// sendMessage runs a message through commands, plugins, automod and the
+// spam and mention checks, and queues it to be stored. It returns true once
+// it's queued, when the ack (or an error) follows from messageSaved; errors
+// and command replies before then go to the client right away.
+func (c *Client) sendMessage(ctx context.Context, sendData SendMessageData) bool {
+	// Only text needs content, other kinds can go without a fallback
+	if sendData.Content == "" && (sendData.Kind == "" || sendData.Kind == store.MessageKindText) {
+		return false
+	}
+
+	if len(sendData.Nonce) > maxNonceLength {
+		c.sendError(WSErrorData{
+			Code:    "invalid_nonce",
+			Message: fmt.Sprintf("Nonces are limited to %d bytes", maxNonceLength),
+		})
+		return false
+	}
+
+	// A retry of something already stored just gets its ack again
+	if sendData.Nonce != "" && c.ackDuplicate(ctx, sendData.Nonce) {
+		return false
+	}
+
+	maxMessage := c.manager.limits.Load().maxMessage
+	if MessageTooLong(sendData.Content, maxMessage) {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "message_too_long",
+			Message: fmt.Sprintf("Messages are limited to %d characters", maxMessage),
+		})
+		return false
+	}
+
+	if !store.ValidMessageTTL(sendData.TTL) {
+		c.sendError(WSErrorData{
+			Nonce: sendData.Nonce,
+			Code:  "invalid_ttl",
+			Message: fmt.Sprintf("ttl_seconds must be between %d and %d",
+				int(store.MinMessageTTL.Seconds()), int(store.MaxMessageTTL.Seconds())),
+		})
+		return false
+	}
+
+	kind, payload, err := store.ValidateMessageKind(sendData.Kind, sendData.Payload)
+	if err != nil {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "invalid_payload",
+			Message: err.Error(),
+		})
+		return false
+	}
+
+	if err := store.ValidateComponents(sendData.Components); err != nil {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "invalid_components",
+			Message: err.Error(),
+		})
+		return false
+	}
+
+	if ok, wait := c.limiter.Allow(); !ok {
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "rate_limited",
+			Message:      "You are sending messages too quickly",
+			RetryAfterMs: wait.Milliseconds(),
+		})
+		return false
+	}
+
+	//verify user is in the room
+	if !c.inRoom(sendData.RoomID) {
+		c.manager.logger.Printf("User %s not in room %d", c.session.Username, sendData.RoomID)
+		return false
+	}
+
+	room, err := c.manager.db.GetRoomByID(ctx, sendData.RoomID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to load room %d: %v", sendData.RoomID, err)
+		return false
+	}
+
+	if room.Archived {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "room_archived",
+			Message: "This room is archived",
+		})
+		return false
+	}
+
+	if !c.attachmentFits(ctx, room, kind, payload, sendData.Nonce) {
+		return false
+	}
+
+	//slash commands run instead of being sent, unless they send something;
+	//only text messages can be commands
+	messageType := store.MessageTypeUser
+	name, args, isCommand := parseCommand(sendData.Content)
+	if isCommand && kind == store.MessageKindText {
+		content, commandType, send := c.runCommand(ctx, room, sendData, name, args)
+		if !send {
+			return false
+		}
+		if MessageTooLong(content, maxMessage) {
+			c.sendError(WSErrorData{
+				Nonce:   sendData.Nonce,
+				Code:    "message_too_long",
+				Message: fmt.Sprintf("Messages are limited to %d characters", maxMessage),
+			})
+			return false
+		}
+		sendData.Content, messageType = content, commandType
+	} else if kind == store.MessageKindText && strings.HasPrefix(sendData.Content, "//") {
+		sendData.Content = sendData.Content[1:]
+	}
+
+	//let plugins change or turn the message away
+	pluginMessage := PluginMessage{
+		HallID:   room.HallID,
+		RoomID:   room.ID,
+		UserID:   c.session.UserID,
+		Username: c.session.Username,
+		Content:  sendData.Content,
+		Kind:     kind,
+	}
+	if err := c.manager.plugins.MessagePre(ctx, &pluginMessage); err != nil {
+		c.sendError(WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    "plugin_rejected",
+			Message: rejectedMessage(err),
+		})
+		return false
+	}
+	if pluginMessage.Content != sendData.Content {
+		if pluginMessage.Content == "" && kind == store.MessageKindText {
+			return false
+		}
+		if MessageTooLong(pluginMessage.Content, maxMessage) {
+			c.sendError(WSErrorData{
+				Nonce:   sendData.Nonce,
+				Code:    "message_too_long",
+				Message: fmt.Sprintf("Messages are limited to %d characters", maxMessage),
+			})
+			return false
+		}
+		sendData.Content = pluginMessage.Content
+	}
+
+	//run the hall's automod rules before anything is stored
+	rule, err := c.manager.automod.Check(ctx, room.HallID, sendData.Content)
+	if err != nil {
+		c.manager.logger.Printf("Automod check failed for hall %d: %v", room.HallID, err)
+	}
+	if rule != nil && rule.Action != store.AutomodActionFlag {
+		details := fmt.Sprintf("rule %d in room %d: %q", rule.ID, room.ID, automodExcerpt(sendData.Content))
+		if err := c.manager.db.AddAuditLog(ctx, room.HallID, 0, "automod_"+rule.Action, "user", c.session.UserID, details); err != nil {
+			c.manager.logger.Printf("Failed to write audit log: %v", err)
+		}
+		if rule.Action == store.AutomodActionReject {
+			c.sendError(WSErrorData{
+				Nonce:   sendData.Nonce,
+				Code:    "automod_rejected",
+				Message: "Your message contains blocked content",
+			})
+		}
+		return false
+	}
+
+	//then the spam heuristics, in halls that set thresholds
+	spamAction, verdict := c.checkSpam(ctx, room, sendData)
+	if spamAction == store.SpamActionThrottle || spamAction == store.SpamActionDelete {
+		return false
+	}
+
+	massMention, ok := c.checkMassMention(ctx, room, sendData)
+	if !ok {
+		return false
+	}
+
+	//queue it for the room's writer, which finishes up once it's committed
+	write := store.MessageWrite{
+		RoomID:     sendData.RoomID,
+		UserID:     c.session.UserID,
+		Content:    sendData.Content,
+		Type:       messageType,
+		Kind:       kind,
+		Payload:    payload,
+		Nonce:      sendData.Nonce,
+		ExpiresAt:  store.MessageExpiry(room, sendData.TTL),
+		Components: sendData.Components,
+	}
+	err = c.manager.writer.Submit(write, func(message *store.Message, err error) {
+		c.messageSaved(room, sendData, rule, spamAction, verdict, massMention, message, err)
+	})
+	if err == store.ErrWriteQueueFull {
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "server_busy",
+			Message:      "Too many messages are waiting to be saved in this room",
+			RetryAfterMs: time.Second.Milliseconds(),
+		})
+	}
+	if err == store.ErrWriterClosed {
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "server_busy",
+			Message:      "This instance is shutting down, send the message again after reconnecting",
+			RetryAfterMs: time.Second.Milliseconds(),
+		})
+	}
+	return err == nil
+}
+
+// messageSaved finishes sending a message once the room's writer has stored
+// it: flags, the broadcast, mentions and the sender's ack. It runs on the
+// writer's goroutine, after the connection may have gone.
+func (c *Client) messageSaved(room *store.Room, sendData SendMessageData, rule *store.AutomodRule, spamAction string, verdict SpamVerdict, massMention string, message *store.Message, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wsQueryTimeout)
+	defer cancel()
+
+	if err != nil {
+		// Lost a race with a concurrent retry of the same message
+		if sendData.Nonce != "" {
+			if ack, ok := c.duplicateAck(ctx, sendData.Nonce); ok {
+				c.sendEventAsync(ack)
+				return
+			}
+		}
+		c.manager.logger.Printf("Failed to save message: %v", err)
+		c.captureError(ErrorReport{
+			Err:  fmt.Errorf("saving message: %w", err),
+			Tags: map[string]string{"hall_id": strconv.Itoa(room.HallID), "room_id": strconv.Itoa(room.ID)},
+		})
+		c.sendEventAsync(WSMessage{Type: "error", Data: WSErrorData{
+			Nonce:   sendData.Nonce,
+			Code:    api.ErrCodeInternal,
+			Message: i18n.Error(c.lang, api.ErrCodeInternal, "Failed to save the message, try again"),
+		}})
+		return
+	}
+
+	if rule != nil {
+		reason := fmt.Sprintf("automod rule %d", rule.ID)
+		if err := c.manager.db.FlagMessage(ctx, message.ID, room.HallID, reason); err != nil {
+			c.manager.logger.Printf("Failed to flag message %d: %v", message.ID, err)
+		}
+		if err := c.manager.db.AddAuditLog(ctx, room.HallID, 0, "automod_flag", "message", message.ID, reason); err != nil {
+			c.manager.logger.Printf("Failed to write audit log: %v", err)
+		}
+	}
+
+	if spamAction == store.SpamActionFlag {
+		if err := c.manager.db.FlagMessage(ctx, message.ID, room.HallID, verdict.String()); err != nil {
+			c.manager.logger.Printf("Failed to flag message %d: %v", message.ID, err)
+		}
+		if err := c.manager.db.AddAuditLog(ctx, room.HallID, 0, "spam_flag", "message", message.ID, verdict.String()); err != nil {
+			c.manager.logger.Printf("Failed to write audit log: %v", err)
+		}
+	}
+
+	//nroadcast to all clients in room
+	seq := c.manager.BroadcastToRoom(sendData.RoomID, "new_message", BroadcastMessageData{
+		Message: *message,
+		RoomID:  sendData.RoomID,
+		Nonce:   sendData.Nonce,
+	})
+
+	mentioned := c.manager.notifier.MentionedUsers(ctx, room, message, massMention)
+	c.manager.SendToUsers(mentioned, "mention", MentionData{
+		HallID:      room.HallID,
+		Message:     *message,
+		MassMention: massMention,
+	})
+	c.manager.notifier.NotifyMentions(ctx, room, message, mentioned)
+
+	c.manager.plugins.MessagePost(ctx, PluginMessage{
+		ID:       message.ID,
+		HallID:   room.HallID,
+		RoomID:   room.ID,
+		UserID:   message.UserID,
+		Username: message.Username,
+		Content:  message.Content,
+		Kind:     message.Kind,
+	})
+
+	c.sendEventAsync(WSMessage{Type: "ack", Data: AckData{
+		Nonce:     sendData.Nonce,
+		RoomID:    message.RoomID,
+		MessageID: message.ID,
+		Seq:       seq,
+	}})
+}
+
+// checkSpam scores a message for the room's hall and returns what the hall's
+// spam policy says to do with it. Throttled and dropped messages are dealt
+// with here; the sender of a throttled one is told to wait.
+func (c *Client) checkSpam(ctx context.Context, room *store.Room, sendData SendMessageData) (string, SpamVerdict) {
+	if wait := c.manager.spam.Throttled(room.HallID, c.session.UserID); wait > 0 {
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "spam_throttled",
+			Message:      "You are posting too much like spam, wait a while",
+			RetryAfterMs: wait.Milliseconds(),
+		})
+		return store.SpamActionThrottle, SpamVerdict{}
+	}
+
+	policy, err := c.manager.db.GetSpamPolicy(ctx, room.HallID)
+	if err != nil {
+		c.manager.logger.Printf("Failed to load spam policy of hall %d: %v", room.HallID, err)
+		return "", SpamVerdict{}
+	}
+	if !policy.Enabled() {
+		return "", SpamVerdict{}
+	}
+
+	verdict, err := c.manager.spam.Score(ctx, room.HallID, room.ID, c.session.UserID, sendData.Content)
+	if err != nil {
+		c.manager.logger.Printf("Spam check failed for hall %d: %v", room.HallID, err)
+		return "", SpamVerdict{}
+	}
+
+	action := verdict.Action(policy)
+	switch action {
+	case store.SpamActionThrottle:
+		c.manager.spam.Throttle(room.HallID, c.session.UserID)
+		c.sendError(WSErrorData{
+			Nonce:        sendData.Nonce,
+			Code:         "spam_throttled",
+			Message:      "You are posting too much like spam, wait a while",
+			RetryAfterMs: spamThrottle.Milliseconds(),
+		})
+	case store.SpamActionDelete:
+	default:
+		return action, verdict
+	}
+
+	details := fmt.Sprintf("%s in room %d: %s", verdict, room.ID, sendData.Content)
+	if err := c.manager.db.AddAuditLog(ctx, room.HallID, 0, "spam_"+action, "user", c.session.UserID, details); err != nil {
+		c.manager.logger.Printf("Failed to write audit log: %v", err)
+	}
+	return action, verdict
+}
+
+// ackDuplicate acks a nonce the user already sent a message with, and
+// reports whether there was one
+func (c *Client) ackDuplicate(ctx context.Context, nonce string) bool {
+	ack, ok := c.duplicateAck(ctx, nonce)
+	if ok {
+		c.sendEvent(ack)
+	}
+	return ok
+}
+
+// duplicateAck builds the ack for a nonce the user already sent a message
+// with, if there was one
+func (c *Client) duplicateAck(ctx context.Context, nonce string) (WSMessage, bool) {
+	message, err := c.manager.db.GetMessageByNonce(ctx, c.session.UserID, nonce)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			c.manager.logger.Printf("Failed to look up nonce: %v", err)
+		}
+		return WSMessage{}, false
+	}
+
+	return WSMessage{Type: "ack", Data: AckData{
+		Nonce:     nonce,
+		RoomID:    message.RoomID,
+		MessageID: message.ID,
+		Duplicate: true,
+	}}, true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"chatapp/internal/store"
+	"chatapp/server"
+)
+
+// runJournalCommand handles `commons-api journal replay`, which puts messages
+// from the configured message journal back into the database where they're
+// missing, e.g. after restoring an older backup. Any flags after it are the
+// usual config flags.
+func runJournalCommand(args []string) error {
+	if len(args) == 0 || args[0] != "replay" {
+		return fmt.Errorf("usage: journal replay")
+	}
+
+	cfg, err := server.LoadConfig(args[1:])
+	if err != nil {
+		return err
+	}
+	if cfg.JournalDir == "" {
+		return fmt.Errorf("no journal_dir is configured")
+	}
+	files, err := store.JournalFiles(cfg.JournalDir)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewDatabase(cfg.DatabaseOptions())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := db.Migrate(ctx); err != nil {
+		return err
+	}
+
+	var read, restored int
+	for _, file := range files {
+		err := store.ReadJournal(file, func(entry store.JournalEntry) error {
+			read++
+			added, err := db.RestoreJournalEntry(ctx, entry)
+			if added {
+				restored++
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Read %d journaled messages from %d files, restored %d missing from %s\n", read, len(files), restored, cfg.DBPath)
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chatapp/internal/api"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// loadTestAckWait is how long clients keep listening for acks after they
+// stop sending; anything still unacked by then counts as lost
+const loadTestAckWait = 5 * time.Second
+
+// runLoadTestCommand handles `commons-api loadtest`. It signs in -clients
+// accounts on a running instance, puts them in a fresh hall and has each send
+// messages over its own websocket at -rate for -duration, then reports how
+// long acks took and what went wrong.
+func runLoadTestCommand(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := fs.String("url", "http://localhost:8080", "base URL of the instance to test")
+	clients := fs.Int("clients", 10, "simulated clients, each with its own account and connection")
+	rate := fs.Float64("rate", 0.5, "messages per second each client sends")
+	duration := fs.Duration("duration", 30*time.Second, "how long clients send for")
+	size := fs.Int("size", 64, "length of each message in characters")
+	prefix := fs.String("user-prefix", "loadtest_", "clients use the accounts prefix0, prefix1, ..., registered if missing")
+	password := fs.String("password", "loadtest-password", "password of those accounts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *clients < 1 || *rate <= 0 || *duration <= 0 || *size < 1 {
+		return fmt.Errorf("-clients, -rate, -duration and -size must be positive")
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(*target, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return fmt.Errorf("-url must be an http or https URL, got %q", *target)
+	}
+	lt := &loadTester{base: base.String(), http: &http.Client{Timeout: 10 * time.Second}}
+
+	log.Printf("Signing in %d accounts", *clients)
+	tokens := make([]string, *clients)
+	for i := range tokens {
+		tokens[i], err = lt.signIn(*prefix+strconv.Itoa(i), *password)
+		if err != nil {
+			return err
+		}
+	}
+
+	room, err := lt.setUpHall(tokens)
+	if err != nil {
+		return err
+	}
+
+	wsURL := *base
+	wsURL.Scheme = strings.Replace(base.Scheme, "http", "ws", 1)
+	wsURL.Path += "/ws"
+
+	log.Printf("Running %d clients at %g messages/s each for %s", *clients, *rate, *duration)
+	stats := &loadTestStats{errors: make(map[string]int)}
+	content := strings.Repeat("x", *size)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func(client int, token string) {
+			defer wg.Done()
+			u := wsURL
+			u.RawQuery = url.Values{"token": {token}}.Encode()
+			runLoadTestClient(u.String(), client, room, content, *rate, *duration, stats)
+		}(i, token)
+	}
+	wg.Wait()
+
+	stats.report(time.Since(start), *duration)
+	return nil
+}
+
+// loadTester makes the REST calls that set a load test up
+type loadTester struct {
+	base string
+	http *http.Client
+}
+
+// call POSTs body (or GETs, if body is nil) and decodes a 2xx response into
+// out. Other statuses come back as an error carrying the response's code.
+func (lt *loadTester) call(path, token string, body, out interface{}) error {
+	method, reader := http.MethodGet, &bytes.Reader{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		method, reader = http.MethodPost, bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, lt.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := lt.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s %s: %d %s (%s)", method, path, resp.StatusCode, apiErr.Message, apiErr.Code)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// signIn logs in as username, registering it first if it doesn't exist
+func (lt *loadTester) signIn(username, password string) (string, error) {
+	credentials := map[string]string{"username": username, "password": password}
+	var session struct {
+		Token string `json:"token"`
+	}
+
+	err := lt.call("/api/register", "", credentials, &session)
+	if err != nil && strings.Contains(err.Error(), "("+api.ErrCodeUsernameTaken+")") {
+		err = lt.call("/api/login", "", credentials, &session)
+	}
+	if err != nil {
+		return "", fmt.Errorf("signing in as %s: %w", username, err)
+	}
+	return session.Token, nil
+}
+
+// setUpHall creates a hall for this run as the first account, has the rest
+// join it and returns its first room
+func (lt *loadTester) setUpHall(tokens []string) (store.Room, error) {
+	var created struct {
+		Hall store.Hall `json:"hall"`
+	}
+	name := fmt.Sprintf("loadtest-%d", time.Now().Unix())
+	if err := lt.call("/api/halls/create", tokens[0], map[string]string{"name": name}, &created); err != nil {
+		return store.Room{}, err
+	}
+
+	for _, token := range tokens[1:] {
+		var joined struct{}
+		if err := lt.call("/api/halls/join", token, map[string]string{"invite_code": created.Hall.InviteCode}, &joined); err != nil {
+			return store.Room{}, err
+		}
+	}
+
+	var listed struct {
+		Rooms []store.Room `json:"rooms"`
+	}
+	if err := lt.call("/api/rooms/"+strconv.Itoa(created.Hall.ID), tokens[0], nil, &listed); err != nil {
+		return store.Room{}, err
+	}
+	if len(listed.Rooms) == 0 {
+		return store.Room{}, fmt.Errorf("hall %s has no rooms", name)
+	}
+	log.Printf("Sending to room %d in hall %s", listed.Rooms[0].ID, name)
+	return listed.Rooms[0], nil
+}
+
+// loadTestStats collects what every client saw
+type loadTestStats struct {
+	mu             sync.Mutex
+	sent           int
+	latencies      []time.Duration // from sending a message to its ack
+	errors         map[string]int  // error events by code
+	connectFailed  int
+	disconnected   int
+	unacknowledged int
+}
+
+func (s *loadTestStats) add(f func(s *loadTestStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+// runLoadTestClient is one simulated client: it connects, joins the room and
+// sends a message every 1/rate seconds until duration is up, timing each
+// from send to ack
+func runLoadTestClient(wsURL string, client int, room store.Room, content string, rate float64, duration time.Duration, stats *loadTestStats) {
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		log.Printf("Client %d failed to connect: %v", client, err)
+		stats.add(func(s *loadTestStats) { s.connectFailed++ })
+		return
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	pending := make(map[string]time.Time) // nonce -> when it was sent
+	readerDone := make(chan struct{})
+
+	go func() {
+		defer close(readerDone)
+		for {
+			var frame struct {
+				Type string `json:"type"`
+				Data struct {
+					Nonce string `json:"nonce"`
+					Code  string `json:"code"`
+				} `json:"data"`
+			}
+			if err := conn.ReadJSON(&frame); err != nil {
+				return
+			}
+			if frame.Type != "ack" && frame.Type != "error" {
+				continue
+			}
+
+			mu.Lock()
+			sentAt, ok := pending[frame.Data.Nonce]
+			delete(pending, frame.Data.Nonce)
+			mu.Unlock()
+			if !ok && frame.Type == "ack" {
+				continue
+			}
+
+			if frame.Type == "ack" {
+				latency := time.Since(sentAt)
+				stats.add(func(s *loadTestStats) { s.latencies = append(s.latencies, latency) })
+			} else {
+				stats.add(func(s *loadTestStats) { s.errors[frame.Data.Code]++ })
+			}
+		}
+	}()
+
+	join := ws.WSMessage{Type: "join_room", Data: ws.JoinRoomData{HallID: room.HallID, RoomID: room.ID}}
+	if err := conn.WriteJSON(join); err != nil {
+		stats.add(func(s *loadTestStats) { s.disconnected++ })
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+send:
+	for n := 0; ; n++ {
+		select {
+		case <-deadline:
+			break send
+		case <-readerDone:
+			stats.add(func(s *loadTestStats) { s.disconnected++ })
+			break send
+		case <-ticker.C:
+		}
+
+		nonce := fmt.Sprintf("lt-%d-%d", client, n)
+		mu.Lock()
+		pending[nonce] = time.Now()
+		mu.Unlock()
+
+		msg := ws.WSMessage{Type: "send_message", Data: ws.SendMessageData{RoomID: room.ID, Content: content, Nonce: nonce}}
+		if err := conn.WriteJSON(msg); err != nil {
+			stats.add(func(s *loadTestStats) { s.disconnected++ })
+			break
+		}
+		stats.add(func(s *loadTestStats) { s.sent++ })
+	}
+
+	unacked := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(pending)
+	}
+
+	// Give the last messages time to be acked
+	wait := time.NewTimer(loadTestAckWait)
+	defer wait.Stop()
+	for waiting := true; waiting && unacked() > 0; {
+		select {
+		case <-readerDone:
+			waiting = false
+		case <-wait.C:
+			waiting = false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	lost := unacked()
+	stats.add(func(s *loadTestStats) { s.unacknowledged += lost })
+}
+
+// report prints throughput, latency percentiles and errors
+func (s *loadTestStats) report(elapsed, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acked := len(s.latencies)
+	failed := 0
+	for _, n := range s.errors {
+		failed += n
+	}
+
+	fmt.Printf("\nsent %d messages in %s, %.1f/s acked\n", s.sent, elapsed.Round(time.Millisecond), float64(acked)/duration.Seconds())
+	fmt.Printf("acked %d, errors %d, unacknowledged %d", acked, failed, s.unacknowledged)
+	if s.sent > 0 {
+		fmt.Printf(" (%.2f%% failed)", 100*float64(failed+s.unacknowledged)/float64(s.sent))
+	}
+	fmt.Println()
+	if s.connectFailed > 0 || s.disconnected > 0 {
+		fmt.Printf("connections failed %d, dropped %d\n", s.connectFailed, s.disconnected)
+	}
+
+	if acked > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		fmt.Printf("ack latency p50 %s, p90 %s, p99 %s, max %s\n",
+			percentile(s.latencies, 0.50), percentile(s.latencies, 0.90),
+			percentile(s.latencies, 0.99), percentile(s.latencies, 1))
+	}
+
+	codes := make([]string, 0, len(s.errors))
+	for code := range s.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Printf("  %s: %d\n", code, s.errors[code])
+	}
+}
+
+// percentile picks the p-th quantile from sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Round(10 * time.Microsecond)
+}
@@ -1,62 +1,133 @@
 package main
 
 import (
+	"context"
 	"log"
-	"net/http"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"chatapp/server"
+)
+
+// drainGracePeriod is how long a drain on SIGTERM may run past the drain
+// period, for the last clients to go, before the process exits anyway.
+// Requests still in flight after it get shutdownTimeout to finish.
+const (
+	drainGracePeriod = 10 * time.Second
+	shutdownTimeout  = 10 * time.Second
 )
 
 func main() {
-	// Initialize database
-	dbPath := "chat.db"
-	if len(os.Args) > 1 {
-		dbPath = os.Args[1]
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
 	}
 
-	db, err := NewDatabase(dbPath)
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdminCommand(os.Args[2:]); err != nil {
+			log.Fatal("Admin command failed: ", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "journal" {
+		if err := runJournalCommand(os.Args[2:]); err != nil {
+			log.Fatal("Journal replay failed: ", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeedCommand(os.Args[2:]); err != nil {
+			log.Fatal("Seeding failed: ", err)
+		}
+		return
 	}
-	defer db.Close()
 
-	if err := db.CreateTables(); err != nil {
-		log.Fatal("Failed to create tables:", err)
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTestCommand(os.Args[2:]); err != nil {
+			log.Fatal("Load test failed: ", err)
+		}
+		return
 	}
 
-	if err := db.EnsureDefaultHall(); err != nil {
-		log.Fatal("Failed to ensure default hall:", err)
+	cfg, err := server.LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
 	}
 
-	// Initialize server
-	server := NewServer(db)
+	srv, err := server.New(cfg, server.WithConfigLoader(func() (*server.Config, error) {
+		return server.LoadConfig(os.Args[1:])
+	}))
+	if err != nil {
+		log.Fatal("Failed to start server: ", err)
+	}
+	defer srv.Close()
 
-	// Setup routes
-	mux := server.RegisterRoutes()
+	host := cfg.BindAddress
+	if host == "" {
+		host = "localhost"
+	}
+	displayAddr := net.JoinHostPort(host, strconv.Itoa(cfg.Port))
 
-	// Add CORS middleware
-	handler := corsMiddleware(mux)
+	httpScheme, wsScheme := "http", "ws"
+	if cfg.TLSEnabled() {
+		httpScheme, wsScheme = "https", "wss"
+	}
 
-	log.Println("Chat server starting on :8080")
-	log.Println("Web UI: http://localhost:8080")
-	log.Println("WebSocket endpoint: ws://localhost:8080/ws")
-	log.Println("API endpoints: http://localhost:8080/api/*")
+	log.Printf("Chat server starting on %s", cfg.Addr())
+	if cfg.StaticDir != "" {
+		log.Printf("Web UI: %s://%s", httpScheme, displayAddr)
+	}
+	log.Printf("WebSocket endpoint: %s://%s/ws", wsScheme, displayAddr)
+	log.Printf("SSE endpoint: %s://%s/api/events", httpScheme, displayAddr)
+	log.Printf("API endpoints: %s://%s/api/*", httpScheme, displayAddr)
 
-	if err := http.ListenAndServe(":8080", handler); err != nil {
-		log.Fatal("Server failed:", err)
+	if cfg.PprofAddress != "" {
+		startPprof(cfg.PprofAddress)
 	}
-}
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	failed := make(chan error, 1)
+	go func() {
+		failed <- srv.ListenAndServe()
+	}()
+
+	// Deploys stop the old process with SIGTERM; its clients are moved off
+	// gradually before it exits. SIGHUP reloads the config in place.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+	for {
+		select {
+		case err := <-failed:
+			log.Fatal("Server failed:", err)
+		case <-hup:
+			log.Printf("Got SIGHUP, reloading config")
+			if _, err := srv.Reload(); err != nil {
+				log.Printf("Failed to reload config, keeping the old one: %v", err)
+			}
+		case <-stop:
+			log.Printf("Got SIGTERM, draining connections")
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainPeriod+drainGracePeriod)
+			defer cancel()
+			srv.Drain(ctx)
+
+			// Close, deferred above, closes the database, so nothing may
+			// be using it any more
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancelShutdown()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Requests still running at shutdown: %v", err)
+			}
 			return
 		}
-
-		next.ServeHTTP(w, r)
-	})
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"chatapp/internal/store"
+	"chatapp/server"
+)
+
+// runMigrateCommand handles `commons-api migrate status|up|down <version>`.
+// Any flags after it are the usual config flags, e.g. -db or -config.
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status | up | down <version>")
+	}
+	action, args := args[0], args[1:]
+
+	target := 0
+	if action == "down" {
+		if len(args) == 0 {
+			return fmt.Errorf("usage: migrate down <version> (0 reverts everything)")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil || version < 0 {
+			return fmt.Errorf("bad version %q", args[0])
+		}
+		target, args = version, args[1:]
+	}
+
+	cfg, err := server.LoadConfig(args)
+	if err != nil {
+		return err
+	}
+
+	db, err := store.NewDatabase(cfg.DatabaseOptions())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch action {
+	case "status":
+	case "up":
+		err = db.Migrate(ctx)
+	case "down":
+		err = db.MigrateDown(ctx, target)
+	default:
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is at schema version %d\n", cfg.DBPath, version)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves the profiling endpoints on their own listener so they're
+// never reachable through the public API. Bind it to localhost (or a private
+// interface) and reach it over SSH:
+//
+//	go tool pprof http://127.0.0.1:6060/debug/pprof/heap
+//	curl http://127.0.0.1:6060/debug/pprof/goroutine?debug=1
+func startPprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); host == "" || (ip != nil && !ip.IsLoopback() && !ip.IsPrivate()) {
+			log.Printf("Warning: pprof is listening on %s, which may be publicly reachable", addr)
+		}
+	}
+
+	go func() {
+		log.Printf("pprof endpoints on http://%s/debug/pprof/", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("pprof server failed: %v", err)
+		}
+	}()
+}
@@ -1,4 +1,7 @@
 -- Simple Chat App SQLite Schema
+--
+-- Reference copy of the full schema. The database itself is built from the
+-- versioned files in migrations/, which are applied at startup.
 
 -- Users table
 CREATE TABLE users (
@@ -6,7 +9,29 @@ CREATE TABLE users (
     username VARCHAR(50) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+    last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+    is_admin BOOLEAN NOT NULL DEFAULT 0 -- server-wide administrator
+);
+
+-- Server-level invites, needed to register when registration is invite-only
+CREATE TABLE registration_invites (
+    token VARCHAR(32) PRIMARY KEY,
+    created_by INTEGER NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 1, -- 0 for unlimited
+    uses INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME,                 -- NULL never expires
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Usernames people had before renaming themselves. Recent ones still
+-- resolve to them and can't be taken by anyone else.
+CREATE TABLE username_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    old_username VARCHAR(50) NOT NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
 -- Halls table (like Discord servers)
@@ -24,6 +49,7 @@ CREATE TABLE rooms (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     hall_id INTEGER NOT NULL,
     name VARCHAR(100) NOT NULL,
+    type VARCHAR(10) NOT NULL DEFAULT 'text', -- text or voice
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
     UNIQUE(hall_id, name)
@@ -47,12 +73,266 @@ CREATE TABLE messages (
     user_id INTEGER NOT NULL,
     content TEXT NOT NULL,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    nonce TEXT, -- client idempotency key, unique per user
+    expires_at DATETIME, -- self-destructing messages are deleted after this
+    type VARCHAR(10) NOT NULL DEFAULT 'user', -- 'system' for server-authored activity
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Per-hall settings
+CREATE TABLE hall_settings (
+    hall_id INTEGER PRIMARY KEY,
+    auto_archive_days INTEGER NOT NULL DEFAULT 0, -- 0 disables auto-archiving
+    retention_days INTEGER NOT NULL DEFAULT 0,         -- prune messages older than this
+    retention_max_messages INTEGER NOT NULL DEFAULT 0, -- keep at most this many per room
+    room_creation VARCHAR(10) NOT NULL DEFAULT 'members', -- members or admins may create rooms
+    welcome_message TEXT NOT NULL DEFAULT '', -- posted when someone joins, '' for none
+    landing_room_id INTEGER, -- where new members land, NULL for the oldest text room
+    spam_flag_score INTEGER NOT NULL DEFAULT 0,     -- spam scores that flag a message,
+    spam_throttle_score INTEGER NOT NULL DEFAULT 0, -- throttle its sender or drop it;
+    spam_delete_score INTEGER NOT NULL DEFAULT 0,   -- 0 turns each off
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
+    FOREIGN KEY (landing_room_id) REFERENCES rooms(id) ON DELETE SET NULL
+);
+
+-- Per-room settings and archive state
+CREATE TABLE room_settings (
+    room_id INTEGER PRIMARY KEY,
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    archived_at DATETIME,
+    archive_exempt BOOLEAN NOT NULL DEFAULT 0, -- never auto-archived
+    archive_warned_at DATETIME,                -- last time admins were warned
+    announcement BOOLEAN NOT NULL DEFAULT 0,   -- published as an Atom feed
+    feed_key VARCHAR(32),                      -- feed tokens are signed over this
+    message_ttl_seconds INTEGER NOT NULL DEFAULT 0, -- disappearing messages, 0 is off
+    public BOOLEAN NOT NULL DEFAULT 0,         -- guests can read it without an account
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+-- Temporary rooms, archived or deleted once expires_at passes
+CREATE TABLE room_expiry (
+    room_id INTEGER PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+    on_expiry TEXT NOT NULL DEFAULT 'archive', -- archive or delete
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+-- Message reactions, one per user and emoji
+CREATE TABLE message_reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji VARCHAR(32) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Per-user settings
+CREATE TABLE user_settings (
+    user_id INTEGER PRIMARY KEY,
+    dm_privacy VARCHAR(20) NOT NULL DEFAULT 'everyone', -- everyone, halls or nobody
+    email VARCHAR(254), -- for notification digests
+    email_notifications BOOLEAN NOT NULL DEFAULT 1,
+    email_verified BOOLEAN NOT NULL DEFAULT 0, -- reset when email changes
+    email_verify_token VARCHAR(64),            -- the one mailed out, if any
+    email_verify_expires_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Mentions and DMs received while offline, waiting to go out in a digest.
+-- Rows are deleted once sent.
+CREATE TABLE email_notifications (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(20) NOT NULL, -- mention or dm
+    actor_name VARCHAR(50) NOT NULL,
+    room_name VARCHAR(100) NOT NULL DEFAULT '', -- for mentions
+    hall_name VARCHAR(100) NOT NULL DEFAULT '',
+    excerpt TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- How much a user wants to hear about a hall or one of its rooms. room_id 0
+-- is the hall-wide preference; a room preference overrides it.
+CREATE TABLE notification_preferences (
+    user_id INTEGER NOT NULL,
+    hall_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL DEFAULT 0,
+    level VARCHAR(20) NOT NULL, -- all, mentions or muted
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, hall_id, room_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Half-typed messages, one per user and room, so they follow the user
+-- between devices
+CREATE TABLE drafts (
+    user_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, room_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+-- Who's connected to voice rooms. A participant is one connection, known to
+-- other peers by its random peer_id; seen_at is refreshed by the
+-- connection's pings so participants of a crashed instance get pruned.
+CREATE TABLE voice_participants (
+    peer_id VARCHAR(32) PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 );
+CREATE INDEX idx_voice_participants_room ON voice_participants(room_id);
+
+-- Direct message conversations, one row per pair of users (user_low < user_high)
+CREATE TABLE dm_conversations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_low INTEGER NOT NULL,
+    user_high INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'accepted', -- accepted or pending (message request)
+    requested_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_low) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_high) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(user_low, user_high)
+);
+
+-- Per-participant conversation state (mute/archive)
+CREATE TABLE dm_conversation_state (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    muted BOOLEAN NOT NULL DEFAULT 0,
+    archived BOOLEAN NOT NULL DEFAULT 0,
+    unarchive_on_message BOOLEAN NOT NULL DEFAULT 0, -- move back to the inbox when a new message arrives
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Direct messages
+CREATE TABLE dm_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    encrypted BOOLEAN NOT NULL DEFAULT 0, -- content is ciphertext, see device_keys
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Public keys devices publish for end-to-end encrypted DMs. The server
+-- hands them out but never sees a private key or plaintext.
+CREATE TABLE device_keys (
+    user_id INTEGER NOT NULL,
+    device_id VARCHAR(64) NOT NULL,
+    identity_key TEXT NOT NULL,
+    signed_prekey TEXT NOT NULL,
+    prekey_signature TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, device_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- One-time prekeys, each handed out to a single claim and then deleted
+CREATE TABLE one_time_prekeys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    device_id VARCHAR(64) NOT NULL,
+    key_id VARCHAR(64) NOT NULL,
+    public_key TEXT NOT NULL,
+    UNIQUE(user_id, device_id, key_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Hall admins (the owner is always an admin)
+CREATE TABLE hall_admins (
+    hall_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    granted_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (hall_id, user_id),
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Moderation audit log (actor_id is NULL for automatic actions)
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    actor_id INTEGER,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL DEFAULT '',
+    target_id INTEGER NOT NULL DEFAULT 0,
+    details TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+-- Automod blocked word / regex rules
+CREATE TABLE automod_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    hall_id INTEGER NOT NULL,
+    pattern TEXT NOT NULL,
+    is_regex BOOLEAN NOT NULL DEFAULT 0,
+    action VARCHAR(20) NOT NULL, -- reject, flag or delete
+    created_by INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+-- Messages flagged for moderator review
+CREATE TABLE message_flags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER NOT NULL,
+    hall_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (hall_id) REFERENCES halls(id) ON DELETE CASCADE
+);
+
+-- Per-room event sequence counters, kept apart from room_events so pruning
+-- never makes a sequence go backwards
+CREATE TABLE room_sequences (
+    room_id INTEGER PRIMARY KEY,
+    seq INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
+
+-- Recent room events, replayed to websocket clients that resume
+CREATE TABLE room_events (
+    room_id INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL, -- the event's data as JSON
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, seq),
+    FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE
+);
 
 -- Indexes for performance
 CREATE INDEX idx_messages_room_created ON messages(room_id, created_at);
 CREATE INDEX idx_hall_members_hall ON hall_members(hall_id);
 CREATE INDEX idx_hall_members_user ON hall_members(user_id);
 CREATE INDEX idx_rooms_hall ON rooms(hall_id);
+CREATE INDEX idx_dm_messages_conversation ON dm_messages(conversation_id, created_at);
+CREATE INDEX idx_message_reactions_message ON message_reactions(message_id);
+CREATE INDEX idx_audit_log_hall ON audit_log(hall_id, created_at);
+CREATE INDEX idx_automod_rules_hall ON automod_rules(hall_id);
+CREATE INDEX idx_message_flags_hall ON message_flags(hall_id, created_at);
+CREATE INDEX idx_room_events_created ON room_events(created_at);
+CREATE UNIQUE INDEX idx_messages_user_nonce ON messages(user_id, nonce) WHERE nonce IS NOT NULL;
+CREATE INDEX idx_messages_expires ON messages(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX idx_email_notifications_user ON email_notifications(user_id, created_at);
+CREATE INDEX idx_user_settings_email_verify_token ON user_settings(email_verify_token);
+CREATE INDEX idx_username_history_user ON username_history(user_id, changed_at);
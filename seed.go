@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/server"
+)
+
+// Seeded users are named seedUserPrefix + a first name, which is also how a
+// second run notices the database was already seeded
+const seedUserPrefix = "demo_"
+
+var (
+	seedNames = []string{
+		"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi",
+		"ivan", "judy", "mallory", "niaj", "olivia", "peggy", "rupert", "sybil",
+		"trent", "uma", "victor", "walter", "xena", "yusuf", "zoe", "quinn",
+	}
+
+	seedHalls = map[string][]string{
+		"Robotics Club": {"general", "build-log", "parts", "competition"},
+		"Book Nook":     {"general", "now-reading", "recommendations"},
+		"Game Jam":      {"general", "ideas", "showcase", "team-up", "art"},
+	}
+
+	seedOpeners = []string{
+		"has anyone tried", "I just finished", "quick question about", "thoughts on",
+		"we should look at", "finally got around to", "can someone explain", "loving",
+		"not sure about", "big update on",
+	}
+	seedTopics = []string{
+		"the new servo mounts", "that sci-fi series", "the weekend meetup", "pixel art",
+		"the soldering station", "chapter twelve", "the jam theme", "motor drivers",
+		"the reading list", "shader tricks", "the 3d printer", "sound design",
+	}
+	seedEndings = []string{
+		"", "!", "?", " :)", " - any tips?", ", it went better than expected",
+		" (more soon)", ", would love feedback", "...", " lol",
+	}
+	seedEmoji = []string{"👍", "🎉", "❤️", "😂", "👀", "🔥"}
+)
+
+// runSeedCommand fills the database with sample users, halls, rooms and
+// messages for local development and load tests
+func runSeedCommand(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	users := fs.Int("users", 12, "number of users to create")
+	messages := fs.Int("messages", 3000, "number of messages to spread across the rooms")
+	password := fs.String("password", "demo-password", "password for every seeded user")
+	days := fs.Int("days", 30, "how many days back message timestamps go")
+	randSeed := fs.Int64("rand-seed", 1, "random seed, so runs are reproducible")
+
+	cfg, err := server.LoadConfigFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if *users < 1 || *users > len(seedNames) {
+		return fmt.Errorf("-users must be between 1 and %d", len(seedNames))
+	}
+	if *messages < 0 || *days < 1 {
+		return fmt.Errorf("-messages can't be negative and -days must be at least 1")
+	}
+	if errs := auth.DefaultCredentialPolicy().ValidatePassword("password", *password, ""); len(errs) > 0 {
+		return fmt.Errorf("bad -password: %s", errs[0].Message)
+	}
+
+	db, err := store.NewDatabase(cfg.DatabaseOptions())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if err := db.Migrate(ctx); err != nil {
+		return err
+	}
+	if err := db.EnsureSystemUser(ctx); err != nil {
+		return err
+	}
+	if cfg.DefaultHall != "" {
+		if err := db.EnsureDefaultHall(ctx, cfg.DefaultHall, cfg.DefaultHallRooms); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.GetUserByUsername(ctx, seedUserPrefix+seedNames[0]); err == nil {
+		return fmt.Errorf("database %s is already seeded", cfg.DBPath)
+	}
+
+	rng := rand.New(rand.NewSource(*randSeed))
+
+	log.Printf("Creating %d users", *users)
+	var people []*store.User
+	for _, name := range seedNames[:*users] {
+		user, err := db.CreateUser(ctx, seedUserPrefix+name, *password)
+		if err != nil {
+			return fmt.Errorf("create user %s: %w", name, err)
+		}
+		if cfg.DefaultHall != "" {
+			if _, err := db.AddUserToDefaultHall(ctx, cfg.DefaultHall, user.ID); err != nil {
+				return err
+			}
+		}
+		people = append(people, user)
+	}
+
+	// Every seeded room, plus the default hall's rooms so the first thing a
+	// user sees isn't empty
+	members := make(map[int][]*store.User)
+	var rooms []*store.Room
+
+	joined, err := db.GetUserHalls(ctx, people[0].ID)
+	if err != nil {
+		return err
+	}
+	for _, hall := range joined {
+		hallRooms, err := db.GetHallRooms(ctx, hall.ID, false)
+		if err != nil {
+			return err
+		}
+		for i := range hallRooms {
+			rooms = append(rooms, &hallRooms[i])
+			members[hallRooms[i].ID] = people
+		}
+	}
+
+	hallNames := make([]string, 0, len(seedHalls))
+	for name := range seedHalls {
+		hallNames = append(hallNames, name)
+	}
+	sort.Strings(hallNames)
+
+	for i, hallName := range hallNames {
+		owner := people[i%len(people)]
+		hall, err := db.CreateHall(ctx, hallName, owner.ID)
+		if err != nil {
+			return fmt.Errorf("create hall %s: %w", hallName, err)
+		}
+
+		// Each hall gets its owner and a random two thirds of everyone else
+		hallMembers := []*store.User{owner}
+		for _, user := range people {
+			if user.ID != owner.ID && rng.Intn(3) > 0 {
+				hallMembers = append(hallMembers, user)
+			}
+		}
+		for _, user := range hallMembers[1:] {
+			if err := db.JoinHall(ctx, user.ID, hall.InviteCode); err != nil {
+				return err
+			}
+		}
+
+		for _, roomName := range seedHalls[hallName] {
+			room, err := db.CreateRoom(ctx, hall.ID, store.CleanRoomName(roomName), store.RoomTypeText)
+			if err != nil {
+				return fmt.Errorf("create room %s: %w", roomName, err)
+			}
+			rooms = append(rooms, room)
+			members[room.ID] = hallMembers
+		}
+		log.Printf("Created hall %q with %d members and %d rooms", hallName, len(hallMembers), len(seedHalls[hallName]))
+	}
+
+	// Timestamps are spread over the last -days days and sorted, so IDs and
+	// times go up together like they would for real traffic
+	now := time.Now()
+	span := int64(time.Duration(*days) * 24 * time.Hour)
+	times := make([]time.Time, *messages)
+	for i := range times {
+		times[i] = now.Add(-time.Duration(rng.Int63n(span)))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	batch := make([]store.Message, 0, *messages)
+	for _, createdAt := range times {
+		room := rooms[rng.Intn(len(rooms))]
+		roomMembers := members[room.ID]
+		author := roomMembers[rng.Intn(len(roomMembers))]
+		batch = append(batch, store.Message{
+			RoomID:    room.ID,
+			UserID:    author.ID,
+			Content:   seedMessage(rng),
+			CreatedAt: createdAt,
+		})
+	}
+
+	log.Printf("Writing %d messages across %d rooms", len(batch), len(rooms))
+	if err := db.ImportMessages(ctx, batch); err != nil {
+		return err
+	}
+
+	// React to a sample of the newest messages
+	reactions := 0
+	for _, room := range rooms {
+		recent, err := db.GetRoomMessages(ctx, room.ID, 50, 0)
+		if err != nil {
+			return err
+		}
+		for _, message := range recent {
+			if rng.Intn(4) != 0 {
+				continue
+			}
+			roomMembers := members[room.ID]
+			user := roomMembers[rng.Intn(len(roomMembers))]
+			added, err := db.AddReaction(ctx, message.ID, user.ID, seedEmoji[rng.Intn(len(seedEmoji))])
+			if err != nil {
+				return err
+			}
+			if added {
+				reactions++
+			}
+		}
+	}
+	log.Printf("Added %d reactions", reactions)
+
+	names := make([]string, len(people))
+	for i, user := range people {
+		names[i] = user.Username
+	}
+	fmt.Printf("Seeded %s. Log in as any of these with password %q:\n  %s\n",
+		cfg.DBPath, *password, strings.Join(names, "\n  "))
+	return nil
+}
+
+func seedMessage(rng *rand.Rand) string {
+	return seedOpeners[rng.Intn(len(seedOpeners))] + " " +
+		seedTopics[rng.Intn(len(seedTopics))] +
+		seedEndings[rng.Intn(len(seedEndings))]
+}
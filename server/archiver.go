@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// Rooms in halls with an auto-archive policy are checked every
+// archiveCheckInterval. Hall admins are warned archiveWarnBefore ahead of a
+// room being archived. Temporary rooms are checked every expiryCheckInterval.
+const (
+	archiveCheckInterval = time.Hour
+	archiveWarnBefore    = 24 * time.Hour
+	expiryCheckInterval  = time.Minute
+
+	// archiveRunTimeout bounds the database work of one check
+	archiveRunTimeout = time.Minute
+)
+
+// RoomEventData is sent with room_archived, room_unarchived, room_deleted,
+// room_extended and room_archive_warning
+type RoomEventData struct {
+	RoomID    int        `json:"room_id"`
+	HallID    int        `json:"hall_id"`
+	Name      string     `json:"name"`
+	Days      int        `json:"days,omitempty"`
+	Reason    string     `json:"reason,omitempty"` // "inactive" or "expired" when done by the server
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// runRoomArchiver archives inactive rooms and expires temporary ones until
+// the process exits
+func (s *Server) runRoomArchiver() {
+	inactivity := time.NewTicker(archiveCheckInterval)
+	defer inactivity.Stop()
+	expiry := time.NewTicker(expiryCheckInterval)
+	defer expiry.Stop()
+
+	s.archiveInactiveRooms()
+	s.expireTemporaryRooms()
+	for {
+		select {
+		case <-inactivity.C:
+			s.archiveInactiveRooms()
+		case <-expiry.C:
+			s.expireTemporaryRooms()
+		}
+	}
+}
+
+func (s *Server) archiveInactiveRooms() {
+	ctx, cancel := context.WithTimeout(context.Background(), archiveRunTimeout)
+	defer cancel()
+
+	candidates, err := s.db.GetAutoArchiveCandidates(ctx, archiveWarnBefore)
+	if err != nil {
+		s.logger.Printf("Failed to find rooms to auto-archive: %v", err)
+		return
+	}
+
+	for _, c := range candidates {
+		data := RoomEventData{
+			RoomID: c.Room.ID,
+			HallID: c.Room.HallID,
+			Name:   c.Room.Name,
+			Days:   c.Days,
+			Reason: "inactive",
+		}
+
+		if !c.Due {
+			admins, err := s.db.GetHallAdminIDs(ctx, c.Room.HallID)
+			if err != nil {
+				s.logger.Printf("Failed to fetch admins of hall %d: %v", c.Room.HallID, err)
+				continue
+			}
+			for _, userID := range admins {
+				s.wsManager.SendToUser(userID, "room_archive_warning", data)
+			}
+			if err := s.db.MarkRoomArchiveWarned(ctx, c.Room.ID); err != nil {
+				s.logger.Printf("Failed to mark room %d as warned: %v", c.Room.ID, err)
+			}
+			continue
+		}
+
+		if err := s.db.SetRoomArchived(ctx, c.Room.ID, true); err != nil {
+			s.logger.Printf("Failed to archive room %d: %v", c.Room.ID, err)
+			continue
+		}
+
+		details := fmt.Sprintf("no activity for %d days", c.Days)
+		if err := s.db.AddAuditLog(ctx, c.Room.HallID, 0, "room_auto_archived", "room", c.Room.ID, details); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+
+		s.wsManager.BroadcastToRoom(c.Room.ID, "room_archived", data)
+	}
+}
+
+func (s *Server) expireTemporaryRooms() {
+	ctx, cancel := context.WithTimeout(context.Background(), archiveRunTimeout)
+	defer cancel()
+
+	rooms, err := s.db.GetExpiredRooms(ctx)
+	if err != nil {
+		s.logger.Printf("Failed to find expired rooms: %v", err)
+		return
+	}
+
+	for _, room := range rooms {
+		data := RoomEventData{
+			RoomID: room.ID,
+			HallID: room.HallID,
+			Name:   room.Name,
+			Reason: "expired",
+		}
+
+		// A room with held messages is archived instead
+		deleteRoom := room.OnExpiry == store.RoomExpiryDelete
+		if deleteRoom {
+			held, err := s.db.RoomOnLegalHold(ctx, room.ID)
+			if err != nil {
+				s.logger.Printf("Failed to check legal holds of room %d: %v", room.ID, err)
+				continue
+			}
+			deleteRoom = !held
+		}
+
+		if deleteRoom {
+			// Tell the hall before the room is gone
+			s.wsManager.BroadcastToHall(ctx, room.HallID, "room_deleted", data)
+			if err := s.db.DeleteRoom(ctx, room.ID); err != nil {
+				s.logger.Printf("Failed to delete expired room %d: %v", room.ID, err)
+				continue
+			}
+			if err := s.db.AddAuditLog(ctx, room.HallID, 0, "room_expired", "room", room.ID, "deleted"); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+			continue
+		}
+
+		if err := s.db.SetRoomArchived(ctx, room.ID, true); err != nil {
+			s.logger.Printf("Failed to archive expired room %d: %v", room.ID, err)
+			continue
+		}
+		if err := s.db.ClearRoomExpiry(ctx, room.ID); err != nil {
+			s.logger.Printf("Failed to clear expiry of room %d: %v", room.ID, err)
+		}
+		if err := s.db.AddAuditLog(ctx, room.HallID, 0, "room_expired", "room", room.ID, "archived"); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+
+		s.wsManager.BroadcastToRoom(room.ID, "room_archived", data)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+const (
+	backupRunTimeout = time.Hour
+	backupPrefix     = "backup-"
+	backupSuffix     = ".db"
+)
+
+// Backup job states
+const (
+	BackupStatusRunning = "running"
+	BackupStatusDone    = "done"
+	BackupStatusFailed  = "failed"
+)
+
+// BackupStore keeps database backups somewhere besides backup_dir, like a
+// blob store, see WithBackupStore
+type BackupStore interface {
+	// PutBackup copies the finished backup at path, a SQLite file, to the
+	// store under name. It's called from the backup job, never from a
+	// request.
+	PutBackup(ctx context.Context, name, path string) error
+}
+
+type BackupJob struct {
+	Name       string     `json:"name"`
+	Trigger    string     `json:"trigger"` // "schedule" or "admin"
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// BackupManager snapshots the database into a directory, one backup at a
+// time, and copies each to the BackupStore if there is one
+type BackupManager struct {
+	db     *store.Database
+	dir    string
+	keep   int
+	store  BackupStore // nil keeps backups on local disk only
+	errors ErrorSink
+	last   *BackupJob // the running backup, or the one before
+	mutex  sync.Mutex
+	logger *log.Logger
+}
+
+func NewBackupManager(db *store.Database, dir string, keep int, logger *log.Logger) *BackupManager {
+	return &BackupManager{
+		db:     db,
+		dir:    dir,
+		keep:   keep,
+		logger: logger,
+	}
+}
+
+// Run backs up every interval until the process exits
+func (bm *BackupManager) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		bm.Start("schedule")
+	}
+}
+
+// Start begins a backup and returns it right away, or returns the one
+// that's already running and false
+func (bm *BackupManager) Start(trigger string) (BackupJob, bool) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if bm.last != nil && bm.last.Status == BackupStatusRunning {
+		return *bm.last, false
+	}
+
+	now := time.Now().UTC()
+	job := &BackupJob{
+		Name:      backupPrefix + now.Format("20060102T150405.000Z") + backupSuffix,
+		Trigger:   trigger,
+		Status:    BackupStatusRunning,
+		StartedAt: now,
+	}
+	bm.last = job
+
+	go bm.run(job)
+	return *job, true
+}
+
+// Last returns a copy of the running backup, or the latest one; nil before
+// the first
+func (bm *BackupManager) Last() *BackupJob {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if bm.last == nil {
+		return nil
+	}
+	job := *bm.last
+	return &job
+}
+
+func (bm *BackupManager) run(job *BackupJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), backupRunTimeout)
+	defer cancel()
+
+	path := filepath.Join(bm.dir, job.Name)
+	size, err := bm.backup(ctx, job.Name, path)
+	if err != nil {
+		bm.logger.Printf("Backup %s failed: %v", job.Name, err)
+		if bm.errors != nil {
+			bm.errors.CaptureError(ErrorReport{
+				Err:    fmt.Errorf("backup %s failed: %w", job.Name, err),
+				Source: "backup",
+				Tags:   map[string]string{"backup": job.Name, "trigger": job.Trigger},
+			})
+		}
+	} else {
+		bm.logger.Printf("Backed up database to %s (%d bytes) in %s", path, size, time.Since(job.StartedAt).Round(time.Millisecond))
+		bm.prune()
+	}
+
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Size = size
+	if err != nil {
+		job.Status = BackupStatusFailed
+		job.Error = "backup failed"
+		return
+	}
+	job.Status = BackupStatusDone
+}
+
+// backup writes the snapshot and hands it to the store. A snapshot the
+// store didn't take is still kept on disk.
+func (bm *BackupManager) backup(ctx context.Context, name, path string) (int64, error) {
+	if err := os.MkdirAll(bm.dir, 0o700); err != nil {
+		return 0, err
+	}
+	if err := bm.db.Backup(ctx, path); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if bm.store != nil {
+		if err := bm.store.PutBackup(ctx, name, path); err != nil {
+			return info.Size(), fmt.Errorf("storing backup: %w", err)
+		}
+	}
+	return info.Size(), nil
+}
+
+// prune removes the oldest backups in the directory beyond keep
+func (bm *BackupManager) prune() {
+	if bm.keep == 0 {
+		return
+	}
+	entries, err := os.ReadDir(bm.dir)
+	if err != nil {
+		bm.logger.Printf("Failed to list backups: %v", err)
+		return
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, filepath.Join(bm.dir, name))
+		}
+	}
+	// Backup times sort as text
+	sort.Strings(backups)
+	for len(backups) > bm.keep {
+		if err := os.Remove(backups[0]); err != nil {
+			bm.logger.Printf("Failed to remove %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// handleAdminBackup serves /api/admin/backup: GET returns the running or
+// latest backup, POST starts one unless one is running
+func (s *Server) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.RespondJSON(w, map[string]interface{}{
+			"backup": s.backups.Last(),
+		})
+	case http.MethodPost:
+		job, started := s.backups.Start("admin")
+		if started {
+			session := auth.SessionFromContext(r.Context())
+			s.logger.Printf("Instance admin %s started backup %s", session.Username, job.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"backup": job,
+		})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
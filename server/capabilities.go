@@ -0,0 +1,137 @@
+package server
+
+import (
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// apiVersion is the REST API version this server speaks. Bump it when a
+// change would break existing clients.
+const apiVersion = 1
+
+// Capabilities tells clients what this server supports so they don't have to
+// hardcode it. It's served from /api/instance and with login and register.
+type Capabilities struct {
+	Features     []string           `json:"features"`
+	Registration string             `json:"registration"` // open, invite or closed
+	Captcha      *CapabilityCaptcha `json:"captcha,omitempty"`
+	Limits       CapabilityLimits   `json:"limits"`
+	Protocols    CapabilityVersion  `json:"protocols"`
+	MessageKinds []string           `json:"message_kinds"` // kinds clients can send
+	Languages    []string           `json:"languages"`     // error and system messages, see Accept-Language
+	Language     string             `json:"language"`      // the default one
+}
+
+// CapabilityCaptcha tells clients which captcha to show and when
+type CapabilityCaptcha struct {
+	Provider      string `json:"provider"`             // hcaptcha, turnstile or pow
+	SiteKey       string `json:"site_key,omitempty"`   // hcaptcha and turnstile
+	Difficulty    int    `json:"difficulty,omitempty"` // pow
+	LoginFailures int    `json:"login_failures"`       // 0: every login needs one
+}
+
+type CapabilityLimits struct {
+	MaxMessageLength       int   `json:"max_message_length"`
+	MaxRoomNameLength      int   `json:"max_room_name_length"`
+	MaxUploadBytes         int   `json:"max_upload_bytes"` // 0: uploads aren't supported
+	MinUsernameLength      int   `json:"min_username_length"`
+	MaxUsernameLength      int   `json:"max_username_length"`
+	MinPasswordLength      int   `json:"min_password_length"`
+	DailyRequestQuota      int   `json:"daily_request_quota"`       // 0: unlimited
+	MaxOwnedHalls          int   `json:"max_owned_halls"`           // 0: unlimited
+	MaxHallRooms           int   `json:"max_hall_rooms"`            // 0: unlimited
+	MaxHallMembers         int   `json:"max_hall_members"`          // 0: unlimited
+	MaxHallAttachmentBytes int64 `json:"max_hall_attachment_bytes"` // 0: unlimited
+	WSMessageLimit         int   `json:"ws_message_limit"`
+	WSMessageWindowMs      int   `json:"ws_message_window_ms"`
+	WSMessageBurst         int   `json:"ws_message_burst"`
+	WSMaxFrameBytes        int   `json:"ws_max_frame_bytes"`
+}
+
+type CapabilityVersion struct {
+	API         []int    `json:"api"`
+	WS          []int    `json:"ws"`
+	WSEncodings []string `json:"ws_encodings"`
+	WSIntents   []string `json:"ws_intents"`
+}
+
+// serverFeatures lists the optional features clients can check for
+var serverFeatures = []string{
+	"direct_messages",
+	"dm_privacy",
+	"reactions",
+	"top_messages",
+	"automod",
+	"audit_log",
+	"room_archive",
+	"temporary_rooms",
+	"session_management",
+	"password_change",
+	"webhook_signatures",
+	"outgoing_webhooks",
+	"usage_quota",
+	"hall_quotas",
+	"voice_rooms",
+	"encrypted_dms",
+	"email_verification",
+	"cookie_sessions",
+	"scoped_tokens",
+	"legal_holds",
+	"slash_commands",
+	"gateway_intents",
+	"message_components",
+	"message_kinds",
+	"localized_errors",
+	"invite_qr_codes",
+	"join_requests",
+	"mention_everyone",
+	"dnd_windows",
+}
+
+func (s *Server) capabilities() Capabilities {
+	cfg := s.config.Load()
+	var captcha *CapabilityCaptcha
+	if s.captcha != nil {
+		captcha = &CapabilityCaptcha{
+			Provider:      s.captcha.provider,
+			LoginFailures: s.captcha.loginFailures,
+		}
+		if s.captcha.provider == CaptchaPoW {
+			captcha.Difficulty = s.captcha.difficulty
+		} else {
+			captcha.SiteKey = s.captcha.siteKey
+		}
+	}
+
+	return Capabilities{
+		Features:     serverFeatures,
+		Registration: cfg.Registration,
+		Captcha:      captcha,
+		Limits: CapabilityLimits{
+			MaxMessageLength:       cfg.MaxMessageLength,
+			MaxRoomNameLength:      store.MaxRoomNameLength,
+			MinUsernameLength:      s.policy.MinUsernameLength,
+			MaxUsernameLength:      s.policy.MaxUsernameLength,
+			MinPasswordLength:      s.policy.MinPasswordLength,
+			DailyRequestQuota:      s.auth.Usage().Quota(),
+			MaxOwnedHalls:          cfg.MaxOwnedHalls,
+			MaxHallRooms:           cfg.MaxHallRooms,
+			MaxHallMembers:         cfg.MaxHallMembers,
+			MaxHallAttachmentBytes: cfg.MaxHallAttachmentBytes,
+			WSMessageLimit:         ws.MessageLimit,
+			WSMessageWindowMs:      int(ws.MessageWindow.Milliseconds()),
+			WSMessageBurst:         ws.MessageBurst,
+			WSMaxFrameBytes:        int(cfg.WSMaxFrameBytes),
+		},
+		Protocols: CapabilityVersion{
+			API:         []int{apiVersion},
+			WS:          ws.Versions(),
+			WSEncodings: ws.CodecNames,
+			WSIntents:   ws.IntentNames,
+		},
+		MessageKinds: store.MessageKinds,
+		Languages:    i18n.Languages,
+		Language:     cfg.DefaultLanguage,
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/store"
+)
+
+// With captcha set, registering always needs a solved captcha and logging in
+// needs one once an IP or username has failed captcha_login_failures times.
+// hCaptcha and Turnstile tokens are checked with the provider; "pow" is a
+// proof-of-work the client solves itself:
+//
+//	GET /api/captcha/challenge -> {"challenge": "...", "difficulty": 20}
+//	find a nonce so that SHA-256(challenge + ":" + nonce) starts with
+//	difficulty zero bits, and send "captcha": challenge + ":" + nonce
+//
+// Challenges are signed, expire after captchaChallengeTTL and work once.
+const (
+	captchaChallengeTTL  = 5 * time.Minute
+	captchaFailureWindow = 15 * time.Minute
+	captchaVerifyTimeout = 10 * time.Second
+)
+
+var captchaVerifyURLs = map[string]string{
+	CaptchaHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	CaptchaTurnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// CaptchaGuard verifies captchas and keeps count of failed logins
+type CaptchaGuard struct {
+	provider      string
+	siteKey       string
+	secret        string // the provider's, or what pow challenges are signed with
+	difficulty    int
+	loginFailures int
+	client        *http.Client
+	challenges    *NonceCache
+
+	failures map[string]*loginFailures
+	mutex    sync.Mutex
+	logger   *log.Logger
+}
+
+func NewCaptchaGuard(cfg *Config, logger *log.Logger) *CaptchaGuard {
+	return &CaptchaGuard{
+		provider:      cfg.Captcha,
+		siteKey:       cfg.CaptchaSiteKey,
+		secret:        cfg.CaptchaSecret,
+		difficulty:    cfg.CaptchaDifficulty,
+		loginFailures: cfg.CaptchaLoginFailures,
+		client:        &http.Client{Timeout: captchaVerifyTimeout},
+		challenges:    NewNonceCache(captchaChallengeTTL),
+		failures:      make(map[string]*loginFailures),
+		logger:        logger,
+	}
+}
+
+// Challenge issues a proof-of-work challenge
+func (g *CaptchaGuard) Challenge() (string, time.Time, error) {
+	random, err := store.GenerateInviteCode()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(captchaChallengeTTL)
+	payload := random + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + g.signChallenge(payload), expiresAt, nil
+}
+
+func (g *CaptchaGuard) signChallenge(payload string) string {
+	mac := hmac.New(sha256.New, []byte(g.secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether response is a solved captcha. An error means the
+// provider couldn't be asked.
+func (g *CaptchaGuard) Verify(ctx context.Context, response, remoteIP string) (bool, error) {
+	if g.provider == CaptchaPoW {
+		return g.verifyPoW(response), nil
+	}
+
+	form := url.Values{
+		"secret":   {g.secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	}
+	if g.provider == CaptchaHCaptcha {
+		form.Set("sitekey", g.siteKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, captchaVerifyURLs[g.provider], strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s siteverify returned %s", g.provider, resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if !result.Success && len(result.ErrorCodes) > 0 {
+		g.logger.Printf("%s rejected captcha: %s", g.provider, strings.Join(result.ErrorCodes, ", "))
+	}
+	return result.Success, nil
+}
+
+func (g *CaptchaGuard) verifyPoW(response string) bool {
+	challenge, nonce, ok := strings.Cut(response, ":")
+	if !ok || nonce == "" {
+		return false
+	}
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(g.signChallenge(payload)), []byte(parts[2])) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(response))) < g.difficulty {
+		return false
+	}
+
+	// Only burn the challenge once the work is known to be done
+	return g.challenges.Use(challenge)
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// LoginNeedsCaptcha reports whether a login from ip as username has to come
+// with a captcha
+func (g *CaptchaGuard) LoginNeedsCaptcha(ip, username string) bool {
+	if g.loginFailures == 0 {
+		return true
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	for _, key := range loginFailureKeys(ip, username) {
+		f, ok := g.failures[key]
+		if ok && f.count >= g.loginFailures && time.Since(f.last) < captchaFailureWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordLoginFailure counts a failed login against both the IP and the
+// username, so neither spreading guesses over accounts nor over addresses
+// gets around the captcha
+func (g *CaptchaGuard) RecordLoginFailure(ip, username string) {
+	now := time.Now()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Drop stale counts while we hold the lock
+	for key, f := range g.failures {
+		if now.Sub(f.last) >= captchaFailureWindow {
+			delete(g.failures, key)
+		}
+	}
+
+	for _, key := range loginFailureKeys(ip, username) {
+		f, ok := g.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			g.failures[key] = f
+		}
+		f.count++
+		f.last = now
+	}
+}
+
+// ClearLoginFailures forgets the failures of a username once it logs in
+func (g *CaptchaGuard) ClearLoginFailures(username string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.failures, "user:"+strings.ToLower(username))
+}
+
+func loginFailureKeys(ip, username string) []string {
+	return []string{"ip:" + ip, "user:" + strings.ToLower(username)}
+}
+
+// checkCaptcha makes sure a register or login came with a solved captcha,
+// and otherwise responds and returns false
+func (s *Server) checkCaptcha(w http.ResponseWriter, r *http.Request, response string) bool {
+	if response == "" {
+		api.RespondErrorCode(w, api.ErrCodeCaptchaRequired, "A captcha is required", http.StatusForbidden)
+		return false
+	}
+
+	ok, err := s.captcha.Verify(r.Context(), response, api.ClientIP(r))
+	if err != nil {
+		s.logger.Printf("Failed to verify captcha: %v", err)
+		api.RespondError(w, "Failed to verify captcha", http.StatusBadGateway)
+		return false
+	}
+	if !ok {
+		api.RespondErrorCode(w, api.ErrCodeCaptchaFailed, "The captcha was not solved", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleCaptchaChallenge serves GET /api/captcha/challenge, a fresh
+// proof-of-work challenge when captcha is "pow"
+func (s *Server) handleCaptchaChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.captcha == nil || s.captcha.provider != CaptchaPoW {
+		api.RespondError(w, "Proof-of-work captcha is disabled", http.StatusNotFound)
+		return
+	}
+
+	challenge, expiresAt, err := s.captcha.Challenge()
+	if err != nil {
+		api.RespondError(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"challenge":  challenge,
+		"difficulty": s.captcha.difficulty,
+		"expires_at": expiresAt.UTC(),
+	})
+}
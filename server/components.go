@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// handleMessageInteraction serves POST /api/messages/id/{message_id}/interactions,
+// which clicks a button of the message or picks from one of its selects.
+// Nothing about the message changes; whoever posted it hears about it over
+// ws, and the hall's webhooks get it too.
+func (s *Server) handleMessageInteraction(w http.ResponseWriter, r *http.Request, message *store.Message, room *store.Room) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := auth.SessionFromContext(r.Context())
+
+	var req struct {
+		CustomID string   `json:"custom_id"`
+		Values   []string `json:"values"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if room.Archived {
+		api.RespondError(w, "This room is archived", http.StatusForbidden)
+		return
+	}
+
+	component := message.FindComponent(req.CustomID)
+	if component == nil {
+		api.RespondError(w, "Component not found", http.StatusNotFound)
+		return
+	}
+	if component.Disabled {
+		api.RespondError(w, "This component is disabled", http.StatusConflict)
+		return
+	}
+	if err := component.CheckValues(req.Values); err != nil {
+		api.RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := store.GenerateInviteCode()
+	if err != nil {
+		api.RespondError(w, "Failed to create interaction", http.StatusInternalServerError)
+		return
+	}
+	values := req.Values
+	if values == nil {
+		values = []string{}
+	}
+	interaction := ws.InteractionData{
+		ID:            id,
+		MessageID:     message.ID,
+		RoomID:        room.ID,
+		HallID:        room.HallID,
+		CustomID:      component.CustomID,
+		ComponentType: component.Type,
+		Values:        values,
+		UserID:        session.UserID,
+		Username:      session.Username,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+	}
+
+	s.wsManager.SendToUser(message.UserID, "interaction_created", interaction)
+	if payload, err := json.Marshal(interaction); err == nil {
+		s.webhooks.RoomEvent(room.ID, "interaction_created", payload)
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"interaction": interaction,
+	})
+}
+
+// handleMessageComponents serves PUT /api/messages/id/{message_id}/components,
+// which lets whoever posted a message change its components, e.g. to disable
+// the buttons of a request once it's been approved
+func (s *Server) handleMessageComponents(w http.ResponseWriter, r *http.Request, message *store.Message) {
+	if r.Method != http.MethodPut {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := auth.SessionFromContext(r.Context())
+
+	if message.UserID != session.UserID {
+		api.RespondError(w, "Only the message's author can change its components", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Components []store.MessageComponent `json:"components"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	if err := store.ValidateComponents(req.Components); err != nil {
+		api.RespondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Components == nil {
+		req.Components = []store.MessageComponent{}
+	}
+
+	if err := s.db.SetMessageComponents(r.Context(), message, req.Components); err != nil {
+		s.logger.Printf("Failed to set components of message %d: %v", message.ID, err)
+		api.RespondError(w, "Failed to update components", http.StatusInternalServerError)
+		return
+	}
+	message.Components = req.Components
+
+	s.wsManager.BroadcastToRoom(message.RoomID, "message_components_updated", ws.MessageComponentsData{
+		MessageID:  message.ID,
+		RoomID:     message.RoomID,
+		Components: req.Components,
+	})
+
+	api.RespondJSON(w, map[string]interface{}{
+		"message": message,
+	})
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressionMiddleware gzips (or deflates) responses of at least threshold
+// bytes for clients that accept it. Smaller responses go out as they are,
+// since compressing them costs more than it saves. Streaming paths are left
+// alone: the websocket upgrade needs the raw connection and SSE events have
+// to reach the client the moment they're written.
+func compressionMiddleware(next http.Handler, cfg *Config) http.Handler {
+	if !cfg.HTTPCompression {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, threshold: cfg.HTTPCompressionThreshold}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if the client takes neither
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[name] = true
+	}
+
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressibleType reports whether a Content-Type is text worth compressing
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/javascript" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+// compressWriter holds the start of a response back until it knows whether
+// the response reaches the threshold, then either compresses everything or
+// passes it through untouched
+type compressWriter struct {
+	http.ResponseWriter
+	encoding  string
+	threshold int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when the response goes out uncompressed
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = code
+
+	// Responses that can't have a body have nothing to compress
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.threshold {
+			return len(b), nil
+		}
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start sends the headers and whatever is buffered, compressed if compress
+// is set and the response is something worth compressing
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	// Partial content and responses that are already encoded go out as is
+	if compress && cw.status != http.StatusPartialContent && header.Get("Content-Encoding") == "" &&
+		header.Get("Content-Range") == "" && compressibleType(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// The compressed bytes differ, so a strong validator can't stay strong
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		if cw.encoding == "gzip" {
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.enc = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if cw.enc != nil {
+		_, err := cw.enc.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends what's been written so far, so streamed exports keep moving.
+// A response flushed before it reaches the threshold isn't compressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.start(false)
+	}
+	if flusher, ok := cw.enc.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the response once the handler is done
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,1244 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Config holds the server settings. Values come from, in increasing order of
+// precedence: the defaults, a YAML config file, COMMONS_* environment
+// variables and command line flags. Server.Reload can change some of them
+// while the server runs, see reloadableSettings.
+type Config struct {
+	BindAddress string        `yaml:"bind_address"`
+	Port        int           `yaml:"port"`
+	DBPath      string        `yaml:"db_path"`
+	CORSOrigins []string      `yaml:"cors_origins"` // "*" allows any origin
+	StaticDir   string        `yaml:"static_dir"`   // empty disables the web UI
+	ExportDir   string        `yaml:"export_dir"`   // where hall exports are written
+	SessionTTL  time.Duration `yaml:"session_ttl"`
+
+	// The database connection pool. SQLite takes one writer at a time, so
+	// more open connections only queue up on its lock; idle ones are kept up
+	// to the same number so they, and their prepared statements, aren't
+	// reopened under load. DBMaxIdleConns over DBMaxOpenConns is cut down to
+	// it, and DBConnMaxLifetime 0 keeps connections for good.
+	DBMaxOpenConns    int           `yaml:"db_max_open_conns"`
+	DBMaxIdleConns    int           `yaml:"db_max_idle_conns"`
+	DBConnMaxLifetime time.Duration `yaml:"db_conn_max_lifetime"`
+
+	// MessageCacheRooms is how many rooms keep their newest messages and
+	// events in memory for history and resume; 0 turns the cache off. It's
+	// always off when Clustered, since other instances write the database too.
+	MessageCacheRooms int `yaml:"message_cache_rooms"`
+
+	// JournalDir, if set, gets every stored message and DM appended to a
+	// JSONL journal there as well, to rebuild or audit history from if the
+	// database is lost. Files are rotated at JournalMaxBytes and gzipped,
+	// keeping the JournalKeep newest (0 keeps them all).
+	JournalDir      string `yaml:"journal_dir"`
+	JournalMaxBytes int64  `yaml:"journal_max_bytes"`
+	JournalKeep     int    `yaml:"journal_keep"`
+
+	// BackupDir is where database snapshots are written, every
+	// BackupInterval (0 only takes them on request) and by
+	// POST /api/admin/backup, keeping the BackupKeep newest (0 keeps them
+	// all).
+	BackupDir      string        `yaml:"backup_dir"`
+	BackupInterval time.Duration `yaml:"backup_interval"`
+	BackupKeep     int           `yaml:"backup_keep"`
+
+	// TrustedProxies are the reverse proxies, as IPs or CIDR ranges, whose
+	// X-Forwarded-For and X-Forwarded-Proto headers are believed
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// RequestTimeout is the deadline for the database work of one HTTP request
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// DefaultLanguage is what error messages are written in for clients
+	// whose Accept-Language has none of i18n.Languages, and what system
+	// messages are written in
+	DefaultLanguage string `yaml:"default_language"`
+
+	// DefaultHall, if set, is a hall every new account joins. It's created
+	// at startup, owned by the system user, with DefaultHallRooms.
+	DefaultHall      string   `yaml:"default_hall"`
+	DefaultHallRooms []string `yaml:"default_hall_rooms"`
+
+	// UsernameChangeCooldown is how long users wait between renaming
+	// themselves; 0 lets them rename any time
+	UsernameChangeCooldown time.Duration `yaml:"username_change_cooldown"`
+
+	// Registration is who may create an account at /api/register: anyone
+	// ("open"), people with a server invite ("invite") or nobody ("closed")
+	Registration string `yaml:"registration"`
+
+	// GuestAccess lets visitors without an account read rooms that hall
+	// admins made public, over REST and a read-only websocket
+	GuestAccess bool `yaml:"guest_access"`
+
+	// PublicArchive serves the history of public rooms as web pages under
+	// /archive/, a day per page, for anyone to read and link to
+	PublicArchive bool `yaml:"public_archive"`
+
+	// Captcha makes registering, and logging in after CaptchaLoginFailures
+	// failed attempts from an IP or for a username, need a solved captcha:
+	// "hcaptcha" and "turnstile" verify with the provider using
+	// CaptchaSiteKey and CaptchaSecret, "pow" is a proof-of-work of
+	// CaptchaDifficulty bits signed with CaptchaSecret. Empty turns it off.
+	Captcha              string `yaml:"captcha"`
+	CaptchaSiteKey       string `yaml:"captcha_site_key"`
+	CaptchaSecret        string `yaml:"captcha_secret"`
+	CaptchaDifficulty    int    `yaml:"captcha_difficulty"`
+	CaptchaLoginFailures int    `yaml:"captcha_login_failures"` // 0: every login
+
+	// HTTPS, either with a certificate and key from disk or with certificates
+	// from Let's Encrypt for AutocertDomains. HTTPRedirectPort, if set, serves
+	// plain HTTP redirects to HTTPS (and ACME challenges for autocert).
+	TLSCertFile      string   `yaml:"tls_cert_file"`
+	TLSKeyFile       string   `yaml:"tls_key_file"`
+	AutocertDomains  []string `yaml:"autocert_domains"`
+	AutocertEmail    string   `yaml:"autocert_email"`
+	AutocertCacheDir string   `yaml:"autocert_cache_dir"`
+	HTTPRedirectPort int      `yaml:"http_redirect_port"`
+
+	// PprofAddress serves net/http/pprof on its own listener, kept off the
+	// public port. Empty disables it.
+	PprofAddress string `yaml:"pprof_address"`
+
+	// WSCompression negotiates permessage-deflate with clients that support
+	// it; frames smaller than WSCompressionThreshold bytes go uncompressed
+	WSCompression          bool `yaml:"ws_compression"`
+	WSCompressionThreshold int  `yaml:"ws_compression_threshold"`
+
+	// HTTPCompression gzips or deflates HTTP responses for clients that
+	// accept it; responses under HTTPCompressionThreshold bytes go uncompressed
+	HTTPCompression          bool `yaml:"http_compression"`
+	HTTPCompressionThreshold int  `yaml:"http_compression_threshold"`
+
+	// MaxMessageLength caps room and DM messages, in characters.
+	// WSMaxFrameBytes caps incoming websocket frames; bigger ones are
+	// discarded with an error instead of closing the connection.
+	// MaxBodyBytes caps HTTP request bodies; bigger ones get a 413.
+	MaxMessageLength int   `yaml:"max_message_length"`
+	WSMaxFrameBytes  int64 `yaml:"ws_max_frame_bytes"`
+	MaxBodyBytes     int64 `yaml:"max_body_bytes"`
+
+	// Caps on simultaneous ws and SSE connections per account and per client
+	// IP (0 is unlimited). Over the cap, WSConnectionLimitMode "reject"
+	// turns the new connection away and "evict" closes the oldest one.
+	WSMaxConnectionsPerUser int    `yaml:"ws_max_connections_per_user"`
+	WSMaxConnectionsPerIP   int    `yaml:"ws_max_connections_per_ip"`
+	WSConnectionLimitMode   string `yaml:"ws_connection_limit_mode"`
+
+	// Quotas, 0 for no limit: how many halls one account may own, and how
+	// many rooms and members a hall may have. They're checked when a hall or
+	// room is created or someone joins, so halls already over a quota keep
+	// what they have. MaxHallAttachmentBytes adds up the sizes image and
+	// file messages give, checked when one is sent.
+	MaxOwnedHalls          int   `yaml:"max_owned_halls"`
+	MaxHallRooms           int   `yaml:"max_hall_rooms"`
+	MaxHallMembers         int   `yaml:"max_hall_members"`
+	MaxHallAttachmentBytes int64 `yaml:"max_hall_attachment_bytes"`
+
+	// DailyTokenQuota is how many authenticated API requests one account may
+	// make per UTC day, across all of its tokens; 0 for no limit
+	DailyTokenQuota int `yaml:"daily_token_quota"`
+
+	// A drain, on SIGTERM or from /api/admin/drain, closes ws and SSE
+	// connections spread over DrainPeriod, telling clients to reconnect to
+	// the instance at base URL DrainReconnectURL or, if it's empty, wherever
+	// they connected before
+	DrainPeriod       time.Duration `yaml:"drain_period"`
+	DrainReconnectURL string        `yaml:"drain_reconnect_url"`
+
+	// SMTP server for email notifications about mentions and DMs received
+	// while offline; empty SMTPHost disables them. Notifications are batched
+	// into one digest per EmailDigestWindow.
+	SMTPHost          string        `yaml:"smtp_host"`
+	SMTPPort          int           `yaml:"smtp_port"`
+	SMTPUsername      string        `yaml:"smtp_username"`
+	SMTPPassword      string        `yaml:"smtp_password"`
+	SMTPFrom          string        `yaml:"smtp_from"`
+	EmailDigestWindow time.Duration `yaml:"email_digest_window"`
+
+	// RequireVerifiedEmail keeps accounts that haven't confirmed an email
+	// address from creating halls. Confirmation links go out over SMTP.
+	RequireVerifiedEmail bool `yaml:"require_verified_email"`
+
+	// FeedSecret signs the access tokens of announcement room feeds; empty
+	// disables the feeds. Changing it revokes every feed URL handed out.
+	FeedSecret string `yaml:"feed_secret"`
+
+	// Outgoing webhook deliveries are tried WebhookMaxAttempts times, backing
+	// off between tries, before they're dead-lettered. Webhooks can't point at
+	// loopback or private addresses unless WebhookAllowPrivate is set.
+	WebhookMaxAttempts  int  `yaml:"webhook_max_attempts"`
+	WebhookAllowPrivate bool `yaml:"webhook_allow_private"`
+
+	// SentryDSN, if set, sends panics, failures to save or broadcast
+	// messages and dead-lettered webhook deliveries to Sentry, tagged with
+	// SentryEnvironment
+	SentryDSN         string `yaml:"sentry_dsn"`
+	SentryEnvironment string `yaml:"sentry_environment"`
+
+	// RedisURL, if set, fans websocket broadcasts out over Redis pub/sub so
+	// several instances can run behind a load balancer
+	RedisURL     string `yaml:"redis_url"`
+	RedisChannel string `yaml:"redis_channel"`
+
+	// NATSURL does the same over a NATS subject instead, for setups that
+	// already run NATS. Only one of RedisURL and NATSURL may be set.
+	NATSURL     string `yaml:"nats_url"`
+	NATSSubject string `yaml:"nats_subject"`
+
+	// NodeID goes into every ID this instance makes, so instances sharing
+	// a database need different ones
+	NodeID int `yaml:"node_id"`
+}
+
+// Clustered reports whether broadcasts are shared with other instances,
+// which then use the same database
+func (c *Config) Clustered() bool {
+	return c.RedisURL != "" || c.NATSURL != ""
+}
+
+// store.Options opens the configured database. Other instances write to
+// the same database without the message cache hearing about it, so it's
+// only kept by a single instance.
+func (c *Config) DatabaseOptions() store.Options {
+	opts := store.Options{
+		Path:            c.DBPath,
+		MaxOpenConns:    c.DBMaxOpenConns,
+		MaxIdleConns:    c.DBMaxIdleConns,
+		ConnMaxLifetime: c.DBConnMaxLifetime,
+		NodeID:          c.NodeID,
+	}
+	if !c.Clustered() {
+		opts.CacheRooms = c.MessageCacheRooms
+	}
+	return opts
+}
+
+// ws.Options applies the configured connection limits to the websocket
+// manager
+func (c *Config) WSOptions() ws.Options {
+	return ws.Options{
+		Compression:           c.WSCompression,
+		CompressionThreshold:  c.WSCompressionThreshold,
+		MaxFrameBytes:         c.WSMaxFrameBytes,
+		MaxMessageLength:      c.MaxMessageLength,
+		MaxHallAttachments:    c.MaxHallAttachmentBytes,
+		MaxConnectionsPerUser: c.WSMaxConnectionsPerUser,
+		MaxConnectionsPerIP:   c.WSMaxConnectionsPerIP,
+		EvictOldest:           c.WSConnectionLimitMode == WSConnectionLimitEvict,
+		DefaultLanguage:       c.DefaultLanguage,
+	}
+}
+
+// Captcha providers, see Config.Captcha
+const (
+	CaptchaHCaptcha  = "hcaptcha"
+	CaptchaTurnstile = "turnstile"
+	CaptchaPoW       = "pow"
+)
+
+// maxCaptchaDifficulty keeps proof-of-work challenges solvable in a browser,
+// and pow challenges are signed with at least minCaptchaSecretLength bytes
+const (
+	maxCaptchaDifficulty   = 32
+	minCaptchaSecretLength = 32
+)
+
+// minFeedSecretLength keeps feed tokens from being signed with something
+// guessable
+const minFeedSecretLength = 32
+
+// Who may register, see Config.Registration
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite" // needs a token from an instance admin
+	RegistrationClosed     = "closed"
+)
+
+// What happens to a connection over the per-user or per-IP limit
+const (
+	WSConnectionLimitReject = "reject" // the new connection is turned away
+	WSConnectionLimitEvict  = "evict"  // the oldest connection is closed
+)
+
+func DefaultConfig() *Config {
+	return &Config{
+		BindAddress: "",
+		Port:        8080,
+		DBPath:      "chat.db",
+		CORSOrigins: []string{"*"},
+		StaticDir:   "../commons-webui",
+		ExportDir:   "exports",
+		SessionTTL:  24 * time.Hour,
+
+		DBMaxOpenConns: 8,
+		DBMaxIdleConns: 8,
+
+		MessageCacheRooms: 256,
+
+		JournalMaxBytes: 64 << 20,
+
+		BackupDir:  "backups",
+		BackupKeep: 7,
+
+		RequestTimeout: 10 * time.Second,
+
+		DefaultLanguage: i18n.DefaultLanguage,
+
+		DefaultHallRooms: []string{"#general"},
+		Registration:     RegistrationOpen,
+
+		UsernameChangeCooldown: 30 * 24 * time.Hour,
+
+		CaptchaDifficulty:    20,
+		CaptchaLoginFailures: 3,
+
+		AutocertCacheDir: "certs",
+
+		WSCompression:          true,
+		WSCompressionThreshold: 512,
+
+		HTTPCompression:          true,
+		HTTPCompressionThreshold: 1024,
+
+		MaxMessageLength: 4000,
+		WSMaxFrameBytes:  32 * 1024,
+		MaxBodyBytes:     1 << 20,
+
+		WSMaxConnectionsPerUser: 10,
+		WSMaxConnectionsPerIP:   50,
+		WSConnectionLimitMode:   WSConnectionLimitReject,
+
+		DailyTokenQuota: 10000,
+
+		DrainPeriod: 30 * time.Second,
+
+		SMTPPort:          587,
+		EmailDigestWindow: 15 * time.Minute,
+
+		WebhookMaxAttempts: 6,
+
+		RedisChannel: "commons:broadcast",
+		NATSSubject:  "commons.broadcast",
+	}
+}
+
+// Addr is the address to listen on
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port))
+}
+
+// TLSEnabled reports whether the server speaks HTTPS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
+// LoadConfig builds the config from the command line arguments (without the
+// program name), the config file they or COMMONS_CONFIG point at, and the
+// environment. A bare positional argument is still taken as the database path.
+func LoadConfig(args []string) (*Config, error) {
+	return LoadConfigFlags(flag.NewFlagSet("commons-api", flag.ContinueOnError), args)
+}
+
+// LoadConfigFlags is LoadConfig for subcommands that add their own flags to
+// fs before the config flags are registered
+func LoadConfigFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	configPath := fs.String("config", os.Getenv("COMMONS_CONFIG"), "path to a YAML config file")
+	bind := fs.String("bind", "", "address to bind to")
+	port := fs.Int("port", 0, "port to listen on")
+	dbPath := fs.String("db", "", "path to the SQLite database")
+	dbMaxOpenConns := fs.Int("db-max-open-conns", 0, "most database connections open at once")
+	dbMaxIdleConns := fs.Int("db-max-idle-conns", 0, "most idle database connections kept for reuse")
+	dbConnMaxLifetime := fs.Duration("db-conn-max-lifetime", 0, "how long a database connection is reused, 0 for no limit")
+	messageCacheRooms := fs.Int("message-cache-rooms", 0, "rooms whose newest messages are kept in memory, 0 to turn the cache off")
+	journalDir := fs.String("journal-dir", "", "directory to journal every stored message to, empty for none")
+	journalMaxBytes := fs.Int64("journal-max-bytes", 0, "size, in bytes, at which the message journal is rotated")
+	journalKeep := fs.Int("journal-keep", 0, "rotated message journal files to keep, 0 for all")
+	backupDir := fs.String("backup-dir", "", "directory to write database backups to")
+	backupInterval := fs.Duration("backup-interval", 0, "how often to back up the database, 0 for only on request")
+	backupKeep := fs.Int("backup-keep", 0, "database backups to keep, 0 for all")
+	cors := fs.String("cors-origins", "", "comma-separated allowed CORS origins")
+	staticDir := fs.String("static-dir", "", "directory with the web UI")
+	exportDir := fs.String("export-dir", "", "directory to write hall exports to")
+	sessionTTL := fs.Duration("session-ttl", 0, "how long sessions last")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated IPs or CIDR ranges of reverse proxies to trust X-Forwarded-* headers from")
+	requestTimeout := fs.Duration("request-timeout", 0, "deadline for each HTTP request's database work")
+	defaultLanguage := fs.String("default-language", "", "language for system messages and clients that ask for none we have")
+	defaultHall := fs.String("default-hall", "", "name of the hall every new account joins, empty for none")
+	defaultHallRooms := fs.String("default-hall-rooms", "", "comma-separated rooms the default hall is created with")
+	usernameChangeCooldown := fs.Duration("username-change-cooldown", 0, "how long users wait between username changes")
+	registration := fs.String("registration", "", "who may register: open, invite or closed")
+	guestAccess := fs.Bool("guest-access", false, "let visitors without an account read public rooms")
+	publicArchive := fs.Bool("public-archive", false, "serve the history of public rooms as web pages under /archive/")
+	captcha := fs.String("captcha", "", "captcha for register and login: hcaptcha, turnstile or pow, empty for none")
+	captchaSiteKey := fs.String("captcha-site-key", "", "hCaptcha or Turnstile site key")
+	captchaSecret := fs.String("captcha-secret", "", "hCaptcha or Turnstile secret, or the key pow challenges are signed with")
+	captchaDifficulty := fs.Int("captcha-difficulty", 0, "leading zero bits a pow captcha needs")
+	captchaLoginFailures := fs.Int("captcha-login-failures", 0, "failed logins before login needs a captcha, 0 for always")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS private key file")
+	autocertDomains := fs.String("autocert-domains", "", "comma-separated domains to get Let's Encrypt certificates for")
+	autocertEmail := fs.String("autocert-email", "", "contact email for Let's Encrypt")
+	autocertCache := fs.String("autocert-cache", "", "directory to cache certificates in")
+	redirectPort := fs.Int("http-redirect-port", 0, "port to redirect plain HTTP to HTTPS from")
+	pprofAddr := fs.String("pprof-addr", "", "address to serve pprof on, e.g. 127.0.0.1:6060")
+	wsCompression := fs.Bool("ws-compression", false, "compress websocket frames with permessage-deflate")
+	wsCompressionThreshold := fs.Int("ws-compression-threshold", 0, "smallest websocket frame, in bytes, worth compressing")
+	httpCompression := fs.Bool("http-compression", false, "compress HTTP responses with gzip or deflate")
+	httpCompressionThreshold := fs.Int("http-compression-threshold", 0, "smallest HTTP response, in bytes, worth compressing")
+	maxMessageLength := fs.Int("max-message-length", 0, "longest room or DM message, in characters")
+	wsMaxFrameBytes := fs.Int64("ws-max-frame-bytes", 0, "largest websocket frame clients may send, in bytes")
+	maxBodyBytes := fs.Int64("max-body-bytes", 0, "largest HTTP request body clients may send, in bytes")
+	wsMaxPerUser := fs.Int("ws-max-connections-per-user", 0, "simultaneous websocket connections allowed per account, 0 for no limit")
+	wsMaxPerIP := fs.Int("ws-max-connections-per-ip", 0, "simultaneous websocket connections allowed per IP, 0 for no limit")
+	wsLimitMode := fs.String("ws-connection-limit-mode", "", "what to do over the connection limit: reject or evict")
+	maxOwnedHalls := fs.Int("max-owned-halls", 0, "halls one account may own, 0 for no limit")
+	maxHallRooms := fs.Int("max-hall-rooms", 0, "rooms a hall may have, 0 for no limit")
+	maxHallMembers := fs.Int("max-hall-members", 0, "members a hall may have, 0 for no limit")
+	maxHallAttachmentBytes := fs.Int64("max-hall-attachment-bytes", 0, "bytes of image and file messages a hall may have, 0 for no limit")
+	dailyTokenQuota := fs.Int("daily-token-quota", 0, "authenticated API requests one account may make per day, 0 for no limit")
+	drainPeriod := fs.Duration("drain-period", 0, "how long a drain takes to close every connection")
+	drainReconnectURL := fs.String("drain-reconnect-url", "", "base URL drained clients are told to reconnect to, empty for the same place")
+	smtpHost := fs.String("smtp-host", "", "SMTP server for email notifications")
+	smtpPort := fs.Int("smtp-port", 0, "SMTP server port")
+	smtpUsername := fs.String("smtp-username", "", "SMTP username")
+	smtpPassword := fs.String("smtp-password", "", "SMTP password")
+	smtpFrom := fs.String("smtp-from", "", "sender address for notification emails")
+	emailDigestWindow := fs.Duration("email-digest-window", 0, "how long notifications are collected before they're emailed")
+	requireVerifiedEmail := fs.Bool("require-verified-email", false, "only let accounts with a verified email create halls")
+	feedSecret := fs.String("feed-secret", "", "secret to sign announcement feed tokens with")
+	webhookMaxAttempts := fs.Int("webhook-max-attempts", 0, "tries at delivering a webhook before it's dead-lettered")
+	webhookAllowPrivate := fs.Bool("webhook-allow-private", false, "let webhooks point at loopback and private addresses")
+	sentryDSN := fs.String("sentry-dsn", "", "Sentry DSN to report errors to")
+	sentryEnvironment := fs.String("sentry-environment", "", "environment errors are reported to Sentry under, e.g. production")
+	redisURL := fs.String("redis-url", "", "Redis URL for broadcasting between instances, e.g. redis://localhost:6379/0")
+	redisChannel := fs.String("redis-channel", "", "Redis pub/sub channel for broadcasts")
+	natsURL := fs.String("nats-url", "", "NATS URL for broadcasting between instances, e.g. nats://localhost:4222")
+	natsSubject := fs.String("nats-subject", "", "NATS subject for broadcasts")
+	nodeID := fs.Int("node-id", 0, "this instance's node ID, different for every instance sharing a database")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		cfg.DBPath = fs.Arg(0)
+	}
+
+	// Only flags that were actually given override the file and environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "bind":
+			cfg.BindAddress = *bind
+		case "port":
+			cfg.Port = *port
+		case "db":
+			cfg.DBPath = *dbPath
+		case "db-max-open-conns":
+			cfg.DBMaxOpenConns = *dbMaxOpenConns
+		case "db-max-idle-conns":
+			cfg.DBMaxIdleConns = *dbMaxIdleConns
+		case "db-conn-max-lifetime":
+			cfg.DBConnMaxLifetime = *dbConnMaxLifetime
+		case "message-cache-rooms":
+			cfg.MessageCacheRooms = *messageCacheRooms
+		case "journal-dir":
+			cfg.JournalDir = *journalDir
+		case "journal-max-bytes":
+			cfg.JournalMaxBytes = *journalMaxBytes
+		case "journal-keep":
+			cfg.JournalKeep = *journalKeep
+		case "backup-dir":
+			cfg.BackupDir = *backupDir
+		case "backup-interval":
+			cfg.BackupInterval = *backupInterval
+		case "backup-keep":
+			cfg.BackupKeep = *backupKeep
+		case "cors-origins":
+			cfg.CORSOrigins = splitList(*cors)
+		case "static-dir":
+			cfg.StaticDir = *staticDir
+		case "export-dir":
+			cfg.ExportDir = *exportDir
+		case "session-ttl":
+			cfg.SessionTTL = *sessionTTL
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trustedProxies)
+		case "request-timeout":
+			cfg.RequestTimeout = *requestTimeout
+		case "default-language":
+			cfg.DefaultLanguage = *defaultLanguage
+		case "default-hall":
+			cfg.DefaultHall = *defaultHall
+		case "default-hall-rooms":
+			cfg.DefaultHallRooms = splitList(*defaultHallRooms)
+		case "username-change-cooldown":
+			cfg.UsernameChangeCooldown = *usernameChangeCooldown
+		case "registration":
+			cfg.Registration = *registration
+		case "guest-access":
+			cfg.GuestAccess = *guestAccess
+		case "public-archive":
+			cfg.PublicArchive = *publicArchive
+		case "captcha":
+			cfg.Captcha = *captcha
+		case "captcha-site-key":
+			cfg.CaptchaSiteKey = *captchaSiteKey
+		case "captcha-secret":
+			cfg.CaptchaSecret = *captchaSecret
+		case "captcha-difficulty":
+			cfg.CaptchaDifficulty = *captchaDifficulty
+		case "captcha-login-failures":
+			cfg.CaptchaLoginFailures = *captchaLoginFailures
+		case "tls-cert":
+			cfg.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.TLSKeyFile = *tlsKey
+		case "autocert-domains":
+			cfg.AutocertDomains = splitList(*autocertDomains)
+		case "autocert-email":
+			cfg.AutocertEmail = *autocertEmail
+		case "autocert-cache":
+			cfg.AutocertCacheDir = *autocertCache
+		case "http-redirect-port":
+			cfg.HTTPRedirectPort = *redirectPort
+		case "pprof-addr":
+			cfg.PprofAddress = *pprofAddr
+		case "ws-compression":
+			cfg.WSCompression = *wsCompression
+		case "ws-compression-threshold":
+			cfg.WSCompressionThreshold = *wsCompressionThreshold
+		case "http-compression":
+			cfg.HTTPCompression = *httpCompression
+		case "http-compression-threshold":
+			cfg.HTTPCompressionThreshold = *httpCompressionThreshold
+		case "max-message-length":
+			cfg.MaxMessageLength = *maxMessageLength
+		case "ws-max-frame-bytes":
+			cfg.WSMaxFrameBytes = *wsMaxFrameBytes
+		case "max-body-bytes":
+			cfg.MaxBodyBytes = *maxBodyBytes
+		case "ws-max-connections-per-user":
+			cfg.WSMaxConnectionsPerUser = *wsMaxPerUser
+		case "ws-max-connections-per-ip":
+			cfg.WSMaxConnectionsPerIP = *wsMaxPerIP
+		case "ws-connection-limit-mode":
+			cfg.WSConnectionLimitMode = *wsLimitMode
+		case "max-owned-halls":
+			cfg.MaxOwnedHalls = *maxOwnedHalls
+		case "max-hall-rooms":
+			cfg.MaxHallRooms = *maxHallRooms
+		case "max-hall-members":
+			cfg.MaxHallMembers = *maxHallMembers
+		case "max-hall-attachment-bytes":
+			cfg.MaxHallAttachmentBytes = *maxHallAttachmentBytes
+		case "daily-token-quota":
+			cfg.DailyTokenQuota = *dailyTokenQuota
+		case "drain-period":
+			cfg.DrainPeriod = *drainPeriod
+		case "drain-reconnect-url":
+			cfg.DrainReconnectURL = *drainReconnectURL
+		case "smtp-host":
+			cfg.SMTPHost = *smtpHost
+		case "smtp-port":
+			cfg.SMTPPort = *smtpPort
+		case "smtp-username":
+			cfg.SMTPUsername = *smtpUsername
+		case "smtp-password":
+			cfg.SMTPPassword = *smtpPassword
+		case "smtp-from":
+			cfg.SMTPFrom = *smtpFrom
+		case "email-digest-window":
+			cfg.EmailDigestWindow = *emailDigestWindow
+		case "require-verified-email":
+			cfg.RequireVerifiedEmail = *requireVerifiedEmail
+		case "feed-secret":
+			cfg.FeedSecret = *feedSecret
+		case "webhook-max-attempts":
+			cfg.WebhookMaxAttempts = *webhookMaxAttempts
+		case "webhook-allow-private":
+			cfg.WebhookAllowPrivate = *webhookAllowPrivate
+		case "sentry-dsn":
+			cfg.SentryDSN = *sentryDSN
+		case "sentry-environment":
+			cfg.SentryEnvironment = *sentryEnvironment
+		case "redis-url":
+			cfg.RedisURL = *redisURL
+		case "redis-channel":
+			cfg.RedisChannel = *redisChannel
+		case "nats-url":
+			cfg.NATSURL = *natsURL
+		case "nats-subject":
+			cfg.NATSSubject = *natsSubject
+		case "node-id":
+			cfg.NodeID = *nodeID
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	// Unknown keys are most likely typos, so they're errors
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	if v, ok := os.LookupEnv("COMMONS_BIND_ADDRESS"); ok {
+		c.BindAddress = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_PORT: %w", err)
+		}
+		c.Port = port
+	}
+	if v, ok := os.LookupEnv("COMMONS_DB_PATH"); ok {
+		c.DBPath = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_DB_MAX_OPEN_CONNS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_DB_MAX_OPEN_CONNS: %w", err)
+		}
+		c.DBMaxOpenConns = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_DB_MAX_IDLE_CONNS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_DB_MAX_IDLE_CONNS: %w", err)
+		}
+		c.DBMaxIdleConns = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_DB_CONN_MAX_LIFETIME"); ok {
+		lifetime, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_DB_CONN_MAX_LIFETIME: %w", err)
+		}
+		c.DBConnMaxLifetime = lifetime
+	}
+	if v, ok := os.LookupEnv("COMMONS_MESSAGE_CACHE_ROOMS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MESSAGE_CACHE_ROOMS: %w", err)
+		}
+		c.MessageCacheRooms = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_JOURNAL_DIR"); ok {
+		c.JournalDir = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_JOURNAL_MAX_BYTES"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("COMMONS_JOURNAL_MAX_BYTES: %w", err)
+		}
+		c.JournalMaxBytes = size
+	}
+	if v, ok := os.LookupEnv("COMMONS_JOURNAL_KEEP"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_JOURNAL_KEEP: %w", err)
+		}
+		c.JournalKeep = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_BACKUP_DIR"); ok {
+		c.BackupDir = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_BACKUP_INTERVAL"); ok {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_BACKUP_INTERVAL: %w", err)
+		}
+		c.BackupInterval = interval
+	}
+	if v, ok := os.LookupEnv("COMMONS_BACKUP_KEEP"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_BACKUP_KEEP: %w", err)
+		}
+		c.BackupKeep = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_CORS_ORIGINS"); ok {
+		c.CORSOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("COMMONS_STATIC_DIR"); ok {
+		c.StaticDir = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_EXPORT_DIR"); ok {
+		c.ExportDir = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SESSION_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_SESSION_TTL: %w", err)
+		}
+		c.SessionTTL = ttl
+	}
+	if v, ok := os.LookupEnv("COMMONS_TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = splitList(v)
+	}
+	if v, ok := os.LookupEnv("COMMONS_REQUEST_TIMEOUT"); ok {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_REQUEST_TIMEOUT: %w", err)
+		}
+		c.RequestTimeout = timeout
+	}
+	if v, ok := os.LookupEnv("COMMONS_DEFAULT_LANGUAGE"); ok {
+		c.DefaultLanguage = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_DEFAULT_HALL"); ok {
+		c.DefaultHall = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_DEFAULT_HALL_ROOMS"); ok {
+		c.DefaultHallRooms = splitList(v)
+	}
+	if v, ok := os.LookupEnv("COMMONS_USERNAME_CHANGE_COOLDOWN"); ok {
+		cooldown, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_USERNAME_CHANGE_COOLDOWN: %w", err)
+		}
+		c.UsernameChangeCooldown = cooldown
+	}
+	if v, ok := os.LookupEnv("COMMONS_REGISTRATION"); ok {
+		c.Registration = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_GUEST_ACCESS"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_GUEST_ACCESS: %w", err)
+		}
+		c.GuestAccess = enabled
+	}
+	if v, ok := os.LookupEnv("COMMONS_PUBLIC_ARCHIVE"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_PUBLIC_ARCHIVE: %w", err)
+		}
+		c.PublicArchive = enabled
+	}
+	if v, ok := os.LookupEnv("COMMONS_CAPTCHA"); ok {
+		c.Captcha = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_CAPTCHA_SITE_KEY"); ok {
+		c.CaptchaSiteKey = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_CAPTCHA_SECRET"); ok {
+		c.CaptchaSecret = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_CAPTCHA_DIFFICULTY"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_CAPTCHA_DIFFICULTY: %w", err)
+		}
+		c.CaptchaDifficulty = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_CAPTCHA_LOGIN_FAILURES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_CAPTCHA_LOGIN_FAILURES: %w", err)
+		}
+		c.CaptchaLoginFailures = n
+	}
+	if v, ok := os.LookupEnv("COMMONS_TLS_CERT_FILE"); ok {
+		c.TLSCertFile = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_TLS_KEY_FILE"); ok {
+		c.TLSKeyFile = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_AUTOCERT_DOMAINS"); ok {
+		c.AutocertDomains = splitList(v)
+	}
+	if v, ok := os.LookupEnv("COMMONS_AUTOCERT_EMAIL"); ok {
+		c.AutocertEmail = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_AUTOCERT_CACHE_DIR"); ok {
+		c.AutocertCacheDir = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_HTTP_REDIRECT_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_HTTP_REDIRECT_PORT: %w", err)
+		}
+		c.HTTPRedirectPort = port
+	}
+	if v, ok := os.LookupEnv("COMMONS_PPROF_ADDRESS"); ok {
+		c.PprofAddress = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_COMPRESSION"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WS_COMPRESSION: %w", err)
+		}
+		c.WSCompression = enabled
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_COMPRESSION_THRESHOLD"); ok {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WS_COMPRESSION_THRESHOLD: %w", err)
+		}
+		c.WSCompressionThreshold = threshold
+	}
+	if v, ok := os.LookupEnv("COMMONS_HTTP_COMPRESSION"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_HTTP_COMPRESSION: %w", err)
+		}
+		c.HTTPCompression = enabled
+	}
+	if v, ok := os.LookupEnv("COMMONS_HTTP_COMPRESSION_THRESHOLD"); ok {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_HTTP_COMPRESSION_THRESHOLD: %w", err)
+		}
+		c.HTTPCompressionThreshold = threshold
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_MESSAGE_LENGTH"); ok {
+		length, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_MESSAGE_LENGTH: %w", err)
+		}
+		c.MaxMessageLength = length
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_MAX_FRAME_BYTES"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WS_MAX_FRAME_BYTES: %w", err)
+		}
+		c.WSMaxFrameBytes = size
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_BODY_BYTES"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_BODY_BYTES: %w", err)
+		}
+		c.MaxBodyBytes = size
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_MAX_CONNECTIONS_PER_USER"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WS_MAX_CONNECTIONS_PER_USER: %w", err)
+		}
+		c.WSMaxConnectionsPerUser = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_MAX_CONNECTIONS_PER_IP"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WS_MAX_CONNECTIONS_PER_IP: %w", err)
+		}
+		c.WSMaxConnectionsPerIP = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_WS_CONNECTION_LIMIT_MODE"); ok {
+		c.WSConnectionLimitMode = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_OWNED_HALLS"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_OWNED_HALLS: %w", err)
+		}
+		c.MaxOwnedHalls = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_HALL_ROOMS"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_HALL_ROOMS: %w", err)
+		}
+		c.MaxHallRooms = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_HALL_MEMBERS"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_HALL_MEMBERS: %w", err)
+		}
+		c.MaxHallMembers = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_MAX_HALL_ATTACHMENT_BYTES"); ok {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("COMMONS_MAX_HALL_ATTACHMENT_BYTES: %w", err)
+		}
+		c.MaxHallAttachmentBytes = limit
+	}
+	if v, ok := os.LookupEnv("COMMONS_DAILY_TOKEN_QUOTA"); ok {
+		quota, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_DAILY_TOKEN_QUOTA: %w", err)
+		}
+		c.DailyTokenQuota = quota
+	}
+	if v, ok := os.LookupEnv("COMMONS_DRAIN_PERIOD"); ok {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_DRAIN_PERIOD: %w", err)
+		}
+		c.DrainPeriod = period
+	}
+	if v, ok := os.LookupEnv("COMMONS_DRAIN_RECONNECT_URL"); ok {
+		c.DrainReconnectURL = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SMTP_HOST"); ok {
+		c.SMTPHost = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SMTP_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_SMTP_PORT: %w", err)
+		}
+		c.SMTPPort = port
+	}
+	if v, ok := os.LookupEnv("COMMONS_SMTP_USERNAME"); ok {
+		c.SMTPUsername = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SMTP_PASSWORD"); ok {
+		c.SMTPPassword = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SMTP_FROM"); ok {
+		c.SMTPFrom = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_EMAIL_DIGEST_WINDOW"); ok {
+		window, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_EMAIL_DIGEST_WINDOW: %w", err)
+		}
+		c.EmailDigestWindow = window
+	}
+	if v, ok := os.LookupEnv("COMMONS_REQUIRE_VERIFIED_EMAIL"); ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_REQUIRE_VERIFIED_EMAIL: %w", err)
+		}
+		c.RequireVerifiedEmail = enabled
+	}
+	if v, ok := os.LookupEnv("COMMONS_FEED_SECRET"); ok {
+		c.FeedSecret = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_WEBHOOK_MAX_ATTEMPTS"); ok {
+		attempts, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WEBHOOK_MAX_ATTEMPTS: %w", err)
+		}
+		c.WebhookMaxAttempts = attempts
+	}
+	if v, ok := os.LookupEnv("COMMONS_WEBHOOK_ALLOW_PRIVATE"); ok {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_WEBHOOK_ALLOW_PRIVATE: %w", err)
+		}
+		c.WebhookAllowPrivate = allow
+	}
+	if v, ok := os.LookupEnv("COMMONS_SENTRY_DSN"); ok {
+		c.SentryDSN = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_SENTRY_ENVIRONMENT"); ok {
+		c.SentryEnvironment = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_REDIS_URL"); ok {
+		c.RedisURL = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_REDIS_CHANNEL"); ok {
+		c.RedisChannel = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_NATS_URL"); ok {
+		c.NATSURL = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_NATS_SUBJECT"); ok {
+		c.NATSSubject = v
+	}
+	if v, ok := os.LookupEnv("COMMONS_NODE_ID"); ok {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("COMMONS_NODE_ID: %w", err)
+		}
+		c.NodeID = id
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil && c.BindAddress != "localhost" {
+		errs = append(errs, fmt.Errorf("bind_address %q is not an IP address", c.BindAddress))
+	}
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("db_path is required"))
+	}
+	if c.DBMaxOpenConns < 1 {
+		errs = append(errs, fmt.Errorf("db_max_open_conns must be at least 1, got %d", c.DBMaxOpenConns))
+	}
+	if c.DBMaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("db_max_idle_conns can't be negative, got %d", c.DBMaxIdleConns))
+	}
+	if c.DBConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("db_conn_max_lifetime can't be negative, got %s", c.DBConnMaxLifetime))
+	}
+	if c.MessageCacheRooms < 0 {
+		errs = append(errs, fmt.Errorf("message_cache_rooms can't be negative, got %d", c.MessageCacheRooms))
+	}
+	if c.JournalMaxBytes < 1024*1024 {
+		errs = append(errs, fmt.Errorf("journal_max_bytes must be at least 1048576, got %d", c.JournalMaxBytes))
+	}
+	if c.JournalKeep < 0 {
+		errs = append(errs, fmt.Errorf("journal_keep can't be negative, got %d", c.JournalKeep))
+	}
+	if c.BackupDir == "" {
+		errs = append(errs, errors.New("backup_dir is required"))
+	}
+	if c.BackupInterval != 0 && c.BackupInterval < time.Minute {
+		errs = append(errs, fmt.Errorf("backup_interval must be 0 or at least 1m, got %s", c.BackupInterval))
+	}
+	if c.BackupKeep < 0 {
+		errs = append(errs, fmt.Errorf("backup_keep can't be negative, got %d", c.BackupKeep))
+	}
+	if c.ExportDir == "" {
+		errs = append(errs, errors.New("export_dir is required"))
+	}
+	if c.SessionTTL < time.Minute {
+		errs = append(errs, fmt.Errorf("session_ttl must be at least 1m, got %s", c.SessionTTL))
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an IP address or CIDR range", proxy))
+		}
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("request_timeout must be positive, got %s", c.RequestTimeout))
+	}
+	if !i18n.Supported(c.DefaultLanguage) {
+		errs = append(errs, fmt.Errorf("default_language must be one of %s, got %q", strings.Join(i18n.Languages, ", "), c.DefaultLanguage))
+	}
+	if c.DefaultHall != "" {
+		if len(c.DefaultHallRooms) == 0 {
+			errs = append(errs, errors.New("default_hall_rooms needs at least one room with default_hall"))
+		}
+		for _, room := range c.DefaultHallRooms {
+			if store.CleanRoomName(room) != room {
+				errs = append(errs, fmt.Errorf("default_hall_rooms: %q is not a valid room name, e.g. %q is", room, store.CleanRoomName(room)))
+			}
+		}
+	}
+	if c.UsernameChangeCooldown < 0 {
+		errs = append(errs, fmt.Errorf("username_change_cooldown can't be negative, got %s", c.UsernameChangeCooldown))
+	}
+	switch c.Registration {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+	default:
+		errs = append(errs, fmt.Errorf("registration must be open, invite or closed, got %q", c.Registration))
+	}
+	switch c.Captcha {
+	case "":
+	case CaptchaHCaptcha, CaptchaTurnstile:
+		if c.CaptchaSiteKey == "" || c.CaptchaSecret == "" {
+			errs = append(errs, fmt.Errorf("captcha %s needs captcha_site_key and captcha_secret", c.Captcha))
+		}
+	case CaptchaPoW:
+		if len(c.CaptchaSecret) < minCaptchaSecretLength {
+			errs = append(errs, fmt.Errorf("captcha pow needs a captcha_secret of at least %d characters", minCaptchaSecretLength))
+		}
+		if c.CaptchaDifficulty < 1 || c.CaptchaDifficulty > maxCaptchaDifficulty {
+			errs = append(errs, fmt.Errorf("captcha_difficulty must be between 1 and %d, got %d", maxCaptchaDifficulty, c.CaptchaDifficulty))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("captcha must be hcaptcha, turnstile, pow or empty, got %q", c.Captcha))
+	}
+	if c.CaptchaLoginFailures < 0 {
+		errs = append(errs, fmt.Errorf("captcha_login_failures must not be negative, got %d", c.CaptchaLoginFailures))
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Errorf("cors origin %q must be \"*\" or start with http:// or https://", origin))
+		}
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err == nil && !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static_dir %q is not a directory", c.StaticDir))
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be set together"))
+	}
+	if c.TLSCertFile != "" && len(c.AutocertDomains) > 0 {
+		errs = append(errs, errors.New("use either tls_cert_file/tls_key_file or autocert_domains, not both"))
+	}
+	if len(c.AutocertDomains) > 0 && c.AutocertCacheDir == "" {
+		errs = append(errs, errors.New("autocert_cache_dir is required with autocert_domains"))
+	}
+	if c.HTTPRedirectPort != 0 {
+		if !c.TLSEnabled() {
+			errs = append(errs, errors.New("http_redirect_port needs TLS to be configured"))
+		} else if c.HTTPRedirectPort < 1 || c.HTTPRedirectPort > 65535 || c.HTTPRedirectPort == c.Port {
+			errs = append(errs, fmt.Errorf("http_redirect_port must be a free port between 1 and 65535, got %d", c.HTTPRedirectPort))
+		}
+	}
+	if c.PprofAddress != "" {
+		_, port, err := net.SplitHostPort(c.PprofAddress)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pprof_address %q: %v", c.PprofAddress, err))
+		} else if port == strconv.Itoa(c.Port) || port == strconv.Itoa(c.HTTPRedirectPort) {
+			errs = append(errs, errors.New("pprof_address must not share a port with the server"))
+		}
+	}
+	if c.WSCompressionThreshold < 0 {
+		errs = append(errs, fmt.Errorf("ws_compression_threshold can't be negative, got %d", c.WSCompressionThreshold))
+	}
+	if c.HTTPCompressionThreshold < 0 {
+		errs = append(errs, fmt.Errorf("http_compression_threshold can't be negative, got %d", c.HTTPCompressionThreshold))
+	}
+	if c.MaxMessageLength < 1 {
+		errs = append(errs, fmt.Errorf("max_message_length must be positive, got %d", c.MaxMessageLength))
+	}
+	// Anything smaller can't even carry a join_room
+	if c.WSMaxFrameBytes < 512 {
+		errs = append(errs, fmt.Errorf("ws_max_frame_bytes must be at least 512, got %d", c.WSMaxFrameBytes))
+	}
+	// Key bundles and hall settings need a few KB
+	if c.MaxBodyBytes < 16*1024 {
+		errs = append(errs, fmt.Errorf("max_body_bytes must be at least 16384, got %d", c.MaxBodyBytes))
+	}
+	if c.WSMaxConnectionsPerUser < 0 {
+		errs = append(errs, fmt.Errorf("ws_max_connections_per_user can't be negative, got %d", c.WSMaxConnectionsPerUser))
+	}
+	if c.WSMaxConnectionsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("ws_max_connections_per_ip can't be negative, got %d", c.WSMaxConnectionsPerIP))
+	}
+	if c.WSConnectionLimitMode != WSConnectionLimitReject && c.WSConnectionLimitMode != WSConnectionLimitEvict {
+		errs = append(errs, fmt.Errorf("ws_connection_limit_mode must be reject or evict, got %q", c.WSConnectionLimitMode))
+	}
+	if c.MaxOwnedHalls < 0 {
+		errs = append(errs, fmt.Errorf("max_owned_halls can't be negative, got %d", c.MaxOwnedHalls))
+	}
+	if c.MaxHallRooms < 0 {
+		errs = append(errs, fmt.Errorf("max_hall_rooms can't be negative, got %d", c.MaxHallRooms))
+	}
+	if c.MaxHallMembers < 0 {
+		errs = append(errs, fmt.Errorf("max_hall_members can't be negative, got %d", c.MaxHallMembers))
+	}
+	if c.MaxHallAttachmentBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_hall_attachment_bytes can't be negative, got %d", c.MaxHallAttachmentBytes))
+	}
+	if c.DailyTokenQuota < 0 {
+		errs = append(errs, fmt.Errorf("daily_token_quota can't be negative, got %d", c.DailyTokenQuota))
+	}
+	if c.DrainPeriod < 0 {
+		errs = append(errs, fmt.Errorf("drain_period can't be negative, got %s", c.DrainPeriod))
+	}
+	if c.DrainReconnectURL != "" {
+		if u, err := url.Parse(c.DrainReconnectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("drain_reconnect_url must be an http or https URL, got %q", c.DrainReconnectURL))
+		}
+	}
+	if c.SMTPHost != "" {
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			errs = append(errs, fmt.Errorf("smtp_port must be between 1 and 65535, got %d", c.SMTPPort))
+		}
+		if _, err := mail.ParseAddress(c.SMTPFrom); err != nil {
+			errs = append(errs, fmt.Errorf("smtp_from must be an email address with smtp_host, got %q", c.SMTPFrom))
+		}
+		if c.EmailDigestWindow < time.Minute {
+			errs = append(errs, fmt.Errorf("email_digest_window must be at least 1m, got %s", c.EmailDigestWindow))
+		}
+	}
+	if c.RequireVerifiedEmail && c.SMTPHost == "" {
+		errs = append(errs, errors.New("require_verified_email needs smtp_host to send confirmation links"))
+	}
+	if c.FeedSecret != "" && len(c.FeedSecret) < minFeedSecretLength {
+		errs = append(errs, fmt.Errorf("feed_secret must be at least %d characters", minFeedSecretLength))
+	}
+	if c.WebhookMaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("webhook_max_attempts must be at least 1, got %d", c.WebhookMaxAttempts))
+	}
+	if c.SentryDSN != "" {
+		if _, err := parseSentryDSN(c.SentryDSN); err != nil {
+			errs = append(errs, fmt.Errorf("sentry_dsn: %w", err))
+		}
+	}
+	if c.RedisURL != "" && c.RedisChannel == "" {
+		errs = append(errs, errors.New("redis_channel is required with redis_url"))
+	}
+	if c.NATSURL != "" && c.NATSSubject == "" {
+		errs = append(errs, errors.New("nats_subject is required with nats_url"))
+	}
+	if c.RedisURL != "" && c.NATSURL != "" {
+		errs = append(errs, errors.New("redis_url and nats_url can't both be set"))
+	}
+	if c.NodeID < 0 || c.NodeID > store.MaxNodeID {
+		errs = append(errs, fmt.Errorf("node_id must be between 0 and %d, got %d", store.MaxNodeID, c.NodeID))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether a CORS request from origin may be answered
+func (c *Config) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.CORSOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
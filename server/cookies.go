@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// respondSession answers a successful register or login, with the session
+// in cookies or as a bearer token depending on how it was asked for
+func (s *Server) respondSession(w http.ResponseWriter, r *http.Request, user *store.User, session *auth.Session) {
+	response := map[string]interface{}{
+		"user":         user,
+		"capabilities": s.capabilities(),
+	}
+
+	if session.CSRFToken != "" {
+		auth.SetSessionCookies(w, r, session)
+		response["csrf_token"] = session.CSRFToken
+		response["expires_at"] = session.ExpiresAt
+	} else {
+		response["token"] = session.Token
+	}
+
+	api.RespondJSON(w, response)
+}
+
+// cookieOriginAllowed reports whether a websocket handshake may use the
+// session cookie. Handshakes aren't subject to CORS, so any page could
+// otherwise open a connection as whoever is logged in: only our own origin
+// and origins listed by name in cors_origins are let through.
+func (s *Server) cookieOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	for _, allowed := range s.config.Load().CORSOrigins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
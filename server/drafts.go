@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/ws"
+)
+
+// handleDrafts serves /api/drafts, which lists the user's drafts so a
+// device can pick them all up when it connects
+func (s *Server) handleDrafts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	drafts, err := s.db.GetDrafts(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch drafts", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"drafts": drafts,
+	})
+}
+
+// handleDraft serves /api/drafts/{room_id}: GET returns the user's draft in
+// the room, PUT replaces it
+func (s *Server) handleDraft(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/drafts/"))
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// Empty content clears the draft, which clients do once it's sent
+		var req struct {
+			Content string `json:"content"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+		if maxLength := s.config.Load().MaxMessageLength; ws.MessageTooLong(req.Content, maxLength) {
+			api.RespondError(w, fmt.Sprintf("Draft is longer than %d characters", maxLength), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.db.SetDraft(r.Context(), session.UserID, roomID, req.Content); err != nil {
+			api.RespondError(w, "Failed to save draft", http.StatusInternalServerError)
+			return
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	draft, err := s.db.GetDraft(r.Context(), session.UserID, roomID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch draft", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the user's other devices in step
+	if r.Method == http.MethodPut {
+		s.wsManager.SendToUser(session.UserID, "draft_updated", draft)
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"draft": draft,
+	})
+}
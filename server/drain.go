@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// Drain moves this instance's ws and SSE clients elsewhere before it's
+// stopped: new connections are turned away and the current ones are sent a
+// reconnect frame and closed, spread over the configured drain period. It
+// returns once they're all gone or ctx is done. REST requests are served as
+// usual throughout.
+func (s *Server) Drain(ctx context.Context) {
+	s.wsManager.Drain(ctx, s.config.Load().DrainPeriod, s.config.Load().DrainReconnectURL)
+}
+
+// handleAdminDrain serves /api/admin/drain: GET tells whether a drain is
+// running, POST starts one and returns right away
+func (s *Server) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !s.wsManager.Draining() {
+			session := auth.SessionFromContext(r.Context())
+			s.logger.Printf("Instance admin %s started a drain", session.Username)
+			go s.Drain(context.Background())
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"draining":       s.wsManager.Draining(),
+		"ws_connections": s.wsManager.ClientCount(),
+	})
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// A user's email, given at registration or in their settings, is unverified
+// until they open the link mailed to it. With require_verified_email on,
+// unverified accounts can't create halls.
+const (
+	emailVerifyTTL = 24 * time.Hour
+
+	// emailVerifyResendInterval keeps resends from being used to spam
+	// someone's inbox
+	emailVerifyResendInterval = time.Minute
+)
+
+// parseEmail cleans up an email address a user gave, and reports false if
+// it isn't one
+func parseEmail(raw string) (string, bool) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(raw))
+	if err != nil || len(addr.Address) > maxEmailLength {
+		return "", false
+	}
+	return addr.Address, true
+}
+
+// sendEmailVerification mails a user a link confirming their unverified
+// email. It reports false if they have none or the last link went out less
+// than emailVerifyResendInterval ago. Without SMTP there's nothing to send.
+func (s *Server) sendEmailVerification(ctx context.Context, r *http.Request, userID int, username, email string) (bool, error) {
+	if !s.notifier.Enabled() {
+		return false, nil
+	}
+
+	token, err := s.auth.GenerateToken()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	stored, err := s.db.SetEmailVerifyToken(ctx, userID, token, now.Add(emailVerifyTTL), now.Add(emailVerifyTTL-emailVerifyResendInterval))
+	if err != nil || !stored {
+		return false, err
+	}
+
+	link := fmt.Sprintf("%s://%s/api/email/verify?token=%s", api.RequestScheme(r), r.Host, token)
+	body := fmt.Sprintf("Hi %s,\n\nplease confirm this is your email address by opening this link within 24 hours:\n\n%s\n\nIf you didn't give this address to us, you can ignore this email.\n", username, link)
+
+	// SMTP can be slow, so don't hold up the request for it
+	go func() {
+		if err := s.notifier.mailer.Send(email, "Confirm your email address", body); err != nil {
+			s.logger.Printf("Failed to send email verification to user %d: %v", userID, err)
+		}
+	}()
+	return true, nil
+}
+
+// requireVerifiedEmail makes sure the user has a verified email when
+// require_verified_email is on, and otherwise responds and returns false
+func (s *Server) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, userID int) bool {
+	if !s.config.Load().RequireVerifiedEmail {
+		return true
+	}
+
+	settings, err := s.db.GetUserSettings(r.Context(), userID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return false
+	}
+	if !settings.EmailVerified {
+		api.RespondErrorCode(w, api.ErrCodeEmailUnverified, "Verify your email address first", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleVerifyEmail serves /api/email/verify, which confirms an email with
+// the token mailed to it: GET ?token= for the link itself, or POST
+// {"token": "..."} for clients
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+	case http.MethodPost:
+		var req struct {
+			Token string `json:"token"`
+		}
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+		token = req.Token
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token == "" {
+		api.RespondError(w, "Token required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := s.db.VerifyEmail(r.Context(), token)
+	if err == sql.ErrNoRows {
+		api.RespondError(w, "This link is invalid or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Printf("User %d verified their email", userID)
+	api.RespondJSON(w, map[string]string{"status": "email verified"})
+}
+
+// handleResendEmailVerification serves POST /api/email/verify/resend, which
+// mails a new link to the user's unverified email
+func (s *Server) handleResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !s.notifier.Enabled() {
+		api.RespondError(w, "Email is not configured on this server", http.StatusNotImplemented)
+		return
+	}
+
+	settings, err := s.db.GetUserSettings(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+	if settings.Email == "" {
+		api.RespondError(w, "Set an email in your settings first", http.StatusBadRequest)
+		return
+	}
+	if settings.EmailVerified {
+		api.RespondError(w, "Your email is already verified", http.StatusConflict)
+		return
+	}
+
+	sent, err := s.sendEmailVerification(r.Context(), r, session.UserID, session.Username, settings.Email)
+	if err != nil {
+		api.RespondError(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if !sent {
+		api.RespondError(w, "A verification email was sent less than a minute ago", http.StatusTooManyRequests)
+		return
+	}
+
+	api.RespondJSON(w, map[string]string{"status": "verification email sent"})
+}
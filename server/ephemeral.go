@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Expired messages are deleted every messageExpiryInterval, at most
+// messageExpiryBatchSize per run, so they can outlive their TTL by a few
+// seconds; reads hide them in the meantime.
+const (
+	messageExpiryInterval  = 5 * time.Second
+	messageExpiryBatchSize = 500
+	messageExpiryTimeout   = 30 * time.Second
+)
+
+// runMessageExpiry deletes expired messages until the process exits
+func (s *Server) runMessageExpiry() {
+	ticker := time.NewTicker(messageExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.expireMessages()
+	}
+}
+
+func (s *Server) expireMessages() {
+	ctx, cancel := context.WithTimeout(context.Background(), messageExpiryTimeout)
+	defer cancel()
+
+	for {
+		expired, err := s.db.DeleteExpiredMessages(ctx, messageExpiryBatchSize)
+		if err != nil {
+			s.logger.Printf("Failed to delete expired messages: %v", err)
+			return
+		}
+
+		for _, message := range expired {
+			s.wsManager.BroadcastToRoom(message.RoomID, "message_deleted", ws.MessageDeletedData{
+				MessageID: message.ID,
+				RoomID:    message.RoomID,
+				Reason:    "expired",
+			})
+		}
+
+		if len(expired) < messageExpiryBatchSize {
+			return
+		}
+	}
+}
+
+// handleRoomMessageTTL serves /api/rooms/{room_id}/message-ttl, which sets
+// how long messages in a room last
+func (s *Server) handleRoomMessageTTL(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		MessageTTL int `json:"message_ttl_seconds"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	if !store.ValidMessageTTL(req.MessageTTL) {
+		api.RespondError(w, fmt.Sprintf("message_ttl_seconds must be 0 or between %d and %d",
+			int(store.MinMessageTTL.Seconds()), int(store.MaxMessageTTL.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can change disappearing messages", http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.SetRoomMessageTTL(r.Context(), roomID, req.MessageTTL); err != nil {
+		api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("message_ttl_seconds=%d", req.MessageTTL)
+	if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, "room_message_ttl_updated", "room", roomID, details); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	s.wsManager.BroadcastToRoom(roomID, "message_ttl_updated", ws.MessageTTLData{
+		RoomID:     roomID,
+		MessageTTL: req.MessageTTL,
+	})
+
+	room.MessageTTL = req.MessageTTL
+	api.RespondJSON(w, map[string]interface{}{
+		"room": room,
+	})
+}
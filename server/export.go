@@ -0,0 +1,471 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// Exports run in the background and are kept for exportTTL after they finish
+const (
+	exportTTL           = 24 * time.Hour
+	exportRunTimeout    = 30 * time.Minute
+	exportFormatVersion = 1
+)
+
+// Export job states
+const (
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+type ExportJob struct {
+	ID          string     `json:"id"`
+	HallID      int        `json:"hall_id"`
+	RequestedBy int        `json:"requested_by"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Size        int64      `json:"size,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+
+	path string
+}
+
+// ExportManager builds hall export archives and keeps track of them
+type ExportManager struct {
+	db     *store.Database
+	dir    string
+	jobs   map[string]*ExportJob
+	mutex  sync.Mutex
+	logger *log.Logger
+}
+
+func NewExportManager(db *store.Database, dir string, logger *log.Logger) *ExportManager {
+	return &ExportManager{
+		db:     db,
+		dir:    dir,
+		jobs:   make(map[string]*ExportJob),
+		logger: logger,
+	}
+}
+
+// Start begins exporting a hall and returns the job right away
+func (em *ExportManager) Start(hall *store.Hall, userID int) (ExportJob, error) {
+	em.removeExpired()
+
+	id, err := store.GenerateInviteCode()
+	if err != nil {
+		return ExportJob{}, err
+	}
+
+	if err := os.MkdirAll(em.dir, 0o700); err != nil {
+		return ExportJob{}, err
+	}
+
+	job := &ExportJob{
+		ID:          id,
+		HallID:      hall.ID,
+		RequestedBy: userID,
+		Status:      ExportStatusRunning,
+		CreatedAt:   time.Now().UTC(),
+		path:        filepath.Join(em.dir, fmt.Sprintf("hall-%d-%s.zip", hall.ID, id)),
+	}
+
+	em.mutex.Lock()
+	em.jobs[id] = job
+	snapshot := *job
+	em.mutex.Unlock()
+
+	go em.run(job, *hall)
+	return snapshot, nil
+}
+
+// Get returns a copy of a hall's export job
+func (em *ExportManager) Get(hallID int, id string) (ExportJob, bool) {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	job, ok := em.jobs[id]
+	if !ok || job.HallID != hallID {
+		return ExportJob{}, false
+	}
+	return *job, true
+}
+
+func (em *ExportManager) run(job *ExportJob, hall store.Hall) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportRunTimeout)
+	defer cancel()
+
+	err := em.writeArchive(ctx, job.path, hall, job.RequestedBy)
+	if err != nil {
+		em.logger.Printf("Export %s of hall %d failed: %v", job.ID, hall.ID, err)
+		os.Remove(job.path)
+	}
+
+	var size int64
+	if info, statErr := os.Stat(job.path); err == nil && statErr == nil {
+		size = info.Size()
+	}
+
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	now := time.Now().UTC()
+	expires := now.Add(exportTTL)
+	job.FinishedAt = &now
+	job.ExpiresAt = &expires
+	if err != nil {
+		job.Status = ExportStatusFailed
+		job.Error = "export failed"
+		return
+	}
+	job.Status = ExportStatusDone
+	job.Size = size
+}
+
+// writeArchive writes the export zip:
+//
+//	manifest.json          format version, hall, export time and counts
+//	members.json           hall members
+//	rooms.json             rooms, including archived ones
+//	messages/{room}.json   each room's messages, oldest first
+//	attachments/           reserved for uploaded files (none yet)
+func (em *ExportManager) writeArchive(ctx context.Context, path string, hall store.Hall, userID int) error {
+	members, err := em.db.GetHallMembers(ctx, hall.ID)
+	if err != nil {
+		return err
+	}
+
+	rooms, err := em.db.GetHallRooms(ctx, hall.ID, true)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	archive := zip.NewWriter(f)
+
+	if err := writeZipJSON(archive, "members.json", members); err != nil {
+		return err
+	}
+	if err := writeZipJSON(archive, "rooms.json", rooms); err != nil {
+		return err
+	}
+
+	messageCount := 0
+	for _, room := range rooms {
+		w, err := createZipEntry(archive, fmt.Sprintf("messages/%d.json", room.ID))
+		if err != nil {
+			return err
+		}
+
+		// Stream messages so big rooms don't have to fit in memory
+		if _, err := w.Write([]byte("[\n")); err != nil {
+			return err
+		}
+		first := true
+		err = em.db.EachRoomMessage(ctx, room.ID, func(message store.Message) error {
+			data, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := w.Write([]byte(",\n")); err != nil {
+					return err
+				}
+			}
+			first = false
+			messageCount++
+			_, err = w.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte("\n]\n")); err != nil {
+			return err
+		}
+	}
+
+	manifest := map[string]interface{}{
+		"format_version": exportFormatVersion,
+		"hall":           hall,
+		"exported_at":    time.Now().UTC(),
+		"exported_by":    userID,
+		"counts": map[string]int{
+			"members":     len(members),
+			"rooms":       len(rooms),
+			"messages":    messageCount,
+			"attachments": 0,
+		},
+	}
+	if err := writeZipJSON(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func createZipEntry(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+func writeZipJSON(archive *zip.Writer, name string, v interface{}) error {
+	w, err := createZipEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// removeExpired forgets finished exports past their TTL and deletes their files
+func (em *ExportManager) removeExpired() {
+	em.mutex.Lock()
+	defer em.mutex.Unlock()
+
+	now := time.Now()
+	for id, job := range em.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			os.Remove(job.path)
+			delete(em.jobs, id)
+		}
+	}
+}
+
+// Room exports are streamed straight to the client, reading roomExportBatch
+// messages at a time so a slow download doesn't hold a read open on the
+// database and block writers
+const roomExportBatch = 1000
+
+// handleRoomExport serves /api/rooms/{room_id}/export?format=json|csv, a
+// room's whole history for hall admins
+func (s *Server) handleRoomExport(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		api.RespondError(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can export rooms", http.StatusForbidden)
+		return
+	}
+
+	// Fetched before anything is written, so failing here still gets a
+	// proper error response
+	batch, err := s.db.GetRoomMessagesAfter(r.Context(), roomID, 0, roomExportBatch)
+	if err != nil {
+		api.RespondError(w, "Failed to export room", http.StatusInternalServerError)
+		return
+	}
+
+	details := "format=" + format
+	if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, "room_exported", "room", roomID, details); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	var exporter roomExporter
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		exporter = newCSVRoomExporter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		exporter = &jsonRoomExporter{w: w, key: "room", subject: room}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="room-%d.%s"`, roomID, format))
+
+	// Headers are out from here on, so a failure can only cut the export short
+	next := func(ctx context.Context, afterID int) ([]store.Message, error) {
+		return s.db.GetRoomMessagesAfter(ctx, roomID, afterID, roomExportBatch)
+	}
+	if err := streamRoomExport(r.Context(), w, exporter, batch, next); err != nil {
+		s.logger.Printf("Export of room %d stopped: %v", roomID, err)
+	}
+}
+
+// streamRoomExport writes batch and then what next returns after the last
+// message written, until a batch comes back short
+func streamRoomExport(ctx context.Context, w http.ResponseWriter, exporter roomExporter, batch []store.Message, next func(ctx context.Context, afterID int) ([]store.Message, error)) error {
+	if err := exporter.begin(); err != nil {
+		return err
+	}
+
+	for len(batch) > 0 {
+		for _, message := range batch {
+			if err := exporter.write(message); err != nil {
+				return err
+			}
+		}
+		if err := exporter.flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if len(batch) < roomExportBatch {
+			break
+		}
+
+		var err error
+		batch, err = next(ctx, batch[len(batch)-1].ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return exporter.end()
+}
+
+// roomExporter writes a room export in one format. flush hands what it
+// buffered to the response after each batch.
+type roomExporter interface {
+	begin() error
+	write(message store.Message) error
+	flush() error
+	end() error
+}
+
+// jsonRoomExporter writes {key: subject, "exported_at": ..., "messages": [...]},
+// where subject is what was exported, like the room
+type jsonRoomExporter struct {
+	w       io.Writer
+	key     string
+	subject interface{}
+	count   int
+}
+
+func (e *jsonRoomExporter) begin() error {
+	subject, err := json.Marshal(e.subject)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "{%q:%s,\"exported_at\":%q,\"messages\":[\n", e.key, subject, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func (e *jsonRoomExporter) write(message store.Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := e.w.Write([]byte(",\n")); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonRoomExporter) flush() error {
+	return nil
+}
+
+func (e *jsonRoomExporter) end() error {
+	_, err := e.w.Write([]byte("\n]}\n"))
+	return err
+}
+
+// csvRoomExporter writes one row per message under a header row
+type csvRoomExporter struct {
+	w *csv.Writer
+}
+
+func newCSVRoomExporter(w io.Writer) *csvRoomExporter {
+	return &csvRoomExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvRoomExporter) begin() error {
+	return e.w.Write([]string{"id", "created_at", "user_id", "username", "type", "content", "kind", "payload"})
+}
+
+func (e *csvRoomExporter) write(message store.Message) error {
+	return e.w.Write([]string{
+		strconv.Itoa(message.ID),
+		message.CreatedAt.UTC().Format(time.RFC3339),
+		strconv.Itoa(message.UserID),
+		csvSafe(message.Username),
+		message.Type,
+		csvSafe(message.Content),
+		message.Kind,
+		csvSafe(string(message.Payload)),
+	})
+}
+
+func (e *csvRoomExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvRoomExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvSafe keeps spreadsheets from running cells as formulas by prefixing
+// the ones that would start one with a quote
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// feedEntries is how many of a room's latest messages its feed carries
+const feedEntries = 50
+
+// feedTitleLength caps entry titles, in characters; the full message is the
+// entry's content
+const feedTitleLength = 80
+
+// feedToken signs a room's feed URL. Tokens are tied to the room's feed key,
+// so a new key revokes them.
+func feedToken(secret string, roomID int, feedKey string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "room-feed:%d:%s", roomID, feedKey)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func feedURL(r *http.Request, roomID int) string {
+	return fmt.Sprintf("%s://%s/feeds/rooms/%d.xml", api.RequestScheme(r), r.Host, roomID)
+}
+
+// handleRoomFeed serves /api/rooms/{room_id}/feed: GET shows whether the
+// room is an announcement room and its feed URL, POST changes that
+func (s *Server) handleRoomFeed(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// The feed URL is as good as read access, so only admins hand it out
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can manage room feeds", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// rotate_token revokes the feed URL handed out so far
+		var req struct {
+			Announcement bool `json:"announcement"`
+			RotateToken  bool `json:"rotate_token"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		feedKey, err := s.db.GetRoomFeedKey(r.Context(), roomID)
+		if err != nil {
+			api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+			return
+		}
+		newKey := ""
+		if feedKey == "" || req.RotateToken {
+			if newKey, err = store.GenerateInviteCode(); err != nil {
+				api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := s.db.SetRoomAnnouncement(r.Context(), roomID, req.Announcement, newKey); err != nil {
+			api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+			return
+		}
+
+		details := fmt.Sprintf("announcement=%t rotate_token=%t", req.Announcement, req.RotateToken)
+		if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, "room_feed_updated", "room", roomID, details); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+		room.Announcement = req.Announcement
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := map[string]interface{}{
+		"announcement": room.Announcement,
+	}
+
+	// Without a feed secret there are no feeds to link to
+	if room.Announcement && s.config.Load().FeedSecret != "" {
+		feedKey, err := s.db.GetRoomFeedKey(r.Context(), roomID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch room feed", http.StatusInternalServerError)
+			return
+		}
+		response["feed_url"] = feedURL(r, roomID) + "?token=" + feedToken(s.config.Load().FeedSecret, roomID, feedKey)
+	}
+
+	api.RespondJSON(w, response)
+}
+
+// Atom documents, see RFC 4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Content atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// handleFeed serves /feeds/rooms/{room_id}.xml?token=..., the Atom feed of
+// an announcement room. It needs no session, the signed token is the access.
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Every way of not getting a feed looks the same, so tokens and room IDs
+	// can't be probed
+	notFound := func() { api.RespondError(w, "Feed not found", http.StatusNotFound) }
+
+	if s.config.Load().FeedSecret == "" {
+		notFound()
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/feeds/rooms/")
+	if !strings.HasSuffix(name, ".xml") {
+		notFound()
+		return
+	}
+	roomID, err := strconv.Atoi(strings.TrimSuffix(name, ".xml"))
+	if err != nil {
+		notFound()
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil || !room.Announcement {
+		notFound()
+		return
+	}
+
+	feedKey, err := s.db.GetRoomFeedKey(r.Context(), roomID)
+	if err != nil || feedKey == "" {
+		notFound()
+		return
+	}
+	expected := feedToken(s.config.Load().FeedSecret, roomID, feedKey)
+	if !hmac.Equal([]byte(expected), []byte(r.URL.Query().Get("token"))) {
+		notFound()
+		return
+	}
+
+	hall, err := s.db.GetHallByID(r.Context(), room.HallID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+
+	messages, err := s.db.GetRoomMessages(r.Context(), roomID, feedEntries, 0)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+
+	url := feedURL(r, roomID)
+	feed := atomFeed{
+		ID:      url,
+		Title:   fmt.Sprintf("%s (%s)", room.Name, hall.Name),
+		Updated: room.CreatedAt.UTC().Format(time.RFC3339),
+		Link:    atomLink{Rel: "self", Href: url + "?token=" + expected},
+		Entries: make([]atomEntry, 0, len(messages)),
+	}
+
+	// Newest first, as feed readers expect
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := messages[i]
+		// Feed readers keep what they fetch, which disappearing messages
+		// shouldn't outlive
+		if message.ExpiresAt != nil {
+			continue
+		}
+		// Joins and new rooms are noise in a reader
+		if message.Type == store.MessageTypeSystem {
+			continue
+		}
+		title := excerpt(message.Content)
+		if runes := []rune(title); len(runes) > feedTitleLength {
+			title = string(runes[:feedTitleLength]) + "…"
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("%s#message-%d", url, message.ID),
+			Title:   title,
+			Updated: message.CreatedAt.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: message.Username},
+			Content: atomContent{Type: "text", Body: message.Content},
+		})
+	}
+	if len(messages) > 0 {
+		feed.Updated = messages[len(messages)-1].CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		s.logger.Printf("Failed to write feed of room %d: %v", roomID, err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// handleRoomPublic serves /api/rooms/{room_id}/public, which makes a room
+// readable by guests or not (hall admins only)
+func (s *Server) handleRoomPublic(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can make rooms public", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Public bool `json:"public"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := s.db.SetRoomPublic(r.Context(), roomID, req.Public); err != nil {
+		api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("public=%t", req.Public)
+	if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, "room_public_updated", "room", roomID, details); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	if !req.Public {
+		s.wsManager.RemoveGuestsFromRoom(roomID)
+	}
+	room.Public = req.Public
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room": room,
+	})
+}
+
+// handlePublicRooms serves /api/public/rooms, the rooms guests can read
+func (s *Server) handlePublicRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.config.Load().GuestAccess {
+		api.RespondError(w, "Guest access is disabled", http.StatusNotFound)
+		return
+	}
+
+	rooms, err := s.db.GetPublicRooms(r.Context())
+	if err != nil {
+		api.RespondError(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"rooms": rooms,
+	})
+}
+
+// handlePublicRoom serves /api/public/rooms/{room_id}/messages, a public
+// room's recent messages, paged like /api/messages
+func (s *Server) handlePublicRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.config.Load().GuestAccess {
+		api.RespondError(w, "Guest access is disabled", http.StatusNotFound)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/public/rooms/"), "/")
+	if len(parts) != 2 || parts[1] != "messages" {
+		api.RespondError(w, "Invalid URL format", http.StatusBadRequest)
+		return
+	}
+
+	roomID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	// Private rooms look the same as missing ones
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil || !room.Public || room.Archived {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	messages, err := s.db.GetRoomMessages(r.Context(), roomID, limit, offset)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room":     room,
+		"messages": messages,
+	})
+}
@@ -0,0 +1,3206 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Server is the chat server, an http.Handler for the whole API. See New.
+type Server struct {
+	db        *store.Database
+	auth      *auth.Manager
+	wsManager *ws.Manager
+	policy    *auth.CredentialPolicy
+	config    atomic.Pointer[Config] // swapped by Reload
+	retention *RetentionPruner
+	exports   *ExportManager
+	backups   *BackupManager
+	notifier  *Notifier
+	webhooks  *WebhookDispatcher
+	plugins   *ws.Plugins
+	captcha   *CaptchaGuard // nil when captcha is off
+	errors    ErrorSink     // nil when errors are only logged
+	broker    ws.Broker
+	handler   http.Handler                // the routes wrapped in middleware
+	listener  atomic.Pointer[http.Server] // set by ListenAndServe, see Shutdown
+	logger    *log.Logger
+	startedAt time.Time
+
+	ownsBroker  bool                    // false when it came from WithBroker
+	journal     *store.Journal          // nil when messages aren't journaled
+	loadConfig  func() (*Config, error) // from WithConfigLoader
+	reloadMutex sync.Mutex
+}
+
+func newServer(db *store.Database, cfg *Config, broker ws.Broker, plugins *ws.Plugins, errorSink ErrorSink, backupStore BackupStore, logger *log.Logger) *Server {
+	am := auth.NewManager(db, cfg.SessionTTL, cfg.DailyTokenQuota)
+	notifier := NewNotifier(db, cfg, logger)
+	wsOpts := cfg.WSOptions()
+	webhooks := NewWebhookDispatcher(db, cfg, logger)
+	webhooks.errors = errorSink
+	wsOpts.Logger = logger
+	wsOpts.Events = webhooks
+	wsOpts.Errors = errorSink
+	wsOpts.Plugins = plugins
+	wsManager := ws.NewManager(db, am, broker, notifier, wsOpts)
+	backups := NewBackupManager(db, cfg.BackupDir, cfg.BackupKeep, logger)
+	backups.store = backupStore
+	backups.errors = errorSink
+
+	server := &Server{
+		db:        db,
+		auth:      am,
+		wsManager: wsManager,
+		policy:    auth.DefaultCredentialPolicy(),
+		retention: NewRetentionPruner(db, logger),
+		exports:   NewExportManager(db, cfg.ExportDir, logger),
+		backups:   backups,
+		notifier:  notifier,
+		webhooks:  webhooks,
+		plugins:   plugins,
+		errors:    errorSink,
+		broker:    broker,
+		logger:    logger,
+		startedAt: time.Now().UTC(),
+	}
+	server.config.Store(cfg)
+	if cfg.Captcha != "" {
+		server.captcha = NewCaptchaGuard(cfg, logger)
+	}
+	go server.runRoomArchiver()
+	go server.retention.Run()
+	go server.notifier.Run()
+	go server.webhooks.Run()
+	go server.runMessageExpiry()
+	if cfg.BackupInterval > 0 {
+		go server.backups.Run(cfg.BackupInterval)
+	}
+
+	// CORS, request logging, panic recovery, compression and body size
+	// middleware. Trusted proxies' forwarding headers are resolved before
+	// anything logs or limits by IP. The ones whose settings Reload changes
+	// read the live config.
+	handler := requestLogMiddleware(server.recoverMiddleware(languageMiddleware(corsMiddleware(compressionMiddleware(api.BodyLimitMiddleware(timeoutMiddleware(server.RegisterRoutes(), &server.config), cfg.MaxBodyBytes), cfg), &server.config), &server.config)), logger)
+	server.handler = proxyMiddleware(handler, cfg)
+
+	return server
+}
+
+func (s *Server) RegisterRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Serve the web UI from the configured static dir
+	if s.config.Load().StaticDir != "" {
+		mux.Handle("/", http.FileServer(http.Dir(s.config.Load().StaticDir)))
+	}
+
+	// Instance info
+	mux.HandleFunc("/api/instance", s.handleInstance)
+
+	// Auth endpoints
+	mux.HandleFunc("/api/register", s.handleRegister)
+	mux.HandleFunc("/api/login", s.handleLogin)
+	mux.HandleFunc("/api/captcha/challenge", s.handleCaptchaChallenge)
+	mux.HandleFunc("/api/logout", s.auth.RequireAuth(s.handleLogout))
+	mux.HandleFunc("/api/password", s.auth.RequireAuth(s.handleChangePassword))
+	mux.HandleFunc("/api/usage", s.auth.RequireAuth(s.handleUsage))
+	mux.HandleFunc("/api/sessions", s.auth.RequireAuth(s.handleSessions))
+	mux.HandleFunc("/api/sessions/", s.auth.RequireAuth(s.handleSessionWithID))
+	mux.HandleFunc("/api/users/me", s.auth.RequireAuth(s.handleUserMe))
+	mux.HandleFunc("/api/tokens", s.auth.RequireAuth(s.handleTokens))
+
+	// Hall management
+	mux.HandleFunc("/api/halls/create", s.auth.RequireAuth(s.handleCreateHall))
+	mux.HandleFunc("/api/halls/join", s.auth.RequireAuth(s.handleJoinHall))
+	mux.HandleFunc("/api/halls/leave", s.auth.RequireAuth(s.handleLeaveHall))
+	mux.HandleFunc("/api/halls/give-admin", s.auth.RequireScope("", auth.ScopeAdminHall, s.handleGiveAdmin))
+	mux.HandleFunc("/api/halls", s.auth.RequireScope(auth.ScopeReadMessages, "", s.handleHalls))
+	mux.HandleFunc("/api/halls/", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeAdminHall, s.handleHallWithID))
+
+	// Room management
+	mux.HandleFunc("/api/rooms/create", s.auth.RequireScope("", auth.ScopeAdminHall, s.handleCreateRoom))
+	mux.HandleFunc("/api/rooms/delete", s.auth.RequireScope("", auth.ScopeAdminHall, s.handleDeleteRoom))
+	mux.HandleFunc("/api/rooms/", s.auth.RequireScopeFunc(roomRouteScope, s.handleRoomsWithID))
+	mux.HandleFunc("/api/commands", s.auth.RequireAuth(s.handleCommands))
+	mux.HandleFunc("/api/messages/batch", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeReadMessages, s.handleMessagesBatch))
+	mux.HandleFunc("/api/messages/", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleMessages))
+
+	// Direct messages
+	mux.HandleFunc("/api/settings", s.auth.RequireAuth(s.handleSettings))
+	mux.HandleFunc("/api/email/verify", s.handleVerifyEmail)
+	mux.HandleFunc("/api/email/verify/resend", s.auth.RequireAuth(s.handleResendEmailVerification))
+	mux.HandleFunc("/api/dms", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleDMs))
+	mux.HandleFunc("/api/dms/requests", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleDMRequests))
+	mux.HandleFunc("/api/dms/send", s.auth.RequireScope("", auth.ScopeWriteMessages, s.handleSendDM))
+	mux.HandleFunc("/api/dms/", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleDMWithID))
+	mux.HandleFunc("/api/keys", s.auth.RequireAuth(s.handleKeys))
+	mux.HandleFunc("/api/keys/upload", s.auth.RequireAuth(s.handleUploadKeys))
+	mux.HandleFunc("/api/keys/claim", s.auth.RequireAuth(s.handleClaimKeys))
+	mux.HandleFunc("/api/keys/delete", s.auth.RequireAuth(s.handleDeleteKeys))
+	mux.HandleFunc("/api/keys/users/", s.auth.RequireAuth(s.handleUserKeys))
+
+	// Notification preferences
+	mux.HandleFunc("/api/notifications", s.auth.RequireAuth(s.handleNotificationPreferences))
+
+	// Drafts
+	mux.HandleFunc("/api/drafts", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleDrafts))
+	mux.HandleFunc("/api/drafts/", s.auth.RequireScope(auth.ScopeReadMessages, auth.ScopeWriteMessages, s.handleDraft))
+
+	// Instance administration
+	mux.HandleFunc("/api/admin/stats", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminStats)))
+	mux.HandleFunc("/api/admin/metrics", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminMetrics)))
+	mux.HandleFunc("/api/admin/drain", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminDrain)))
+	mux.HandleFunc("/api/admin/reload", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminReload)))
+	mux.HandleFunc("/api/admin/backup", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminBackup)))
+	mux.HandleFunc("/api/admin/halls", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminHalls)))
+	mux.HandleFunc("/api/admin/users", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminUsers)))
+	mux.HandleFunc("/api/admin/users/", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminUserWithID)))
+	mux.HandleFunc("/api/admin/invites", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminInvites)))
+	mux.HandleFunc("/api/admin/invites/", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminInviteWithID)))
+	mux.HandleFunc("/api/admin/legal-holds", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminLegalHolds)))
+	mux.HandleFunc("/api/admin/legal-holds/", s.auth.RequireAuth(s.requireInstanceAdmin(s.handleAdminLegalHoldWithID)))
+
+	// Public rooms, readable without an account when guest access is on
+	mux.HandleFunc("/api/public/rooms", s.handlePublicRooms)
+	mux.HandleFunc("/api/public/rooms/", s.handlePublicRoom)
+
+	// Invite QR codes, for anyone with the code
+	mux.HandleFunc("/api/invites/", s.handleInvite)
+
+	// Atom feeds of announcement rooms, authorized by a signed token
+	mux.HandleFunc("/feeds/rooms/", s.handleFeed)
+
+	// Web archive of public rooms, for anyone
+	mux.HandleFunc("/archive/", s.handleArchive)
+
+	// WebSocket endpoint
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/api/events", s.handleEvents)
+
+	return mux
+}
+
+func (s *Server) handleInstance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Point clients at wss:// when they reached us over HTTPS
+	wsScheme := api.WebSocketScheme(r)
+
+	api.RespondJSON(w, map[string]interface{}{
+		"name":          "commons-api",
+		"websocket_url": wsScheme + "://" + r.Host + "/ws",
+		"capabilities":  s.capabilities(),
+		"webhooks": map[string]interface{}{
+			"signature": webhookScheme,
+			"events":    webhookEvents,
+		},
+	})
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.config.Load().Registration == RegistrationClosed {
+		api.RespondErrorCode(w, api.ErrCodeRegistrationClosed, "Registration is closed", http.StatusForbidden)
+		return
+	}
+
+	// invite_token is only needed when registration is invite-only, and
+	// captcha when captcha is on. email is optional. cookie asks for the
+	// session in a cookie instead of a bearer token.
+	var req struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		Email       string `json:"email"`
+		InviteToken string `json:"invite_token"`
+		Captcha     string `json:"captcha"`
+		Cookie      bool   `json:"cookie"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	errs := append(
+		s.policy.ValidateUsername(req.Username),
+		s.policy.ValidatePassword("password", req.Password, req.Username)...,
+	)
+	email := ""
+	if strings.TrimSpace(req.Email) != "" {
+		var ok bool
+		if email, ok = parseEmail(req.Email); !ok {
+			errs = append(errs, api.FieldError{Field: "email", Code: "invalid", Message: "email must be a valid email address"})
+		}
+	}
+	if len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return
+	}
+
+	if s.captcha != nil && !s.checkCaptcha(w, r, req.Captcha) {
+		return
+	}
+
+	if !s.checkUsernameAvailable(w, r, req.Username, 0) {
+		return
+	}
+
+	if !s.useRegistrationInvite(w, r, req.InviteToken) {
+		return
+	}
+
+	user, err := s.db.CreateUser(r.Context(), req.Username, req.Password)
+	if err != nil {
+		s.releaseRegistrationInvite(r.Context(), req.InviteToken)
+		if errors.Is(err, store.ErrUsernameTaken) {
+			api.RespondErrorCode(w, api.ErrCodeUsernameTaken, "Username already exists", http.StatusConflict)
+			return
+		}
+		api.RespondError(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	if s.config.Load().Registration == RegistrationInviteOnly {
+		s.logger.Printf("User %s registered with an invite", user.Username)
+	}
+
+	// The account works without the email, so don't fail over it
+	if email != "" {
+		if err := s.db.SetEmailSettings(r.Context(), user.ID, email, true); err != nil {
+			s.logger.Printf("Failed to save email of user %s: %v", user.Username, err)
+		} else if _, err := s.sendEmailVerification(r.Context(), r, user.ID, user.Username, email); err != nil {
+			s.logger.Printf("Failed to send email verification to user %s: %v", user.Username, err)
+		}
+	}
+
+	// Add user to the default hall, if there is one and no plugin objects
+	if s.config.Load().DefaultHall != "" {
+		if err := s.defaultHallJoin(r.Context(), user); err != nil {
+			s.logger.Printf("Plugin kept user %s out of the default hall: %v", user.Username, err)
+		} else if hallID, err := s.db.AddUserToDefaultHall(r.Context(), s.config.Load().DefaultHall, user.ID); err != nil {
+			s.logger.Printf("Warning: Failed to add user %s to default hall: %v", user.Username, err)
+			// Don't fail registration if this fails, just log it
+		} else {
+			s.wsManager.BroadcastToHall(r.Context(), hallID, "member_joined", ws.HallMemberData{
+				HallID:   hallID,
+				UserID:   user.ID,
+				Username: user.Username,
+			})
+			s.welcomeMember(r.Context(), hallID, user.Username)
+		}
+	}
+
+	session, err := s.auth.CreateSession(user, r, req.Cookie)
+	if err != nil {
+		api.RespondError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	s.respondSession(w, r, user, session)
+}
+
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if _, err := s.db.AuthenticateUser(r.Context(), session.Username, req.CurrentPassword); err != nil {
+		api.RespondValidationErrors(w, []api.FieldError{{
+			Field:   "current_password",
+			Code:    "incorrect",
+			Message: "Current password is incorrect",
+		}})
+		return
+	}
+
+	if errs := s.policy.ValidatePassword("new_password", req.NewPassword, session.Username); len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return
+	}
+
+	if err := s.db.UpdatePassword(r.Context(), session.UserID, req.NewPassword); err != nil {
+		api.RespondError(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	// Sign out everywhere else
+	revoked, err := s.auth.RevokeSessions(r.Context(), session.UserID, func(candidate *auth.Session) bool {
+		return candidate.ID != session.ID
+	})
+	if err != nil {
+		s.logger.Printf("Failed to revoke sessions of user %d: %v", session.UserID, err)
+		api.RespondError(w, "Failed to revoke other sessions", http.StatusInternalServerError)
+		return
+	}
+	s.wsManager.DisconnectSessions(session.UserID, revoked)
+
+	api.RespondJSON(w, map[string]interface{}{
+		"success":          true,
+		"revoked_sessions": len(revoked),
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Captcha  string `json:"captcha"`
+		Cookie   bool   `json:"cookie"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	// A captcha is only asked for after repeated failures
+	ip := api.ClientIP(r)
+	if s.captcha != nil && s.captcha.LoginNeedsCaptcha(ip, req.Username) && !s.checkCaptcha(w, r, req.Captcha) {
+		return
+	}
+
+	user, err := s.db.AuthenticateUser(r.Context(), req.Username, req.Password)
+	if err != nil {
+		if s.captcha != nil {
+			s.captcha.RecordLoginFailure(ip, req.Username)
+		}
+		api.RespondError(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if s.captcha != nil {
+		s.captcha.ClearLoginFailures(req.Username)
+	}
+
+	session, err := s.auth.CreateSession(user, r, req.Cookie)
+	if err != nil {
+		api.RespondError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	s.respondSession(w, r, user, session)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := s.auth.ExtractToken(r)
+	if session, err := s.auth.ValidateSession(r.Context(), token); err == nil {
+		sessionID, err := s.auth.DeleteSession(r.Context(), token)
+		if err != nil {
+			api.RespondError(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+		s.wsManager.DisconnectSessions(session.UserID, []string{sessionID})
+	}
+	auth.ClearSessionCookies(w, r)
+
+	api.RespondJSON(w, map[string]string{"status": "logged out"})
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.auth.UserSessions(r.Context(), session.UserID, session.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch sessions", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"sessions": sessions,
+		})
+	case http.MethodDelete:
+		// DELETE /api/sessions?others=true signs out every other device
+		if r.URL.Query().Get("others") != "true" {
+			api.RespondError(w, "Use ?others=true to revoke all other sessions", http.StatusBadRequest)
+			return
+		}
+		revoked, err := s.auth.RevokeSessions(r.Context(), session.UserID, func(candidate *auth.Session) bool {
+			return candidate.ID != session.ID
+		})
+		if err != nil {
+			api.RespondError(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		s.wsManager.DisconnectSessions(session.UserID, revoked)
+
+		api.RespondJSON(w, map[string]interface{}{
+			"revoked": len(revoked),
+		})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleSessionWithID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract session ID from URL path /api/sessions/{session_id}
+	sessionID := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	revoked, err := s.auth.RevokeSessions(r.Context(), session.UserID, func(candidate *auth.Session) bool {
+		return candidate.ID == sessionID
+	})
+	if err != nil {
+		api.RespondError(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if len(revoked) == 0 {
+		api.RespondError(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	s.wsManager.DisconnectSessions(session.UserID, revoked)
+
+	api.RespondJSON(w, map[string]string{"status": "session revoked"})
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	usage, err := s.auth.Usage().Usage(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	ownedHalls, err := s.ownedHallsQuota(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"day":         usage.Day,
+		"requests":    usage.Requests,
+		"daily_quota": s.auth.Usage().Quota(),
+		"remaining":   s.auth.Usage().Remaining(usage.Requests),
+		"resets_at":   auth.NextUsageReset(time.Now()),
+		"owned_halls": ownedHalls,
+	})
+}
+
+func (s *Server) handleHalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, ok := parseListFilter(w, r)
+	if !ok {
+		return
+	}
+
+	halls, total, nextCursor, err := s.db.ListUserHalls(r.Context(), session.UserID, filter)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch halls", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"halls":       halls,
+		"total":       total,
+		"next_cursor": listCursor(nextCursor),
+	})
+}
+
+func (s *Server) handleCreateHall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !s.requireVerifiedEmail(w, r, session.UserID) {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" {
+		api.RespondError(w, "Hall name required", http.StatusBadRequest)
+		return
+	}
+
+	ownedHalls, err := s.ownedHallsQuota(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to create hall", http.StatusInternalServerError)
+		return
+	}
+	if ownedHalls.Reached() {
+		respondQuotaExceeded(w, fmt.Sprintf("You've reached your quota of owned halls (%d)", ownedHalls.Limit))
+		return
+	}
+
+	hall, err := s.db.CreateHall(r.Context(), req.Name, session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to create hall", http.StatusInternalServerError)
+		return
+	}
+
+	// Create default "#general" room
+	_, err = s.db.CreateRoom(r.Context(), hall.ID, "#general", store.RoomTypeText)
+	if err != nil {
+		api.RespondError(w, "Failed to create default room", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"hall": hall,
+	})
+}
+
+func (s *Server) handleJoinHall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		InviteCode string `json:"invite_code"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.InviteCode == "" {
+		api.RespondError(w, "Invite code required", http.StatusBadRequest)
+		return
+	}
+
+	hall, err := s.db.GetHallByInviteCode(r.Context(), req.InviteCode)
+	if err != nil {
+		api.RespondError(w, "Invalid invite code or already member", http.StatusBadRequest)
+		return
+	}
+
+	// Joining again is a no-op, and shouldn't be announced again
+	wasMember, err := s.db.IsUserInHall(r.Context(), session.UserID, hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to join hall", http.StatusInternalServerError)
+		return
+	}
+
+	if !wasMember {
+		_, members, err := s.hallQuotas(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to join hall", http.StatusInternalServerError)
+			return
+		}
+		if members.Reached() {
+			respondQuotaExceeded(w, fmt.Sprintf("This hall has reached its member quota (%d)", members.Limit))
+			return
+		}
+
+		settings, err := s.db.GetHallSettings(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to join hall", http.StatusInternalServerError)
+			return
+		}
+		if settings.JoinMode == store.JoinModeApproval {
+			s.requestToJoin(w, r, hall)
+			return
+		}
+
+		join := ws.PluginJoin{HallID: hall.ID, UserID: session.UserID, Username: session.Username}
+		if err := s.plugins.UserJoin(r.Context(), join); err != nil {
+			api.RespondErrorCode(w, api.ErrCodePluginRejected, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	err = s.db.JoinHall(r.Context(), session.UserID, req.InviteCode)
+	if err != nil {
+		api.RespondError(w, "Invalid invite code or already member", http.StatusBadRequest)
+		return
+	}
+
+	if !wasMember {
+		s.wsManager.BroadcastToHall(r.Context(), hall.ID, "member_joined", ws.HallMemberData{
+			HallID:   hall.ID,
+			UserID:   session.UserID,
+			Username: session.Username,
+		})
+		s.welcomeMember(r.Context(), hall.ID, session.Username)
+	}
+
+	// Clients open this room first
+	var landingRoom *store.Room
+	if settings, err := s.db.GetHallSettings(r.Context(), hall.ID); err != nil {
+		s.logger.Printf("Failed to load settings of hall %d: %v", hall.ID, err)
+	} else if landingRoom, err = s.landingRoom(r.Context(), hall.ID, settings); err != nil {
+		s.logger.Printf("Failed to find landing room of hall %d: %v", hall.ID, err)
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"hall":         hall,
+		"landing_room": landingRoom,
+	})
+}
+
+func (s *Server) handleLeaveHall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		HallID int `json:"hall_id"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.HallID == 0 {
+		api.RespondError(w, "Hall ID required", http.StatusBadRequest)
+		return
+	}
+
+	wasMember, err := s.db.IsUserInHall(r.Context(), session.UserID, req.HallID)
+	if err != nil {
+		api.RespondError(w, "Failed to leave hall", http.StatusInternalServerError)
+		return
+	}
+
+	err = s.db.LeaveHall(r.Context(), session.UserID, req.HallID)
+	if err != nil {
+		api.RespondError(w, "Failed to leave hall", http.StatusBadRequest)
+		return
+	}
+
+	// The leaver's other tabs hear about it too
+	if wasMember {
+		s.wsManager.BroadcastToHall(r.Context(), req.HallID, "member_left", ws.HallMemberData{
+			HallID:   req.HallID,
+			UserID:   session.UserID,
+			Username: session.Username,
+		}, session.UserID)
+		s.postHallSystemMessage(r.Context(), req.HallID, i18n.SystemMemberLeft, map[string]string{"username": session.Username})
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// roomRouteScope is the scope a token needs for a request under /api/rooms/:
+// reading takes read:messages, posting messages and read markers
+// write:messages, and changing the room admin:hall
+func roomRouteScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return auth.ScopeReadMessages
+	}
+	if strings.HasSuffix(r.URL.Path, "/messages") || strings.HasSuffix(r.URL.Path, "/read") {
+		return auth.ScopeWriteMessages
+	}
+	return auth.ScopeAdminHall
+}
+
+func (s *Server) handleRoomsWithID(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract path /api/rooms/{id} or /api/rooms/{id}/action
+	path := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
+	parts := strings.Split(path, "/")
+
+	if len(parts) == 1 {
+		// Handle /api/rooms/{hall_id} - get rooms in hall
+		s.handleRooms(w, r)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "delete" {
+		// Handle /api/rooms/{room_id}/delete
+		s.handleDeleteRoomByID(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "top" {
+		// Handle /api/rooms/{room_id}/top
+		s.handleTopMessages(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "message-ttl" {
+		// Handle /api/rooms/{room_id}/message-ttl
+		s.handleRoomMessageTTL(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "export" {
+		// Handle /api/rooms/{room_id}/export
+		s.handleRoomExport(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "members" {
+		// Handle /api/rooms/{room_id}/members
+		s.handleRoomMembers(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "messages" {
+		// Handle /api/rooms/{room_id}/messages
+		s.handlePostRoomMessage(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "read" {
+		// Handle /api/rooms/{room_id}/read
+		s.handleRoomRead(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "state" {
+		// Handle /api/rooms/{room_id}/state
+		s.handleRoomState(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "events" {
+		// Handle /api/rooms/{room_id}/events
+		s.handleRoomEvents(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "voice" {
+		// Handle /api/rooms/{room_id}/voice
+		s.handleRoomVoice(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "feed" {
+		// Handle /api/rooms/{room_id}/feed
+		s.handleRoomFeed(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "public" {
+		// Handle /api/rooms/{room_id}/public
+		s.handleRoomPublic(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "extend" {
+		// Handle /api/rooms/{room_id}/extend
+		s.handleExtendRoom(w, r, parts[0])
+		return
+	}
+
+	if len(parts) == 2 && (parts[1] == "archive" || parts[1] == "unarchive") {
+		// Handle /api/rooms/{room_id}/archive and /api/rooms/{room_id}/unarchive
+		s.handleArchiveRoom(w, r, parts[0], parts[1] == "archive")
+		return
+	}
+
+	api.RespondError(w, "Invalid URL format", http.StatusNotFound)
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract hall ID from URL path /api/rooms/{hall_id}
+	path := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
+	hallID, err := strconv.Atoi(path)
+	if err != nil {
+		api.RespondError(w, "Invalid hall ID", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is member of hall
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, hallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	// Archived rooms are hidden unless asked for
+	includeArchived := r.URL.Query().Get("archived") == "true"
+
+	filter, ok := parseListFilter(w, r)
+	if !ok {
+		return
+	}
+
+	rooms, total, nextCursor, err := s.db.ListHallRooms(r.Context(), hallID, includeArchived, filter)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+	listed, err := s.withReadStates(r.Context(), session.UserID, rooms)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"rooms":       listed,
+		"total":       total,
+		"next_cursor": listCursor(nextCursor),
+	})
+}
+
+func (s *Server) handleDeleteRoomByID(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get room to check ownership
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// Get hall to check if user is owner
+	hall, err := s.db.GetHallByID(r.Context(), room.HallID)
+	if err != nil {
+		api.RespondError(w, "Hall not found", http.StatusNotFound)
+		return
+	}
+
+	if hall.OwnerID != session.UserID {
+		api.RespondError(w, "Only hall owner can delete rooms", http.StatusForbidden)
+		return
+	}
+
+	err = s.db.DeleteRoom(r.Context(), roomID)
+	if errors.Is(err, store.ErrLegalHold) {
+		respondLegalHold(w, "This room has messages under a legal hold")
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to delete room", http.StatusInternalServerError)
+		return
+	}
+
+	s.wsManager.BroadcastToHall(r.Context(), room.HallID, "room_deleted", RoomEventData{
+		RoomID: room.ID,
+		HallID: room.HallID,
+		Name:   room.Name,
+	})
+
+	api.RespondJSON(w, map[string]string{"status": "room deleted"})
+}
+
+func (s *Server) handleArchiveRoom(w http.ResponseWriter, r *http.Request, roomIDStr string, archive bool) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can archive rooms", http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.SetRoomArchived(r.Context(), roomID, archive); err != nil {
+		api.RespondError(w, "Failed to update room", http.StatusInternalServerError)
+		return
+	}
+
+	// The audit log action doubles as the ws event name
+	action := "room_unarchived"
+	if archive {
+		action = "room_archived"
+	}
+	if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, action, "room", roomID, ""); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	s.wsManager.BroadcastToRoom(roomID, action, RoomEventData{
+		RoomID: room.ID,
+		HallID: room.HallID,
+		Name:   room.Name,
+	})
+
+	room.Archived = archive
+	api.RespondJSON(w, map[string]interface{}{
+		"room": room,
+	})
+}
+
+// handleExtendRoom moves the expiry of a temporary room
+func (s *Server) handleExtendRoom(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if !req.ExpiresAt.After(time.Now()) {
+		api.RespondError(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isAdmin {
+		api.RespondError(w, "Only hall admins can extend rooms", http.StatusForbidden)
+		return
+	}
+
+	if room.ExpiresAt == nil {
+		api.RespondError(w, "Room is not temporary", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.SetRoomExpiry(r.Context(), roomID, req.ExpiresAt, room.OnExpiry); err != nil {
+		api.RespondError(w, "Failed to extend room", http.StatusInternalServerError)
+		return
+	}
+
+	details := fmt.Sprintf("expires at %s", req.ExpiresAt.UTC().Format(time.RFC3339))
+	if err := s.db.AddAuditLog(r.Context(), room.HallID, session.UserID, "room_extended", "room", roomID, details); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	expiresAt := req.ExpiresAt.UTC()
+	room.ExpiresAt = &expiresAt
+	s.wsManager.BroadcastToRoom(roomID, "room_extended", RoomEventData{
+		RoomID:    room.ID,
+		HallID:    room.HallID,
+		Name:      room.Name,
+		ExpiresAt: room.ExpiresAt,
+	})
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room": room,
+	})
+}
+
+// topMessagePeriods maps the period query parameter of the top messages
+// endpoint to how far back it looks; "all" has no cutoff
+var topMessagePeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"all":   0,
+}
+
+// handleRoomMembers lists who can see a room, for member sidebars
+func (s *Server) handleRoomMembers(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	// Online means the same as for email notifications: pinged recently
+	limit, offset := parsePagination(r)
+	members, total, online, err := s.db.GetRoomMembers(r.Context(), room.HallID, time.Now().Add(-notifyOfflineAfter), limit, offset)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch members", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"members": members,
+		"total":   total,
+		"online":  online,
+	})
+}
+
+func (s *Server) handleTopMessages(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "week"
+	}
+	window, ok := topMessagePeriods[period]
+	if !ok {
+		api.RespondError(w, "Period must be day, week, month or all", http.StatusBadRequest)
+		return
+	}
+
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+
+	limit := 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 50 {
+			limit = parsedLimit
+		}
+	}
+
+	top, err := s.db.GetTopMessages(r.Context(), roomID, since, limit)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch top messages", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"period":   period,
+		"since":    since.UTC(),
+		"messages": top,
+	})
+}
+
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		HallID    int        `json:"hall_id"`
+		Name      string     `json:"name"`
+		Type      string     `json:"type"`       // text unless set
+		ExpiresAt *time.Time `json:"expires_at"` // optional, makes the room temporary
+		OnExpiry  string     `json:"on_expiry"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" || req.HallID == 0 {
+		api.RespondError(w, "Hall ID and room name required", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Type {
+	case "":
+		req.Type = store.RoomTypeText
+	case store.RoomTypeText, store.RoomTypeVoice:
+	default:
+		api.RespondError(w, "type must be text or voice", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			api.RespondError(w, "Expiry must be in the future", http.StatusBadRequest)
+			return
+		}
+		switch req.OnExpiry {
+		case "":
+			req.OnExpiry = store.RoomExpiryArchive
+		case store.RoomExpiryArchive, store.RoomExpiryDelete:
+		default:
+			api.RespondError(w, "on_expiry must be archive or delete", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Clean and validate room name
+	cleanName := store.CleanRoomName(req.Name)
+	if cleanName == "" {
+		api.RespondError(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is member of hall
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, req.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	settings, err := s.db.GetHallSettings(r.Context(), req.HallID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch hall settings", http.StatusInternalServerError)
+		return
+	}
+	if settings.RoomCreation == store.RoomCreationAdmins {
+		isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, req.HallID)
+		if err != nil || !isAdmin {
+			api.RespondError(w, "Only hall admins can create rooms in this hall", http.StatusForbidden)
+			return
+		}
+	}
+
+	rooms, _, err := s.hallQuotas(r.Context(), req.HallID)
+	if err != nil {
+		api.RespondError(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+	if rooms.Reached() {
+		respondQuotaExceeded(w, fmt.Sprintf("This hall has reached its room quota (%d)", rooms.Limit))
+		return
+	}
+
+	room, err := s.db.CreateRoom(r.Context(), req.HallID, cleanName, req.Type)
+	if err != nil {
+		if errors.Is(err, store.ErrRoomNameTaken) {
+			api.RespondErrorCode(w, api.ErrCodeRoomNameTaken, "Room name already exists in this hall", http.StatusConflict)
+			return
+		}
+		api.RespondError(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	if req.ExpiresAt != nil {
+		if err := s.db.SetRoomExpiry(r.Context(), room.ID, *req.ExpiresAt, req.OnExpiry); err != nil {
+			s.db.DeleteRoom(r.Context(), room.ID)
+			api.RespondError(w, "Failed to create room", http.StatusInternalServerError)
+			return
+		}
+		if room, err = s.db.GetRoomByID(r.Context(), room.ID); err != nil {
+			api.RespondError(w, "Failed to fetch room", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.wsManager.BroadcastToHall(r.Context(), room.HallID, "room_created", ws.RoomCreatedData{
+		HallID: room.HallID,
+		Room:   room,
+	})
+	s.postHallSystemMessage(r.Context(), room.HallID, i18n.SystemRoomCreated, map[string]string{"username": session.Username, "room": room.Name})
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room": room,
+	})
+}
+
+// handleCommands serves GET /api/commands, the slash commands clients can
+// offer to complete
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"commands": s.wsManager.Commands(),
+	})
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/api/messages/id/"); ok {
+		s.handleMessageByID(w, r, rest)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract room ID from URL path /api/messages/{room_id}
+	path := strings.TrimPrefix(r.URL.Path, "/api/messages/")
+	roomID, err := strconv.Atoi(path)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get room to check hall membership
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// Check if user is member of hall
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	query := historyQuery{Limit: limit, Offset: offset}
+
+	// around_id={message_id} loads the context of a linked message, or of
+	// the first unread one, instead of a page counted back from the newest.
+	// around is its older name.
+	aroundStr := r.URL.Query().Get("around_id")
+	if aroundStr == "" {
+		aroundStr = r.URL.Query().Get("around")
+	}
+	if aroundStr != "" {
+		messageID, err := strconv.Atoi(aroundStr)
+		if err != nil {
+			api.RespondError(w, "Invalid message ID", http.StatusBadRequest)
+			return
+		}
+		query.AroundID = &messageID
+	}
+
+	// since_id={message_id} catches up on what came after the newest message
+	// the client has, oldest first
+	if sinceStr := r.URL.Query().Get("since_id"); sinceStr != "" && query.AroundID == nil {
+		sinceID, err := strconv.Atoi(sinceStr)
+		if err != nil || sinceID < 0 {
+			api.RespondError(w, "Invalid since_id", http.StatusBadRequest)
+			return
+		}
+		query.SinceID = &sinceID
+	}
+
+	messages, err := s.fetchHistory(r.Context(), roomID, query)
+	if errors.Is(err, errMessageNotFound) {
+		api.RespondError(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	respondJSONWithETag(w, r, map[string]interface{}{
+		"messages": messages,
+	})
+}
+
+// historyQuery is which of a room's messages a history fetch wants: with
+// AroundID those around a message, with SinceID those after one, and
+// otherwise a page counted back Offset messages from the newest
+type historyQuery struct {
+	Limit    int
+	Offset   int
+	SinceID  *int
+	AroundID *int
+}
+
+// errMessageNotFound is what fetchHistory returns when AroundID isn't a
+// message of the room
+var errMessageNotFound = errors.New("message not found")
+
+// fetchHistory loads the messages query asks for, oldest first. The caller
+// checks the user may read the room.
+func (s *Server) fetchHistory(ctx context.Context, roomID int, query historyQuery) ([]store.Message, error) {
+	switch {
+	case query.AroundID != nil:
+		message, err := s.db.GetMessageByID(ctx, *query.AroundID)
+		if err != nil || message.RoomID != roomID || store.IsExpired(message) {
+			return nil, errMessageNotFound
+		}
+		return s.db.GetRoomMessagesAround(ctx, roomID, *query.AroundID, query.Limit)
+	case query.SinceID != nil:
+		return s.db.GetRoomMessagesAfter(ctx, roomID, *query.SinceID, query.Limit)
+	default:
+		return s.db.GetRoomMessages(ctx, roomID, query.Limit, query.Offset)
+	}
+}
+
+// handleMessageByID serves /api/messages/id/{message_id}, which resolves a
+// permalink to the message and the room it's in, and the message's
+// components under it
+func (s *Server) handleMessageByID(w http.ResponseWriter, r *http.Request, path string) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	messageIDStr, action, _ := strings.Cut(path, "/")
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	message, err := s.db.GetMessageByID(r.Context(), messageID)
+	if err != nil || store.IsExpired(message) {
+		api.RespondError(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), message.RoomID)
+	if err != nil {
+		api.RespondError(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	switch action {
+	case "":
+	case "interactions":
+		s.handleMessageInteraction(w, r, message, room)
+		return
+	case "components":
+		s.handleMessageComponents(w, r, message)
+		return
+	default:
+		api.RespondError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"message": message,
+		"room":    room,
+	})
+}
+
+func (s *Server) handleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		RoomID   int    `json:"room_id,omitempty"`
+		RoomName string `json:"room_name,omitempty"`
+		HallID   int    `json:"hall_id,omitempty"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var room *store.Room
+	var err error
+
+	// Get room by ID or by name+hall
+	if req.RoomID != 0 {
+		room, err = s.db.GetRoomByID(r.Context(), req.RoomID)
+	} else if req.RoomName != "" && req.HallID != 0 {
+		room, err = s.db.GetRoomByName(r.Context(), req.HallID, req.RoomName)
+	} else {
+		api.RespondError(w, "Either room_id or (room_name + hall_id) required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// Get hall to check if user is owner
+	hall, err := s.db.GetHallByID(r.Context(), room.HallID)
+	if err != nil {
+		api.RespondError(w, "Hall not found", http.StatusNotFound)
+		return
+	}
+
+	if hall.OwnerID != session.UserID {
+		api.RespondError(w, "Only hall owner can delete rooms", http.StatusForbidden)
+		return
+	}
+
+	err = s.db.DeleteRoom(r.Context(), room.ID)
+	if errors.Is(err, store.ErrLegalHold) {
+		respondLegalHold(w, "This room has messages under a legal hold")
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to delete room", http.StatusInternalServerError)
+		return
+	}
+
+	s.wsManager.BroadcastToHall(r.Context(), room.HallID, "room_deleted", RoomEventData{
+		RoomID: room.ID,
+		HallID: room.HallID,
+		Name:   room.Name,
+	})
+
+	api.RespondJSON(w, map[string]string{"status": "room deleted"})
+}
+
+func (s *Server) handleHallWithID(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract hall ID from URL path /api/halls/{hall_id}/action
+	path := strings.TrimPrefix(r.URL.Path, "/api/halls/")
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		api.RespondError(w, "Invalid URL format", http.StatusBadRequest)
+		return
+	}
+
+	hallID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid hall ID", http.StatusBadRequest)
+		return
+	}
+
+	action := parts[1]
+
+	// Check if user owns the hall
+	hall, err := s.db.GetHallByID(r.Context(), hallID)
+	if err != nil {
+		api.RespondError(w, "Hall not found", http.StatusNotFound)
+		return
+	}
+
+	// Members can see the settings, only the owner can change them
+	if action == "settings" && len(parts) == 2 {
+		s.handleHallSettings(w, r, hall)
+		return
+	}
+	if action == "usage" && len(parts) == 2 {
+		s.handleHallUsage(w, r, hall)
+		return
+	}
+	// Someone's own join request, they aren't a member yet
+	if action == "join-requests" && len(parts) == 3 && parts[2] == "me" {
+		s.handleOwnJoinRequest(w, r, hall)
+		return
+	}
+
+	// Moderation actions are open to hall admins, the rest is owner-only
+	switch action {
+	case "automod", "audit-log", "flagged", "auto-archive", "retention", "spam", "messages", "join-requests":
+		isAdmin, err := s.db.IsHallAdmin(r.Context(), session.UserID, hallID)
+		if err != nil || !isAdmin {
+			api.RespondError(w, "Only hall admins can perform moderation actions", http.StatusForbidden)
+			return
+		}
+		s.handleHallModeration(w, r, hall, parts[1:])
+		return
+	}
+
+	if hall.OwnerID != session.UserID {
+		api.RespondError(w, "Only hall owner can perform admin actions", http.StatusForbidden)
+		return
+	}
+
+	switch action {
+	case "regenerate-invite":
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		newCode, err := s.db.RegenerateInviteCode(r.Context(), hallID)
+		if err != nil {
+			api.RespondError(w, "Failed to regenerate invite code", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"invite_code": newCode,
+		})
+	case "delete":
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// The default hall is where new accounts land
+		if s.isDefaultHall(r.Context(), hall) {
+			api.RespondError(w, "Cannot delete default hall", http.StatusForbidden)
+			return
+		}
+		err := s.db.DeleteHall(r.Context(), hallID)
+		if errors.Is(err, store.ErrLegalHold) {
+			respondLegalHold(w, "This hall has messages under a legal hold")
+			return
+		}
+		if err != nil {
+			api.RespondError(w, "Failed to delete hall", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]string{"status": "hall deleted"})
+	case "export":
+		s.handleHallExport(w, r, hall, parts[2:])
+	case "webhooks":
+		s.handleHallWebhooks(w, r, hall, parts[2:])
+	default:
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+	}
+}
+
+// handleHallSettings serves /api/halls/{hall_id}/settings: GET shows the
+// hall's settings to its members, POST lets the owner change them
+func (s *Server) handleHallSettings(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	session := auth.SessionFromContext(r.Context())
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, hall.ID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if hall.OwnerID != session.UserID {
+			api.RespondError(w, "Only hall owner can change hall settings", http.StatusForbidden)
+			return
+		}
+
+		// Only the fields present in the request are changed
+		var req struct {
+			RoomCreation    *string `json:"room_creation"`
+			WelcomeMessage  *string `json:"welcome_message"`
+			LandingRoomID   *int    `json:"landing_room_id"`
+			JoinMode        *string `json:"join_mode"`
+			MentionEveryone *string `json:"mention_everyone"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.RoomCreation != nil {
+			switch *req.RoomCreation {
+			case store.RoomCreationMembers, store.RoomCreationAdmins:
+			default:
+				api.RespondError(w, "room_creation must be members or admins", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.JoinMode != nil {
+			switch *req.JoinMode {
+			case store.JoinModeOpen, store.JoinModeApproval:
+			default:
+				api.RespondError(w, "join_mode must be open or approval", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.MentionEveryone != nil {
+			switch *req.MentionEveryone {
+			case store.MentionEveryoneAdmins, store.MentionEveryoneMembers:
+			default:
+				api.RespondError(w, "mention_everyone must be admins or members", http.StatusBadRequest)
+				return
+			}
+		}
+		if maxLength := s.config.Load().MaxMessageLength; req.WelcomeMessage != nil && ws.MessageTooLong(*req.WelcomeMessage, maxLength) {
+			api.RespondError(w, fmt.Sprintf("welcome_message is longer than %d characters", maxLength), http.StatusBadRequest)
+			return
+		}
+		// 0 goes back to landing in the oldest text room
+		if req.LandingRoomID != nil && *req.LandingRoomID != 0 {
+			room, err := s.db.GetRoomByID(r.Context(), *req.LandingRoomID)
+			if err != nil || room.HallID != hall.ID {
+				api.RespondError(w, "Landing room not found in this hall", http.StatusBadRequest)
+				return
+			}
+			if room.Type != store.RoomTypeText || room.Archived {
+				api.RespondError(w, "The landing room must be an unarchived text room", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var changes []string
+		if req.RoomCreation != nil {
+			if err := s.db.SetRoomCreation(r.Context(), hall.ID, *req.RoomCreation); err != nil {
+				api.RespondError(w, "Failed to update hall settings", http.StatusInternalServerError)
+				return
+			}
+
+			changes = append(changes, "room_creation="+*req.RoomCreation)
+		}
+		if req.WelcomeMessage != nil {
+			if err := s.db.SetWelcomeMessage(r.Context(), hall.ID, *req.WelcomeMessage); err != nil {
+				api.RespondError(w, "Failed to update hall settings", http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, "welcome_message")
+		}
+		if req.LandingRoomID != nil {
+			if err := s.db.SetLandingRoom(r.Context(), hall.ID, *req.LandingRoomID); err != nil {
+				api.RespondError(w, "Failed to update hall settings", http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, fmt.Sprintf("landing_room_id=%d", *req.LandingRoomID))
+		}
+		if req.JoinMode != nil {
+			if err := s.db.SetJoinMode(r.Context(), hall.ID, *req.JoinMode); err != nil {
+				api.RespondError(w, "Failed to update hall settings", http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, "join_mode="+*req.JoinMode)
+		}
+		if req.MentionEveryone != nil {
+			if err := s.db.SetMentionEveryone(r.Context(), hall.ID, *req.MentionEveryone); err != nil {
+				api.RespondError(w, "Failed to update hall settings", http.StatusInternalServerError)
+				return
+			}
+			changes = append(changes, "mention_everyone="+*req.MentionEveryone)
+		}
+
+		if len(changes) > 0 {
+			details := strings.Join(changes, " ")
+			if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "hall_settings_updated", "hall", hall.ID, details); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := s.db.GetHallSettings(r.Context(), hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch hall settings", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		s.wsManager.BroadcastToHall(r.Context(), hall.ID, "hall_settings_updated", store.HallSettingsData{
+			HallID:   hall.ID,
+			Settings: settings,
+		})
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"settings": settings,
+	})
+}
+
+// handleHallExport serves /api/halls/{hall_id}/export (start an export),
+// /export/{export_id} (its status) and /export/{export_id}/download
+func (s *Server) handleHallExport(w http.ResponseWriter, r *http.Request, hall *store.Hall, parts []string) {
+	session := auth.SessionFromContext(r.Context())
+
+	if len(parts) == 0 {
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		job, err := s.exports.Start(hall, session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to start export", http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "hall_exported", "hall", hall.ID, job.ID); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"export": job,
+		})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, ok := s.exports.Get(hall.ID, parts[0])
+	if !ok {
+		api.RespondError(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		api.RespondJSON(w, map[string]interface{}{
+			"export": job,
+		})
+	case len(parts) == 2 && parts[1] == "download":
+		if job.Status != ExportStatusDone {
+			api.RespondErrorCode(w, api.ErrCodeExportNotReady, "Export is not ready", http.StatusConflict)
+			return
+		}
+		filename := fmt.Sprintf("hall-%d-export-%s.zip", hall.ID, job.CreatedAt.UTC().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		http.ServeFile(w, r, job.path)
+	default:
+		api.RespondError(w, "Invalid URL format", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleGiveAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		HallID   int    `json:"hall_id"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	// Check if user owns the hall
+	hall, err := s.db.GetHallByID(r.Context(), req.HallID)
+	if err != nil {
+		api.RespondError(w, "Hall not found", http.StatusNotFound)
+		return
+	}
+
+	if hall.OwnerID != session.UserID {
+		api.RespondError(w, "Only hall owner can give admin rights", http.StatusForbidden)
+		return
+	}
+
+	// Get target user
+	targetUser, err := s.db.ResolveUsername(r.Context(), req.Username, usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Check if user is member of hall
+	isMember, err := s.db.IsUserInHall(r.Context(), targetUser.ID, req.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "User is not a member of this hall", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.AddHallAdmin(r.Context(), req.HallID, targetUser.ID, session.UserID); err != nil {
+		api.RespondError(w, "Failed to grant admin rights", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.db.AddAuditLog(r.Context(), req.HallID, session.UserID, "admin_granted", "user", targetUser.ID, targetUser.Username); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	api.RespondJSON(w, map[string]string{"status": "admin rights granted"})
+}
+
+// parsePagination reads the limit and offset query parameters shared by the
+// list endpoints
+func parsePagination(r *http.Request) (int, int) {
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
+			limit = parsedLimit
+		}
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	return limit, offset
+}
+
+// parseListFilter reads the name, limit and cursor query parameters of the
+// hall and room listings. Without a limit the whole list comes back, as it
+// did before these were paginated.
+func parseListFilter(w http.ResponseWriter, r *http.Request) (store.ListFilter, bool) {
+	query := r.URL.Query()
+	filter := store.ListFilter{Name: strings.TrimSpace(query.Get("name"))}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			api.RespondError(w, "Limit must be between 1 and 100", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.Limit = limit
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := strconv.Atoi(cursorStr)
+		if err != nil || cursor <= 0 {
+			api.RespondError(w, "Invalid cursor", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.Cursor = cursor
+	}
+
+	return filter, true
+}
+
+// listCursor turns a next-page cursor into JSON, null on the last page
+func listCursor(cursor int) interface{} {
+	if cursor == 0 {
+		return nil
+	}
+	return cursor
+}
+
+// handleHallModeration serves /api/halls/{hall_id}/{automod,audit-log,flagged,auto-archive,retention,spam,messages,join-requests}
+// for hall admins
+func (s *Server) handleHallModeration(w http.ResponseWriter, r *http.Request, hall *store.Hall, parts []string) {
+	session := auth.SessionFromContext(r.Context())
+
+	switch {
+	case parts[0] == "audit-log" && len(parts) == 1:
+		if r.Method != http.MethodGet {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, offset := parsePagination(r)
+		entries, err := s.db.GetAuditLog(r.Context(), hall.ID, limit, offset)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch audit log", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"entries": entries,
+		})
+
+	case parts[0] == "flagged" && len(parts) == 1:
+		if r.Method != http.MethodGet {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, offset := parsePagination(r)
+		flagged, err := s.db.GetFlaggedMessages(r.Context(), hall.ID, limit, offset)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch flagged messages", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"flagged": flagged,
+		})
+
+	case parts[0] == "automod" && len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := s.db.GetAutomodRules(r.Context(), hall.ID)
+			if err != nil {
+				api.RespondError(w, "Failed to fetch automod rules", http.StatusInternalServerError)
+				return
+			}
+			api.RespondJSON(w, map[string]interface{}{
+				"rules": rules,
+			})
+		case http.MethodPost:
+			var req struct {
+				Pattern string `json:"pattern"`
+				IsRegex bool   `json:"is_regex"`
+				Action  string `json:"action"`
+			}
+
+			if !api.DecodeJSON(w, r, &req) {
+				return
+			}
+
+			if req.Pattern == "" {
+				api.RespondError(w, "Pattern required", http.StatusBadRequest)
+				return
+			}
+
+			switch req.Action {
+			case store.AutomodActionReject, store.AutomodActionFlag, store.AutomodActionDelete:
+			default:
+				api.RespondError(w, "Action must be reject, flag or delete", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := ws.CompileAutomodPattern(req.Pattern, req.IsRegex); err != nil {
+				api.RespondError(w, "Invalid pattern: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			rule, err := s.db.CreateAutomodRule(r.Context(), hall.ID, req.Pattern, req.IsRegex, req.Action, session.UserID)
+			if err != nil {
+				api.RespondError(w, "Failed to create automod rule", http.StatusInternalServerError)
+				return
+			}
+
+			details := fmt.Sprintf("%s %q", rule.Action, rule.Pattern)
+			if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "automod_rule_created", "automod_rule", rule.ID, details); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+
+			api.RespondJSON(w, map[string]interface{}{
+				"rule": rule,
+			})
+		default:
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	case parts[0] == "automod" && len(parts) == 3 && parts[2] == "delete":
+		// /api/halls/{hall_id}/automod/{rule_id}/delete
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ruleID, err := strconv.Atoi(parts[1])
+		if err != nil {
+			api.RespondError(w, "Invalid rule ID", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := s.db.DeleteAutomodRule(r.Context(), hall.ID, ruleID)
+		if err != nil {
+			api.RespondError(w, "Failed to delete automod rule", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			api.RespondError(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+
+		if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "automod_rule_deleted", "automod_rule", ruleID, ""); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+
+		api.RespondJSON(w, map[string]string{"status": "rule deleted"})
+
+	case parts[0] == "retention" && len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req store.RetentionPolicy
+			if !api.DecodeJSON(w, r, &req) {
+				return
+			}
+
+			if req.Days < 0 || req.MaxMessagesPerRoom < 0 {
+				api.RespondError(w, "Retention limits must be 0 (disabled) or more", http.StatusBadRequest)
+				return
+			}
+
+			if err := s.db.SetRetentionPolicy(r.Context(), hall.ID, req); err != nil {
+				api.RespondError(w, "Failed to update retention policy", http.StatusInternalServerError)
+				return
+			}
+
+			details := fmt.Sprintf("days=%d max_messages_per_room=%d", req.Days, req.MaxMessagesPerRoom)
+			if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "retention_updated", "hall", hall.ID, details); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+		default:
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		policy, err := s.db.GetRetentionPolicy(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch retention policy", http.StatusInternalServerError)
+			return
+		}
+		holds, err := s.db.GetLegalHolds(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch legal holds", http.StatusInternalServerError)
+			return
+		}
+		jobStats, hallStats := s.retention.Stats(hall.ID)
+		api.RespondJSON(w, map[string]interface{}{
+			"policy":      policy,
+			"legal_holds": holds,
+			"stats":       hallStats,
+			"job":         jobStats,
+		})
+
+	case parts[0] == "messages" && len(parts) == 2 && parts[1] == "delete":
+		// /api/halls/{hall_id}/messages/delete
+		s.handleBulkDeleteMessages(w, r, hall)
+
+	case parts[0] == "messages" && len(parts) == 3 && parts[2] == "delete":
+		// /api/halls/{hall_id}/messages/{message_id}/delete
+		s.handleModeratorDeleteMessage(w, r, hall, parts[1])
+
+	case parts[0] == "join-requests" && len(parts) == 1:
+		s.handleJoinRequests(w, r, hall)
+
+	case parts[0] == "join-requests" && len(parts) == 3:
+		// /api/halls/{hall_id}/join-requests/{user_id}/{approve,deny}
+		s.handleResolveJoinRequest(w, r, hall, parts[1], parts[2])
+
+	case parts[0] == "spam" && len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req store.SpamPolicy
+			if !api.DecodeJSON(w, r, &req) {
+				return
+			}
+
+			if req.FlagScore < 0 || req.ThrottleScore < 0 || req.DeleteScore < 0 {
+				api.RespondError(w, "Spam thresholds must be 0 (disabled) or more", http.StatusBadRequest)
+				return
+			}
+
+			if err := s.db.SetSpamPolicy(r.Context(), hall.ID, req); err != nil {
+				api.RespondError(w, "Failed to update spam policy", http.StatusInternalServerError)
+				return
+			}
+
+			details := fmt.Sprintf("flag_score=%d throttle_score=%d delete_score=%d", req.FlagScore, req.ThrottleScore, req.DeleteScore)
+			if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "spam_policy_updated", "hall", hall.ID, details); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+		default:
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		policy, err := s.db.GetSpamPolicy(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch spam policy", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"policy": policy,
+		})
+
+	case parts[0] == "auto-archive" && len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Days    int   `json:"days"`
+				Exclude []int `json:"exclude"`
+			}
+
+			if !api.DecodeJSON(w, r, &req) {
+				return
+			}
+
+			if req.Days < 0 {
+				api.RespondError(w, "Days must be 0 (disabled) or more", http.StatusBadRequest)
+				return
+			}
+
+			if err := s.db.SetAutoArchivePolicy(r.Context(), hall.ID, req.Days, req.Exclude); err != nil {
+				api.RespondError(w, "Failed to update auto-archive policy", http.StatusInternalServerError)
+				return
+			}
+
+			details := fmt.Sprintf("days=%d exclude=%v", req.Days, req.Exclude)
+			if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "auto_archive_updated", "hall", hall.ID, details); err != nil {
+				s.logger.Printf("Failed to write audit log: %v", err)
+			}
+		default:
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		days, exclude, err := s.db.GetAutoArchivePolicy(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch auto-archive policy", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"days":    days,
+			"exclude": exclude,
+		})
+
+	default:
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+	}
+}
+
+// defaultHallJoin runs the plugins' join hooks for a new account landing
+// in the default hall
+func (s *Server) defaultHallJoin(ctx context.Context, user *store.User) error {
+	hallID, err := s.db.GetDefaultHallID(ctx, s.config.Load().DefaultHall)
+	if err != nil {
+		return nil // AddUserToDefaultHall reports it
+	}
+	return s.plugins.UserJoin(ctx, ws.PluginJoin{HallID: hallID, UserID: user.ID, Username: user.Username})
+}
+
+// isDefaultHall reports whether hall is the configured default hall, which
+// is owned by the system user
+func (s *Server) isDefaultHall(ctx context.Context, hall *store.Hall) bool {
+	if s.config.Load().DefaultHall == "" || hall.Name != s.config.Load().DefaultHall {
+		return false
+	}
+	owner, err := s.db.GetUserByID(ctx, hall.OwnerID)
+	return err == nil && owner.Username == "system"
+}
+
+func (s *Server) handleDeleteHall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		HallID int `json:"hall_id"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	// Check if user owns the hall
+	hall, err := s.db.GetHallByID(r.Context(), req.HallID)
+	if err != nil {
+		api.RespondError(w, "Hall not found", http.StatusNotFound)
+		return
+	}
+
+	if hall.OwnerID != session.UserID {
+		api.RespondError(w, "Only hall owner can delete hall", http.StatusForbidden)
+		return
+	}
+
+	// The default hall is where new accounts land
+	if s.isDefaultHall(r.Context(), hall) {
+		api.RespondError(w, "Cannot delete default hall", http.StatusForbidden)
+		return
+	}
+
+	err = s.db.DeleteHall(r.Context(), req.HallID)
+	if errors.Is(err, store.ErrLegalHold) {
+		respondLegalHold(w, "This hall has messages under a legal hold")
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to delete hall", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]string{"status": "hall deleted"})
+}
+
+// maxEmailLength is the longest address SMTP allows (RFC 5321)
+const maxEmailLength = 254
+
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Every field is optional, so a client can change one setting at a time
+		var req struct {
+			DMPrivacy          *string            `json:"dm_privacy"`
+			Email              *string            `json:"email"`
+			EmailNotifications *bool              `json:"email_notifications"`
+			Timezone           *string            `json:"timezone"`
+			DNDWindows         *[]store.DNDWindow `json:"dnd_windows"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.DMPrivacy != nil {
+			switch *req.DMPrivacy {
+			case store.DMPrivacyEveryone, store.DMPrivacyHalls, store.DMPrivacyNobody:
+			default:
+				api.RespondError(w, "dm_privacy must be everyone, halls or nobody", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Timezone != nil && !store.ValidTimezone(*req.Timezone) {
+			api.RespondError(w, "timezone must be an IANA time zone like Europe/Berlin", http.StatusBadRequest)
+			return
+		}
+		if req.DNDWindows != nil {
+			if err := store.ValidateDNDWindows(*req.DNDWindows); err != nil {
+				api.RespondError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		settings, err := s.db.GetUserSettings(r.Context(), session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch settings", http.StatusInternalServerError)
+			return
+		}
+
+		oldEmail := settings.Email
+		if req.Email != nil {
+			settings.Email = ""
+			if strings.TrimSpace(*req.Email) != "" {
+				email, ok := parseEmail(*req.Email)
+				if !ok {
+					api.RespondError(w, "email must be a valid email address", http.StatusBadRequest)
+					return
+				}
+				settings.Email = email
+			}
+		}
+		if req.EmailNotifications != nil {
+			settings.EmailNotifications = *req.EmailNotifications
+		}
+
+		if req.DMPrivacy != nil {
+			if err := s.db.SetDMPrivacy(r.Context(), session.UserID, *req.DMPrivacy); err != nil {
+				api.RespondError(w, "Failed to update settings", http.StatusInternalServerError)
+				return
+			}
+		}
+		if req.Email != nil || req.EmailNotifications != nil {
+			if err := s.db.SetEmailSettings(r.Context(), session.UserID, settings.Email, settings.EmailNotifications); err != nil {
+				api.RespondError(w, "Failed to update settings", http.StatusInternalServerError)
+				return
+			}
+		}
+		// The time zone and windows are stored together, so keep whichever
+		// one wasn't sent
+		if req.Timezone != nil || req.DNDWindows != nil {
+			timezone, windows := settings.Timezone, settings.DNDWindows
+			if req.Timezone != nil {
+				timezone = *req.Timezone
+			}
+			if req.DNDWindows != nil {
+				windows = *req.DNDWindows
+			}
+			if err := s.db.SetDNDSchedule(r.Context(), session.UserID, timezone, windows); err != nil {
+				api.RespondError(w, "Failed to update settings", http.StatusInternalServerError)
+				return
+			}
+		}
+		if settings.Email != "" && settings.Email != oldEmail {
+			if _, err := s.sendEmailVerification(r.Context(), r, session.UserID, session.Username, settings.Email); err != nil {
+				s.logger.Printf("Failed to send email verification to user %s: %v", session.Username, err)
+			}
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	settings, err := s.db.GetUserSettings(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch settings", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, settings)
+}
+
+// handleNotificationPreferences lists the user's hall and room notification
+// levels, or sets one of them
+func (s *Server) handleNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// One of hall_id or room_id. "default" drops the preference, so a
+		// room follows its hall again
+		var req struct {
+			HallID int    `json:"hall_id"`
+			RoomID int    `json:"room_id"`
+			Level  string `json:"level"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		pref := store.NotificationPreference{HallID: req.HallID, RoomID: req.RoomID, Level: req.Level}
+		switch req.Level {
+		case store.NotificationLevelAll, store.NotificationLevelMentions, store.NotificationLevelMuted:
+		case "default":
+			pref.Level = ""
+		default:
+			api.RespondError(w, "level must be all, mentions, muted or default", http.StatusBadRequest)
+			return
+		}
+
+		if (req.HallID == 0) == (req.RoomID == 0) {
+			api.RespondError(w, "Set either hall_id or room_id", http.StatusBadRequest)
+			return
+		}
+		if req.RoomID != 0 {
+			room, err := s.db.GetRoomByID(r.Context(), req.RoomID)
+			if err != nil {
+				api.RespondError(w, "Room not found", http.StatusNotFound)
+				return
+			}
+			pref.HallID = room.HallID
+		}
+
+		isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, pref.HallID)
+		if err != nil || !isMember {
+			api.RespondError(w, "Access denied", http.StatusForbidden)
+			return
+		}
+
+		if err := s.db.SetNotificationPreference(r.Context(), session.UserID, pref); err != nil {
+			api.RespondError(w, "Failed to update notification preferences", http.StatusInternalServerError)
+			return
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs, err := s.db.GetNotificationPreferences(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch notification preferences", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the user's other devices in step
+	if r.Method == http.MethodPost {
+		s.wsManager.SendToUser(session.UserID, "notification_preferences", prefs)
+	}
+
+	api.RespondJSON(w, prefs)
+}
+
+func (s *Server) handleDMs(w http.ResponseWriter, r *http.Request) {
+	// Archived conversations are hidden unless asked for
+	if r.URL.Query().Get("archived") == "true" {
+		s.listDMConversations(w, r, store.DMListArchived)
+		return
+	}
+	s.listDMConversations(w, r, store.DMListInbox)
+}
+
+func (s *Server) handleDMRequests(w http.ResponseWriter, r *http.Request) {
+	s.listDMConversations(w, r, store.DMListRequests)
+}
+
+func (s *Server) listDMConversations(w http.ResponseWriter, r *http.Request, list string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conversations, err := s.db.GetUserDMConversations(r.Context(), session.UserID, list)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch conversations", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"conversations": conversations,
+	})
+}
+
+// dmDeclineCooldown is how long after a message request was declined the
+// requester must wait before asking again
+const dmDeclineCooldown = 30 * 24 * time.Hour
+
+// dmDeclineApplies reports whether senderID's message request in conv was
+// declined too recently to ask again. The user who declined may start the
+// conversation over any time.
+func dmDeclineApplies(conv *store.DMConversation, senderID int) bool {
+	return conv.Status == store.DMStatusDeclined && conv.RequestedBy == senderID &&
+		conv.DeclinedAt != nil && time.Since(*conv.DeclinedAt) < dmDeclineCooldown
+}
+
+// newDMStatus decides whether a first message from senderID to recipientID
+// opens a conversation, lands as a message request, or is refused. Users who
+// share a hall count as contacts.
+func (s *Server) newDMStatus(ctx context.Context, senderID, recipientID int) (string, string, error) {
+	privacy, err := s.db.GetDMPrivacy(ctx, recipientID)
+	if err != nil {
+		return "", "", err
+	}
+
+	shared, err := s.db.SharesHall(ctx, senderID, recipientID)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch privacy {
+	case store.DMPrivacyNobody:
+		return "", "User is not accepting direct messages", nil
+	case store.DMPrivacyHalls:
+		if !shared {
+			return "", "User only accepts direct messages from hall members", nil
+		}
+		return store.DMStatusAccepted, "", nil
+	default:
+		if !shared {
+			return store.DMStatusPending, "", nil
+		}
+		return store.DMStatusAccepted, "", nil
+	}
+}
+
+func (s *Server) handleSendDM(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// With encrypted set, content is ciphertext the server passes on as is
+	var req struct {
+		Username  string `json:"username"`
+		Content   string `json:"content"`
+		Encrypted bool   `json:"encrypted"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Username == "" || req.Content == "" {
+		api.RespondError(w, "Username and content required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Encrypted {
+		if len(req.Content) > maxCiphertextLength {
+			api.RespondError(w, fmt.Sprintf("Encrypted messages are limited to %d bytes", maxCiphertextLength), http.StatusBadRequest)
+			return
+		}
+	} else if maxLength := s.config.Load().MaxMessageLength; ws.MessageTooLong(req.Content, maxLength) {
+		api.RespondError(w, fmt.Sprintf("Message is longer than %d characters", maxLength), http.StatusBadRequest)
+		return
+	}
+
+	recipient, err := s.db.ResolveUsername(r.Context(), req.Username, usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if recipient.ID == session.UserID {
+		api.RespondError(w, "Cannot message yourself", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := s.db.GetDMConversationBetween(r.Context(), session.UserID, recipient.ID)
+	if err != nil && err != sql.ErrNoRows {
+		api.RespondError(w, "Failed to fetch conversation", http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case err == sql.ErrNoRows || conv.Status == store.DMStatusDeclined:
+		if conv != nil && dmDeclineApplies(conv, session.UserID) {
+			api.RespondErrorCode(w, api.ErrCodeRequestDeclined, "User declined your message request", http.StatusForbidden)
+			return
+		}
+
+		status, denied, err := s.newDMStatus(r.Context(), session.UserID, recipient.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to check privacy settings", http.StatusInternalServerError)
+			return
+		}
+		if denied != "" {
+			api.RespondError(w, denied, http.StatusForbidden)
+			return
+		}
+
+		if conv == nil {
+			conv, err = s.db.CreateDMConversation(r.Context(), session.UserID, recipient.ID, status)
+		} else {
+			conv, err = s.db.ReopenDMConversation(r.Context(), conv.ID, session.UserID, status)
+		}
+		if err != nil {
+			api.RespondError(w, "Failed to create conversation", http.StatusInternalServerError)
+			return
+		}
+	case conv.Status == store.DMStatusPending && conv.RequestedBy != session.UserID:
+		// Replying to a message request accepts it
+		if err := s.db.AcceptDMConversation(r.Context(), conv.ID); err != nil {
+			api.RespondError(w, "Failed to accept conversation", http.StatusInternalServerError)
+			return
+		}
+		conv.Status = store.DMStatusAccepted
+	}
+
+	message, err := s.db.SaveDMMessage(r.Context(), conv.ID, session.UserID, req.Content, req.Encrypted)
+	if err != nil {
+		api.RespondError(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.db.UnarchiveDMConversationOnMessage(r.Context(), conv.ID); err != nil {
+		s.logger.Printf("Failed to unarchive conversation %d: %v", conv.ID, err)
+	}
+	if updated, err := s.db.GetDMConversation(r.Context(), conv.ID, session.UserID); err == nil {
+		conv = updated
+	}
+
+	s.deliverDM(r.Context(), conv, message)
+
+	api.RespondJSON(w, map[string]interface{}{
+		"conversation": conv,
+		"message":      message,
+	})
+}
+
+// deliverDM pushes a DM to both participants. While a conversation is still a
+// request the recipient gets a dm_request event instead of dm_message.
+func (s *Server) deliverDM(ctx context.Context, conv *store.DMConversation, message *store.DMMessage) {
+	s.wsManager.SendToUser(message.UserID, "dm_message", ws.DMMessageData{
+		Conversation: *conv,
+		Message:      *message,
+	})
+
+	recipientView, err := s.db.GetDMConversation(ctx, conv.ID, conv.OtherUserID)
+	if err != nil {
+		s.logger.Printf("Failed to load conversation %d for delivery: %v", conv.ID, err)
+		return
+	}
+
+	eventType := "dm_message"
+	if recipientView.Status == store.DMStatusPending {
+		eventType = "dm_request"
+	}
+	s.wsManager.SendToUser(conv.OtherUserID, eventType, ws.DMMessageData{
+		Conversation: *recipientView,
+		Message:      *message,
+	})
+
+	if !recipientView.Muted {
+		s.notifier.NotifyDM(ctx, conv.OtherUserID, message)
+	}
+}
+
+func (s *Server) handleDMWithID(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Extract path /api/dms/{conversation_id}/action
+	path := strings.TrimPrefix(r.URL.Path, "/api/dms/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		api.RespondError(w, "Invalid URL format", http.StatusNotFound)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := s.db.GetDMConversation(r.Context(), conversationID, session.UserID)
+	if err != nil {
+		api.RespondError(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "messages":
+		if r.Method != http.MethodGet {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, offset := parsePagination(r)
+		messages, err := s.db.GetDMMessages(r.Context(), conv.ID, limit, offset)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+
+		api.RespondJSON(w, map[string]interface{}{
+			"conversation": conv,
+			"messages":     messages,
+		})
+	case "settings":
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Only the fields present in the request are changed
+		var req struct {
+			Muted              *bool `json:"muted"`
+			Archived           *bool `json:"archived"`
+			UnarchiveOnMessage *bool `json:"unarchive_on_message"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.Muted != nil {
+			conv.Muted = *req.Muted
+		}
+		if req.Archived != nil {
+			conv.Archived = *req.Archived
+		}
+		if req.UnarchiveOnMessage != nil {
+			conv.UnarchiveOnMessage = *req.UnarchiveOnMessage
+		}
+
+		if err := s.db.SetDMConversationState(r.Context(), conv.ID, session.UserID, conv.Muted, conv.Archived, conv.UnarchiveOnMessage); err != nil {
+			api.RespondError(w, "Failed to update conversation", http.StatusInternalServerError)
+			return
+		}
+
+		api.RespondJSON(w, map[string]interface{}{
+			"conversation": conv,
+		})
+	case "accept", "decline":
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if conv.Status != store.DMStatusPending || conv.RequestedBy == session.UserID {
+			api.RespondError(w, "No pending request to answer", http.StatusBadRequest)
+			return
+		}
+
+		if parts[1] == "decline" {
+			if err := s.db.DeclineDMConversation(r.Context(), conv.ID); err != nil {
+				api.RespondError(w, "Failed to decline request", http.StatusInternalServerError)
+				return
+			}
+			api.RespondJSON(w, map[string]string{"status": "request declined"})
+			return
+		}
+
+		if err := s.db.AcceptDMConversation(r.Context(), conv.ID); err != nil {
+			api.RespondError(w, "Failed to accept request", http.StatusInternalServerError)
+			return
+		}
+		conv.Status = store.DMStatusAccepted
+
+		api.RespondJSON(w, map[string]interface{}{
+			"conversation": conv,
+		})
+	default:
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+	}
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Extract token from query parameter for WebSocket auth, or from the
+	// session cookie for connections from our own pages
+	token := r.URL.Query().Get("token")
+	if token == "" && s.config.Load().GuestAccess && r.URL.Query().Get("guest") == "true" {
+		s.wsManager.HandleConnection(w, r, &auth.Session{Username: ws.GuestUsername}, true)
+		return
+	}
+	if token == "" {
+		if cookie, err := r.Cookie(auth.SessionCookieName); err == nil && s.cookieOriginAllowed(r) {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		api.RespondErrorCode(w, api.ErrCodeMissingToken, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := s.auth.ValidateSession(r.Context(), token)
+	if errors.Is(err, auth.ErrInvalidSession) {
+		api.RespondErrorCode(w, api.ErrCodeInvalidSession, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to check session", http.StatusInternalServerError)
+		return
+	}
+	if !session.HasScope(auth.ScopeReadMessages) {
+		auth.RespondInsufficientScope(w, auth.ScopeReadMessages)
+		return
+	}
+	s.auth.TouchSession(session, r)
+
+	s.wsManager.HandleConnection(w, r, session, false)
+}
+
+// handleEvents streams room events over SSE. EventSource can't set headers,
+// so like /ws it takes the token as a query parameter.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = s.auth.ExtractToken(r)
+	}
+	if token == "" {
+		api.RespondErrorCode(w, api.ErrCodeMissingToken, "Missing token", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := s.auth.ValidateSession(r.Context(), token)
+	if errors.Is(err, auth.ErrInvalidSession) {
+		api.RespondErrorCode(w, api.ErrCodeInvalidSession, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to check session", http.StatusInternalServerError)
+		return
+	}
+	if !session.HasScope(auth.ScopeReadMessages) {
+		auth.RespondInsufficientScope(w, auth.ScopeReadMessages)
+		return
+	}
+	s.auth.TouchSession(session, r)
+
+	// Browsers resend the last event id by themselves when reconnecting
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	positions, err := ws.ParseSSECursor(lastEventID)
+	if err != nil {
+		api.RespondError(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	var roomIDs []int
+	if roomsParam := r.URL.Query().Get("rooms"); roomsParam != "" {
+		for _, field := range strings.Split(roomsParam, ",") {
+			roomID, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+				return
+			}
+			roomIDs = append(roomIDs, roomID)
+		}
+	} else {
+		// Every unarchived room in the user's halls
+		halls, err := s.db.GetUserHalls(r.Context(), session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch halls", http.StatusInternalServerError)
+			return
+		}
+		for _, hall := range halls {
+			rooms, err := s.db.GetHallRooms(r.Context(), hall.ID, false)
+			if err != nil {
+				api.RespondError(w, "Failed to fetch rooms", http.StatusInternalServerError)
+				return
+			}
+			for _, room := range rooms {
+				roomIDs = append(roomIDs, room.ID)
+			}
+		}
+	}
+
+	s.wsManager.HandleEventStream(w, r, session, roomIDs, positions)
+}
+
+// requireInstanceAdmin only lets server-wide admins through; it goes inside
+// RequireAuth
+func (s *Server) requireInstanceAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := auth.SessionFromContext(r.Context())
+		if session == nil {
+			api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		isAdmin, err := s.db.IsInstanceAdmin(r.Context(), session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			api.RespondError(w, "Only instance admins can do this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := s.db.GetInstanceStats(r.Context())
+	if err != nil {
+		api.RespondError(w, "Failed to fetch stats", http.StatusInternalServerError)
+		return
+	}
+	sessions, err := s.auth.SessionCount(r.Context())
+	if err != nil {
+		api.RespondError(w, "Failed to fetch stats", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"counts":         stats,
+		"sessions":       sessions,
+		"ws_connections": s.wsManager.ClientCount(),
+		"ws_delivery":    s.wsManager.DeliveryStats(),
+		"started_at":     s.startedAt,
+		"uptime_seconds": int(time.Since(s.startedAt).Seconds()),
+	})
+}
+
+func (s *Server) handleAdminHalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	halls, err := s.db.ListHalls(r.Context(), limit, offset)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch halls", http.StatusInternalServerError)
+		return
+	}
+	api.RespondJSON(w, halls)
+}
+
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	users, err := s.db.ListUsers(r.Context(), r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	api.RespondJSON(w, users)
+}
+
+// handleAdminUserWithID serves DELETE /api/admin/users/{user_id} and
+// POST /api/admin/users/{user_id}/admin
+func (s *Server) handleAdminUserWithID(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/admin/users/")
+	parts := strings.Split(path, "/")
+
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		api.RespondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.Username == "system" {
+		api.RespondError(w, "The system user can't be changed", http.StatusForbidden)
+		return
+	}
+	if user.ID == session.UserID {
+		api.RespondError(w, "You can't do this to your own account", http.StatusBadRequest)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "admin" {
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			IsAdmin bool `json:"is_admin"`
+		}
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		if err := s.db.SetInstanceAdmin(r.Context(), user.ID, req.IsAdmin); err != nil {
+			api.RespondError(w, "Failed to update user", http.StatusInternalServerError)
+			return
+		}
+		s.logger.Printf("Instance admin %s set is_admin=%t on %s", session.Username, req.IsAdmin, user.Username)
+		api.RespondJSON(w, map[string]interface{}{"user_id": user.ID, "is_admin": req.IsAdmin})
+		return
+	}
+
+	if len(parts) != 1 {
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Checked first so a held account isn't logged out for nothing
+	held, err := s.db.UserOnLegalHold(r.Context(), user.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	if held {
+		respondLegalHold(w, "This user has messages under a legal hold")
+		return
+	}
+
+	// Log the account out everywhere before its rows go away
+	revoked, err := s.auth.RevokeSessions(r.Context(), user.ID, func(*auth.Session) bool { return true })
+	if err != nil {
+		api.RespondError(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	s.wsManager.DisconnectSessions(user.ID, revoked)
+
+	if err := s.db.DeleteUser(r.Context(), user.ID); err != nil {
+		if errors.Is(err, store.ErrLegalHold) {
+			respondLegalHold(w, "This user has messages under a legal hold")
+			return
+		}
+		api.RespondError(w, "Failed to delete user", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("Instance admin %s deleted user %s (%d)", session.Username, user.Username, user.ID)
+	api.RespondJSON(w, map[string]string{"status": "user deleted"})
+}
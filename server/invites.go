@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"chatapp/internal/api"
+	"chatapp/internal/qr"
+)
+
+// QR code images are scale pixels to a module
+const (
+	defaultQRScale = 10
+	maxQRScale     = 40
+)
+
+// inviteURL is where an invite code takes people: the web UI, which offers
+// to join the hall
+func inviteURL(r *http.Request, code string) string {
+	return fmt.Sprintf("%s://%s/?invite=%s", api.RequestScheme(r), r.Host, url.QueryEscape(code))
+}
+
+// handleInvite serves GET /api/invites/{code}/qr.png, a QR code of the
+// invite's URL to put on posters and screens. It needs no session: whoever
+// has the code can join anyway.
+func (s *Server) handleInvite(w http.ResponseWriter, r *http.Request) {
+	code, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/invites/"), "/qr.png")
+	if !ok || code == "" || strings.Contains(code, "/") {
+		api.RespondError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scale := defaultQRScale
+	if value := r.URL.Query().Get("scale"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxQRScale {
+			api.RespondError(w, fmt.Sprintf("scale must be between 1 and %d", maxQRScale), http.StatusBadRequest)
+			return
+		}
+		scale = parsed
+	}
+
+	if _, err := s.db.GetHallByInviteCode(r.Context(), code); err != nil {
+		api.RespondError(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	qrCode, err := qr.Encode([]byte(inviteURL(r, code)))
+	if err != nil {
+		api.RespondError(w, "The invite URL is too long for a QR code", http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qrCode.Image(scale)); err != nil {
+		s.logger.Printf("Failed to encode invite QR code: %v", err)
+		api.RespondError(w, "Failed to draw QR code", http.StatusInternalServerError)
+		return
+	}
+
+	// Short, since the code stops working when the hall's invite is reset
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Write(buf.Bytes())
+}
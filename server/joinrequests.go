@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// requestToJoin is what using the invite code of a hall in approval mode
+// does: it asks the hall's admins to let the user in, and answers 202 with
+// the request. Asking again is a no-op.
+func (s *Server) requestToJoin(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	session := auth.SessionFromContext(r.Context())
+
+	request, created, err := s.db.CreateJoinRequest(r.Context(), hall.ID, session.UserID)
+	if err != nil {
+		s.logger.Printf("Failed to create join request for hall %d: %v", hall.ID, err)
+		api.RespondError(w, "Failed to join hall", http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		if admins, err := s.db.GetHallAdminIDs(r.Context(), hall.ID); err != nil {
+			s.logger.Printf("Failed to load admins of hall %d: %v", hall.ID, err)
+		} else {
+			s.wsManager.SendToUsers(admins, "join_request_created", request)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hall":         hall,
+		"join_request": request,
+	})
+}
+
+// handleOwnJoinRequest serves /api/halls/{hall_id}/join-requests/me: GET
+// shows the user's pending request, DELETE withdraws it
+func (s *Server) handleOwnJoinRequest(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	session := auth.SessionFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		request, err := s.db.GetJoinRequest(r.Context(), hall.ID, session.UserID)
+		if err != nil {
+			api.RespondError(w, "Join request not found", http.StatusNotFound)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"join_request": request,
+		})
+	case http.MethodDelete:
+		deleted, err := s.db.DeleteJoinRequest(r.Context(), hall.ID, session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to withdraw join request", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			api.RespondError(w, "Join request not found", http.StatusNotFound)
+			return
+		}
+		api.RespondJSON(w, map[string]string{"status": "join request withdrawn"})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJoinRequests serves GET /api/halls/{hall_id}/join-requests, the
+// hall's pending requests for its admins
+func (s *Server) handleJoinRequests(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests, err := s.db.GetJoinRequests(r.Context(), hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch join requests", http.StatusInternalServerError)
+		return
+	}
+	api.RespondJSON(w, map[string]interface{}{
+		"join_requests": requests,
+	})
+}
+
+// handleResolveJoinRequest serves POST
+// /api/halls/{hall_id}/join-requests/{user_id}/{approve,deny}. Approving
+// lets the user in like joining an open hall would, quota and plugins
+// included; either way they hear about it over ws.
+func (s *Server) handleResolveJoinRequest(w http.ResponseWriter, r *http.Request, hall *store.Hall, userIDStr, action string) {
+	if action != "approve" && action != "deny" {
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session := auth.SessionFromContext(r.Context())
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	request, err := s.db.GetJoinRequest(r.Context(), hall.ID, userID)
+	if err != nil {
+		api.RespondError(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+
+	resolved := ws.JoinRequestResolvedData{HallID: hall.ID, HallName: hall.Name}
+
+	if action == "deny" {
+		deleted, err := s.db.DeleteJoinRequest(r.Context(), hall.ID, userID)
+		if err != nil {
+			api.RespondError(w, "Failed to deny join request", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			api.RespondError(w, "Join request not found", http.StatusNotFound)
+			return
+		}
+
+		if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "join_request_denied", "user", userID, request.Username); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+		s.wsManager.SendToUser(userID, "join_request_denied", resolved)
+		api.RespondJSON(w, map[string]string{"status": "join request denied"})
+		return
+	}
+
+	_, members, err := s.hallQuotas(r.Context(), hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to approve join request", http.StatusInternalServerError)
+		return
+	}
+	if members.Reached() {
+		respondQuotaExceeded(w, fmt.Sprintf("This hall has reached its member quota (%d)", members.Limit))
+		return
+	}
+
+	join := ws.PluginJoin{HallID: hall.ID, UserID: userID, Username: request.Username}
+	if err := s.plugins.UserJoin(r.Context(), join); err != nil {
+		api.RespondErrorCode(w, api.ErrCodePluginRejected, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	approved, err := s.db.ApproveJoinRequest(r.Context(), hall.ID, userID)
+	if err != nil {
+		api.RespondError(w, "Failed to approve join request", http.StatusInternalServerError)
+		return
+	}
+	if !approved {
+		api.RespondError(w, "Join request not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "join_request_approved", "user", userID, request.Username); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+
+	s.wsManager.BroadcastToHall(r.Context(), hall.ID, "member_joined", ws.HallMemberData{
+		HallID:   hall.ID,
+		UserID:   userID,
+		Username: request.Username,
+	})
+	s.welcomeMember(r.Context(), hall.ID, request.Username)
+	s.wsManager.SendToUser(userID, "join_request_approved", resolved)
+
+	api.RespondJSON(w, map[string]string{"status": "join request approved"})
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// End-to-end encrypted DMs: every device publishes an identity key, a signed
+// prekey and a stock of one-time prekeys, and senders claim one set per
+// device of the recipient to open a session. All crypto happens in clients;
+// the server only stores public keys and passes ciphertext along.
+const (
+	maxDevicesPerUser   = 20
+	maxOneTimePrekeys   = 100 // kept per device
+	maxPublicKeyLength  = 512 // characters of an encoded key or signature
+	maxCiphertextLength = 64 * 1024
+)
+
+// deviceIDPattern is what device and key IDs look like
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// handleKeys serves /api/keys, which lists the user's own devices with how
+// many one-time prekeys each has left, so clients know when to upload more
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	devices, err := s.db.GetDeviceKeys(r.Context(), session.UserID, true)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"devices": devices,
+	})
+}
+
+// handleUploadKeys publishes a device's keys. Leaving out the identity key,
+// signed prekey and signature just adds one-time prekeys to a device that
+// already published them.
+func (s *Server) handleUploadKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DeviceID        string                `json:"device_id"`
+		IdentityKey     string                `json:"identity_key"`
+		SignedPrekey    string                `json:"signed_prekey"`
+		PrekeySignature string                `json:"prekey_signature"`
+		OneTimePrekeys  []store.OneTimePrekey `json:"one_time_prekeys"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var errs []api.FieldError
+	if !deviceIDPattern.MatchString(req.DeviceID) {
+		errs = append(errs, api.FieldError{Field: "device_id", Code: "invalid", Message: "device_id must be 1-64 letters, digits, - or _"})
+	}
+	replenish := req.IdentityKey == "" && req.SignedPrekey == "" && req.PrekeySignature == ""
+	if !replenish {
+		keys := []struct{ field, value string }{
+			{"identity_key", req.IdentityKey},
+			{"signed_prekey", req.SignedPrekey},
+			{"prekey_signature", req.PrekeySignature},
+		}
+		for _, key := range keys {
+			if key.value == "" || len(key.value) > maxPublicKeyLength {
+				errs = append(errs, api.FieldError{Field: key.field, Code: "invalid", Message: fmt.Sprintf("%s must be 1-%d characters", key.field, maxPublicKeyLength)})
+			}
+		}
+	}
+	if len(req.OneTimePrekeys) > maxOneTimePrekeys {
+		errs = append(errs, api.FieldError{Field: "one_time_prekeys", Code: "too_many", Message: fmt.Sprintf("Upload at most %d one-time prekeys", maxOneTimePrekeys)})
+	}
+	for _, prekey := range req.OneTimePrekeys {
+		if !deviceIDPattern.MatchString(prekey.KeyID) || prekey.PublicKey == "" || len(prekey.PublicKey) > maxPublicKeyLength {
+			errs = append(errs, api.FieldError{Field: "one_time_prekeys", Code: "invalid", Message: "Every one-time prekey needs a key_id and a public_key"})
+			break
+		}
+	}
+	if len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return
+	}
+
+	devices, err := s.db.GetDeviceKeys(r.Context(), session.UserID, true)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+	var existing *store.DeviceKeys
+	for i := range devices {
+		if devices[i].DeviceID == req.DeviceID {
+			existing = &devices[i]
+		}
+	}
+
+	if existing == nil && replenish {
+		api.RespondError(w, "Unknown device, upload its identity key first", http.StatusNotFound)
+		return
+	}
+	if existing == nil && len(devices) >= maxDevicesPerUser {
+		api.RespondError(w, fmt.Sprintf("Accounts can have at most %d devices", maxDevicesPerUser), http.StatusConflict)
+		return
+	}
+	if existing != nil && *existing.OneTimePrekeys+len(req.OneTimePrekeys) > maxOneTimePrekeys {
+		api.RespondError(w, fmt.Sprintf("Devices can hold at most %d one-time prekeys", maxOneTimePrekeys), http.StatusConflict)
+		return
+	}
+
+	// Anyone who encrypts for this user needs to know of new or changed keys
+	changed := !replenish && (existing == nil ||
+		existing.IdentityKey != req.IdentityKey ||
+		existing.SignedPrekey != req.SignedPrekey ||
+		existing.PrekeySignature != req.PrekeySignature)
+
+	if changed {
+		err := s.db.SetDeviceKeys(r.Context(), store.DeviceKeys{
+			UserID:          session.UserID,
+			DeviceID:        req.DeviceID,
+			IdentityKey:     req.IdentityKey,
+			SignedPrekey:    req.SignedPrekey,
+			PrekeySignature: req.PrekeySignature,
+		})
+		if err != nil {
+			api.RespondError(w, "Failed to save keys", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.db.AddOneTimePrekeys(r.Context(), session.UserID, req.DeviceID, req.OneTimePrekeys); err != nil {
+		api.RespondError(w, "Failed to save keys", http.StatusInternalServerError)
+		return
+	}
+
+	if changed {
+		s.announceDeviceKeys(r.Context(), session)
+	}
+
+	devices, err = s.db.GetDeviceKeys(r.Context(), session.UserID, true)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+	for _, device := range devices {
+		if device.DeviceID == req.DeviceID {
+			api.RespondJSON(w, map[string]interface{}{
+				"device": device,
+			})
+			return
+		}
+	}
+	api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+}
+
+// handleDeleteKeys removes one of the user's devices, e.g. when signing out
+// of it for good. Nobody can encrypt for it afterwards.
+func (s *Server) handleDeleteKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DeviceID string `json:"device_id"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	deleted, err := s.db.DeleteDeviceKeys(r.Context(), session.UserID, req.DeviceID)
+	if err != nil {
+		api.RespondError(w, "Failed to delete device", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		api.RespondError(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	s.announceDeviceKeys(r.Context(), session)
+
+	api.RespondJSON(w, map[string]string{"status": "device deleted"})
+}
+
+// handleUserKeys serves /api/keys/users/{username}, the devices a user
+// published keys for. Public keys are public, so any user can look.
+func (s *Server) handleUserKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.db.ResolveUsername(r.Context(), strings.TrimPrefix(r.URL.Path, "/api/keys/users/"), usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	devices, err := s.db.GetDeviceKeys(r.Context(), user.ID, false)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"devices":  devices,
+	})
+}
+
+// handleClaimKeys hands out what's needed to open a session with each of a
+// user's devices, using up one one-time prekey per device. Only someone the
+// user would take a DM from can claim, so strangers can't drain the keys.
+func (s *Server) handleClaimKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := s.db.ResolveUsername(r.Context(), req.Username, usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Your own other devices need sessions too, for the copies you read there
+	if user.ID != session.UserID {
+		conv, err := s.db.GetDMConversationBetween(r.Context(), session.UserID, user.ID)
+		if err == nil && dmDeclineApplies(conv, session.UserID) {
+			api.RespondErrorCode(w, api.ErrCodeRequestDeclined, "User declined your message request", http.StatusForbidden)
+			return
+		}
+		if err == sql.ErrNoRows || (err == nil && conv.Status == store.DMStatusDeclined) {
+			_, denied, err := s.newDMStatus(r.Context(), session.UserID, user.ID)
+			if err != nil {
+				api.RespondError(w, "Failed to check privacy settings", http.StatusInternalServerError)
+				return
+			}
+			if denied != "" {
+				api.RespondError(w, denied, http.StatusForbidden)
+				return
+			}
+		} else if err != nil {
+			api.RespondError(w, "Failed to fetch conversation", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	devices, err := s.db.GetDeviceKeys(r.Context(), user.ID, false)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch keys", http.StatusInternalServerError)
+		return
+	}
+
+	claimed := make([]store.ClaimedKeys, 0, len(devices))
+	for _, device := range devices {
+		prekey, err := s.db.ClaimOneTimePrekey(r.Context(), user.ID, device.DeviceID)
+		if err != nil {
+			api.RespondError(w, "Failed to claim keys", http.StatusInternalServerError)
+			return
+		}
+		claimed = append(claimed, store.ClaimedKeys{DeviceKeys: device, OneTimePrekey: prekey})
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"devices":  claimed,
+	})
+}
+
+// announceDeviceKeys tells the user's DM partners, and the user's other
+// devices, to refetch the user's keys
+func (s *Server) announceDeviceKeys(ctx context.Context, session *auth.Session) {
+	userIDs, err := s.db.GetDMPartnerIDs(ctx, session.UserID)
+	if err != nil {
+		s.logger.Printf("Failed to load DM partners of user %d: %v", session.UserID, err)
+		return
+	}
+
+	s.wsManager.SendToUsers(append(userIDs, session.UserID), "device_keys_updated", ws.DeviceKeysUpdatedData{
+		UserID:   session.UserID,
+		Username: session.Username,
+	})
+}
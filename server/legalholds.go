@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// maxLegalHoldReasonLength caps the reason given for a hold, in characters
+const maxLegalHoldReasonLength = 500
+
+// respondLegalHold turns away a delete that would remove held messages
+func respondLegalHold(w http.ResponseWriter, message string) {
+	api.RespondErrorCode(w, api.ErrCodeLegalHold, message, http.StatusConflict)
+}
+
+// handleAdminLegalHolds serves /api/admin/legal-holds: GET lists the holds,
+// of one hall with ?hall_id=, and POST places one on a room or on a user's
+// messages in a hall
+func (s *Server) handleAdminLegalHolds(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		var hallID int
+		if hallIDStr := r.URL.Query().Get("hall_id"); hallIDStr != "" {
+			var err error
+			if hallID, err = strconv.Atoi(hallIDStr); err != nil {
+				api.RespondError(w, "Invalid hall ID", http.StatusBadRequest)
+				return
+			}
+		}
+		holds, err := s.db.GetLegalHolds(r.Context(), hallID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch legal holds", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"legal_holds": holds,
+		})
+	case http.MethodPost:
+		var req struct {
+			HallID int    `json:"hall_id"`
+			RoomID int    `json:"room_id"`
+			UserID int    `json:"user_id"`
+			Reason string `json:"reason"`
+		}
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		if (req.RoomID == 0) == (req.UserID == 0) {
+			api.RespondError(w, "Give either room_id or user_id", http.StatusBadRequest)
+			return
+		}
+		if utf8.RuneCountInString(req.Reason) > maxLegalHoldReasonLength {
+			api.RespondError(w, fmt.Sprintf("Reasons are limited to %d characters", maxLegalHoldReasonLength), http.StatusBadRequest)
+			return
+		}
+
+		// A room hold can leave out its hall
+		if req.RoomID != 0 {
+			room, err := s.db.GetRoomByID(r.Context(), req.RoomID)
+			if err != nil || (req.HallID != 0 && room.HallID != req.HallID) {
+				api.RespondError(w, "Room not found", http.StatusNotFound)
+				return
+			}
+			req.HallID = room.HallID
+		} else {
+			if req.HallID == 0 {
+				api.RespondError(w, "hall_id required with user_id", http.StatusBadRequest)
+				return
+			}
+			if _, err := s.db.GetHallByID(r.Context(), req.HallID); err != nil {
+				api.RespondError(w, "Hall not found", http.StatusNotFound)
+				return
+			}
+			if _, err := s.db.GetUserByID(r.Context(), req.UserID); err != nil {
+				api.RespondError(w, "User not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		hold, err := s.db.CreateLegalHold(r.Context(), req.HallID, req.RoomID, req.UserID, req.Reason, session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to place legal hold", http.StatusInternalServerError)
+			return
+		}
+		s.auditLegalHold(r.Context(), hold, session.UserID, "legal_hold_placed")
+		s.logger.Printf("Instance admin %s placed legal hold %d in hall %d", session.Username, hold.ID, hold.HallID)
+		api.RespondJSON(w, map[string]interface{}{
+			"legal_hold": hold,
+		})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminLegalHoldWithID serves /api/admin/legal-holds/{hold_id}: GET
+// shows the hold and DELETE releases it. /export streams the messages it
+// covers for a compliance request.
+func (s *Server) handleAdminLegalHoldWithID(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/admin/legal-holds/"), "/")
+	holdID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid legal hold ID", http.StatusBadRequest)
+		return
+	}
+
+	hold, err := s.db.GetLegalHold(r.Context(), holdID)
+	if err != nil {
+		api.RespondError(w, "Legal hold not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "export" {
+		s.handleLegalHoldExport(w, r, hold)
+		return
+	}
+	if len(parts) != 1 {
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.RespondJSON(w, map[string]interface{}{
+			"legal_hold": hold,
+		})
+	case http.MethodDelete:
+		if _, err := s.db.ReleaseLegalHold(r.Context(), hold.ID); err != nil {
+			api.RespondError(w, "Failed to release legal hold", http.StatusInternalServerError)
+			return
+		}
+		s.auditLegalHold(r.Context(), hold, session.UserID, "legal_hold_released")
+		s.logger.Printf("Instance admin %s released legal hold %d in hall %d", session.Username, hold.ID, hold.HallID)
+		api.RespondJSON(w, map[string]string{"status": "legal hold released"})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLegalHoldExport serves
+// GET /api/admin/legal-holds/{hold_id}/export?format=json|csv, every message
+// the hold covers, expired ones included, streamed like a room export
+func (s *Server) handleLegalHoldExport(w http.ResponseWriter, r *http.Request, hold *store.LegalHold) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		api.RespondError(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	next := func(ctx context.Context, afterID int) ([]store.Message, error) {
+		return s.db.GetHeldMessagesAfter(ctx, hold, afterID, roomExportBatch)
+	}
+	batch, err := next(r.Context(), 0)
+	if err != nil {
+		api.RespondError(w, "Failed to export legal hold", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLegalHold(r.Context(), hold, session.UserID, "legal_hold_exported")
+
+	var exporter roomExporter
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		exporter = newCSVRoomExporter(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		exporter = &jsonRoomExporter{w: w, key: "legal_hold", subject: hold}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="legal-hold-%d.%s"`, hold.ID, format))
+
+	if err := streamRoomExport(r.Context(), w, exporter, batch, next); err != nil {
+		s.logger.Printf("Export of legal hold %d stopped: %v", hold.ID, err)
+	}
+}
+
+// auditLegalHold records something done to a hold in its hall's audit log
+func (s *Server) auditLegalHold(ctx context.Context, hold *store.LegalHold, actorID int, action string) {
+	targetType, targetID := "room", hold.RoomID
+	if hold.UserID != 0 {
+		targetType, targetID = "user", hold.UserID
+	}
+	details := fmt.Sprintf("hold=%d reason=%q", hold.ID, hold.Reason)
+	if err := s.db.AddAuditLog(ctx, hold.HallID, actorID, action, targetType, targetID, details); err != nil {
+		s.logger.Printf("Failed to write audit log: %v", err)
+	}
+}
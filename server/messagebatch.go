@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+)
+
+// maxBatchRooms is how many rooms one /api/messages/batch request may ask for
+const maxBatchRooms = 50
+
+// messageBatchRoom is one room's history request in a batch, with the same
+// meaning as GET /api/messages/{room_id}'s query parameters
+type messageBatchRoom struct {
+	RoomID   int  `json:"room_id"`
+	Limit    int  `json:"limit"` // 0 for the default of 50
+	Offset   int  `json:"offset"`
+	SinceID  *int `json:"since_id"`
+	AroundID *int `json:"around_id"`
+}
+
+// messageBatchResult is one room's answer in a batch: its messages, or why
+// there are none
+type messageBatchResult struct {
+	RoomID   int                `json:"room_id"`
+	Messages []store.Message    `json:"messages"` // null with an error
+	Error    *messageBatchError `json:"error,omitempty"`
+}
+
+type messageBatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// handleMessagesBatch serves POST /api/messages/batch, which fetches the
+// history of several rooms in one round trip. A room the user can't read
+// gets an error in its result rather than failing the batch.
+func (s *Server) handleMessagesBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Rooms []messageBatchRoom `json:"rooms"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var errs []api.FieldError
+	if len(req.Rooms) == 0 {
+		errs = append(errs, api.FieldError{Field: "rooms", Code: "required", Message: "rooms must list at least one room"})
+	}
+	if len(req.Rooms) > maxBatchRooms {
+		errs = append(errs, api.FieldError{Field: "rooms", Code: "too_many", Message: fmt.Sprintf("Ask for at most %d rooms at once", maxBatchRooms)})
+	}
+	for i, room := range req.Rooms {
+		if room.Limit < 0 || room.Limit > 100 {
+			errs = append(errs, api.FieldError{Field: fmt.Sprintf("rooms[%d].limit", i), Code: "invalid", Message: "limit must be between 1 and 100"})
+		}
+		if room.Offset < 0 {
+			errs = append(errs, api.FieldError{Field: fmt.Sprintf("rooms[%d].offset", i), Code: "invalid", Message: "offset can't be negative"})
+		}
+		if room.SinceID != nil && *room.SinceID < 0 {
+			errs = append(errs, api.FieldError{Field: fmt.Sprintf("rooms[%d].since_id", i), Code: "invalid", Message: "Invalid since_id"})
+		}
+	}
+	if len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return
+	}
+
+	lang := w.Header().Get(api.ContentLanguageHeader)
+	roomError := func(roomID int, code, message string) messageBatchResult {
+		if lang != "" {
+			message = i18n.Error(lang, code, message)
+		}
+		return messageBatchResult{RoomID: roomID, Error: &messageBatchError{Code: code, Message: message}}
+	}
+
+	// Rooms of the same hall share one membership check
+	memberOf := make(map[int]bool)
+	results := make([]messageBatchResult, 0, len(req.Rooms))
+	for _, batchRoom := range req.Rooms {
+		room, err := s.db.GetRoomByID(r.Context(), batchRoom.RoomID)
+		if err != nil {
+			results = append(results, roomError(batchRoom.RoomID, api.ErrCodeNotFound, "Room not found"))
+			continue
+		}
+
+		isMember, checked := memberOf[room.HallID]
+		if !checked {
+			isMember, err = s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+			isMember = err == nil && isMember
+			memberOf[room.HallID] = isMember
+		}
+		if !isMember {
+			results = append(results, roomError(batchRoom.RoomID, api.ErrCodeForbidden, "Access denied"))
+			continue
+		}
+
+		query := historyQuery{
+			Limit:    batchRoom.Limit,
+			Offset:   batchRoom.Offset,
+			SinceID:  batchRoom.SinceID,
+			AroundID: batchRoom.AroundID,
+		}
+		if query.Limit == 0 {
+			query.Limit = 50
+		}
+		messages, err := s.fetchHistory(r.Context(), room.ID, query)
+		if errors.Is(err, errMessageNotFound) {
+			results = append(results, roomError(batchRoom.RoomID, api.ErrCodeNotFound, "Message not found"))
+			continue
+		}
+		if err != nil {
+			api.RespondError(w, "Failed to fetch messages", http.StatusInternalServerError)
+			return
+		}
+		if messages == nil {
+			messages = []store.Message{}
+		}
+		results = append(results, messageBatchResult{RoomID: room.ID, Messages: messages})
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"results": results,
+	})
+}
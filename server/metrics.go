@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"chatapp/internal/api"
+)
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// handleAdminMetrics serves GET /api/admin/metrics in the Prometheus text
+// format, for scraping with an instance admin's token
+func (s *Server) handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions, err := s.auth.SessionCount(r.Context())
+	if err != nil {
+		api.RespondError(w, "Failed to count sessions", http.StatusInternalServerError)
+		return
+	}
+
+	m := s.wsManager
+	counts, subscribers := m.ConnectionCounts()
+	delivery := m.DeliveryStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "commons_uptime_seconds", "gauge", "Seconds since the server started.", int(time.Since(s.startedAt).Seconds()))
+	writeMetric(w, "commons_sessions", "gauge", "Sessions that haven't expired.", sessions)
+
+	fmt.Fprintf(w, "# HELP commons_ws_clients Open event connections by transport.\n# TYPE commons_ws_clients gauge\n")
+	fmt.Fprintf(w, "commons_ws_clients{transport=\"websocket\"} %d\n", counts.WebSocket)
+	fmt.Fprintf(w, "commons_ws_clients{transport=\"sse\"} %d\n", counts.SSE)
+	writeMetric(w, "commons_ws_guest_clients", "gauge", "Open connections of guests without an account.", counts.Guests)
+
+	roomIDs := make([]int, 0, len(subscribers))
+	for roomID := range subscribers {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Ints(roomIDs)
+	fmt.Fprintf(w, "# HELP commons_ws_room_subscribers Connections that joined a room, for rooms with any.\n# TYPE commons_ws_room_subscribers gauge\n")
+	for _, roomID := range roomIDs {
+		fmt.Fprintf(w, "commons_ws_room_subscribers{room_id=\"%d\"} %d\n", roomID, subscribers[roomID])
+	}
+
+	writeMetric(w, "commons_ws_broadcasts_total", "counter", "Broadcasts delivered to this instance's clients.", delivery.Broadcasts)
+	writeMetric(w, "commons_ws_frames_queued_total", "counter", "Frames queued for sending to clients.", delivery.FramesQueued)
+	writeMetric(w, "commons_ws_frames_dropped_total", "counter", "Frames dropped because a client's send queue was full.", delivery.FramesDropped)
+	writeMetric(w, "commons_ws_slow_disconnects_total", "counter", "Clients disconnected for falling behind.", delivery.SlowDisconnects)
+	m.FanoutLatency().WritePrometheus(w, "commons_ws_fanout_seconds", "Time to queue a broadcast for every local recipient.")
+
+	cache := s.db.CacheStats()
+	writeMetric(w, "commons_message_cache_rooms", "gauge", "Rooms in the message cache.", cache.Rooms)
+	writeMetric(w, "commons_message_cache_hits_total", "counter", "History and resume reads answered from the message cache.", cache.Hits)
+	writeMetric(w, "commons_message_cache_misses_total", "counter", "History and resume reads the message cache sent to the database.", cache.Misses)
+}
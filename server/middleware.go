@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/i18n"
+	"chatapp/internal/ws"
+)
+
+// streamingPaths stay open for as long as the client wants, so they don't
+// get the request deadline
+var streamingPaths = map[string]bool{
+	"/ws":         true,
+	"/api/events": true,
+}
+
+// timeoutMiddleware puts a deadline on the request context, which the database
+// layer honours, so a slow query can't hold a handler forever
+func timeoutMiddleware(next http.Handler, config *atomic.Pointer[Config]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), config.Load().RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func corsMiddleware(next http.Handler, config *atomic.Pointer[Config]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.Load()
+		origin := r.Header.Get("Origin")
+		if origin != "" && !cfg.AllowsOrigin(origin) {
+			// Not allowed: leave the CORS headers off and let the browser block it
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Cookie sessions only work cross-origin from origins listed by name
+		if len(cfg.CORSOrigins) == 1 && cfg.CORSOrigins[0] == "*" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match, "+auth.CSRFHeader)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// languageMiddleware picks the language error messages are written in from
+// Accept-Language and says which in Content-Language, where
+// api.RespondError finds it
+func languageMiddleware(next http.Handler, config *atomic.Pointer[Config]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(api.ContentLanguageHeader, i18n.Negotiate(r.Header.Get("Accept-Language"), config.Load().DefaultLanguage))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverMiddleware answers a handler's panic with a 500 instead of
+// dropping the connection, and reports it with the request it came from
+func (s *Server) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Handlers use this one on purpose, to drop the connection
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			s.logger.Printf("[%s] %s %s panicked: %v", requestIDFromContext(r.Context()), r.Method, r.URL.Path, p)
+			if s.errors != nil {
+				report := ErrorReport{
+					Err:       fmt.Errorf("panic: %v", p),
+					Source:    "http",
+					Stack:     ws.PanicStack(),
+					RequestID: requestIDFromContext(r.Context()),
+					Method:    r.Method,
+					URL:       api.RequestScheme(r) + "://" + r.Host + r.URL.Path,
+				}
+				if session, err := s.auth.ValidateSession(r.Context(), s.auth.ExtractToken(r)); err == nil {
+					report.UserID, report.Username = session.UserID, session.Username
+				}
+				s.errors.CaptureError(report)
+			}
+			api.RespondError(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
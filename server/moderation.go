@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Hall admins delete at most maxBulkDelete messages per request, and give a
+// reason of at most maxDeleteReasonLength characters
+const (
+	maxBulkDelete         = 100
+	maxDeleteReasonLength = 500
+)
+
+// contentHash identifies what a deleted message said without keeping it
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// deleteHallMessages deletes messages of a hall on behalf of one of its
+// admins, writing an audit log entry for each with the reason and a hash of
+// what it said
+func (s *Server) deleteHallMessages(ctx context.Context, hall *store.Hall, actorID int, messages []store.Message, reason string) error {
+	ids := make([]int, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	if err := s.db.DeleteMessages(ctx, ids); err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		details := fmt.Sprintf("room=%d author=%d sha256=%s reason=%q", message.RoomID, message.UserID, contentHash(message.Content), reason)
+		if err := s.db.AddAuditLog(ctx, hall.ID, actorID, "message_deleted", "message", message.ID, details); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+	}
+	return nil
+}
+
+// handleModeratorDeleteMessage serves
+// POST /api/halls/{hall_id}/messages/{message_id}/delete
+func (s *Server) handleModeratorDeleteMessage(w http.ResponseWriter, r *http.Request, hall *store.Hall, messageIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxDeleteReasonLength {
+		api.RespondError(w, fmt.Sprintf("Reasons are limited to %d characters", maxDeleteReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := s.db.GetHallMessagesByIDs(r.Context(), hall.ID, []int{messageID})
+	if err != nil {
+		api.RespondError(w, "Failed to fetch message", http.StatusInternalServerError)
+		return
+	}
+	if len(messages) == 0 {
+		api.RespondError(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.deleteHallMessages(r.Context(), hall, session.UserID, messages, req.Reason); err != nil {
+		if errors.Is(err, store.ErrLegalHold) {
+			respondLegalHold(w, "This message is under a legal hold")
+			return
+		}
+		api.RespondError(w, "Failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	message := messages[0]
+	s.wsManager.BroadcastToRoom(message.RoomID, "message_deleted", ws.MessageDeletedData{
+		MessageID: message.ID,
+		RoomID:    message.RoomID,
+		Reason:    "moderation",
+	})
+
+	api.RespondJSON(w, map[string]interface{}{
+		"deleted": []int{message.ID},
+	})
+}
+
+// handleBulkDeleteMessages serves POST /api/halls/{hall_id}/messages/delete,
+// which deletes up to maxBulkDelete messages from any of the hall's rooms.
+// IDs that aren't messages in the hall are skipped and listed as not_found.
+func (s *Server) handleBulkDeleteMessages(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+
+	var req struct {
+		MessageIDs []int  `json:"message_ids"`
+		Reason     string `json:"reason"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.MessageIDs) == 0 {
+		api.RespondError(w, "message_ids required", http.StatusBadRequest)
+		return
+	}
+	if len(req.MessageIDs) > maxBulkDelete {
+		api.RespondError(w, fmt.Sprintf("At most %d messages can be deleted at once", maxBulkDelete), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > maxDeleteReasonLength {
+		api.RespondError(w, fmt.Sprintf("Reasons are limited to %d characters", maxDeleteReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := s.db.GetHallMessagesByIDs(r.Context(), hall.ID, req.MessageIDs)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.deleteHallMessages(r.Context(), hall, session.UserID, messages, req.Reason); err != nil {
+		if errors.Is(err, store.ErrLegalHold) {
+			respondLegalHold(w, "Some of these messages are under a legal hold, none were deleted")
+			return
+		}
+		api.RespondError(w, "Failed to delete messages", http.StatusInternalServerError)
+		return
+	}
+
+	// One event per room, for the clients that have it open
+	deleted := make([]int, 0, len(messages))
+	byRoom := make(map[int][]int)
+	var roomOrder []int
+	for _, message := range messages {
+		deleted = append(deleted, message.ID)
+		if byRoom[message.RoomID] == nil {
+			roomOrder = append(roomOrder, message.RoomID)
+		}
+		byRoom[message.RoomID] = append(byRoom[message.RoomID], message.ID)
+	}
+	for _, roomID := range roomOrder {
+		s.wsManager.BroadcastToRoom(roomID, "messages_bulk_deleted", ws.MessagesBulkDeletedData{
+			RoomID:     roomID,
+			MessageIDs: byRoom[roomID],
+			Reason:     "moderation",
+		})
+	}
+
+	found := make(map[int]bool, len(messages))
+	for _, id := range deleted {
+		found[id] = true
+	}
+	notFound := make([]int, 0)
+	for _, id := range req.MessageIDs {
+		if !found[id] {
+			notFound = append(notFound, id)
+			found[id] = true // list duplicates once
+		}
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"deleted":   deleted,
+		"not_found": notFound,
+	})
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Offline users are emailed about mentions and DMs, batched into one digest
+// per digest window. Someone counts as offline once they've gone
+// notifyOfflineAfter without pinging the websocket. Digests that can't be
+// sent are retried every notifyCheckInterval for up to notifyMaxAge.
+const (
+	notifyCheckInterval = time.Minute
+	notifyOfflineAfter  = ws.HeartbeatTimeout
+	notifyMaxAge        = 24 * time.Hour
+	notifyRunTimeout    = time.Minute
+
+	notifyMaxMentions    = 20  // per message
+	notifyDigestMaxItems = 50  // listed in one email, the rest are counted
+	notifyExcerptLength  = 200 // characters of the message quoted
+)
+
+// Mailer sends plain text email
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends through an SMTP server, using STARTTLS when the server
+// offers it
+type SMTPMailer struct {
+	addr         string
+	auth         smtp.Auth
+	from         string // for the From header
+	envelopeFrom string
+}
+
+func NewSMTPMailer(cfg *Config) *SMTPMailer {
+	// Validate already made sure this parses
+	from, _ := mail.ParseAddress(cfg.SMTPFrom)
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	return &SMTPMailer{
+		addr:         net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		auth:         auth,
+		from:         from.String(),
+		envelopeFrom: from.Address,
+	}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("Auto-Submitted: auto-generated\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.addr, m.auth, m.envelopeFrom, []string{to}, msg.Bytes())
+}
+
+// Notifier works out who a message should notify, and emails the ones who
+// are offline in digests
+type Notifier struct {
+	db     *store.Database
+	mailer Mailer // nil when email isn't configured
+	window time.Duration
+	logger *log.Logger
+}
+
+func NewNotifier(db *store.Database, cfg *Config, logger *log.Logger) *Notifier {
+	notifier := &Notifier{
+		db:     db,
+		window: cfg.EmailDigestWindow,
+		logger: logger,
+	}
+	if cfg.SMTPHost != "" {
+		notifier.mailer = NewSMTPMailer(cfg)
+	}
+	return notifier
+}
+
+// Enabled reports whether email notifications are configured
+func (n *Notifier) Enabled() bool {
+	return n.mailer != nil
+}
+
+// MentionedUsers returns the members of the room's hall mentioned in
+// message, leaving out its author and anyone who muted the room. A mass
+// mention adds every member for @everyone, or those online for @here, all
+// in one lookup rather than one per member.
+func (n *Notifier) MentionedUsers(ctx context.Context, room *store.Room, message *store.Message, massMention string) []int {
+	var userIDs []int
+	if names := mentionedUsernames(message.Content); len(names) > 0 && massMention != ws.MentionEveryone {
+		ids, err := n.db.GetHallMemberIDsByUsername(ctx, room.HallID, names, usernameRedirectSince())
+		if err != nil {
+			n.logger.Printf("Failed to resolve mentions in message %d: %v", message.ID, err)
+			return nil
+		}
+		userIDs = ids
+	}
+	if massMention != "" {
+		var since time.Time
+		if massMention == ws.MentionHere {
+			since = time.Now().Add(-notifyOfflineAfter)
+		}
+		ids, err := n.db.GetHallMemberIDsSeenSince(ctx, room.HallID, since)
+		if err != nil {
+			n.logger.Printf("Failed to resolve @%s in message %d: %v", massMention, message.ID, err)
+			return nil
+		}
+		userIDs = append(userIDs, ids...)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	levels, err := n.db.GetNotificationLevels(ctx, room.HallID, room.ID, userIDs)
+	if err != nil {
+		n.logger.Printf("Failed to load notification levels for room %d: %v", room.ID, err)
+		return nil
+	}
+
+	// Mentioning yourself doesn't count
+	seen := make(map[int]bool, len(userIDs))
+	mentioned := make([]int, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		if userID != message.UserID && levels[userID] != store.NotificationLevelMuted {
+			mentioned = append(mentioned, userID)
+		}
+	}
+	return mentioned
+}
+
+// NotifyMentions queues a notification of message for each of the
+// mentioned users who's offline
+func (n *Notifier) NotifyMentions(ctx context.Context, room *store.Room, message *store.Message, mentioned []int) {
+	if !n.Enabled() || len(mentioned) == 0 {
+		return
+	}
+
+	hall, err := n.db.GetHallByID(ctx, room.HallID)
+	if err != nil {
+		n.logger.Printf("Failed to load hall %d for mentions: %v", room.HallID, err)
+		return
+	}
+
+	n.queue(ctx, mentioned, store.EmailNotification{
+		Kind:      store.EmailNotificationMention,
+		ActorName: message.Username,
+		RoomName:  room.Name,
+		HallName:  hall.Name,
+		Excerpt:   excerpt(message.Content),
+	})
+}
+
+// NotifyDM queues a notification for the recipient of a DM if they're offline
+func (n *Notifier) NotifyDM(ctx context.Context, recipientID int, message *store.DMMessage) {
+	if !n.Enabled() {
+		return
+	}
+
+	// Ciphertext means nothing to the reader and shouldn't sit in a mailbox
+	quote := excerpt(message.Content)
+	if message.Encrypted {
+		quote = "(encrypted message)"
+	}
+
+	n.queue(ctx, []int{recipientID}, store.EmailNotification{
+		Kind:      store.EmailNotificationDM,
+		ActorName: message.Username,
+		Excerpt:   quote,
+	})
+}
+
+func (n *Notifier) queue(ctx context.Context, userIDs []int, notification store.EmailNotification) {
+	recipients, err := n.db.GetEmailRecipients(ctx, userIDs)
+	if err != nil {
+		n.logger.Printf("Failed to load email recipients: %v", err)
+		return
+	}
+
+	for _, recipient := range recipients {
+		if isOnline(recipient.LastSeen) {
+			continue
+		}
+		notification.UserID = recipient.UserID
+		if err := n.db.QueueEmailNotification(ctx, notification); err != nil {
+			n.logger.Printf("Failed to queue email notification for user %d: %v", recipient.UserID, err)
+		}
+	}
+}
+
+// Run sends digests until the process exits. It does nothing when email
+// isn't configured.
+func (n *Notifier) Run() {
+	if !n.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(notifyCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n.sendDigests()
+	}
+}
+
+func (n *Notifier) sendDigests() {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyRunTimeout)
+	defer cancel()
+
+	if pruned, err := n.db.PruneEmailNotifications(ctx, time.Now().Add(-notifyMaxAge)); err != nil {
+		n.logger.Printf("Failed to prune email notifications: %v", err)
+	} else if pruned > 0 {
+		n.logger.Printf("Dropped %d email notifications that couldn't be sent", pruned)
+	}
+
+	userIDs, err := n.db.GetDueEmailDigests(ctx, time.Now().Add(-n.window))
+	if err != nil {
+		n.logger.Printf("Failed to find due email digests: %v", err)
+		return
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	recipients, err := n.db.GetEmailRecipients(ctx, userIDs)
+	if err != nil {
+		n.logger.Printf("Failed to load email recipients: %v", err)
+		return
+	}
+	byUser := make(map[int]store.EmailRecipient, len(recipients))
+	for _, recipient := range recipients {
+		byUser[recipient.UserID] = recipient
+	}
+
+	for _, userID := range userIDs {
+		pending, err := n.db.GetPendingEmailNotifications(ctx, userID)
+		if err != nil {
+			n.logger.Printf("Failed to load email notifications for user %d: %v", userID, err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		lastID := pending[len(pending)-1].ID
+
+		// Held, not dropped, until their do-not-disturb window is over
+		if n.inDND(ctx, userID) {
+			continue
+		}
+
+		// Back online, or emails turned off since: nothing to send
+		recipient, ok := byUser[userID]
+		if ok && !isOnline(recipient.LastSeen) {
+			subject, body := composeDigest(recipient.Username, pending)
+			if err := n.mailer.Send(recipient.Email, subject, body); err != nil {
+				n.logger.Printf("Failed to email digest to user %d: %v", userID, err)
+				continue
+			}
+		}
+
+		if err := n.db.DeleteEmailNotifications(ctx, userID, lastID); err != nil {
+			n.logger.Printf("Failed to clear email notifications for user %d: %v", userID, err)
+		}
+	}
+}
+
+// inDND reports whether the user is in one of their do-not-disturb windows
+func (n *Notifier) inDND(ctx context.Context, userID int) bool {
+	settings, err := n.db.GetUserSettings(ctx, userID)
+	if err != nil {
+		n.logger.Printf("Failed to load settings of user %d: %v", userID, err)
+		return false
+	}
+	return settings.DND
+}
+
+func isOnline(lastSeen time.Time) bool {
+	return time.Since(lastSeen) < notifyOfflineAfter
+}
+
+// mentionedUsernames returns the distinct, lowercased names @mentioned in
+// content, @everyone and @here aside
+func mentionedUsernames(content string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, match := range ws.MentionPattern.FindAllStringSubmatch(content, -1) {
+		// "@ann." at the end of a sentence means ann
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if name == "" || name == ws.MentionEveryone || name == ws.MentionHere || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == notifyMaxMentions {
+			break
+		}
+	}
+	return names
+}
+
+// excerpt shortens a message to one line for quoting in an email
+func excerpt(content string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	if utf8.RuneCountInString(content) <= notifyExcerptLength {
+		return content
+	}
+	return string([]rune(content)[:notifyExcerptLength]) + "…"
+}
+
+// composeDigest writes the subject and body of a digest email
+func composeDigest(username string, notifications []store.EmailNotification) (string, string) {
+	mentions, dms := 0, 0
+	for _, notification := range notifications {
+		if notification.Kind == store.EmailNotificationMention {
+			mentions++
+		} else {
+			dms++
+		}
+	}
+
+	var parts []string
+	if mentions == 1 {
+		parts = append(parts, "1 new mention")
+	} else if mentions > 1 {
+		parts = append(parts, fmt.Sprintf("%d new mentions", mentions))
+	}
+	if dms == 1 {
+		parts = append(parts, "1 new direct message")
+	} else if dms > 1 {
+		parts = append(parts, fmt.Sprintf("%d new direct messages", dms))
+	}
+	subject := strings.Join(parts, " and ")
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nhere's what you missed while you were away:\n\n", username)
+	for i, notification := range notifications {
+		if i == notifyDigestMaxItems {
+			fmt.Fprintf(&body, "...and %d more.\n\n", len(notifications)-i)
+			break
+		}
+		when := notification.CreatedAt.UTC().Format("Jan 2 15:04 UTC")
+		if notification.Kind == store.EmailNotificationMention {
+			fmt.Fprintf(&body, "%s mentioned you in %s (%s), %s:\n", notification.ActorName, notification.RoomName, notification.HallName, when)
+		} else {
+			fmt.Fprintf(&body, "%s sent you a direct message, %s:\n", notification.ActorName, when)
+		}
+		fmt.Fprintf(&body, "> %s\n\n", notification.Excerpt)
+	}
+	body.WriteString("You're getting this because email notifications are on.\n")
+	body.WriteString("To stop them, set email_notifications to false in your settings.\n")
+
+	return subject, body.String()
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"chatapp/internal/ws"
+)
+
+// Broker shares broadcasts between instances, see WithBroker
+type Broker = ws.Broker
+
+// BrokerMessage is what a Broker carries
+type BrokerMessage = ws.BrokerMessage
+
+// ErrorSink hears about failures worth looking into, see WithErrorSink
+type (
+	ErrorSink   = ws.ErrorSink
+	ErrorReport = ws.ErrorReport
+)
+
+// Plugin and its hooks, see WithPlugins
+type (
+	Plugin          = ws.Plugin
+	MessagePreHook  = ws.MessagePreHook
+	MessagePostHook = ws.MessagePostHook
+	UserJoinHook    = ws.UserJoinHook
+	PluginMessage   = ws.PluginMessage
+	PluginJoin      = ws.PluginJoin
+	CommandProvider = ws.CommandProvider
+	Command         = ws.Command
+	CommandCall     = ws.CommandCall
+	CommandResult   = ws.CommandResult
+)
+
+// RegisterPlugin compiles p into every server New makes afterwards. Call
+// it from the plugin package's init and import that package for its side
+// effects, from the commons-api binary or your own.
+func RegisterPlugin(p Plugin) {
+	ws.RegisterPlugin(p)
+}
+
+// An Option swaps one of the parts New would otherwise build from the config
+type Option func(*options)
+
+type options struct {
+	db         *sql.DB
+	broker     Broker
+	logger     *log.Logger
+	middleware []func(http.Handler) http.Handler
+	plugins    []Plugin
+
+	configLoader func() (*Config, error)
+	errorSink    ErrorSink
+	backupStore  BackupStore
+}
+
+// WithDatabase stores everything in db instead of opening the configured
+// SQLite file. It has to be SQLite, opened with the sqlite3 driver and
+// _loc=UTC so times come back in UTC; New migrates it as usual but Close
+// leaves it open.
+func WithDatabase(db *sql.DB) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithBroker shares broadcasts through broker instead of the configured
+// Redis or NATS one. Close leaves it open.
+func WithBroker(broker Broker) Option {
+	return func(o *options) {
+		o.broker = broker
+	}
+}
+
+// WithLogger sends everything the server logs, access log included, to
+// logger instead of the standard logger
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithMiddleware wraps the server's handler in middleware, the first one
+// outermost. They run before any of the server's own, so requests they
+// answer themselves aren't in the access log.
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// WithPlugins runs plugins' hooks, after those of the plugins registered
+// with RegisterPlugin
+func WithPlugins(plugins ...Plugin) Option {
+	return func(o *options) {
+		o.plugins = append(o.plugins, plugins...)
+	}
+}
+
+// WithConfigLoader lets Server.Reload, and /api/admin/reload, switch to
+// the settings load returns; the commons-api binary passes LoadConfig with
+// its arguments
+func WithConfigLoader(load func() (*Config, error)) Option {
+	return func(o *options) {
+		o.configLoader = load
+	}
+}
+
+// WithErrorSink reports panics, messages that failed to save or broadcast
+// and dead-lettered webhook deliveries to sink instead of the configured
+// Sentry project
+func WithErrorSink(sink ErrorSink) Option {
+	return func(o *options) {
+		o.errorSink = sink
+	}
+}
+
+// WithBackupStore copies every database backup to store, like a blob
+// store, once it's written to backup_dir
+func WithBackupStore(store BackupStore) Option {
+	return func(o *options) {
+		o.backupStore = store
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxy reads a trusted_proxies entry, an IP address or a CIDR
+// range
+func parseTrustedProxy(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// proxyMiddleware makes requests relayed by a trusted reverse proxy look like
+// they came straight from the client: RemoteAddr becomes the address the
+// proxy saw, from X-Forwarded-For, so rate limits, connection limits, session
+// IPs and the access log see the real client, and X-Forwarded-Proto is kept
+// for api.RequestScheme. Anyone else's X-Forwarded-* headers are dropped, since
+// clients could otherwise pick their own IP or claim to be on HTTPS.
+func proxyMiddleware(next http.Handler, cfg *Config) http.Handler {
+	var trusted []netip.Prefix
+	for _, entry := range cfg.TrustedProxies {
+		// Validate already turned away entries that don't parse
+		if prefix, err := parseTrustedProxy(entry); err == nil {
+			trusted = append(trusted, prefix)
+		}
+	}
+
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer, err := netip.ParseAddr(host)
+		if err != nil || !isTrusted(peer.Unmap()) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Forwarded-Proto")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Each proxy appends the address it got the request from, so walk back
+		// from the end until we reach one we don't trust: that's the client
+		var hops []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(value, ",")...)
+		}
+		client := peer.Unmap()
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = addr.Unmap()
+			if !isTrusted(client) {
+				break
+			}
+		}
+		r.RemoteAddr = net.JoinHostPort(client.String(), port)
+
+		// The first proxy in the chain is the one the client talked to
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		proto = strings.ToLower(strings.TrimSpace(proto))
+		if proto == "http" || proto == "https" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		} else {
+			r.Header.Del("X-Forwarded-Proto")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// Quota is how much of a configured limit is used
+type Quota struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"` // 0: unlimited
+}
+
+// Reached reports whether nothing more fits
+func (q Quota) Reached() bool {
+	return q.Limit > 0 && q.Used >= q.Limit
+}
+
+// ownedHallsQuota is how many halls the user owns out of MaxOwnedHalls
+func (s *Server) ownedHallsQuota(ctx context.Context, userID int) (Quota, error) {
+	owned, err := s.db.CountOwnedHalls(ctx, userID)
+	return Quota{Used: owned, Limit: s.config.Load().MaxOwnedHalls}, err
+}
+
+// hallQuotas is how many rooms and members the hall has out of
+// MaxHallRooms and MaxHallMembers
+func (s *Server) hallQuotas(ctx context.Context, hallID int) (rooms, members Quota, err error) {
+	usage, err := s.db.GetHallUsage(ctx, hallID)
+	if err != nil {
+		return Quota{}, Quota{}, err
+	}
+	return Quota{Used: usage.Rooms, Limit: s.config.Load().MaxHallRooms},
+		Quota{Used: usage.Members, Limit: s.config.Load().MaxHallMembers}, nil
+}
+
+// attachmentQuota is how many bytes of attachments the hall's image and file
+// messages add up to out of MaxHallAttachmentBytes
+func (s *Server) attachmentQuota(ctx context.Context, hallID int) (Quota, error) {
+	used, err := s.db.GetHallAttachmentBytes(ctx, hallID)
+	return Quota{Used: int(used), Limit: int(s.config.Load().MaxHallAttachmentBytes)}, err
+}
+
+// respondQuotaExceeded turns away something that would go over a quota.
+// It's a 403 rather than the 429 of the daily request quota: waiting
+// doesn't help, only deleting something does.
+func respondQuotaExceeded(w http.ResponseWriter, message string) {
+	api.RespondErrorCode(w, api.ErrCodeQuotaExceeded, message, http.StatusForbidden)
+}
+
+// handleHallUsage serves /api/halls/{hall_id}/usage, the hall's rooms,
+// members and attachments against their quotas, to its members
+func (s *Server) handleHallUsage(w http.ResponseWriter, r *http.Request, hall *store.Hall) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, hall.ID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	rooms, members, err := s.hallQuotas(r.Context(), hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+	attachments, err := s.attachmentQuota(r.Context(), hall.ID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch usage", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"hall_id":          hall.ID,
+		"rooms":            rooms,
+		"members":          members,
+		"attachment_bytes": attachments,
+	})
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// roomWithReadState is a room as listed for one user, with how far they've
+// read in it
+type roomWithReadState struct {
+	store.Room
+	store.ReadState
+}
+
+// withReadStates adds the user's read state to each room
+func (s *Server) withReadStates(ctx context.Context, userID int, rooms []store.Room) ([]roomWithReadState, error) {
+	roomIDs := make([]int, len(rooms))
+	for i, room := range rooms {
+		roomIDs[i] = room.ID
+	}
+	states, err := s.db.GetReadStates(ctx, userID, roomIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]roomWithReadState, len(rooms))
+	for i, room := range rooms {
+		listed[i] = roomWithReadState{Room: room, ReadState: states[room.ID]}
+	}
+	return listed, nil
+}
+
+// handleRoomRead serves /api/rooms/{room_id}/read: GET returns the user's
+// read state in the room, POST moves their read marker forward to a message
+func (s *Server) handleRoomRead(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	moved := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			MessageID int `json:"message_id"`
+		}
+
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+		message, err := s.db.GetMessageByID(r.Context(), req.MessageID)
+		if err != nil || message.RoomID != roomID {
+			api.RespondError(w, "Message not found", http.StatusNotFound)
+			return
+		}
+
+		moved, err = s.db.SetReadMarker(r.Context(), session.UserID, roomID, req.MessageID)
+		if err != nil {
+			api.RespondError(w, "Failed to save read marker", http.StatusInternalServerError)
+			return
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	states, err := s.db.GetReadStates(r.Context(), session.UserID, []int{roomID})
+	if err != nil {
+		api.RespondError(w, "Failed to fetch read marker", http.StatusInternalServerError)
+		return
+	}
+	state := states[roomID]
+
+	// Keep the user's other devices in step
+	if moved {
+		s.wsManager.SendToUser(session.UserID, "read_marker_updated", map[string]interface{}{
+			"room_id":                 roomID,
+			"last_read_message_id":    state.LastReadMessageID,
+			"first_unread_message_id": state.FirstUnreadMessageID,
+		})
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room_id":                 roomID,
+		"last_read_message_id":    state.LastReadMessageID,
+		"first_unread_message_id": state.FirstUnreadMessageID,
+	})
+}
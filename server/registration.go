@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// MaxInviteUses caps how many registrations one server invite can be good for
+const MaxInviteUses = 10000
+
+// useRegistrationInvite takes a use of the invite a registration came with
+// when registration is invite-only, and otherwise does nothing. It responds
+// and returns false if the registration can't go ahead.
+func (s *Server) useRegistrationInvite(w http.ResponseWriter, r *http.Request, token string) bool {
+	if s.config.Load().Registration != RegistrationInviteOnly {
+		return true
+	}
+	if token == "" {
+		api.RespondErrorCode(w, api.ErrCodeInviteRequired, "An invite is required to register", http.StatusForbidden)
+		return false
+	}
+
+	ok, err := s.db.UseRegistrationInvite(r.Context(), token)
+	if err != nil {
+		api.RespondError(w, "Failed to check invite", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		api.RespondErrorCode(w, api.ErrCodeInvalidInvite, "This invite is invalid, used up or expired", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// releaseRegistrationInvite gives back the use useRegistrationInvite took
+// when the registration failed after all
+func (s *Server) releaseRegistrationInvite(ctx context.Context, token string) {
+	if s.config.Load().Registration != RegistrationInviteOnly {
+		return
+	}
+	if err := s.db.ReleaseRegistrationInvite(ctx, token); err != nil {
+		s.logger.Printf("Failed to release a registration invite: %v", err)
+	}
+}
+
+// handleAdminInvites serves /api/admin/invites: GET lists server invites,
+// POST makes one
+func (s *Server) handleAdminInvites(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		invites, err := s.db.GetRegistrationInvites(r.Context())
+		if err != nil {
+			api.RespondError(w, "Failed to fetch invites", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"invites":      invites,
+			"registration": s.config.Load().Registration,
+		})
+	case http.MethodPost:
+		// Single use and never expiring unless asked otherwise
+		var req struct {
+			MaxUses   *int       `json:"max_uses"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		maxUses := 1
+		if req.MaxUses != nil {
+			maxUses = *req.MaxUses
+		}
+		var errs []api.FieldError
+		if maxUses < 0 || maxUses > MaxInviteUses {
+			errs = append(errs, api.FieldError{Field: "max_uses", Code: "out_of_range", Message: "max_uses must be between 0 (unlimited) and 10000"})
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			errs = append(errs, api.FieldError{Field: "expires_at", Code: "in_past", Message: "expires_at must be in the future"})
+		}
+		if len(errs) > 0 {
+			api.RespondValidationErrors(w, errs)
+			return
+		}
+
+		invite, err := s.db.CreateRegistrationInvite(r.Context(), session.UserID, maxUses, req.ExpiresAt)
+		if err != nil {
+			api.RespondError(w, "Failed to create invite", http.StatusInternalServerError)
+			return
+		}
+		s.logger.Printf("Instance admin %s created a registration invite", session.Username)
+		api.RespondJSON(w, map[string]interface{}{
+			"invite": invite,
+		})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminInviteWithID serves DELETE /api/admin/invites/{token}, which
+// revokes an invite
+func (s *Server) handleAdminInviteWithID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	token := strings.TrimPrefix(r.URL.Path, "/api/admin/invites/")
+
+	deleted, err := s.db.DeleteRegistrationInvite(r.Context(), token)
+	if err != nil {
+		api.RespondError(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		api.RespondError(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	s.logger.Printf("Instance admin %s revoked invite %s", session.Username, token)
+	api.RespondJSON(w, map[string]string{"status": "invite revoked"})
+}
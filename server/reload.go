@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// reloadableSettings are the settings, by YAML key, Reload switches to
+// without a restart. The rest are read once at startup, so changing them
+// needs one.
+var reloadableSettings = map[string]bool{
+	"cors_origins":                true,
+	"request_timeout":             true,
+	"default_language":            true,
+	"username_change_cooldown":    true,
+	"registration":                true,
+	"guest_access":                true,
+	"public_archive":              true,
+	"max_message_length":          true,
+	"ws_max_connections_per_user": true,
+	"ws_max_connections_per_ip":   true,
+	"ws_connection_limit_mode":    true,
+	"max_owned_halls":             true,
+	"max_hall_rooms":              true,
+	"max_hall_members":            true,
+	"max_hall_attachment_bytes":   true,
+	"daily_token_quota":           true,
+	"drain_reconnect_url":         true,
+	"require_verified_email":      true,
+}
+
+// ErrNoConfigLoader is what Reload returns for a server made without
+// WithConfigLoader
+var ErrNoConfigLoader = errors.New("no config loader, see WithConfigLoader")
+
+// ConfigReload is what a Reload changed, by YAML key
+type ConfigReload struct {
+	Changed         []string `json:"changed"`
+	RestartRequired []string `json:"restart_required"` // changed, but not until a restart
+}
+
+// Reload loads the config again with the WithConfigLoader function and
+// switches to its reloadable settings, keeping ws connections open. A config
+// that doesn't load or validate changes nothing.
+func (s *Server) Reload() (*ConfigReload, error) {
+	if s.loadConfig == nil {
+		return nil, ErrNoConfigLoader
+	}
+	next, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	current := s.config.Load()
+	merged := *current
+	reload := &ConfigReload{Changed: []string{}, RestartRequired: []string{}}
+
+	currentValue := reflect.ValueOf(current).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+	mergedValue := reflect.ValueOf(&merged).Elem()
+	for i := 0; i < mergedValue.NumField(); i++ {
+		key, _, _ := strings.Cut(mergedValue.Type().Field(i).Tag.Get("yaml"), ",")
+		if key == "" || reflect.DeepEqual(nextValue.Field(i).Interface(), currentValue.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableSettings[key] {
+			reload.RestartRequired = append(reload.RestartRequired, key)
+			continue
+		}
+		mergedValue.Field(i).Set(nextValue.Field(i))
+		reload.Changed = append(reload.Changed, key)
+	}
+
+	s.config.Store(&merged)
+	s.wsManager.Reconfigure(merged.WSOptions())
+	s.auth.Usage().SetQuota(merged.DailyTokenQuota)
+
+	s.logger.Printf("Reloaded config, changed: %s", settingList(reload.Changed))
+	if len(reload.RestartRequired) > 0 {
+		s.logger.Printf("Config changes waiting for a restart: %s", settingList(reload.RestartRequired))
+	}
+	return reload, nil
+}
+
+func settingList(keys []string) string {
+	if len(keys) == 0 {
+		return "nothing"
+	}
+	return strings.Join(keys, ", ")
+}
+
+// handleAdminReload serves POST /api/admin/reload, which does what SIGHUP
+// does
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	s.logger.Printf("Instance admin %s reloaded the config", session.Username)
+
+	reload, err := s.Reload()
+	if errors.Is(err, ErrNoConfigLoader) {
+		api.RespondError(w, "This instance can't reload its config", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		s.logger.Printf("Failed to reload config: %v", err)
+		api.RespondError(w, "Failed to reload config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.RespondJSON(w, reload)
+}
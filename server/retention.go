@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// Messages outside a hall's retention policy are pruned every
+// retentionCheckInterval, retentionBatchSize at a time.
+const (
+	retentionCheckInterval = time.Hour
+	retentionBatchSize     = 1000
+	retentionRunTimeout    = 10 * time.Minute
+)
+
+// PruneStats are the retention job's counters since the server started
+type PruneStats struct {
+	Runs           int           `json:"runs"`
+	LastRun        *time.Time    `json:"last_run,omitempty"`
+	LastDuration   time.Duration `json:"last_duration_ns"`
+	LastError      string        `json:"last_error,omitempty"`
+	MessagesPruned int           `json:"messages_pruned"`
+}
+
+type HallPruneStats struct {
+	MessagesPruned int        `json:"messages_pruned"`
+	LastPrunedAt   *time.Time `json:"last_pruned_at,omitempty"`
+}
+
+// RetentionPruner deletes messages that fall outside hall retention policies
+type RetentionPruner struct {
+	db     *store.Database
+	stats  PruneStats
+	halls  map[int]*HallPruneStats
+	mutex  sync.Mutex
+	logger *log.Logger
+}
+
+func NewRetentionPruner(db *store.Database, logger *log.Logger) *RetentionPruner {
+	return &RetentionPruner{
+		db:     db,
+		halls:  make(map[int]*HallPruneStats),
+		logger: logger,
+	}
+}
+
+// Run prunes until the process exits
+func (rp *RetentionPruner) Run() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		rp.prune()
+		<-ticker.C
+	}
+}
+
+func (rp *RetentionPruner) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), retentionRunTimeout)
+	defer cancel()
+
+	start := time.Now().UTC()
+	total := 0
+	pruned := make(map[int]int)
+
+	// A failing hall is logged and recorded, but doesn't stop the others
+	var lastErr error
+	policies, err := rp.db.GetRetentionPolicies(ctx)
+	if err != nil {
+		rp.logger.Printf("Failed to load retention policies: %v", err)
+		lastErr = err
+	}
+	for hallID, policy := range policies {
+		for {
+			n, err := rp.db.PruneHallMessages(ctx, hallID, policy, retentionBatchSize)
+			pruned[hallID] += n
+			total += n
+			if err != nil {
+				rp.logger.Printf("Failed to prune messages in hall %d: %v", hallID, err)
+				lastErr = err
+				break
+			}
+			if n < retentionBatchSize {
+				break
+			}
+		}
+	}
+
+	// Room events only need to outlive a reconnect
+	if n, err := rp.db.PruneRoomEvents(ctx, start.Add(-ws.RoomEventRetention)); err != nil {
+		rp.logger.Printf("Failed to prune room events: %v", err)
+		lastErr = err
+	} else if n > 0 {
+		rp.logger.Printf("Retention: pruned %d room events", n)
+	}
+
+	if n, err := rp.db.PruneSubscriptions(ctx, start.Add(-ws.SubscriptionRetention)); err != nil {
+		rp.logger.Printf("Failed to prune saved ws subscriptions: %v", err)
+		lastErr = err
+	} else if n > 0 {
+		rp.logger.Printf("Retention: pruned %d saved ws subscriptions", n)
+	}
+
+	if n, err := rp.db.PruneSessions(ctx, start); err != nil {
+		rp.logger.Printf("Failed to prune expired sessions: %v", err)
+		lastErr = err
+	} else if n > 0 {
+		rp.logger.Printf("Retention: pruned %d expired sessions", n)
+	}
+
+	// An instance resumes its own deliveries when it restarts; ones still
+	// pending a day after they were due belong to one that didn't
+	if n, err := rp.db.PruneWebhookDeliveries(ctx, start.Add(-webhookAbandoned)); err != nil {
+		rp.logger.Printf("Failed to prune webhook deliveries: %v", err)
+		lastErr = err
+	} else if n > 0 {
+		rp.logger.Printf("Retention: pruned %d abandoned webhook deliveries", n)
+	}
+
+	// API usage only counts for the current day
+	if _, err := rp.db.PruneAPIUsage(ctx, start); err != nil {
+		rp.logger.Printf("Failed to prune API usage: %v", err)
+		lastErr = err
+	}
+
+	if total > 0 {
+		rp.logger.Printf("Retention: pruned %d messages in %s", total, time.Since(start).Round(time.Millisecond))
+	}
+
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	rp.stats.Runs++
+	rp.stats.LastRun = &start
+	rp.stats.LastDuration = time.Since(start)
+	rp.stats.MessagesPruned += total
+	rp.stats.LastError = ""
+	if lastErr != nil {
+		rp.stats.LastError = lastErr.Error()
+	}
+
+	for hallID, n := range pruned {
+		if n == 0 {
+			continue
+		}
+		hall, ok := rp.halls[hallID]
+		if !ok {
+			hall = &HallPruneStats{}
+			rp.halls[hallID] = hall
+		}
+		hall.MessagesPruned += n
+		hall.LastPrunedAt = &start
+	}
+}
+
+// Stats returns the job-wide counters and those for one hall
+func (rp *RetentionPruner) Stats(hallID int) (PruneStats, HallPruneStats) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+
+	var hall HallPruneStats
+	if h, ok := rp.halls[hallID]; ok {
+		hall = *h
+	}
+	return rp.stats, hall
+}
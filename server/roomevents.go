@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// handleRoomEvents serves /api/rooms/{room_id}/events?after={seq}, the
+// room's stored events after seq, oldest first. Clients that see a gap in
+// the seqs of a room fill it from here without reconnecting.
+func (s *Server) handleRoomEvents(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var after int64
+	if afterStr := r.URL.Query().Get("after"); afterStr != "" {
+		after, err = strconv.ParseInt(afterStr, 10, 64)
+		if err != nil || after < 0 {
+			api.RespondError(w, "after must be a seq", http.StatusBadRequest)
+			return
+		}
+	}
+	limit, _ := parsePagination(r)
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	latest, err := s.db.GetRoomSeq(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch events", http.StatusInternalServerError)
+		return
+	}
+
+	events, err := s.db.GetRoomEventsSince(r.Context(), roomID, after, limit+1)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch events", http.StatusInternalServerError)
+		return
+	}
+
+	// Events are only kept for ws.RoomEventRetention; past that the client
+	// has to refetch messages instead, like after resync_required
+	pruned := after < latest && (len(events) == 0 || events[0].Seq != after+1)
+	if pruned || after > latest {
+		api.RespondErrorCode(w, api.ErrCodeResyncRequired, "Events after that seq aren't available", http.StatusGone)
+		return
+	}
+
+	hasMore := len(events) > limit
+	if hasMore {
+		events = events[:limit]
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room_id":  roomID,
+		"seq":      latest,
+		"events":   events,
+		"has_more": hasMore,
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// postErrorStatuses are the statuses of the send_message error codes that
+// aren't 400 when a message is posted over REST
+var postErrorStatuses = map[string]int{
+	api.ErrCodeRateLimited:    http.StatusTooManyRequests,
+	"spam_throttled":          http.StatusTooManyRequests,
+	"mention_rate_limited":    http.StatusTooManyRequests,
+	"server_busy":             http.StatusServiceUnavailable,
+	"room_archived":           http.StatusForbidden,
+	"automod_rejected":        http.StatusForbidden,
+	api.ErrCodeQuotaExceeded:  http.StatusForbidden,
+	api.ErrCodePluginRejected: http.StatusForbidden,
+	api.ErrCodeInternal:       http.StatusInternalServerError,
+}
+
+// handlePostRoomMessage serves POST /api/rooms/{room_id}/messages, which
+// sends a message like send_message on a ws connection does, for clients
+// without one
+func (s *Server) handlePostRoomMessage(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var req ws.SendMessageData
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.RoomID = room.ID
+
+	// ws quietly ignores empty text, here it's a mistake worth hearing about
+	if req.Content == "" && (req.Kind == "" || req.Kind == store.MessageKindText) {
+		api.RespondValidationErrors(w, []api.FieldError{{Field: "content", Code: "required", Message: "content is required"}})
+		return
+	}
+
+	result, err := s.wsManager.PostMessage(r.Context(), session, req)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		api.RespondError(w, "Timed out waiting for the message to be saved, retry with the same nonce", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	if result.Error != nil {
+		status, ok := postErrorStatuses[result.Error.Code]
+		if !ok {
+			status = http.StatusBadRequest
+		}
+		if result.Error.RetryAfterMs > 0 {
+			wait := time.Duration(result.Error.RetryAfterMs) * time.Millisecond
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		}
+		api.RespondErrorCode(w, result.Error.Code, result.Error.Message, status)
+		return
+	}
+
+	// Nothing was sent: a command that only replied, or a message automod
+	// or the spam filter dropped, which the sender isn't told about
+	var message *store.Message
+	var seq int64
+	duplicate := false
+	if result.Ack != nil {
+		message, err = s.db.GetMessageByID(r.Context(), result.Ack.MessageID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch message", http.StatusInternalServerError)
+			return
+		}
+		seq, duplicate = result.Ack.Seq, result.Ack.Duplicate
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"message":          message,
+		"seq":              seq,
+		"duplicate":        duplicate,
+		"command_response": result.Command,
+	})
+}
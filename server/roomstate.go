@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// handleRoomState serves /api/rooms/{room_id}/state, what a room header
+// shows, in one call: who's typing, how many people follow the room live
+// and the newest message's ID, so clients needn't join the room first
+func (s *Server) handleRoomState(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	newest, err := s.db.GetRoomMessages(r.Context(), roomID, 1, 0)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch room state", http.StatusInternalServerError)
+		return
+	}
+	var lastMessageID *int
+	if len(newest) > 0 {
+		lastMessageID = &newest[0].ID
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"room_id":         roomID,
+		"typing":          s.wsManager.Typers(roomID),
+		"online":          s.wsManager.RoomSubscribers(roomID),
+		"last_message_id": lastMessageID,
+	})
+}
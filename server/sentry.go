@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sentryQueueSize = 100
+	sentryTimeout   = 10 * time.Second
+	sentryClient    = "commons-api/1.0"
+)
+
+// sentryDSN is where a Sentry project takes events, from a DSN like
+// https://key@o0.ingest.sentry.io/0
+type sentryDSN struct {
+	raw      string
+	key      string
+	envelope string // URL events are POSTed to
+}
+
+func parseSentryDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("must be an http or https URL")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("has no public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || i == len(path)-1 {
+		return nil, errors.New("has no project ID")
+	}
+	prefix, project := path[:i], path[i+1:]
+	return &sentryDSN{
+		raw:      dsn,
+		key:      u.User.Username(),
+		envelope: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+	}, nil
+}
+
+// SentrySink is an ErrorSink that reports to Sentry over its HTTP API. Events
+// are sent in the background and dropped when too many are waiting or
+// Sentry asks for a break, so reporting can't slow the server down.
+type SentrySink struct {
+	dsn         *sentryDSN
+	environment string
+	serverName  string
+	client      *http.Client
+	events      chan []byte
+	pausedUntil atomic.Int64 // unix seconds, after a 429
+	logger      *log.Logger
+}
+
+// NewSentrySink reports to the project dsn points at, once Run is going
+func NewSentrySink(dsn, environment string, logger *log.Logger) (*SentrySink, error) {
+	parsed, err := parseSentryDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	serverName, _ := os.Hostname()
+	return &SentrySink{
+		dsn:         parsed,
+		environment: environment,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: sentryTimeout},
+		events:      make(chan []byte, sentryQueueSize),
+		logger:      logger,
+	}, nil
+}
+
+// sentryEvent is the part of Sentry's event payload we fill in
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Exception   sentryExceptions  `json:"exception"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"` // oldest call first
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+type sentryUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// CaptureError queues report for Sentry
+func (s *SentrySink) CaptureError(report ErrorReport) {
+	if time.Now().Unix() < s.pausedUntil.Load() {
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return
+	}
+	event := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC(),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      report.Source,
+		ServerName:  s.serverName,
+		Environment: s.environment,
+		Tags:        map[string]string{"source": report.Source},
+	}
+
+	exception := sentryException{Type: "error", Value: report.Err.Error()}
+	if report.Stack != nil {
+		exception.Type = "panic"
+		exception.Stacktrace = sentryFrames(report.Stack)
+	}
+	event.Exception.Values = []sentryException{exception}
+
+	if report.Method != "" {
+		event.Request = &sentryRequest{Method: report.Method, URL: report.URL}
+	}
+	if report.UserID != 0 {
+		event.User = &sentryUser{ID: strconv.Itoa(report.UserID), Username: report.Username}
+	}
+	if report.RequestID != "" {
+		event.Tags["request_id"] = report.RequestID
+	}
+	for key, value := range report.Tags {
+		event.Tags[key] = value
+	}
+
+	body, err := s.envelope(event)
+	if err != nil {
+		s.logger.Printf("Failed to marshal Sentry event: %v", err)
+		return
+	}
+	select {
+	case s.events <- body:
+	default:
+		s.logger.Printf("Sentry queue full, dropping event")
+	}
+}
+
+// sentryFrames turns a stack from ws.PanicStack into Sentry's frames
+func sentryFrames(stack []uintptr) *sentryStacktrace {
+	var frames []sentryFrame
+	callers := runtime.CallersFrames(stack)
+	for {
+		frame, more := callers.Next()
+		frames = append(frames, sentryFrame{
+			Function: frame.Function,
+			Filename: frame.File,
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "chatapp/"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return &sentryStacktrace{Frames: frames}
+}
+
+// envelope wraps an event the way Sentry's envelope endpoint takes it
+func (s *SentrySink) envelope(event sentryEvent) ([]byte, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]string{
+		"event_id": event.EventID,
+		"sent_at":  event.Timestamp.Format(time.RFC3339),
+		"dsn":      s.dsn.raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+	return body.Bytes(), nil
+}
+
+// Run sends queued events to Sentry. It doesn't return.
+func (s *SentrySink) Run() {
+	for body := range s.events {
+		s.send(body)
+	}
+}
+
+func (s *SentrySink) send(body []byte) {
+	req, err := http.NewRequest(http.MethodPost, s.dsn.envelope, bytes.NewReader(body))
+	if err != nil {
+		s.logger.Printf("Failed to report error to Sentry: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("User-Agent", sentryClient)
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=%s", s.dsn.key, sentryClient))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.Printf("Failed to report error to Sentry: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		wait, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || wait <= 0 {
+			wait = 60
+		}
+		s.pausedUntil.Store(time.Now().Unix() + int64(wait))
+		s.logger.Printf("Sentry is rate limiting, not reporting errors for %ds", wait)
+	case resp.StatusCode >= 300:
+		s.logger.Printf("Failed to report error to Sentry: %s", resp.Status)
+	}
+}
@@ -0,0 +1,192 @@
+// Package server is the chat server: the HTTP API and websocket endpoint,
+// and the config they run with. The commons-api binary runs it on its own;
+// other programs can embed it with New.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// closeTimeout is how long Close waits for buffered writes before closing
+// the database anyway
+const closeTimeout = 10 * time.Second
+
+// New opens the database cfg points at, brings its schema up to date and
+// connects to the broker, if any; opts swap those or the logger for the
+// caller's own. The returned server handles the whole API, websocket
+// included, so it can be mounted into another program's mux or run on its
+// own with ListenAndServe; Close it once it's done serving.
+// Configs not from LoadConfig should start out as DefaultConfig.
+func New(cfg *Config, opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = log.Default()
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var journal *store.Journal
+	if cfg.JournalDir != "" {
+		var err error
+		journal, err = store.OpenJournal(cfg.JournalDir, cfg.JournalMaxBytes, cfg.JournalKeep, o.logger)
+		if err != nil {
+			return nil, fmt.Errorf("opening message journal: %w", err)
+		}
+	}
+
+	dbOpts := cfg.DatabaseOptions()
+	dbOpts.Logger = o.logger
+	dbOpts.Journal = journal
+	var db *store.Database
+	if o.db != nil {
+		db = store.Wrap(o.db, dbOpts)
+	} else {
+		var err error
+		db, err = store.NewDatabase(dbOpts)
+		if err != nil {
+			journal.Close()
+			return nil, fmt.Errorf("connecting to database: %w", err)
+		}
+	}
+
+	if err := prepareDatabase(db, cfg); err != nil {
+		db.Close()
+		journal.Close()
+		return nil, err
+	}
+
+	errorSink := o.errorSink
+	if errorSink == nil && cfg.SentryDSN != "" {
+		sentry, err := NewSentrySink(cfg.SentryDSN, cfg.SentryEnvironment, o.logger)
+		if err != nil {
+			db.Close()
+			journal.Close()
+			return nil, err
+		}
+		go sentry.Run()
+		errorSink = sentry
+	}
+
+	broker, ownsBroker := o.broker, false
+	if broker == nil {
+		var err error
+		broker, err = newBroker(cfg, o.logger)
+		if err != nil {
+			db.Close()
+			journal.Close()
+			return nil, err
+		}
+		ownsBroker = true
+	}
+
+	plugins := ws.NewPlugins(append(ws.RegisteredPlugins(), o.plugins...), o.logger)
+	s := newServer(db, cfg, broker, plugins, errorSink, o.backupStore, o.logger)
+	s.ownsBroker = ownsBroker
+	s.journal = journal
+	s.loadConfig = o.configLoader
+	for i := len(o.middleware) - 1; i >= 0; i-- {
+		s.handler = o.middleware[i](s.handler)
+	}
+	return s, nil
+}
+
+// prepareDatabase runs startup work that isn't tied to a request, and
+// migrations may take a while
+func prepareDatabase(db *store.Database, cfg *Config) error {
+	ctx := context.Background()
+
+	if err := db.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrating database: %w", err)
+	}
+	if err := db.EnsureSystemUser(ctx); err != nil {
+		return fmt.Errorf("ensuring system user: %w", err)
+	}
+	if cfg.DefaultHall != "" {
+		if err := db.EnsureDefaultHall(ctx, cfg.DefaultHall, cfg.DefaultHallRooms); err != nil {
+			return fmt.Errorf("ensuring default hall: %w", err)
+		}
+	}
+	return nil
+}
+
+// newBroker keeps broadcasts in-process unless Redis or NATS is configured
+func newBroker(cfg *Config, logger *log.Logger) (ws.Broker, error) {
+	if cfg.RedisURL != "" {
+		broker, err := ws.NewRedisBroker(cfg.RedisURL, cfg.RedisChannel, logger)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to Redis: %w", err)
+		}
+		logger.Printf("Broadcasting over Redis channel %s", cfg.RedisChannel)
+		return broker, nil
+	}
+	if cfg.NATSURL != "" {
+		broker, err := ws.NewNATSBroker(cfg.NATSURL, cfg.NATSSubject, logger)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to NATS: %w", err)
+		}
+		logger.Printf("Broadcasting over NATS subject %s", cfg.NATSSubject)
+		return broker, nil
+	}
+	return ws.NewLocalBroker(), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves on the configured address, over TLS if the config
+// asks for it
+func (s *Server) ListenAndServe() error {
+	cfg := s.config.Load()
+	listener := &http.Server{
+		Addr:     cfg.Addr(),
+		Handler:  s,
+		ErrorLog: s.logger,
+	}
+	s.listener.Store(listener)
+	return serve(cfg, listener, s.logger)
+}
+
+// Shutdown stops ListenAndServe from taking new connections and waits for
+// the requests in flight to finish, or for ctx to be done. ws connections
+// aren't waited for, so Drain first. Close the server afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	listener := s.listener.Load()
+	if listener == nil {
+		return nil
+	}
+	return listener.Shutdown(ctx)
+}
+
+// Close waits for queued messages to be stored and writes out what the ws
+// manager buffers, then disconnects from the broker and closes the database
+// and message journal, leaving out any that came from WithBroker or
+// WithDatabase. Call Shutdown first when serving with ListenAndServe.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := s.wsManager.Close(ctx); err != nil {
+		s.logger.Printf("Failed to write out buffered ws state: %v", err)
+	}
+
+	if s.ownsBroker {
+		s.broker.Close()
+	}
+	err := s.db.Close()
+	if journalErr := s.journal.Close(); err == nil {
+		err = journalErr
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"chatapp/server"
+)
+
+// newTestServer runs a server on a fresh database in a temporary directory
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return newTestInstance(t, testConfig(t, t.TempDir()))
+}
+
+// testConfig is the default config with everything stored under dir
+func testConfig(t *testing.T, dir string) *server.Config {
+	t.Helper()
+
+	cfg := server.DefaultConfig()
+	cfg.DBPath = filepath.Join(dir, "chat.db")
+	cfg.StaticDir = ""
+	cfg.ExportDir = filepath.Join(dir, "exports")
+	cfg.BackupDir = filepath.Join(dir, "backups")
+	return cfg
+}
+
+// newTestInstance runs a server with cfg until the test ends
+func newTestInstance(t *testing.T, cfg *server.Config, opts ...server.Option) *httptest.Server {
+	t.Helper()
+
+	opts = append([]server.Option{server.WithLogger(log.New(io.Discard, "", 0))}, opts...)
+	srv, err := server.New(cfg, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return ts
+}
+
+// call sends body as JSON with token, if any, and decodes the answer into
+// out after checking its status
+func call(t *testing.T, ts *httptest.Server, method, path, token string, body, out interface{}, wantStatus int) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.StatusCode, wantStatus, raw)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s: decoding %s: %v", method, path, raw, err)
+		}
+	}
+}
+
+type authResponse struct {
+	Token string `json:"token"`
+	User  struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+type messageJSON struct {
+	ID       int    `json:"id"`
+	RoomID   int    `json:"room_id"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+}
+
+func TestRegisterLoginAndPost(t *testing.T) {
+	ts := newTestServer(t)
+	credentials := map[string]string{"username": "alice", "password": "Password123!x"}
+
+	var registered authResponse
+	call(t, ts, http.MethodPost, "/api/register", "", credentials, &registered, http.StatusOK)
+	if registered.Token == "" || registered.User.Username != "alice" {
+		t.Fatalf("register: got %+v", registered)
+	}
+
+	var loggedIn authResponse
+	call(t, ts, http.MethodPost, "/api/login", "", credentials, &loggedIn, http.StatusOK)
+	if loggedIn.Token == "" || loggedIn.User.ID != registered.User.ID {
+		t.Fatalf("login: got %+v, registered as %+v", loggedIn, registered)
+	}
+	token := loggedIn.Token
+
+	call(t, ts, http.MethodPost, "/api/login", "", map[string]string{"username": "alice", "password": "wrong password"}, nil, http.StatusUnauthorized)
+
+	var created struct {
+		Hall struct {
+			ID int `json:"id"`
+		} `json:"hall"`
+	}
+	call(t, ts, http.MethodPost, "/api/halls/create", token, map[string]string{"name": "test"}, &created, http.StatusOK)
+
+	var roomCreated struct {
+		Room struct {
+			ID int `json:"id"`
+		} `json:"room"`
+	}
+	call(t, ts, http.MethodPost, "/api/rooms/create", token, map[string]interface{}{"hall_id": created.Hall.ID, "name": "chat"}, &roomCreated, http.StatusOK)
+	roomID := roomCreated.Room.ID
+
+	var posted struct {
+		Message   *messageJSON `json:"message"`
+		Duplicate bool         `json:"duplicate"`
+	}
+	call(t, ts, http.MethodPost, fmt.Sprintf("/api/rooms/%d/messages", roomID), token, map[string]string{"content": "hello over REST", "nonce": "n1"}, &posted, http.StatusOK)
+	if posted.Message == nil || posted.Message.Content != "hello over REST" || posted.Message.RoomID != roomID || posted.Duplicate {
+		t.Fatalf("post: got %+v", posted)
+	}
+
+	// Retrying with the same nonce answers with the stored message
+	var retried struct {
+		Message   *messageJSON `json:"message"`
+		Duplicate bool         `json:"duplicate"`
+	}
+	call(t, ts, http.MethodPost, fmt.Sprintf("/api/rooms/%d/messages", roomID), token, map[string]string{"content": "hello over REST", "nonce": "n1"}, &retried, http.StatusOK)
+	if retried.Message == nil || retried.Message.ID != posted.Message.ID || !retried.Duplicate {
+		t.Fatalf("retry: got %+v, first post was %+v", retried, posted.Message)
+	}
+
+	var history struct {
+		Messages []messageJSON `json:"messages"`
+	}
+	call(t, ts, http.MethodGet, fmt.Sprintf("/api/messages/%d", roomID), token, nil, &history, http.StatusOK)
+	var found int
+	for _, message := range history.Messages {
+		if message.ID == posted.Message.ID {
+			found++
+			if message.Username != "alice" || message.Content != "hello over REST" {
+				t.Errorf("history: got %+v", message)
+			}
+		}
+	}
+	if found != 1 {
+		t.Fatalf("history: message %d appears %d times in %+v", posted.Message.ID, found, history.Messages)
+	}
+
+	call(t, ts, http.MethodPost, fmt.Sprintf("/api/rooms/%d/messages", roomID), "", map[string]string{"content": "anonymous"}, nil, http.StatusUnauthorized)
+}
+
+// fanoutBroker is an in-process stand-in for Redis or NATS, delivering to
+// every instance subscribed to it
+type fanoutBroker struct {
+	mutex    sync.Mutex
+	handlers []func(server.BrokerMessage)
+}
+
+func (b *fanoutBroker) Publish(msg server.BrokerMessage) error {
+	b.mutex.Lock()
+	handlers := append([]func(server.BrokerMessage){}, b.handlers...)
+	b.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (b *fanoutBroker) Subscribe(handler func(server.BrokerMessage)) {
+	b.mutex.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.mutex.Unlock()
+}
+
+func (b *fanoutBroker) Close() error {
+	return nil
+}
+
+func TestSessionsAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	broker := &fanoutBroker{}
+	instances := make([]*httptest.Server, 2)
+	for i := range instances {
+		cfg := testConfig(t, dir)
+		cfg.NodeID = i + 1
+		instances[i] = newTestInstance(t, cfg, server.WithBroker(broker))
+	}
+	a, b := instances[0], instances[1]
+	credentials := map[string]string{"username": "alice", "password": "Password123!x"}
+
+	// Logged in on one instance, the session works on the other
+	var registered authResponse
+	call(t, a, http.MethodPost, "/api/register", "", credentials, &registered, http.StatusOK)
+	call(t, b, http.MethodGet, "/api/sessions", registered.Token, nil, nil, http.StatusOK)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(b.URL, "http")+"/ws?token="+registered.Token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("reading hello: %v", err)
+	}
+
+	// Revoked on the first instance, the session's ws connection on the
+	// second is closed and the token stops working there
+	var loggedIn authResponse
+	call(t, a, http.MethodPost, "/api/login", "", credentials, &loggedIn, http.StatusOK)
+	var revoked struct {
+		Revoked int `json:"revoked"`
+	}
+	call(t, a, http.MethodDelete, "/api/sessions?others=true", loggedIn.Token, nil, &revoked, http.StatusOK)
+	if revoked.Revoked != 1 {
+		t.Fatalf("revoked %d sessions, want 1", revoked.Revoked)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Fatal("ws connection on the other instance still open after its session was revoked")
+		}
+		break
+	}
+
+	call(t, b, http.MethodGet, "/api/sessions", registered.Token, nil, nil, http.StatusUnauthorized)
+	call(t, b, http.MethodGet, "/api/sessions", loggedIn.Token, nil, nil, http.StatusOK)
+}
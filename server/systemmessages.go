@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"chatapp/internal/i18n"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// systemMessage writes the system message key in the server's default
+// language, along with the payload clients can translate it from
+func (s *Server) systemMessage(key string, args map[string]string) (string, *store.SystemPayload) {
+	return i18n.System(s.config.Load().DefaultLanguage, key, args), &store.SystemPayload{Key: key, Args: args}
+}
+
+// postSystemMessage writes a system message into a room and sends it to the
+// room's subscribers like any other message
+func (s *Server) postSystemMessage(ctx context.Context, room *store.Room, content string, payload *store.SystemPayload) {
+	message, err := s.db.SaveSystemMessage(ctx, room.ID, content, payload, store.MessageExpiry(room, 0))
+	if err != nil {
+		s.logger.Printf("Failed to save system message in room %d: %v", room.ID, err)
+		return
+	}
+
+	s.wsManager.BroadcastToRoom(room.ID, "new_message", ws.BroadcastMessageData{
+		Message: *message,
+		RoomID:  room.ID,
+	})
+}
+
+// postHallSystemMessage posts hall-wide activity, like new rooms, to the
+// hall's landing room. Halls without one don't get it.
+func (s *Server) postHallSystemMessage(ctx context.Context, hallID int, key string, args map[string]string) {
+	settings, err := s.db.GetHallSettings(ctx, hallID)
+	if err != nil {
+		s.logger.Printf("Failed to load settings of hall %d: %v", hallID, err)
+		return
+	}
+
+	room, err := s.landingRoom(ctx, hallID, settings)
+	if err != nil {
+		s.logger.Printf("Failed to find landing room of hall %d: %v", hallID, err)
+		return
+	}
+	if room != nil {
+		content, payload := s.systemMessage(key, args)
+		s.postSystemMessage(ctx, room, content, payload)
+	}
+}
+
+// welcomeMember announces a new member in the hall's landing room, followed
+// by the hall's welcome message if it has one
+func (s *Server) welcomeMember(ctx context.Context, hallID int, username string) {
+	settings, err := s.db.GetHallSettings(ctx, hallID)
+	if err != nil {
+		s.logger.Printf("Failed to load settings of hall %d: %v", hallID, err)
+		return
+	}
+
+	room, err := s.landingRoom(ctx, hallID, settings)
+	if err != nil {
+		s.logger.Printf("Failed to find landing room of hall %d: %v", hallID, err)
+		return
+	}
+	if room == nil {
+		return
+	}
+
+	content, payload := s.systemMessage(i18n.SystemMemberJoined, map[string]string{"username": username})
+	s.postSystemMessage(ctx, room, content, payload)
+	if settings.WelcomeMessage != "" {
+		s.postSystemMessage(ctx, room, strings.ReplaceAll(settings.WelcomeMessage, "{username}", username), nil)
+	}
+}
+
+// landingRoom returns the room new members of a hall land in: the one the
+// owner picked, or else the hall's oldest text room. It's nil if the hall
+// has no unarchived text room.
+func (s *Server) landingRoom(ctx context.Context, hallID int, settings store.HallSettings) (*store.Room, error) {
+	if settings.LandingRoomID != nil {
+		room, err := s.db.GetRoomByID(ctx, *settings.LandingRoomID)
+		if err == nil && room.HallID == hallID && room.Type == store.RoomTypeText && !room.Archived {
+			return room, nil
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	rooms, err := s.db.GetHallRooms(ctx, hallID, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rooms {
+		if rooms[i].Type == store.RoomTypeText {
+			return &rooms[i], nil
+		}
+	}
+	return nil, nil
+}
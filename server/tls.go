@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs server on the configured address, over TLS if the config asks
+// for it
+func serve(cfg *Config, server *http.Server, logger *log.Logger) error {
+	if !cfg.TLSEnabled() {
+		return server.ListenAndServe()
+	}
+
+	redirect := httpsRedirectHandler(cfg.Port)
+
+	if len(cfg.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		// The redirect listener also answers HTTP-01 challenges
+		redirect = manager.HTTPHandler(redirect)
+		logger.Printf("Getting certificates from Let's Encrypt for %v", cfg.AutocertDomains)
+	}
+
+	if cfg.HTTPRedirectPort != 0 {
+		redirectAddr := net.JoinHostPort(cfg.BindAddress, strconv.Itoa(cfg.HTTPRedirectPort))
+		go func() {
+			logger.Printf("Redirecting HTTP on %s to HTTPS", redirectAddr)
+			if err := http.ListenAndServe(redirectAddr, redirect); err != nil {
+				logger.Printf("HTTP redirect server failed: %v", err)
+			}
+		}()
+	}
+
+	// With autocert the certificate comes from TLSConfig
+	return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the same URL over HTTPS
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// handleTokens serves POST /api/tokens, which makes a scoped token. Tokens
+// are listed and revoked with the other sessions under /api/sessions.
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// expires_in is in seconds and defaults to the session TTL
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int      `json:"expires_in"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var errs []api.FieldError
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		errs = append(errs, api.FieldError{Field: "name", Code: "length", Message: "name must be 1-100 characters"})
+	}
+	if len(req.Scopes) == 0 {
+		errs = append(errs, api.FieldError{Field: "scopes", Code: "required", Message: "scopes must list at least one scope"})
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !auth.ValidScopes[scope] {
+			errs = append(errs, api.FieldError{Field: "scopes", Code: "unknown", Message: "unknown scope " + scope})
+			continue
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	ttl := s.config.Load().SessionTTL
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+		if ttl < time.Minute || ttl > auth.MaxTokenTTL {
+			errs = append(errs, api.FieldError{Field: "expires_in", Code: "out_of_range", Message: "expires_in must be between 60 seconds and 365 days"})
+		}
+	}
+	if len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return
+	}
+
+	token, err := s.auth.CreateToken(session, r, req.Name, scopes, ttl)
+	if err != nil {
+		api.RespondError(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	s.logger.Printf("User %s created token %s with scopes %s", session.Username, token.ID, strings.Join(scopes, ","))
+
+	api.RespondJSON(w, map[string]interface{}{
+		"id":         token.ID,
+		"token":      token.Token,
+		"name":       token.Name,
+		"scopes":     token.Scopes,
+		"expires_at": token.ExpiresAt,
+	})
+}
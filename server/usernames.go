@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+	"chatapp/internal/ws"
+)
+
+// usernameRedirectWindow is how long a name someone renamed away from keeps
+// resolving to them, in mentions, DMs and key lookups, and stays off limits
+// to everyone else
+const usernameRedirectWindow = 90 * 24 * time.Hour
+
+func usernameRedirectSince() time.Time {
+	return time.Now().Add(-usernameRedirectWindow)
+}
+
+// checkUsernameAvailable makes sure nobody but userID gave up username
+// recently, and otherwise responds and returns false. Taken usernames are
+// caught when they're saved.
+func (s *Server) checkUsernameAvailable(w http.ResponseWriter, r *http.Request, username string, userID int) bool {
+	reserved, err := s.db.IsUsernameReserved(r.Context(), username, userID, usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "Failed to check username", http.StatusInternalServerError)
+		return false
+	}
+	if reserved {
+		api.RespondErrorCode(w, api.ErrCodeUsernameTaken, "Username already exists", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// nextUsernameChange returns when the user may rename themselves next, or
+// nil if they may now
+func (s *Server) nextUsernameChange(ctx context.Context, userID int) (*time.Time, error) {
+	last, err := s.db.LastUsernameChange(ctx, userID)
+	if err != nil || last == nil {
+		return nil, err
+	}
+	next := last.Add(s.config.Load().UsernameChangeCooldown)
+	if !next.After(time.Now()) {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// handleUserMe serves /api/users/me: GET shows your account, PATCH with
+// {"username": "..."} renames you
+func (s *Server) handleUserMe(w http.ResponseWriter, r *http.Request) {
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		if !s.renameUser(w, r, session) {
+			return
+		}
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	history, err := s.db.GetUsernameHistory(r.Context(), session.UserID, usernameRedirectSince())
+	if err != nil {
+		api.RespondError(w, "Failed to fetch username history", http.StatusInternalServerError)
+		return
+	}
+	next, err := s.nextUsernameChange(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch username history", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"user":                     user,
+		"previous_usernames":       history,
+		"next_username_change":     next,
+		"username_change_cooldown": int(s.config.Load().UsernameChangeCooldown.Seconds()),
+	})
+}
+
+// renameUser changes the session's user's username, and otherwise responds
+// and returns false
+func (s *Server) renameUser(w http.ResponseWriter, r *http.Request, session *auth.Session) bool {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if !api.DecodeJSON(w, r, &req) {
+		return false
+	}
+
+	if errs := s.policy.ValidateUsername(req.Username); len(errs) > 0 {
+		api.RespondValidationErrors(w, errs)
+		return false
+	}
+	if req.Username == session.Username {
+		api.RespondError(w, "That's already your username", http.StatusBadRequest)
+		return false
+	}
+
+	next, err := s.nextUsernameChange(r.Context(), session.UserID)
+	if err != nil {
+		api.RespondError(w, "Failed to check username history", http.StatusInternalServerError)
+		return false
+	}
+	if next != nil {
+		message := fmt.Sprintf("You can change your username again after %s", next.UTC().Format(time.RFC3339))
+		api.RespondErrorCode(w, api.ErrCodeRenameCooldown, message, http.StatusTooManyRequests)
+		return false
+	}
+
+	if !s.checkUsernameAvailable(w, r, req.Username, session.UserID) {
+		return false
+	}
+
+	oldUsername, err := s.db.RenameUser(r.Context(), session.UserID, req.Username)
+	if errors.Is(err, store.ErrUsernameTaken) {
+		api.RespondErrorCode(w, api.ErrCodeUsernameTaken, "Username already exists", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		api.RespondError(w, "Failed to change username", http.StatusInternalServerError)
+		return false
+	}
+
+	s.logger.Printf("User %s renamed themselves to %s", oldUsername, req.Username)
+
+	// Everyone who can see the user's name, the user's other devices included
+	userIDs, err := s.db.GetHallmateIDs(r.Context(), session.UserID)
+	if err != nil {
+		s.logger.Printf("Failed to fetch hallmates of user %d: %v", session.UserID, err)
+		userIDs = nil
+	}
+	if len(userIDs) == 0 {
+		userIDs = []int{session.UserID}
+	}
+	s.wsManager.SendToUsers(userIDs, "user_renamed", ws.UserRenamedData{
+		UserID:      session.UserID,
+		OldUsername: oldUsername,
+		Username:    req.Username,
+	})
+	return true
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+)
+
+// handleRoomVoice serves /api/rooms/{room_id}/voice, which lists who's in a
+// voice room
+func (s *Server) handleRoomVoice(w http.ResponseWriter, r *http.Request, roomIDStr string) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+	if session == nil {
+		api.RespondError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		api.RespondError(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := s.db.IsUserInHall(r.Context(), session.UserID, room.HallID)
+	if err != nil || !isMember {
+		api.RespondError(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	participants, err := s.db.GetVoiceParticipants(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch voice participants", http.StatusInternalServerError)
+		return
+	}
+
+	api.RespondJSON(w, map[string]interface{}{
+		"participants": participants,
+	})
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/store"
+)
+
+// archivePageSize is how many messages a day's archive page shows; busier
+// days continue on further pages
+const archivePageSize = 500
+
+// archiveDayFormat is how days appear in archive URLs
+const archiveDayFormat = "2006-01-02"
+
+// archiveHall and archiveRoom are what the archive shows of them, leaving
+// out things like the invite code
+type archiveHall struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type archiveRoom struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Topic string `json:"topic,omitempty"`
+}
+
+// archivePage is what an archive page shows. Without a Day it's the room's
+// index of days.
+type archivePage struct {
+	Hall     archiveHall        `json:"hall"`
+	Room     archiveRoom        `json:"room"`
+	Days     []store.ArchiveDay `json:"-"`
+	Day      string             `json:"day,omitempty"`
+	Messages []store.Message    `json:"messages"`
+	PrevDay  string             `json:"prev_day,omitempty"`
+	NextDay  string             `json:"next_day,omitempty"`
+	After    int                `json:"next_after,omitempty"` // for the rest of a busy day
+}
+
+// handleArchive serves the public web archive: /archive/{hall_id}/{room_id}
+// lists the days a public room has messages on, and
+// /archive/{hall_id}/{room_id}/{YYYY-MM-DD} shows one of them, both as HTML
+// or with ?format=json as JSON. Nobody needs an account to read them.
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Rooms that aren't public look the same as missing ones
+	notFound := func() { api.RespondError(w, "Room not found", http.StatusNotFound) }
+	if !s.config.Load().PublicArchive {
+		notFound()
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/archive/"), "/"), "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		notFound()
+		return
+	}
+	hallID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		notFound()
+		return
+	}
+	roomID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		notFound()
+		return
+	}
+
+	room, err := s.db.GetRoomByID(r.Context(), roomID)
+	if err != nil || room.HallID != hallID || !room.Public {
+		notFound()
+		return
+	}
+	hall, err := s.db.GetHallByID(r.Context(), hallID)
+	if err != nil {
+		notFound()
+		return
+	}
+
+	days, err := s.db.GetArchiveDays(r.Context(), roomID)
+	if err != nil {
+		api.RespondError(w, "Failed to fetch archive", http.StatusInternalServerError)
+		return
+	}
+
+	page := archivePage{
+		Hall: archiveHall{ID: hall.ID, Name: hall.Name},
+		Room: archiveRoom{ID: room.ID, Name: room.Name, Topic: room.Topic},
+	}
+
+	if len(parts) == 2 {
+		page.Days = days
+	} else {
+		day, err := time.Parse(archiveDayFormat, parts[2])
+		if err != nil {
+			api.RespondError(w, "Days look like 2024-01-31", http.StatusBadRequest)
+			return
+		}
+		after := 0
+		if value := r.URL.Query().Get("after"); value != "" {
+			if after, err = strconv.Atoi(value); err != nil {
+				api.RespondError(w, "Invalid after", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// One extra tells whether the day goes on
+		messages, err := s.db.GetArchivedMessages(r.Context(), roomID, day, after, archivePageSize+1)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch archive", http.StatusInternalServerError)
+			return
+		}
+		if len(messages) > archivePageSize {
+			messages = messages[:archivePageSize]
+			page.After = messages[len(messages)-1].ID
+		}
+
+		page.Day = day.Format(archiveDayFormat)
+		page.Messages = messages
+		for _, d := range days {
+			if d.Date < page.Day {
+				page.PrevDay = d.Date
+			} else if d.Date > page.Day && page.NextDay == "" {
+				page.NextDay = d.Date
+			}
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if r.URL.Query().Get("format") == "json" {
+		if page.Day == "" {
+			api.RespondJSON(w, map[string]interface{}{
+				"hall": page.Hall,
+				"room": page.Room,
+				"days": page.Days,
+			})
+			return
+		}
+		api.RespondJSON(w, page)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := archiveTemplate.Execute(&buf, page); err != nil {
+		s.logger.Printf("Failed to render archive of room %d: %v", roomID, err)
+		api.RespondError(w, "Failed to render archive", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+var archiveTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"clock": func(t time.Time) string { return t.UTC().Format("15:04") },
+	"path": func(page archivePage, day string) string {
+		return fmt.Sprintf("/archive/%d/%d/%s", page.Hall.ID, page.Room.ID, day)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Room.Name}} · {{.Hall.Name}}{{if .Day}} · {{.Day}}{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; color: #222; }
+header p, nav, time { color: #666; }
+nav { display: flex; justify-content: space-between; margin: 1rem 0; }
+ol { list-style: none; padding: 0; }
+.message { margin: 0.5rem 0; }
+.message p { margin: 0; white-space: pre-wrap; overflow-wrap: anywhere; }
+.action { font-style: italic; }
+</style>
+</head>
+<body>
+<header>
+<h1><a href="/archive/{{.Hall.ID}}/{{.Room.ID}}">{{.Room.Name}}</a></h1>
+<p>{{.Hall.Name}}{{if .Room.Topic}} · {{.Room.Topic}}{{end}}</p>
+</header>
+{{if .Day -}}
+<h2>{{.Day}} (UTC)</h2>
+<ol>
+{{- range .Messages}}
+<li class="message{{if eq .Type "action"}} action{{end}}" id="message-{{.ID}}">
+<a href="#message-{{.ID}}"><time datetime="{{.CreatedAt.UTC.Format "2006-01-02T15:04:05Z"}}">{{clock .CreatedAt}}</time></a>
+<strong>{{.Username}}</strong>
+<p>{{if .Content}}{{.Content}}{{else}}({{.Kind}}){{end}}</p>
+</li>
+{{- else}}
+<li>No messages on this day.</li>
+{{- end}}
+</ol>
+{{if .After}}<p><a href="?after={{.After}}">More from this day</a></p>{{end}}
+<nav>
+<span>{{if .PrevDay}}<a href="{{path . .PrevDay}}">← {{.PrevDay}}</a>{{end}}</span>
+<span>{{if .NextDay}}<a href="{{path . .NextDay}}">{{.NextDay}} →</a>{{end}}</span>
+</nav>
+{{- else -}}
+<ol>
+{{- range .Days}}
+<li><a href="{{path $ .Date}}">{{.Date}}</a> · {{.Messages}} messages</li>
+{{- else}}
+<li>Nothing here yet.</li>
+{{- end}}
+</ol>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,673 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"chatapp/internal/api"
+	"chatapp/internal/auth"
+	"chatapp/internal/store"
+)
+
+// Outgoing webhook deliveries carry these besides the signature headers. The
+// delivery ID stays the same across retries so receivers can drop repeats.
+const (
+	webhookEventHeader    = "X-Commons-Event"
+	webhookDeliveryHeader = "X-Commons-Delivery"
+)
+
+// A failed delivery is tried again after webhookRetryBase, doubling each
+// time up to webhookRetryMax, give or take a quarter so endpoints coming
+// back up aren't hit by every retry at once
+const (
+	webhookTimeout   = 10 * time.Second
+	webhookRetryBase = 10 * time.Second
+	webhookRetryMax  = 10 * time.Minute
+)
+
+// webhookAbandoned is how long past due a pending delivery is kept for the
+// instance that queued it to come back
+const webhookAbandoned = 24 * time.Hour
+
+const (
+	webhookQueueSize = 1024
+	webhookWorkers   = 4
+
+	maxHallWebhooks     = 10
+	maxWebhookURLLength = 2000
+	webhookErrorLength  = 500 // of the error kept with a dead letter
+)
+
+// webhookEvents are the events webhooks can subscribe to
+var webhookEvents = []string{
+	"new_message",
+	"message_deleted",
+	"messages_bulk_deleted",
+	"message_ttl_updated",
+	"interaction_created",
+	"room_created",
+	"room_deleted",
+	"room_archived",
+	"room_extended",
+	"room_topic_updated",
+	"member_joined",
+	"member_left",
+	"hall_settings_updated",
+	"voice_joined",
+	"voice_left",
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// errWebhookAddress is returned for webhooks pointing at a loopback or
+// private address when those aren't allowed
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// publicIP reports whether ip is reachable from outside the instance's
+// network
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
+
+// WebhookPayload is the body of a webhook delivery
+type WebhookPayload struct {
+	ID        string          `json:"id"` // the delivery ID
+	Event     string          `json:"event"`
+	HallID    int             `json:"hall_id"`
+	RoomID    int             `json:"room_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type webhookEvent struct {
+	hallID int
+	roomID int // 0 for hall events
+	event  string
+	data   json.RawMessage
+	at     time.Time
+}
+
+type webhookDelivery struct {
+	hook     store.Webhook
+	id       string
+	event    string
+	body     []byte
+	attempts int
+}
+
+// WebhookDispatcher delivers hall events to the hall's webhooks. It hears
+// about events from the ws manager, as its ws.EventSink, and delivers them
+// in the background; deliveries that keep failing end up as dead letters.
+// Pending deliveries are kept in the database until they're done with, and
+// Run picks up the ones this instance left behind when it last stopped.
+type WebhookDispatcher struct {
+	db           *store.Database
+	nodeID       int
+	client       *http.Client
+	maxAttempts  int
+	allowPrivate bool
+	events       chan webhookEvent
+	deliveries   chan *webhookDelivery
+	errors       ErrorSink // nil when failures are only logged
+	logger       *log.Logger
+}
+
+func NewWebhookDispatcher(db *store.Database, cfg *Config, logger *log.Logger) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		db:           db,
+		nodeID:       cfg.NodeID,
+		maxAttempts:  cfg.WebhookMaxAttempts,
+		allowPrivate: cfg.WebhookAllowPrivate,
+		events:       make(chan webhookEvent, webhookQueueSize),
+		deliveries:   make(chan *webhookDelivery, webhookQueueSize),
+		logger:       logger,
+	}
+
+	// Names can resolve to private addresses too, so check what's dialed
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !d.allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errWebhookAddress
+			}
+			return nil
+		}
+	}
+	d.client = &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: webhookWorkers,
+		},
+		// A redirect is the endpoint's problem, not something to follow
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// RoomEvent queues a room's event for its hall's webhooks
+func (d *WebhookDispatcher) RoomEvent(roomID int, msgType string, data json.RawMessage) {
+	d.queue(webhookEvent{roomID: roomID, event: msgType, data: data})
+}
+
+// HallEvent queues a hall's event for its webhooks
+func (d *WebhookDispatcher) HallEvent(hallID int, msgType string, data json.RawMessage) {
+	d.queue(webhookEvent{hallID: hallID, event: msgType, data: data})
+}
+
+func (d *WebhookDispatcher) queue(event webhookEvent) {
+	if !isWebhookEvent(event.event) {
+		return
+	}
+	event.at = time.Now().UTC().Truncate(time.Second)
+	select {
+	case d.events <- event:
+	default:
+		d.logger.Printf("Webhook queue full, dropping %s event", event.event)
+	}
+}
+
+// Run resumes the deliveries left pending, then fans queued events out to
+// webhooks and delivers them. It doesn't return.
+func (d *WebhookDispatcher) Run() {
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for delivery := range d.deliveries {
+				d.attempt(delivery)
+			}
+		}()
+	}
+
+	d.resume()
+	for event := range d.events {
+		d.fanOut(event)
+	}
+}
+
+// fanOut makes a delivery of the event for each webhook that wants it
+func (d *WebhookDispatcher) fanOut(event webhookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if event.roomID != 0 {
+		room, err := d.db.GetRoomByID(ctx, event.roomID)
+		if err != nil {
+			return
+		}
+		event.hallID = room.HallID
+	}
+
+	hooks, err := d.db.GetHallWebhooks(ctx, event.hallID)
+	if err != nil {
+		d.logger.Printf("Failed to fetch webhooks of hall %d: %v", event.hallID, err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Wants(event.event) {
+			continue
+		}
+		id, err := store.GenerateInviteCode()
+		if err != nil {
+			d.logger.Printf("Failed to make webhook delivery ID: %v", err)
+			return
+		}
+		body, err := json.Marshal(WebhookPayload{
+			ID:        id,
+			Event:     event.event,
+			HallID:    event.hallID,
+			RoomID:    event.roomID,
+			CreatedAt: event.at,
+			Data:      event.data,
+		})
+		if err != nil {
+			d.logger.Printf("Failed to marshal webhook payload: %v", err)
+			return
+		}
+		delivery := &webhookDelivery{hook: hook, id: id, event: event.event, body: body}
+		d.persist(delivery, event.at)
+		d.deliveries <- delivery
+	}
+}
+
+// resume schedules the deliveries this instance had pending when it stopped,
+// each when its next attempt was due
+func (d *WebhookDispatcher) resume() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pending, err := d.db.GetWebhookDeliveries(ctx, d.nodeID)
+	if err != nil {
+		d.logger.Printf("Failed to load pending webhook deliveries: %v", err)
+		return
+	}
+	for _, p := range pending {
+		hook, err := d.db.GetWebhook(ctx, p.WebhookID)
+		if err == sql.ErrNoRows {
+			d.forget(&webhookDelivery{hook: store.Webhook{ID: p.WebhookID}, id: p.DeliveryID})
+			continue
+		}
+		if err != nil {
+			d.logger.Printf("Failed to load webhook %d: %v", p.WebhookID, err)
+			continue
+		}
+		delivery := &webhookDelivery{hook: *hook, id: p.DeliveryID, event: p.Event, body: []byte(p.Payload), attempts: p.Attempts}
+		time.AfterFunc(time.Until(p.NextAttempt), func() {
+			d.deliveries <- delivery
+		})
+	}
+	if len(pending) > 0 {
+		d.logger.Printf("Resumed %d pending webhook deliveries", len(pending))
+	}
+}
+
+// persist stores a delivery as pending until next. If that fails it's still
+// attempted, but a restart before it's done drops it.
+func (d *WebhookDispatcher) persist(delivery *webhookDelivery, next time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := d.db.SaveWebhookDelivery(ctx, &store.WebhookDelivery{
+		WebhookID:   delivery.hook.ID,
+		DeliveryID:  delivery.id,
+		NodeID:      d.nodeID,
+		Event:       delivery.event,
+		Payload:     string(delivery.body),
+		Attempts:    delivery.attempts,
+		NextAttempt: next,
+	})
+	if err != nil {
+		d.logger.Printf("Failed to store webhook %d delivery %s: %v", delivery.hook.ID, delivery.id, err)
+	}
+}
+
+// forget deletes a delivery that's done with from the pending ones
+func (d *WebhookDispatcher) forget(delivery *webhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := d.db.DeleteWebhookDelivery(ctx, delivery.hook.ID, delivery.id); err != nil {
+		d.logger.Printf("Failed to clear webhook %d delivery %s: %v", delivery.hook.ID, delivery.id, err)
+	}
+}
+
+// Redeliver sends a dead letter again, as a new run of attempts under its
+// old delivery ID. If those fail too it's dead-lettered again.
+func (d *WebhookDispatcher) Redeliver(ctx context.Context, hook *store.Webhook, letter *store.WebhookDeadLetter) error {
+	if err := d.db.DeleteWebhookDeadLetter(ctx, letter.ID); err != nil {
+		return err
+	}
+	delivery := &webhookDelivery{hook: *hook, id: letter.DeliveryID, event: letter.Event, body: []byte(letter.Payload)}
+	d.persist(delivery, time.Now())
+	select {
+	case d.deliveries <- delivery:
+	default:
+		// The workers are busy; don't hold up the request
+		go func() { d.deliveries <- delivery }()
+	}
+	return nil
+}
+
+// attempt makes one try at a delivery, then schedules the next or
+// dead-letters it
+func (d *WebhookDispatcher) attempt(delivery *webhookDelivery) {
+	// The webhook may have gone while a retry waited
+	if delivery.attempts > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := d.db.GetWebhook(ctx, delivery.hook.ID)
+		cancel()
+		if err == sql.ErrNoRows {
+			d.forget(delivery)
+			return
+		}
+		if err != nil {
+			d.logger.Printf("Failed to load webhook %d: %v", delivery.hook.ID, err)
+			d.retry(delivery)
+			return
+		}
+	}
+
+	delivery.attempts++
+	status, err := d.send(delivery)
+	if err == nil {
+		d.forget(delivery)
+		return
+	}
+
+	// Only the endpoint being down or overloaded is worth retrying
+	retryable := status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+	if retryable && delivery.attempts < d.maxAttempts {
+		d.retry(delivery)
+		return
+	}
+
+	d.logger.Printf("Webhook %d delivery %s failed after %d attempts: %v", delivery.hook.ID, delivery.id, delivery.attempts, err)
+	if d.errors != nil {
+		d.errors.CaptureError(ErrorReport{
+			Err:    fmt.Errorf("webhook delivery failed after %d attempts: %w", delivery.attempts, err),
+			Source: "webhook",
+			Tags: map[string]string{
+				"hall_id":     strconv.Itoa(delivery.hook.HallID),
+				"webhook_id":  strconv.Itoa(delivery.hook.ID),
+				"delivery_id": delivery.id,
+				"event":       delivery.event,
+				"status":      strconv.Itoa(status),
+			},
+		})
+	}
+	message := err.Error()
+	if len(message) > webhookErrorLength {
+		message = message[:webhookErrorLength]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	letter := &store.WebhookDeadLetter{
+		WebhookID:  delivery.hook.ID,
+		DeliveryID: delivery.id,
+		Event:      delivery.event,
+		Payload:    string(delivery.body),
+		Attempts:   delivery.attempts,
+		LastStatus: status,
+		LastError:  message,
+	}
+	if err := d.db.AddWebhookDeadLetter(ctx, letter); err != nil {
+		d.logger.Printf("Failed to dead-letter webhook %d delivery %s: %v", delivery.hook.ID, delivery.id, err)
+		return
+	}
+	d.forget(delivery)
+}
+
+// retry schedules the next attempt at a delivery after a backoff, storing
+// when it's due so a restart meanwhile doesn't lose it
+func (d *WebhookDispatcher) retry(delivery *webhookDelivery) {
+	delay := webhookBackoff(delivery.attempts)
+	d.persist(delivery, time.Now().Add(delay))
+	time.AfterFunc(delay, func() {
+		d.deliveries <- delivery
+	})
+}
+
+// send POSTs a delivery, signed afresh so retries aren't turned away as
+// stale. The status is 0 if no response came back.
+func (d *WebhookDispatcher) send(delivery *webhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.hook.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "commons-api-webhooks")
+	req.Header.Set(webhookEventHeader, delivery.event)
+	req.Header.Set(webhookDeliveryHeader, delivery.id)
+	if err := SignWebhookRequest(req, delivery.hook.Secret, delivery.body); err != nil {
+		return 0, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff is how long to wait before the try after attempt
+func webhookBackoff(attempt int) time.Duration {
+	delay := webhookRetryMax
+	if attempt < 16 {
+		delay = webhookRetryBase << (attempt - 1)
+		if delay > webhookRetryMax {
+			delay = webhookRetryMax
+		}
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2)) - delay/4
+	return delay + jitter
+}
+
+// validateWebhookURL checks a webhook URL is absolute http(s), and not at a
+// private address when those aren't allowed. Hostnames are checked again
+// when they're dialed.
+func (s *Server) validateWebhookURL(rawURL string) error {
+	if len(rawURL) > maxWebhookURLLength {
+		return fmt.Errorf("URLs are limited to %d characters", maxWebhookURLLength)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("url can't carry credentials")
+	}
+	if !s.config.Load().WebhookAllowPrivate {
+		host := u.Hostname()
+		if ip := net.ParseIP(host); (ip != nil && !publicIP(ip)) || strings.EqualFold(host, "localhost") {
+			return errors.New("url can't point at a loopback or private address")
+		}
+	}
+	return nil
+}
+
+// handleHallWebhooks serves /api/halls/{hall_id}/webhooks to the hall's
+// owner: GET lists the webhooks and POST adds one, returning its signing
+// secret that one time. parts is the path after webhooks.
+func (s *Server) handleHallWebhooks(w http.ResponseWriter, r *http.Request, hall *store.Hall, parts []string) {
+	if len(parts) > 0 && parts[0] != "" {
+		s.handleHallWebhook(w, r, hall, parts)
+		return
+	}
+
+	session := auth.SessionFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := s.db.GetHallWebhooks(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"webhooks": hooks,
+		})
+	case http.MethodPost:
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}
+		if !api.DecodeJSON(w, r, &req) {
+			return
+		}
+
+		req.URL = strings.TrimSpace(req.URL)
+		if err := s.validateWebhookURL(req.URL); err != nil {
+			api.RespondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, event := range req.Events {
+			if !isWebhookEvent(event) {
+				api.RespondError(w, fmt.Sprintf("Unknown event %q", event), http.StatusBadRequest)
+				return
+			}
+		}
+
+		hooks, err := s.db.GetHallWebhooks(r.Context(), hall.ID)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+			return
+		}
+		if len(hooks) >= maxHallWebhooks {
+			api.RespondError(w, fmt.Sprintf("Halls can have at most %d webhooks", maxHallWebhooks), http.StatusBadRequest)
+			return
+		}
+
+		secret, err := s.auth.GenerateToken()
+		if err != nil {
+			api.RespondError(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+		hook, err := s.db.CreateWebhook(r.Context(), hall.ID, req.URL, secret, req.Events, session.UserID)
+		if err != nil {
+			api.RespondError(w, "Failed to create webhook", http.StatusInternalServerError)
+			return
+		}
+
+		details := fmt.Sprintf("webhook=%d url=%q", hook.ID, hook.URL)
+		if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "webhook_created", "hall", hall.ID, details); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"webhook": hook,
+			"secret":  secret,
+		})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHallWebhook serves /api/halls/{hall_id}/webhooks/{webhook_id}: GET
+// shows it and DELETE removes it. /dead-letters lists the deliveries that
+// failed for good, and /dead-letters/{id}/redeliver sends one again.
+func (s *Server) handleHallWebhook(w http.ResponseWriter, r *http.Request, hall *store.Hall, parts []string) {
+	session := auth.SessionFromContext(r.Context())
+
+	webhookID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	hook, err := s.db.GetWebhook(r.Context(), webhookID)
+	if err != nil || hook.HallID != hall.ID {
+		api.RespondError(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) > 1 && parts[1] == "dead-letters" {
+		s.handleWebhookDeadLetters(w, r, hook, parts[2:])
+		return
+	}
+	if len(parts) != 1 {
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.RespondJSON(w, map[string]interface{}{
+			"webhook": hook,
+		})
+	case http.MethodDelete:
+		if _, err := s.db.DeleteWebhook(r.Context(), hook.ID); err != nil {
+			api.RespondError(w, "Failed to delete webhook", http.StatusInternalServerError)
+			return
+		}
+		details := fmt.Sprintf("webhook=%d url=%q", hook.ID, hook.URL)
+		if err := s.db.AddAuditLog(r.Context(), hall.ID, session.UserID, "webhook_deleted", "hall", hall.ID, details); err != nil {
+			s.logger.Printf("Failed to write audit log: %v", err)
+		}
+		api.RespondJSON(w, map[string]string{"status": "webhook deleted"})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWebhookDeadLetters serves .../dead-letters (GET, newest first, with
+// limit and offset), .../dead-letters/{id} (GET, DELETE) and
+// .../dead-letters/{id}/redeliver (POST)
+func (s *Server) handleWebhookDeadLetters(w http.ResponseWriter, r *http.Request, hook *store.Webhook, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		if r.Method != http.MethodGet {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, offset := parsePagination(r)
+		letters, err := s.db.GetWebhookDeadLetters(r.Context(), hook.ID, limit, offset)
+		if err != nil {
+			api.RespondError(w, "Failed to fetch dead letters", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]interface{}{
+			"dead_letters": letters,
+			"limit":        limit,
+			"offset":       offset,
+		})
+		return
+	}
+
+	letterID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		api.RespondError(w, "Invalid dead letter ID", http.StatusBadRequest)
+		return
+	}
+	letter, err := s.db.GetWebhookDeadLetter(r.Context(), letterID)
+	if err != nil || letter.WebhookID != hook.ID {
+		api.RespondError(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "redeliver" {
+		if r.Method != http.MethodPost {
+			api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.webhooks.Redeliver(r.Context(), hook, letter); err != nil {
+			api.RespondError(w, "Failed to redeliver", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]string{"status": "redelivery queued"})
+		return
+	}
+	if len(parts) != 1 {
+		api.RespondError(w, "Unknown action", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.RespondJSON(w, map[string]interface{}{
+			"dead_letter": letter,
+		})
+	case http.MethodDelete:
+		if err := s.db.DeleteWebhookDeadLetter(r.Context(), letter.ID); err != nil {
+			api.RespondError(w, "Failed to delete dead letter", http.StatusInternalServerError)
+			return
+		}
+		api.RespondJSON(w, map[string]string{"status": "dead letter deleted"})
+	default:
+		api.RespondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
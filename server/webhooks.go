@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/internal/store"
+)
+
+// Webhook signature scheme (v1), shared by incoming and outgoing webhooks:
+//
+//	X-Commons-Timestamp: unix seconds when the request was signed
+//	X-Commons-Nonce:     random value unique per delivery
+//	X-Commons-Signature: v1=hex(HMAC-SHA256(secret, timestamp + "." + nonce + "." + body))
+//
+// Receivers must reject requests whose timestamp is outside the tolerance
+// window and nonces they have already seen within it.
+const (
+	webhookSignatureVersion = "v1"
+	webhookTimestampHeader  = "X-Commons-Timestamp"
+	webhookNonceHeader      = "X-Commons-Nonce"
+	webhookSignatureHeader  = "X-Commons-Signature"
+	webhookTolerance        = 5 * time.Minute
+)
+
+// webhookScheme describes the signature scheme for the instance info endpoint.
+var webhookScheme = map[string]interface{}{
+	"version":           webhookSignatureVersion,
+	"algorithm":         "HMAC-SHA256",
+	"timestamp_header":  webhookTimestampHeader,
+	"nonce_header":      webhookNonceHeader,
+	"signature_header":  webhookSignatureHeader,
+	"signed_payload":    "{timestamp}.{nonce}.{body}",
+	"signature_format":  webhookSignatureVersion + "={hex_digest}",
+	"tolerance_seconds": int(webhookTolerance.Seconds()),
+}
+
+func computeWebhookSignature(secret string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s.", timestamp, nonce)
+	mac.Write(body)
+	return webhookSignatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignWebhookRequest sets the timestamp, nonce and signature headers on an
+// outgoing webhook request carrying body.
+func SignWebhookRequest(req *http.Request, secret string, body []byte) error {
+	nonce, err := store.GenerateInviteCode()
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookNonceHeader, nonce)
+	req.Header.Set(webhookSignatureHeader, computeWebhookSignature(secret, timestamp, nonce, body))
+	return nil
+}
+
+// NonceCache remembers nonces for the tolerance window to reject replays.
+// Every nonce lives for the same ttl, so the order they were used in is the
+// order they expire in and pruning only looks at the oldest.
+type NonceCache struct {
+	seen  map[string]time.Time
+	queue []string // nonces in seen, oldest first
+	ttl   time.Duration
+	mutex sync.Mutex
+}
+
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		seen: make(map[string]time.Time),
+		ttl:  ttl,
+	}
+}
+
+// Use records the nonce and reports false if it was already used.
+func (nc *NonceCache) Use(nonce string) bool {
+	now := time.Now()
+
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.prune(now)
+
+	if _, exists := nc.seen[nonce]; exists {
+		return false
+	}
+	nc.seen[nonce] = now.Add(nc.ttl)
+	nc.queue = append(nc.queue, nonce)
+	return true
+}
+
+// prune drops the nonces that expired by now from the front of the queue;
+// the caller holds the mutex
+func (nc *NonceCache) prune(now time.Time) {
+	expired := 0
+	for _, nonce := range nc.queue {
+		if !now.After(nc.seen[nonce]) {
+			break
+		}
+		delete(nc.seen, nonce)
+		expired++
+	}
+	// append moves the rest to a new array once this one is full, so the
+	// expired front doesn't pile up
+	nc.queue = nc.queue[expired:]
+}
+
+// WebhookVerifier checks signatures on incoming webhook requests.
+type WebhookVerifier struct {
+	nonces *NonceCache
+}
+
+func NewWebhookVerifier() *WebhookVerifier {
+	// Nonces must outlive both sides of the timestamp window
+	return &WebhookVerifier{nonces: NewNonceCache(2 * webhookTolerance)}
+}
+
+// Verify validates the signature headers against the body and rejects stale
+// or replayed deliveries.
+func (wv *WebhookVerifier) Verify(secret string, header http.Header, body []byte) error {
+	timestampStr := header.Get(webhookTimestampHeader)
+	nonce := header.Get(webhookNonceHeader)
+	signature := header.Get(webhookSignatureHeader)
+	if timestampStr == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("missing signature headers")
+	}
+
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("timestamp outside tolerance")
+	}
+
+	if !strings.HasPrefix(signature, webhookSignatureVersion+"=") {
+		return fmt.Errorf("unsupported signature version")
+	}
+
+	expected := computeWebhookSignature(secret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	// Only burn the nonce once the signature is known to be genuine
+	if !wv.nonces.Use(nonce) {
+		return fmt.Errorf("replayed request")
+	}
+
+	return nil
+}
+
+// VerifyRequest reads the request body, verifies it, and restores the body so
+// handlers can decode it afterwards.
+func (wv *WebhookVerifier) VerifyRequest(r *http.Request, secret string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return wv.Verify(secret, r.Header, body)
+}